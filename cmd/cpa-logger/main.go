@@ -1,17 +1,35 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/k0ngk0ng/cpa-logger/internal/collector"
-	"github.com/k0ngk0ng/cpa-logger/internal/config"
-	"github.com/k0ngk0ng/cpa-logger/internal/storage"
+	"github.com/k0ngk0ng/cpa-logger/internal/alert"
+	"github.com/k0ngk0ng/cpa-logger/internal/api"
+	"github.com/k0ngk0ng/cpa-logger/internal/forecast"
+	"github.com/k0ngk0ng/cpa-logger/internal/replay"
+	"github.com/k0ngk0ng/cpa-logger/internal/scheduler"
+	"github.com/k0ngk0ng/cpa-logger/internal/stream"
+	"github.com/k0ngk0ng/cpa-logger/pkg/collector"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
 )
 
+// 退出时等待处理中的文件写完的最长时间
+const shutdownTimeout = time.Minute
+
 var (
 	version   = "dev"
 	commit    = "none"
@@ -21,6 +39,12 @@ var (
 func main() {
 	configPath := flag.String("config", "/etc/cpa-logger/config.yaml", "Path to config file")
 	showVersion := flag.Bool("version", false, "Show version")
+	backfill := flag.String("backfill", "", "Backfill logs from a directory, .log file or .tar/.tar.gz/.zip archive, then exit")
+	replayFailed := flag.Int("replay-failed", 0, "Re-send up to N pending requests from the replay queue, then exit")
+	ship := flag.String("ship", "", "Upload bundles from a spool directory to ClickHouse, then exit")
+	purgeBefore := flag.String("purge-before", "", "Drop daily and monthly partitions ending on or before DATE (YYYY-MM-DD) in the tables created by cpa-logger, then exit")
+	purgeProcessed := flag.Bool("purge-processed", false, "With -purge-before, also delete processed_files records of files modified before DATE")
+	purgeDryRun := flag.Bool("purge-dry-run", false, "With -purge-before, only list the partitions that would be dropped")
 	flag.Parse()
 
 	if *showVersion {
@@ -28,48 +52,436 @@ func main() {
 		os.Exit(0)
 	}
 
+	var purgeDate time.Time
+	if *purgeBefore != "" {
+		var err error
+		if purgeDate, err = time.ParseInLocation("2006-01-02", *purgeBefore, time.Local); err != nil {
+			log.Fatalf("Invalid -purge-before date: %v", err)
+		}
+	}
+
 	log.Printf("Starting cpa-logger %s...", version)
 
+	// 收到退出信号时取消，传递给连接、建表、补采和采集器
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
 	// 加载配置
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if err := parser.Configure(cfg); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
 	log.Printf("Log directory: %s", cfg.LogDir)
-	log.Printf("ClickHouse: %s:%d/%s", cfg.ClickHouse.Host, cfg.ClickHouse.Port, cfg.ClickHouse.Database)
+	log.Printf("Host: %s, instance: %s", cfg.Host, cfg.Instance)
+	labels := storage.Labels{Host: cfg.Host, Instance: cfg.Instance}
 
-	// 检查日志目录
-	if _, err := os.Stat(cfg.LogDir); os.IsNotExist(err) {
+	// 检查日志目录，上传和清理模式不需要
+	if _, err := os.Stat(cfg.LogDir); os.IsNotExist(err) && *ship == "" && *purgeBefore == "" {
 		log.Fatalf("Log directory does not exist: %s", cfg.LogDir)
 	}
 
+	// 其他存储后端（如离线模式的 spool）只运行采集器；上传和清理模式总是连接 ClickHouse
+	if cfg.Storage.Type != "clickhouse" && *ship == "" && *purgeBefore == "" {
+		if cfg.Storage.Type == "spool" {
+			log.Printf("Spool mode: writing bundles to %s", cfg.Spool.Dir)
+		} else {
+			log.Printf("Storage backend: %s", cfg.Storage.Type)
+		}
+		store, err := storage.Open(ctx, cfg, labels)
+		if err != nil {
+			log.Fatalf("Failed to open %s storage: %v", cfg.Storage.Type, err)
+		}
+		sink, err := openSinks(ctx, cfg, labels, store)
+		if err != nil {
+			log.Fatalf("Failed to open storage sinks: %v", err)
+		}
+		runCollector(ctx, cfg, sink, nil, *backfill)
+		return
+	}
+
+	log.Printf("ClickHouse: %s:%d/%s", cfg.ClickHouse.Host, cfg.ClickHouse.Port, cfg.ClickHouse.Database)
+	if cfg.MainLogSink == "victorialogs" {
+		log.Printf("Main logs sink: VictoriaLogs %s", cfg.VictoriaLogs.URL)
+	}
+
 	// 连接 ClickHouse
-	store, err := storage.NewClickHouseStorage(&cfg.ClickHouse)
+	store, err := storage.NewClickHouseStorage(ctx, &cfg.ClickHouse, labels)
 	if err != nil {
 		log.Fatalf("Failed to connect to ClickHouse: %v", err)
 	}
 	log.Println("Connected to ClickHouse")
 
+	if err := store.CreateCustomTables(ctx, cfg.CustomLogTypes); err != nil {
+		log.Fatalf("Failed to create custom log type tables: %v", err)
+	}
+
+	// 上传模式：将离线主机的 spool 目录写入 ClickHouse 后退出
+	if *ship != "" {
+		n, err := storage.ShipSpool(ctx, *ship, store)
+		store.Close()
+		if err != nil {
+			log.Fatalf("Shipped %d bundles, then failed: %v", n, err)
+		}
+		log.Printf("Shipped %d bundles from %s", n, *ship)
+		return
+	}
+
+	// 清理模式：删除早于指定日期的分区后退出
+	if *purgeBefore != "" {
+		err := purge(ctx, cfg, store, purgeDate, *purgeProcessed, *purgeDryRun)
+		store.Close()
+		if err != nil {
+			log.Fatalf("Purge failed: %v", err)
+		}
+		return
+	}
+
+	if cfg.ReplayQueue.Enabled {
+		if err := store.CreateReplayQueueTable(ctx); err != nil {
+			log.Fatalf("Failed to create replay queue table: %v", err)
+		}
+	}
+
+	// 重放模式：重新发送队列中失败的请求后退出
+	if *replayFailed > 0 {
+		if !cfg.ReplayQueue.Enabled {
+			log.Fatalf("replay_queue is not enabled")
+		}
+		outcomes, err := replay.New(&cfg.ReplayQueue, store).Run(ctx, nil, *replayFailed)
+		for _, o := range outcomes {
+			log.Printf("Replayed %s", o)
+		}
+		if err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		log.Printf("Replayed %d requests", len(outcomes))
+		return
+	}
+
+	// 各服务（gRPC、指标、REST API）的监听错误交给主协程，按正常流程停止采集器后退出
+	serveErrs := make(chan error, 3)
+
+	// 启动 gRPC 实时订阅服务
+	var hub collector.Publisher
+	var grpcServer *stream.Server
+	if cfg.GRPC.Enabled {
+		h := stream.NewHub(cfg.GRPC.BufferSize)
+		hub = h
+		grpcServer = stream.NewServer(h, cfg.GRPC.Token)
+		go func() {
+			if err := grpcServer.Serve(cfg.GRPC.Listen); err != nil {
+				serveErrs <- fmt.Errorf("gRPC server error: %w", err)
+			}
+		}()
+		log.Printf("gRPC stream server listening on %s", cfg.GRPC.Listen)
+	}
+
+	// 启动指标服务（队列深度、溢出/丢弃计数等）
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsListen, mux); err != nil {
+				serveErrs <- fmt.Errorf("metrics server error: %w", err)
+			}
+		}()
+		log.Printf("Metrics server listening on %s", cfg.MetricsListen)
+	}
+
+	// 启用预写队列时采集结果先写入本地目录，再由后台协程写入 ClickHouse
+	var sink storage.Storage = store
+	if cfg.WAL.Enabled {
+		if sink, err = storage.WAL(&cfg.WAL, store, labels); err != nil {
+			log.Fatalf("Failed to open WAL: %v", err)
+		}
+		log.Printf("WAL: %s", cfg.WAL.Dir)
+	}
+	// 启用本地状态时检查文件是否已处理只读取本地记录
+	if cfg.StateStore.Enabled {
+		if sink, err = storage.LocalState(ctx, &cfg.StateStore, store, sink); err != nil {
+			log.Fatalf("Failed to open state store: %v", err)
+		}
+		log.Printf("State store: %s", cfg.StateStore.StateFile)
+	}
+
+	// 启用 Parquet 归档时采集结果同时写入 S3 或本地目录
+	if cfg.Storage.Parquet.Archive {
+		archive, err := storage.NewParquetStorage(ctx, cfg, labels)
+		if err != nil {
+			log.Fatalf("Failed to open Parquet archive: %v", err)
+		}
+		if cfg.Storage.Parquet.Dir != "" {
+			log.Printf("Parquet archive: %s", filepath.Join(cfg.Storage.Parquet.Dir, cfg.Storage.Parquet.Prefix))
+		} else {
+			log.Printf("Parquet archive: s3://%s/%s", cfg.Storage.Parquet.Bucket, cfg.Storage.Parquet.Prefix)
+		}
+		sink = storage.Mirror(sink, archive)
+	}
+	if sink, err = openSinks(ctx, cfg, labels, sink); err != nil {
+		log.Fatalf("Failed to open storage sinks: %v", err)
+	}
+
 	// 创建采集器
-	col, err := collector.New(cfg, store)
+	col, err := collector.New(cfg, sink, hub)
 	if err != nil {
 		log.Fatalf("Failed to create collector: %v", err)
 	}
+	if cfg.ClickHouse.HealthCheck.Enabled {
+		col.SetStorageHealth(store.Healthy)
+	}
+
+	// 补采模式：处理完指定路径后退出
+	if *backfill != "" {
+		err := col.Backfill(ctx, *backfill)
+		stopCollector(col)
+		if err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		return
+	}
 
 	// 启动采集器
-	if err := col.Start(); err != nil {
+	if err := col.Start(ctx); err != nil {
 		log.Fatalf("Failed to start collector: %v", err)
 	}
 
 	log.Println("Collector started successfully")
 
-	// 等待退出信号
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	// 启动保存的查询和告警规则评估
+	var alerts *alert.Manager
+	if cfg.Alerts.Enabled {
+		alerts, err = alert.NewManager(cfg, store)
+		if err != nil {
+			log.Fatalf("Failed to create alert manager: %v", err)
+		}
+		alerts.Start()
+		log.Printf("Alert rules evaluated every %ds", cfg.Alerts.IntervalSeconds)
+	}
+
+	// 定时汇总任务
+	jobs := scheduler.New()
+	if cfg.ClientIPUsage.Enabled {
+		if err := store.CreateClientIPUsageTables(ctx); err != nil {
+			log.Fatalf("Failed to create client IP usage tables: %v", err)
+		}
+		jobs.Add(scheduler.Job{
+			Name:       "client_ip_usage",
+			Interval:   time.Duration(cfg.ClientIPUsage.IntervalSeconds) * time.Second,
+			RunOnStart: true,
+			Run: func(ctx context.Context) error {
+				return store.RollupClientIPUsage(ctx, &cfg.ClientIPUsage, time.Now())
+			},
+		})
+		log.Printf("Client IP usage rolled up every %ds", cfg.ClientIPUsage.IntervalSeconds)
+	}
+	if cfg.DailyRollup.Enabled {
+		if err := store.CreateDailyUsageTable(ctx); err != nil {
+			log.Fatalf("Failed to create daily usage table: %v", err)
+		}
+		jobs.Add(scheduler.Job{
+			Name:       "daily_rollup",
+			Interval:   time.Duration(cfg.DailyRollup.IntervalSeconds) * time.Second,
+			RunOnStart: true,
+			Run: func(ctx context.Context) error {
+				return store.RollupDailyUsage(ctx, cfg.DailyRollup.LookbackDays, cfg.Pricing, time.Now())
+			},
+		})
+		log.Printf("Daily usage rolled up every %ds", cfg.DailyRollup.IntervalSeconds)
+	}
+	if cfg.ModelPricing.Enabled {
+		if err := store.CreateModelPricingTable(ctx); err != nil {
+			log.Fatalf("Failed to create model pricing table: %v", err)
+		}
+		// 价格与上次写入的相同时不刷新
+		var current []config.ModelPricing
+		jobs.Add(scheduler.Job{
+			Name:       "model_pricing",
+			Interval:   time.Duration(cfg.ModelPricing.IntervalSeconds) * time.Second,
+			RunOnStart: true,
+			Run: func(ctx context.Context) error {
+				prices, err := cfg.ModelPrices()
+				if err != nil {
+					return err
+				}
+				if current != nil && reflect.DeepEqual(prices, current) {
+					return nil
+				}
+				if err := store.RefreshModelPricing(ctx, prices); err != nil {
+					return err
+				}
+				current = prices
+				log.Printf("Model pricing refreshed: %d prices", len(prices))
+				return nil
+			},
+		})
+	}
+	if cfg.Forecast.Enabled {
+		if err := store.CreateCapacityForecastTable(ctx); err != nil {
+			log.Fatalf("Failed to create capacity forecast table: %v", err)
+		}
+		forecaster := forecast.New(&cfg.Forecast, store, alerts)
+		jobs.Add(scheduler.Job{
+			Name:       "forecast",
+			Interval:   time.Duration(cfg.Forecast.IntervalSeconds) * time.Second,
+			RunOnStart: true,
+			Run:        forecaster.Run,
+		})
+		log.Printf("Capacity forecast updated every %ds", cfg.Forecast.IntervalSeconds)
+	}
+	if cfg.Maintenance.Enabled {
+		jobs.Add(scheduler.Job{
+			Name:     "maintenance",
+			Interval: time.Duration(cfg.Maintenance.IntervalSeconds) * time.Second,
+			Run: func(ctx context.Context) error {
+				now := time.Now()
+				if !cfg.Maintenance.InQuietHours(now) {
+					return nil
+				}
+				return store.OptimizeRecentPartitions(ctx, cfg.Maintenance.Tables, now.AddDate(0, 0, -cfg.Maintenance.LookbackDays))
+			},
+		})
+		log.Printf("Table maintenance runs during %s", cfg.Maintenance.QuietHours)
+	}
+	if cfg.Retention.Enabled {
+		if err := store.ApplyRetention(ctx, &cfg.Retention); err != nil {
+			log.Fatalf("Failed to apply retention: %v", err)
+		}
+		jobs.Add(scheduler.Job{
+			Name:       "retention",
+			Interval:   time.Duration(cfg.Retention.IntervalSeconds) * time.Second,
+			RunOnStart: true,
+			Run: func(ctx context.Context) error {
+				freed, err := store.DropExpiredPartitions(ctx, cfg.Retention.Tables, time.Now())
+				if freed > 0 {
+					log.Printf("Retention: freed %.1f MiB", float64(freed)/(1<<20))
+				}
+				return err
+			},
+		})
+		log.Printf("Retention enforced every %ds", cfg.Retention.IntervalSeconds)
+	}
+	jobs.Start()
+
+	// 启动 REST 查询 API
+	var apiServer *api.Server
+	if cfg.API.Enabled {
+		apiServer, err = api.NewServer(cfg, store, col, alerts)
+		if err != nil {
+			log.Fatalf("Failed to create API server: %v", err)
+		}
+		go func() {
+			if err := apiServer.Serve(cfg.API.Listen); err != nil {
+				serveErrs <- fmt.Errorf("API server error: %w", err)
+			}
+		}()
+		log.Printf("REST API listening on %s", cfg.API.Listen)
+	}
+
+	// 等待退出信号或服务出错，之后再次收到信号时直接退出
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-serveErrs:
+		log.Printf("%v, shutting down", serveErr)
+	}
+	stopSignals()
+
+	log.Println("Shutting down...")
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	if apiServer != nil {
+		apiServer.Stop()
+	}
+	if alerts != nil {
+		alerts.Stop()
+	}
+	jobs.Stop()
+	stopCollector(col)
+	if serveErr != nil {
+		log.Fatalf("Stopped after %v", serveErr)
+	}
+	log.Println("Bye!")
+}
+
+// runCollector 只运行采集器（ClickHouse 以外的存储后端），补采完成或收到退出信号后停止并关闭存储
+func runCollector(ctx context.Context, cfg *config.Config, store storage.Storage, hub collector.Publisher, backfill string) {
+	col, err := collector.New(cfg, store, hub)
+	if err != nil {
+		log.Fatalf("Failed to create collector: %v", err)
+	}
+	if backfill != "" {
+		err := col.Backfill(ctx, backfill)
+		stopCollector(col)
+		if err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		return
+	}
+	if err := col.Start(ctx); err != nil {
+		log.Fatalf("Failed to start collector: %v", err)
+	}
+	log.Println("Collector started successfully")
 
+	<-ctx.Done()
 	log.Println("Shutting down...")
-	col.Stop()
+	stopCollector(col)
 	log.Println("Bye!")
 }
+
+// openSinks 按 storage.sinks 创建附加的写入目标，未配置时返回 store
+func openSinks(ctx context.Context, cfg *config.Config, labels storage.Labels, store storage.Storage) (storage.Storage, error) {
+	for _, sc := range cfg.Storage.Sinks {
+		if len(sc.LogTypes) > 0 {
+			log.Printf("Storage sink: %s (%s, log types: %s)", sc.Name, sc.Type, strings.Join(sc.LogTypes, ", "))
+		} else {
+			log.Printf("Storage sink: %s (%s)", sc.Name, sc.Type)
+		}
+	}
+	return storage.FanOut(ctx, cfg, labels, store)
+}
+
+// stopCollector 停止采集器，最多等待 shutdownTimeout 让处理中的文件写完
+func stopCollector(col *collector.Collector) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := col.Stop(ctx); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// purge 删除各表中结束时间不晚于 before 的分区，processed 为 true 时同时删除 before 之前修改的文件的已处理记录
+// （启用 state_store 时包括本地状态文件中的记录）
+func purge(ctx context.Context, cfg *config.Config, store *storage.ClickHouseStorage, before time.Time, processed, dryRun bool) error {
+	partitions, bytes, err := store.PurgePartitions(ctx, before, dryRun)
+	if dryRun {
+		for _, p := range partitions {
+			log.Printf("Would drop partition %s", p)
+		}
+		log.Printf("Would drop %d partitions (%d bytes)", len(partitions), bytes)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Dropped %d partitions, freed %d bytes", len(partitions), bytes)
+	if processed {
+		if err := store.PurgeProcessedFiles(ctx, before); err != nil {
+			return err
+		}
+		log.Printf("Deleted processed_files records of files modified before %s", before.Format("2006-01-02"))
+		if cfg.StateStore.Enabled {
+			n, err := storage.PurgeLocalState(&cfg.StateStore, before)
+			if err != nil {
+				return err
+			}
+			log.Printf("Deleted %d lines from %s", n, cfg.StateStore.StateFile)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CapacityWarning 容量预测超限通知
+type CapacityWarning struct {
+	// disk 或配额名称
+	Target string `json:"target"`
+	State  string `json:"state"`
+	// disk_bytes、requests 或 tokens
+	Metric    string  `json:"metric"`
+	Limit     float64 `json:"limit"`
+	Projected float64 `json:"projected"`
+	// 预计超限的日期，恢复时为空
+	ExceedsOn *time.Time `json:"exceeds_on,omitempty"`
+	Time      time.Time  `json:"time"`
+}
+
+func (w CapacityWarning) text() string {
+	var text strings.Builder
+	if w.State == "firing" {
+		fmt.Fprintf(&text, ":chart_with_upwards_trend: *%s* %s projected to exceed %.0f on %s (projected %.0f)",
+			w.Target, w.Metric, w.Limit, w.ExceedsOn.Format("2006-01-02"), w.Projected)
+	} else {
+		fmt.Fprintf(&text, ":white_check_mark: *%s* %s no longer projected to exceed %.0f", w.Target, w.Metric, w.Limit)
+	}
+	return text.String()
+}
+
+// NotifyCapacity 通过指定渠道发送容量预测通知
+func (m *Manager) NotifyCapacity(channels []string, w CapacityWarning) {
+	m.notify("capacity "+w.Target, channels, w)
+}
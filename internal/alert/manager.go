@@ -0,0 +1,400 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// 默认查询窗口
+const defaultWindow = 300
+
+var (
+	ErrNotFound = errors.New("saved search not found")
+	// 配置文件中定义的查询不能通过 API 修改或删除
+	ErrReadOnly = errors.New("saved search is defined in config")
+)
+
+// Search 保存的查询及其告警状态
+type Search struct {
+	config.SavedSearch
+	// 是否来自配置文件
+	FromConfig bool `json:"from_config"`
+	// 最近一次评估时是否处于告警状态
+	Firing    bool      `json:"firing"`
+	LastCount uint64    `json:"last_count"`
+	LastEval  time.Time `json:"last_eval,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Result 执行保存的查询的结果
+type Result struct {
+	Search   string                   `json:"search"`
+	Since    time.Time                `json:"since"`
+	Until    time.Time                `json:"until"`
+	Count    uint64                   `json:"count"`
+	Requests []storage.RequestSummary `json:"requests"`
+}
+
+// Manager 管理保存的查询，并定时评估配置了通知渠道的告警规则
+type Manager struct {
+	cfg      *config.AlertsConfig
+	store    *storage.ClickHouseStorage
+	channels map[string]notifier
+	// main 日志写入 ClickHouse，可按客户端 IP 过滤（通过 main_logs 匹配）
+	clientIP bool
+
+	mu       sync.Mutex
+	searches map[string]*Search
+	// 配置文件中的 SLO，按配置顺序
+	slos []*SLO
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewManager(cfg *config.Config, store *storage.ClickHouseStorage) (*Manager, error) {
+	m := &Manager{
+		cfg:      &cfg.Alerts,
+		store:    store,
+		channels: make(map[string]notifier),
+		clientIP: cfg.MainLogSink == "clickhouse",
+		searches: make(map[string]*Search),
+		done:     make(chan struct{}),
+	}
+	for _, ch := range m.cfg.Channels {
+		m.channels[ch.Name] = newNotifier(ch)
+	}
+
+	for _, ss := range m.cfg.Searches {
+		if err := m.validate(&ss); err != nil {
+			return nil, fmt.Errorf("alerts.searches.%s: %w", ss.Name, err)
+		}
+		if _, ok := m.searches[ss.Name]; ok {
+			return nil, fmt.Errorf("alerts.searches.%s: duplicate name", ss.Name)
+		}
+		m.searches[ss.Name] = &Search{SavedSearch: ss, FromConfig: true}
+	}
+
+	for _, slo := range m.cfg.SLOs {
+		for _, name := range slo.Channels {
+			if _, ok := m.channels[name]; !ok {
+				return nil, fmt.Errorf("alerts.slos.%s: unknown channel: %s", slo.Name, name)
+			}
+		}
+		m.slos = append(m.slos, &SLO{SLOConfig: slo, Compliance: 1, BudgetRemaining: 1})
+	}
+
+	saved, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	for _, ss := range saved {
+		if _, ok := m.searches[ss.Name]; ok {
+			log.Printf("Saved search %s is shadowed by config, ignoring stored copy", ss.Name)
+			continue
+		}
+		if err := m.validate(&ss); err != nil {
+			log.Printf("Ignoring invalid saved search %s: %v", ss.Name, err)
+			continue
+		}
+		m.searches[ss.Name] = &Search{SavedSearch: ss}
+	}
+	return m, nil
+}
+
+// validate 校验查询条件并填充默认值
+func (m *Manager) validate(ss *config.SavedSearch) error {
+	if ss.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if ss.Status != "" {
+		if _, _, err := storage.ParseStatus(ss.Status); err != nil {
+			return err
+		}
+	}
+	if ss.LogType != "" && !config.IsKnownLogType(ss.LogType) {
+		return fmt.Errorf("unknown log_type: %s", ss.LogType)
+	}
+	if ss.ClientIP != "" && !m.clientIP {
+		return fmt.Errorf("client_ip requires main_log_sink clickhouse")
+	}
+	if ss.WindowSeconds <= 0 {
+		ss.WindowSeconds = defaultWindow
+	}
+	if ss.Threshold <= 0 {
+		ss.Threshold = 1
+	}
+	for _, name := range ss.Channels {
+		if _, ok := m.channels[name]; !ok {
+			return fmt.Errorf("unknown channel: %s", name)
+		}
+	}
+	return nil
+}
+
+// filter 查询对应的 RequestFilter，时间范围为截至 now 的窗口
+func filter(ss config.SavedSearch, now time.Time) storage.RequestFilter {
+	f := storage.RequestFilter{
+		Model:    ss.Model,
+		LogType:  ss.LogType,
+		ClientIP: ss.ClientIP,
+		Host:     ss.Host,
+		Since:    now.Add(-time.Duration(ss.WindowSeconds) * time.Second),
+		Until:    now,
+	}
+	if ss.Status != "" {
+		f.Status, f.StatusClass, _ = storage.ParseStatus(ss.Status)
+	}
+	return f
+}
+
+// List 返回全部保存的查询，按名称排序
+func (m *Manager) List() []Search {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Search, 0, len(m.searches))
+	for _, s := range m.searches {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Save 新建或更新通过 API 定义的查询
+func (m *Manager) Save(ss config.SavedSearch) (Search, error) {
+	if err := m.validate(&ss); err != nil {
+		return Search{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.searches[ss.Name]; ok && s.FromConfig {
+		return Search{}, ErrReadOnly
+	}
+	prev := m.searches[ss.Name]
+	m.searches[ss.Name] = &Search{SavedSearch: ss}
+	if err := m.saveState(); err != nil {
+		if prev != nil {
+			m.searches[ss.Name] = prev
+		} else {
+			delete(m.searches, ss.Name)
+		}
+		return Search{}, err
+	}
+	return *m.searches[ss.Name], nil
+}
+
+// Delete 删除通过 API 定义的查询
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.searches[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.FromConfig {
+		return ErrReadOnly
+	}
+	delete(m.searches, name)
+	if err := m.saveState(); err != nil {
+		m.searches[name] = s
+		return err
+	}
+	return nil
+}
+
+// Run 执行保存的查询，返回窗口内的匹配数和最近的 limit 条请求
+func (m *Manager) Run(ctx context.Context, name string, limit int) (*Result, error) {
+	m.mu.Lock()
+	s, ok := m.searches[name]
+	var ss config.SavedSearch
+	if ok {
+		ss = s.SavedSearch
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	f := filter(ss, time.Now())
+	count, err := m.store.CountRequests(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	f.Limit = limit
+	requests, err := m.store.SearchRequests(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Search: name, Since: f.Since, Until: f.Until, Count: count, Requests: requests}, nil
+}
+
+// loadState 读取通过 API 保存的查询
+func (m *Manager) loadState() ([]config.SavedSearch, error) {
+	if m.cfg.StateFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(m.cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alerts.state_file: %w", err)
+	}
+	var saved []config.SavedSearch
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse alerts.state_file: %w", err)
+	}
+	return saved, nil
+}
+
+// saveState 写入通过 API 保存的查询，调用方持有 mu
+func (m *Manager) saveState() error {
+	if m.cfg.StateFile == "" {
+		return nil
+	}
+	saved := []config.SavedSearch{}
+	for _, s := range m.searches {
+		if !s.FromConfig {
+			saved = append(saved, s.SavedSearch)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免写入中断导致文件损坏
+	tmp := m.cfg.StateFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.cfg.StateFile)
+}
+
+// Start 启动告警规则评估
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go m.loop()
+}
+
+// Stop 停止告警规则评估
+func (m *Manager) Stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+func (m *Manager) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(m.cfg.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.evaluate()
+			m.evaluateSLOs()
+		}
+	}
+}
+
+// evaluate 评估所有配置了通知渠道的查询，状态变化（触发/恢复）时发送通知
+func (m *Manager) evaluate() {
+
+	var rules []config.SavedSearch
+	m.mu.Lock()
+	for _, s := range m.searches {
+		if len(s.Channels) > 0 {
+			rules = append(rules, s.SavedSearch)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, rule := range rules {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		now := time.Now()
+		f := filter(rule, now)
+		count, err := m.store.CountRequests(ctx, f)
+		var samples []storage.RequestSummary
+		if err == nil && count >= uint64(rule.Threshold) {
+			f.Limit = 5
+			samples, err = m.store.SearchRequests(ctx, f)
+		}
+		cancel()
+
+		m.mu.Lock()
+		s, ok := m.searches[rule.Name]
+		if !ok {
+			// 评估期间被删除
+			m.mu.Unlock()
+			continue
+		}
+		s.LastEval = now
+		if err != nil {
+			s.LastError = err.Error()
+			m.mu.Unlock()
+			log.Printf("Error evaluating alert %s: %v", rule.Name, err)
+			continue
+		}
+		s.LastError = ""
+		s.LastCount = count
+		firing := count >= uint64(rule.Threshold)
+		changed := firing != s.Firing
+		s.Firing = firing
+		m.mu.Unlock()
+
+		if !changed {
+			continue
+		}
+		state := "resolved"
+		if firing {
+			state = "firing"
+		}
+		log.Printf("Alert %s %s: %d requests in last %ds (threshold %d)",
+			rule.Name, state, count, rule.WindowSeconds, rule.Threshold)
+		m.notify(rule.Name, rule.Channels, notification{
+			Search:        rule.Name,
+			State:         state,
+			Count:         count,
+			Threshold:     rule.Threshold,
+			WindowSeconds: rule.WindowSeconds,
+			Time:          now,
+			Samples:       samples,
+		})
+	}
+}
+
+// notify 向规则的所有渠道发送通知
+func (m *Manager) notify(rule string, channels []string, msg message) {
+	for _, name := range channels {
+		ch, ok := m.channels[name]
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := ch.send(ctx, msg); err != nil {
+			log.Printf("Error sending alert %s to %s: %v", rule, name, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,103 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// notification 告警状态变化通知
+type notification struct {
+	Search        string                   `json:"search"`
+	State         string                   `json:"state"`
+	Count         uint64                   `json:"count"`
+	Threshold     int                      `json:"threshold"`
+	WindowSeconds int                      `json:"window_seconds"`
+	Time          time.Time                `json:"time"`
+	Samples       []storage.RequestSummary `json:"samples,omitempty"`
+}
+
+// message 通知内容：webhook 渠道以 JSON 发送，Slack 渠道发送 text() 文本
+type message interface {
+	text() string
+}
+
+func (n notification) text() string {
+	var text strings.Builder
+	if n.State == "firing" {
+		fmt.Fprintf(&text, ":rotating_light: *%s* firing: %d requests in last %ds (threshold %d)",
+			n.Search, n.Count, n.WindowSeconds, n.Threshold)
+	} else {
+		fmt.Fprintf(&text, ":white_check_mark: *%s* resolved: %d requests in last %ds",
+			n.Search, n.Count, n.WindowSeconds)
+	}
+	for _, r := range n.Samples {
+		fmt.Fprintf(&text, "\n• `%s` %s %d %s", r.RequestID, r.Model, r.ResponseStatus, r.Timestamp.Format(time.RFC3339))
+	}
+	return text.String()
+}
+
+// notifier 告警通知渠道
+type notifier interface {
+	send(ctx context.Context, msg message) error
+}
+
+func newNotifier(ch config.AlertChannel) notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if ch.Type == "slack" {
+		return &slackNotifier{client: client, url: ch.URL}
+	}
+	return &webhookNotifier{client: client, url: ch.URL}
+}
+
+// webhookNotifier 将通知以 JSON POST 到指定地址
+type webhookNotifier struct {
+	client *http.Client
+	url    string
+}
+
+func (w *webhookNotifier) send(ctx context.Context, msg message) error {
+	return postJSON(ctx, w.client, w.url, msg)
+}
+
+// slackNotifier 通过 Slack incoming webhook 发送文本通知
+type slackNotifier struct {
+	client *http.Client
+	url    string
+}
+
+func (s *slackNotifier) send(ctx context.Context, msg message) error {
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": msg.text()})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("notification endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
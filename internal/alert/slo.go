@@ -0,0 +1,177 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// SLO 服务等级目标及最近一次评估结果
+type SLO struct {
+	config.SLOConfig
+	// 合规周期内的请求数和达标比例，没有请求时达标比例为 1
+	Requests   uint64  `json:"requests"`
+	Compliance float64 `json:"compliance"`
+	// 剩余错误预算比例，预算耗尽后为负
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// 各告警窗口的消耗速度
+	BurnRates []BurnRate `json:"burn_rates"`
+	// 触发中的 burn_alerts 下标
+	FiringAlerts []int     `json:"firing_alerts,omitempty"`
+	Firing       bool      `json:"firing"`
+	LastEval     time.Time `json:"last_eval,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// BurnRate 时间窗口内的错误预算消耗速度，1 表示恰好在合规周期结束时耗尽预算
+type BurnRate struct {
+	WindowSeconds int     `json:"window_seconds"`
+	Requests      uint64  `json:"requests"`
+	BurnRate      float64 `json:"burn_rate"`
+}
+
+// sloNotification SLO 告警状态变化通知
+type sloNotification struct {
+	SLO             string             `json:"slo"`
+	State           string             `json:"state"`
+	Objective       float64            `json:"objective"`
+	Compliance      float64            `json:"compliance"`
+	BudgetRemaining float64            `json:"budget_remaining"`
+	BurnRates       []BurnRate         `json:"burn_rates"`
+	Alerts          []config.BurnAlert `json:"alerts,omitempty"`
+	Time            time.Time          `json:"time"`
+}
+
+func (n sloNotification) text() string {
+	var text strings.Builder
+	if n.State == "firing" {
+		fmt.Fprintf(&text, ":fire: SLO *%s* burning error budget too fast", n.SLO)
+		for _, a := range n.Alerts {
+			fmt.Fprintf(&text, "\n• burn rate >= %g over %ds and %ds", a.BurnRate, a.LongWindowSeconds, a.ShortWindowSeconds)
+		}
+	} else {
+		fmt.Fprintf(&text, ":white_check_mark: SLO *%s* burn rate back to normal", n.SLO)
+	}
+	fmt.Fprintf(&text, "\ncompliance %.4f%% (objective %g%%), budget remaining %.1f%%",
+		n.Compliance*100, n.Objective*100, n.BudgetRemaining*100)
+	return text.String()
+}
+
+// sloWindows SLO 需要统计的窗口：合规周期和各告警的长短窗口（去重）
+func sloWindows(slo config.SLOConfig) []time.Duration {
+	windows := []time.Duration{time.Duration(slo.WindowDays) * 24 * time.Hour}
+	seen := map[time.Duration]bool{windows[0]: true}
+	for _, a := range slo.BurnAlerts {
+		for _, sec := range []int{a.LongWindowSeconds, a.ShortWindowSeconds} {
+			w := time.Duration(sec) * time.Second
+			if !seen[w] {
+				seen[w] = true
+				windows = append(windows, w)
+			}
+		}
+	}
+	return windows
+}
+
+// SLOs 返回全部 SLO 及其最近一次评估结果
+func (m *Manager) SLOs() []SLO {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]SLO, 0, len(m.slos))
+	for _, s := range m.slos {
+		list = append(list, *s)
+	}
+	return list
+}
+
+// evaluateSLOs 计算各 SLO 的合规比例和 burn rate，告警状态变化时发送通知
+func (m *Manager) evaluateSLOs() {
+	m.mu.Lock()
+	slos := make([]config.SLOConfig, len(m.slos))
+	for i, s := range m.slos {
+		slos[i] = s.SLOConfig
+	}
+	m.mu.Unlock()
+
+	for i, slo := range slos {
+		now := time.Now()
+		windows := sloWindows(slo)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		counts, err := m.store.SLOCounts(ctx, storage.SLOQuery{
+			LogType:   slo.LogType,
+			Model:     slo.Model,
+			LatencyMs: slo.LatencyMs,
+		}, windows, now)
+		cancel()
+
+		m.mu.Lock()
+		s := m.slos[i]
+		s.LastEval = now
+		if err != nil {
+			s.LastError = err.Error()
+			m.mu.Unlock()
+			log.Printf("Error evaluating SLO %s: %v", slo.Name, err)
+			continue
+		}
+		s.LastError = ""
+
+		budget := 1 - slo.Objective
+		rates := make(map[int]float64, len(counts))
+		s.BurnRates = nil
+		for j, c := range counts {
+			var ratio float64
+			if c.Total > 0 {
+				ratio = float64(c.Bad) / float64(c.Total)
+			}
+			if j == 0 {
+				s.Requests = c.Total
+				s.Compliance = 1 - ratio
+				s.BudgetRemaining = 1 - ratio/budget
+				continue
+			}
+			sec := int(c.Window / time.Second)
+			rates[sec] = ratio / budget
+			s.BurnRates = append(s.BurnRates, BurnRate{WindowSeconds: sec, Requests: c.Total, BurnRate: ratio / budget})
+		}
+		// 合规周期本身也可能是告警窗口
+		rates[slo.WindowDays*86400] = 1 - s.BudgetRemaining
+
+		s.FiringAlerts = nil
+		var firingAlerts []config.BurnAlert
+		for j, a := range slo.BurnAlerts {
+			if rates[a.LongWindowSeconds] >= a.BurnRate && rates[a.ShortWindowSeconds] >= a.BurnRate {
+				s.FiringAlerts = append(s.FiringAlerts, j)
+				firingAlerts = append(firingAlerts, a)
+			}
+		}
+		firing := len(firingAlerts) > 0
+		changed := firing != s.Firing
+		s.Firing = firing
+		n := sloNotification{
+			SLO:             slo.Name,
+			Objective:       slo.Objective,
+			Compliance:      s.Compliance,
+			BudgetRemaining: s.BudgetRemaining,
+			BurnRates:       s.BurnRates,
+			Alerts:          firingAlerts,
+			Time:            now,
+		}
+		m.mu.Unlock()
+
+		if !changed {
+			continue
+		}
+		n.State = "resolved"
+		if firing {
+			n.State = "firing"
+		}
+		log.Printf("SLO %s %s: compliance %.4f, budget remaining %.3f", slo.Name, n.State, n.Compliance, n.BudgetRemaining)
+		m.notify(slo.Name, slo.Channels, n)
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/collector"
+)
+
+// job 后台执行的管理任务
+type job struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Target   string    `json:"target"`
+	Status   string    `json:"status"` // running / done / failed
+	Error    string    `json:"error,omitempty"`
+	Files    []string  `json:"files,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// jobRegistry 保存最近的管理任务状态，仅在内存中
+type jobRegistry struct {
+	mu   sync.Mutex
+	seq  int
+	jobs map[string]*job
+	// 按创建顺序，超出上限时淘汰最早的任务
+	order []string
+
+	// 任务的 context 随 API 服务关闭而取消，关闭时等待进行中的任务结束
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+const maxJobs = 100
+
+func newJobRegistry() *jobRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobRegistry{jobs: make(map[string]*job), ctx: ctx, cancel: cancel}
+}
+
+// stop 取消进行中的任务并等待结束，ctx 到期时不再等待
+func (r *jobRegistry) stop(ctx context.Context) {
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Admin jobs did not finish before shutdown: %v", ctx.Err())
+	}
+}
+
+// start 在后台执行任务，run 返回处理的文件和错误
+func (r *jobRegistry) start(kind, target string, run func(ctx context.Context) ([]string, error)) *job {
+	r.mu.Lock()
+	r.seq++
+	j := &job{
+		ID:      fmt.Sprintf("%d-%d", time.Now().Unix(), r.seq),
+		Kind:    kind,
+		Target:  target,
+		Status:  "running",
+		Started: time.Now(),
+	}
+	r.jobs[j.ID] = j
+	r.order = append(r.order, j.ID)
+	if len(r.order) > maxJobs {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+	snapshot := *j
+	r.running.Add(1)
+	r.mu.Unlock()
+
+	go func() {
+		defer r.running.Done()
+		files, err := run(r.ctx)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		j.Files = files
+		j.Finished = time.Now()
+		if err != nil {
+			j.Status = "failed"
+			j.Error = err.Error()
+			log.Printf("Admin %s job %s failed: %v", kind, j.ID, err)
+		} else {
+			j.Status = "done"
+			log.Printf("Admin %s job %s finished: %s", kind, j.ID, target)
+		}
+	}()
+	return &snapshot
+}
+
+func (r *jobRegistry) get(id string) (job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// handleBackfill POST /admin/backfill {"dir": "..."}，dir 也可以是 .log 文件或归档
+func (s *Server) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Dir string `json:"dir"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Dir == "" {
+		writeError(w, http.StatusBadRequest, `body must be {"dir": "<path>"}`)
+		return
+	}
+
+	j := s.jobs.start("backfill", req.Dir, func(ctx context.Context) ([]string, error) {
+		return nil, s.collector.Backfill(ctx, req.Dir)
+	})
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// handleReprocess POST /admin/reprocess {"request_id": "..."} 或 {"file": "..."}
+func (s *Server) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequestID string `json:"request_id"`
+		File      string `json:"file"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.RequestID == "") == (req.File == "") {
+		writeError(w, http.StatusBadRequest, `body must be {"request_id": "..."} or {"file": "..."}`)
+		return
+	}
+
+	var j *job
+	if req.File != "" {
+		j = s.jobs.start("reprocess", req.File, func(ctx context.Context) ([]string, error) {
+			return []string{req.File}, s.collector.Reprocess(ctx, req.File)
+		})
+	} else {
+		j = s.jobs.start("reprocess", req.RequestID, func(ctx context.Context) ([]string, error) {
+			return s.collector.ReprocessRequest(ctx, req.RequestID)
+		})
+	}
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// handleGetJob GET /admin/jobs/{id}
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// handleGetConfig GET /admin/config 返回生效的配置（敏感字段已隐藏），运行时修改的选项覆盖配置文件中的值
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.cfg.Masked()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rt := s.collector.RuntimeSettings()
+	cfg["batch_size"] = rt.BatchSize
+	cfg["sample_rate"] = rt.SampleRate
+	cfg["paused_types"] = rt.PausedTypes
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+// handlePatchConfig PATCH /admin/config 修改运行时可调整的选项：batch_size、sample_rate、paused_types
+func (s *Server) handlePatchConfig(w http.ResponseWriter, r *http.Request) {
+	var patch collector.RuntimePatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest,
+			"invalid body (only batch_size, sample_rate and paused_types can be changed at runtime): "+err.Error())
+		return
+	}
+
+	settings, err := s.collector.UpdateRuntime(patch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Runtime config updated: batch_size=%d sample_rate=%v paused_types=%v",
+		settings.BatchSize, settings.SampleRate, settings.PausedTypes)
+	writeJSON(w, http.StatusOK, settings)
+}
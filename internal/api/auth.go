@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+const (
+	roleRead  = "read"
+	roleAdmin = "admin"
+)
+
+// tokenStore 配置文件和 token 文件中的访问 token
+type tokenStore struct {
+	static []config.APIToken
+	file   string
+
+	mu        sync.Mutex
+	fileMod   time.Time
+	fileToken []config.APIToken
+}
+
+func newTokenStore(cfg *config.APIConfig) (*tokenStore, error) {
+	ts := &tokenStore{file: cfg.TokenFile}
+	ts.static = append(ts.static, cfg.Tokens...)
+	if cfg.AdminToken != "" {
+		ts.static = append(ts.static, config.APIToken{Name: "admin", Token: cfg.AdminToken, Role: roleAdmin})
+	}
+	if ts.file != "" {
+		if err := ts.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// enabled 是否配置了任何 token
+func (ts *tokenStore) enabled() bool {
+	return len(ts.static) > 0 || ts.file != ""
+}
+
+// hasAdmin 是否可能存在管理员 token（token 文件可随时添加）
+func (ts *tokenStore) hasAdmin() bool {
+	if ts.file != "" {
+		return true
+	}
+	for _, t := range ts.static {
+		if t.Role == roleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// reload token 文件修改时间变化时重新加载
+func (ts *tokenStore) reload() error {
+	info, err := os.Stat(ts.file)
+	if err != nil {
+		return fmt.Errorf("failed to read api.token_file: %w", err)
+	}
+	if info.ModTime().Equal(ts.fileMod) {
+		return nil
+	}
+
+	f, err := os.Open(ts.file)
+	if err != nil {
+		return fmt.Errorf("failed to read api.token_file: %w", err)
+	}
+	defer f.Close()
+
+	var tokens []config.APIToken
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[1] != roleRead && fields[1] != roleAdmin) {
+			return fmt.Errorf("api.token_file line %d: expected \"<name> <read|admin> <token>\"", n)
+		}
+		tokens = append(tokens, config.APIToken{Name: fields[0], Role: fields[1], Token: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	ts.fileToken = tokens
+	ts.fileMod = info.ModTime()
+	return nil
+}
+
+// lookup 查找 token，返回对应的配置
+func (ts *tokenStore) lookup(token string) (config.APIToken, bool) {
+	var candidates []config.APIToken
+	candidates = append(candidates, ts.static...)
+	if ts.file != "" {
+		ts.mu.Lock()
+		if err := ts.reload(); err != nil {
+			log.Printf("Error reloading API tokens: %v", err)
+		}
+		candidates = append(candidates, ts.fileToken...)
+		ts.mu.Unlock()
+	}
+
+	for _, t := range candidates {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return config.APIToken{}, false
+}
+
+// allows 角色是否满足要求，admin 包含 read 权限
+func allows(have, need string) bool {
+	return have == roleAdmin || have == need
+}
+
+type identityKey struct{}
+
+// authorize 校验 Authorization: Bearer <token>（或 Basic 认证的密码）的角色；未配置任何 token 时只读接口无需认证
+func (s *Server) authorize(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if role == roleRead && !s.tokens.enabled() {
+			next(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			// Grafana 等只支持 Basic 认证的客户端以密码作为 token
+			_, token, ok = r.BasicAuth()
+		}
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		t, ok := s.tokens.lookup(token)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if !allows(t.Role, role) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, t)))
+	}
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// 审计记录中保留的请求体长度
+const maxAuditBody = 4096
+
+// audited 记录管理操作：操作者、请求、结果写入日志和 admin_audit 表
+func (s *Server) audited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		t, _ := r.Context().Value(identityKey{}).(config.APIToken)
+		if len(body) > maxAuditBody {
+			body = body[:maxAuditBody]
+		}
+		entry := &storage.AdminAudit{
+			Time:       time.Now(),
+			TokenName:  t.Name,
+			Role:       t.Role,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Body:       string(body),
+			Status:     uint16(rec.status),
+			RemoteAddr: r.RemoteAddr,
+		}
+		log.Printf("Admin action: %s %s by %s from %s -> %d", entry.Method, entry.Path, entry.TokenName, entry.RemoteAddr, entry.Status)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.store.InsertAdminAudit(ctx, entry); err != nil {
+			log.Printf("Error inserting admin audit: %v", err)
+		}
+	}
+}
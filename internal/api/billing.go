@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// billingStatement 某个 API key 的月度账单
+type billingStatement struct {
+	APIKey                   string                `json:"api_key"`
+	Requests                 uint64                `json:"requests"`
+	InputTokens              uint64                `json:"input_tokens"`
+	OutputTokens             uint64                `json:"output_tokens"`
+	CacheReadInputTokens     uint64                `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens uint64                `json:"cache_creation_input_tokens"`
+	Cost                     float64               `json:"cost"`
+	Markup                   float64               `json:"markup"`
+	Total                    float64               `json:"total"`
+	Models                   []storage.BillingLine `json:"models"`
+}
+
+var billingCSVHeader = []string{
+	"month", "api_key", "model", "requests", "input_tokens", "output_tokens",
+	"cache_read_input_tokens", "cache_creation_input_tokens", "cost", "markup", "total",
+}
+
+// handleBilling GET /api/v1/billing?month=2026-09&format=json|csv
+// 按 API key 汇总客户端请求的月度用量和费用（美元），默认为上个月
+func (s *Server) handleBilling(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0)
+	if v := q.Get("month"); v != "" {
+		var err error
+		if month, err = time.ParseInLocation("2006-01", v, time.Local); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid month: "+v)
+			return
+		}
+	}
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv":
+	default:
+		writeError(w, http.StatusBadRequest, "unknown format: "+format)
+		return
+	}
+
+	lines, err := s.store.UsageByKey(r.Context(), month, month.AddDate(0, 1, 0), s.cfg.DailyRollup.Enabled, s.cfg.Pricing)
+	if err != nil {
+		log.Printf("Error querying billing usage: %v", err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+
+	label := month.Format("2006-01")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="billing-%s.csv"`, label))
+		cw := csv.NewWriter(w)
+		cw.Write(billingCSVHeader)
+		for _, l := range lines {
+			markup := s.markupFor(l.APIKey)
+			cw.Write([]string{
+				label, l.APIKey, l.Model,
+				strconv.FormatUint(l.Requests, 10),
+				strconv.FormatUint(l.InputTokens, 10),
+				strconv.FormatUint(l.OutputTokens, 10),
+				strconv.FormatUint(l.CacheReadInputTokens, 10),
+				strconv.FormatUint(l.CacheCreationInputTokens, 10),
+				formatAmount(l.Cost),
+				strconv.FormatFloat(markup, 'f', -1, 64),
+				formatAmount(l.Cost * (1 + markup)),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("Error writing billing CSV: %v", err)
+		}
+		return
+	}
+
+	statements := []*billingStatement{}
+	byKey := make(map[string]*billingStatement)
+	var total float64
+	for _, l := range lines {
+		st, ok := byKey[l.APIKey]
+		if !ok {
+			st = &billingStatement{APIKey: l.APIKey, Markup: s.markupFor(l.APIKey)}
+			byKey[l.APIKey] = st
+			statements = append(statements, st)
+		}
+		st.Requests += l.Requests
+		st.InputTokens += l.InputTokens
+		st.OutputTokens += l.OutputTokens
+		st.CacheReadInputTokens += l.CacheReadInputTokens
+		st.CacheCreationInputTokens += l.CacheCreationInputTokens
+		st.Cost += l.Cost
+		l.Cost = roundAmount(l.Cost)
+		st.Models = append(st.Models, l)
+	}
+	for _, st := range statements {
+		st.Total = roundAmount(st.Cost * (1 + st.Markup))
+		st.Cost = roundAmount(st.Cost)
+		total += st.Total
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"month":      label,
+		"currency":   "USD",
+		"statements": statements,
+		"total":      roundAmount(total),
+	})
+}
+
+// markupFor API key 的加收比例，未单独配置时使用 billing.markup
+func (s *Server) markupFor(apiKey string) float64 {
+	if m, ok := s.cfg.Billing.KeyMarkups[apiKey]; ok {
+		return m
+	}
+	return s.cfg.Billing.Markup
+}
+
+// roundAmount 金额保留 6 位小数
+func roundAmount(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(roundAmount(v), 'f', 6, 64)
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// valueChange 字段修改前后的值
+type valueChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// headerDiff 请求头差异，名称不区分大小写，以小写输出
+type headerDiff struct {
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]valueChange `json:"changed"`
+}
+
+// bodyChange 请求体中一处差异，path 形如 messages[0].content，整体替换时为空
+type bodyChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// upstreamDiff 客户端请求与一次上游调用之间的差异
+type upstreamDiff struct {
+	Index   int          `json:"index"`
+	URL     string       `json:"url"`
+	Method  string       `json:"method"`
+	Status  int          `json:"status"`
+	Headers headerDiff   `json:"headers"`
+	Body    []bodyChange `json:"body"`
+	// json 或 text，text 时 body 只记录整体替换
+	BodyFormat string `json:"body_format"`
+}
+
+type requestDiff struct {
+	LogType   string         `json:"log_type"`
+	URL       string         `json:"url"`
+	Method    string         `json:"method"`
+	Upstreams []upstreamDiff `json:"upstreams"`
+}
+
+// handleDiff GET /api/v1/requests/{request_id}/diff?log_type=
+// 对比客户端请求与各次上游调用的请求头和请求体，展示代理改写了什么
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("request_id")
+	logType := r.URL.Query().Get("log_type")
+
+	detail, err := s.store.GetRequest(r.Context(), requestID, false)
+	if err != nil {
+		log.Printf("Error getting request %s: %v", requestID, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+
+	diffs := []requestDiff{}
+	for _, record := range detail.APILogs {
+		if logType != "" && record.LogType != logType {
+			continue
+		}
+		var upstream []parser.UpstreamCall
+		json.Unmarshal(record.UpstreamRequests, &upstream)
+		if len(upstream) == 0 {
+			continue
+		}
+		diffs = append(diffs, diffRecord(record, upstream))
+	}
+	if len(diffs) == 0 {
+		writeError(w, http.StatusNotFound, "no upstream calls for request")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"request_id": requestID, "diffs": diffs})
+}
+
+func diffRecord(record storage.APILogRecord, upstream []parser.UpstreamCall) requestDiff {
+	var headers map[string]string
+	json.Unmarshal(record.Headers, &headers)
+
+	d := requestDiff{LogType: record.LogType, URL: record.URL, Method: record.Method}
+	for _, call := range upstream {
+		ud := upstreamDiff{
+			Index:   call.Index,
+			URL:     call.URL,
+			Method:  call.Method,
+			Status:  call.Status,
+			Headers: diffHeaders(headers, call.Headers),
+		}
+		ud.Body, ud.BodyFormat = diffBodies(record.RequestBody, call.Body)
+		d.Upstreams = append(d.Upstreams, ud)
+	}
+	return d
+}
+
+func diffHeaders(from, to map[string]string) headerDiff {
+	d := headerDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]valueChange{},
+	}
+	lowerFrom, lowerTo := lowerKeys(from), lowerKeys(to)
+	for name, v := range lowerFrom {
+		nv, ok := lowerTo[name]
+		switch {
+		case !ok:
+			d.Removed[name] = v
+		case nv != v:
+			d.Changed[name] = valueChange{From: v, To: nv}
+		}
+	}
+	for name, v := range lowerTo {
+		if _, ok := lowerFrom[name]; !ok {
+			d.Added[name] = v
+		}
+	}
+	return d
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	lower := make(map[string]string, len(m))
+	for k, v := range m {
+		lower[strings.ToLower(k)] = v
+	}
+	return lower
+}
+
+// diffBodies 两者都是 JSON 时逐字段对比，否则整体对比
+func diffBodies(from, to string) ([]bodyChange, string) {
+	var a, b interface{}
+	if json.Unmarshal([]byte(from), &a) == nil && json.Unmarshal([]byte(to), &b) == nil {
+		changes := []bodyChange{}
+		diffJSON("", a, b, &changes)
+		return changes, "json"
+	}
+	if from == to {
+		return []bodyChange{}, "text"
+	}
+	return []bodyChange{{Op: "changed", From: from, To: to}}, "text"
+}
+
+// diffJSON 递归对比 JSON 值，对象按键、数组按下标对比
+func diffJSON(path string, a, b interface{}, changes *[]bodyChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			x, inA := av[k]
+			y, inB := bv[k]
+			switch {
+			case !inB:
+				*changes = append(*changes, bodyChange{Path: child, Op: "removed", From: x})
+			case !inA:
+				*changes = append(*changes, bodyChange{Path: child, Op: "added", To: y})
+			default:
+				diffJSON(child, x, y, changes)
+			}
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(bv):
+				*changes = append(*changes, bodyChange{Path: child, Op: "removed", From: av[i]})
+			case i >= len(av):
+				*changes = append(*changes, bodyChange{Path: child, Op: "added", To: bv[i]})
+			default:
+				diffJSON(child, av[i], bv[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, bodyChange{Path: path, Op: "changed", From: a, To: b})
+	}
+}
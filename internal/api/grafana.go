@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// Grafana JSON datasource（simpod-json-datasource / SimpleJSON）接口
+// 指标名为 "<metric>" 或 "<metric> by <group>"，过滤条件放在 target 的 payload 中
+
+// 标注最多返回的条数
+const maxAnnotations = 500
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaTarget struct {
+	Target  string          `json:"target"`
+	RefID   string          `json:"refId"`
+	Hide    bool            `json:"hide"`
+	Payload json.RawMessage `json:"payload"`
+	// 旧版 SimpleJSON 的附加数据
+	Data json.RawMessage `json:"data"`
+}
+
+// grafanaFilter target payload 中的过滤条件
+type grafanaFilter struct {
+	Model    string `json:"model"`
+	Status   string `json:"status"`
+	LogType  string `json:"log_type"`
+	ClientIP string `json:"client_ip"`
+	Host     string `json:"host"`
+	Streamed *bool  `json:"streamed"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// handleGrafanaTest GET /grafana/ 数据源连接测试
+func (s *Server) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// grafanaMetricNames 全部可查询的指标名
+func grafanaMetricNames(prefix string) []string {
+	var names []string
+	for _, m := range storage.SeriesMetrics {
+		for _, name := range append([]string{m}, groupedNames(m)...) {
+			if strings.Contains(name, prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func groupedNames(metric string) []string {
+	names := make([]string, 0, len(storage.SeriesGroups))
+	for _, g := range storage.SeriesGroups {
+		names = append(names, metric+" by "+g)
+	}
+	return names
+}
+
+// handleGrafanaSearch POST /grafana/search {"target": "..."} 返回指标名列表
+func (s *Server) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	writeJSON(w, http.StatusOK, grafanaMetricNames(req.Target))
+}
+
+// handleGrafanaMetrics POST /grafana/metrics 新版 JSON datasource 的指标列表
+func (s *Server) handleGrafanaMetrics(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Metric string `json:"metric"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	metrics := []map[string]string{}
+	for _, name := range grafanaMetricNames(req.Metric) {
+		metrics = append(metrics, map[string]string{"label": name, "value": name})
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+// handleGrafanaQuery POST /grafana/query 返回各 target 的时间序列
+func (s *Server) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Range         grafanaRange    `json:"range"`
+		IntervalMs    int64           `json:"intervalMs"`
+		MaxDataPoints int64           `json:"maxDataPoints"`
+		Targets       []grafanaTarget `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+
+	step := time.Duration(req.IntervalMs) * time.Millisecond
+	span := req.Range.To.Sub(req.Range.From)
+	if req.MaxDataPoints > 0 && step > 0 && span/step > time.Duration(req.MaxDataPoints) {
+		step = span / time.Duration(req.MaxDataPoints)
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+
+	result := []grafanaSeries{}
+	for _, target := range req.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		metric, group, _ := strings.Cut(target.Target, " by ")
+		filter, err := parseGrafanaFilter(target)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", target.Target, err))
+			return
+		}
+		filter.Since, filter.Until = req.Range.From, req.Range.To
+
+		series, err := s.store.RequestSeries(r.Context(), filter, strings.TrimSpace(metric), strings.TrimSpace(group), step)
+		if err != nil {
+			log.Printf("Error querying Grafana target %q: %v", target.Target, err)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", target.Target, err))
+			return
+		}
+		for _, ser := range series {
+			name := target.Target
+			if ser.Name != "" {
+				name = strings.TrimSpace(metric) + " " + ser.Name
+			}
+			points := make([][2]float64, 0, len(ser.Points))
+			for _, p := range ser.Points {
+				points = append(points, [2]float64{p.Value, float64(p.Time.UnixMilli())})
+			}
+			result = append(result, grafanaSeries{Target: name, Datapoints: points})
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseGrafanaFilter 读取 target 的 payload（或旧版 data）中的过滤条件
+func parseGrafanaFilter(target grafanaTarget) (storage.RequestFilter, error) {
+	raw := target.Payload
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		raw = target.Data
+	}
+	var gf grafanaFilter
+	if len(raw) > 0 && string(raw) != "null" && string(raw) != `""` {
+		if err := json.Unmarshal(raw, &gf); err != nil {
+			return storage.RequestFilter{}, fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+
+	f := storage.RequestFilter{
+		Model:    gf.Model,
+		LogType:  gf.LogType,
+		ClientIP: gf.ClientIP,
+		Host:     gf.Host,
+		Streamed: gf.Streamed,
+	}
+	if gf.Status != "" {
+		var err error
+		if f.Status, f.StatusClass, err = storage.ParseStatus(gf.Status); err != nil {
+			return storage.RequestFilter{}, err
+		}
+	}
+	return f, nil
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation,omitempty"`
+	Time       int64           `json:"time"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// handleGrafanaAnnotations POST /grafana/annotations
+// query 为 "admin" 时返回管理操作，否则按 "status=5xx model=..." 形式的条件返回匹配的请求
+func (s *Server) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Range      grafanaRange    `json:"range"`
+		Annotation json.RawMessage `json:"annotation"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid annotation query: "+err.Error())
+		return
+	}
+	var ann struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(req.Annotation, &ann)
+
+	result := []grafanaAnnotation{}
+	if strings.TrimSpace(ann.Query) == "admin" {
+		// 管理操作与 /admin 下的接口一样只对管理员 token 开放
+		if t, _ := r.Context().Value(identityKey{}).(config.APIToken); !allows(t.Role, roleAdmin) {
+			writeError(w, http.StatusForbidden, "admin annotations require an admin token")
+			return
+		}
+		actions, err := s.store.AdminActions(r.Context(), req.Range.From, req.Range.To, maxAnnotations)
+		if err != nil {
+			log.Printf("Error querying admin actions: %v", err)
+			writeError(w, http.StatusInternalServerError, "query failed")
+			return
+		}
+		for _, a := range actions {
+			result = append(result, grafanaAnnotation{
+				Annotation: req.Annotation,
+				Time:       a.Time.UnixMilli(),
+				Title:      fmt.Sprintf("%s %s", a.Method, a.Path),
+				Text:       fmt.Sprintf("by %s, status %d", a.TokenName, a.Status),
+				Tags:       []string{"admin", a.TokenName},
+			})
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	filter, err := parseAnnotationQuery(ann.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Since, filter.Until, filter.Limit = req.Range.From, req.Range.To, maxAnnotations
+
+	requests, err := s.store.SearchRequests(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error querying annotation requests: %v", err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	for _, rq := range requests {
+		result = append(result, grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       rq.Timestamp.UnixMilli(),
+			Title:      fmt.Sprintf("%d %s", rq.ResponseStatus, rq.Model),
+			Text:       fmt.Sprintf("%s %s %s", rq.RequestID, rq.Method, rq.URL),
+			Tags:       []string{rq.LogType, fmt.Sprint(rq.ResponseStatus)},
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseAnnotationQuery 解析 "key=value ..." 形式的标注查询
+func parseAnnotationQuery(query string) (storage.RequestFilter, error) {
+	var f storage.RequestFilter
+	for _, field := range strings.Fields(query) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return f, fmt.Errorf("invalid annotation query term: %s (use key=value)", field)
+		}
+		switch key {
+		case "model":
+			f.Model = value
+		case "status":
+			var err error
+			if f.Status, f.StatusClass, err = storage.ParseStatus(value); err != nil {
+				return f, err
+			}
+		case "log_type":
+			f.LogType = value
+		case "client_ip":
+			f.ClientIP = value
+		case "host":
+			f.Host = value
+		default:
+			return f, fmt.Errorf("unknown annotation query key: %s", key)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// HAR 1.2 格式（http://www.softwareishard.com/blog/har-12-spec/），只包含日志中能还原的字段
+
+type harLog struct {
+	Log harBody `json:"log"`
+}
+
+type harBody struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// handleExportHAR GET /api/v1/requests/{request_id}/har?log_type=
+// 将 API 日志（客户端请求及各次上游调用）导出为 HAR 文件
+func (s *Server) handleExportHAR(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("request_id")
+	logType := r.URL.Query().Get("log_type")
+
+	detail, err := s.store.GetRequest(r.Context(), requestID, false)
+	if err != nil {
+		log.Printf("Error getting request %s: %v", requestID, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+
+	har := harLog{Log: harBody{
+		Version: "1.2",
+		Creator: harCreator{Name: "cpa-logger"},
+		Entries: []harEntry{},
+	}}
+	for _, record := range detail.APILogs {
+		if logType != "" && record.LogType != logType {
+			continue
+		}
+		har.Log.Entries = append(har.Log.Entries, recordEntries(record)...)
+	}
+	if len(har.Log.Entries) == 0 {
+		writeError(w, http.StatusNotFound, "request not found")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", requestID+".har"))
+	writeJSON(w, http.StatusOK, har)
+}
+
+// recordEntries 一条 API 日志对应的 HAR 条目：客户端请求在前，上游调用按顺序在后
+func recordEntries(record storage.APILogRecord) []harEntry {
+	var headers, respHeaders map[string]string
+	json.Unmarshal(record.Headers, &headers)
+	json.Unmarshal(record.ResponseHeaders, &respHeaders)
+
+	entries := []harEntry{harExchange(
+		record.Timestamp, record.Method, clientURL(record.URL, headers), headers, record.RequestBody,
+		int(record.ResponseStatus), respHeaders, record.ResponseBody,
+		fmt.Sprintf("%s client request", record.LogType),
+	)}
+
+	var upstream []parser.UpstreamCall
+	json.Unmarshal(record.UpstreamRequests, &upstream)
+	for _, call := range upstream {
+		ts := call.Timestamp
+		if ts.IsZero() {
+			ts = record.Timestamp
+		}
+		entries = append(entries, harExchange(
+			ts, call.Method, call.URL, call.Headers, call.Body,
+			call.Status, call.RespHeaders, call.RespBody,
+			fmt.Sprintf("upstream call %d", call.Index),
+		))
+	}
+	return entries
+}
+
+// clientURL 客户端请求的 URL 只记录了路径时，用 Host 请求头补全
+func clientURL(raw string, headers map[string]string) string {
+	if strings.Contains(raw, "://") {
+		return raw
+	}
+	if host := headerValue(headers, "Host"); host != "" {
+		return "http://" + host + raw
+	}
+	return raw
+}
+
+func harExchange(ts time.Time, method, rawURL string, reqHeaders map[string]string, reqBody string,
+	status int, respHeaders map[string]string, respBody, comment string) harEntry {
+	req := harRequest{
+		Method:      method,
+		URL:         rawURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(reqHeaders),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(reqBody),
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: v})
+			}
+		}
+		sort.Slice(req.QueryString, func(i, j int) bool { return req.QueryString[i].Name < req.QueryString[j].Name })
+	}
+	if reqBody != "" {
+		req.PostData = &harPostData{MimeType: mimeType(reqHeaders), Text: reqBody}
+	}
+
+	return harEntry{
+		StartedDateTime: ts.Format(time.RFC3339Nano),
+		Request:         req,
+		Response: harResponse{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(respHeaders),
+			Content: harContent{
+				Size:     len(respBody),
+				MimeType: mimeType(respHeaders),
+				Text:     respBody,
+			},
+			HeadersSize: -1,
+			BodySize:    len(respBody),
+		},
+		Comment: comment,
+	}
+}
+
+// harHeaders 将请求头转为按名称排序的列表
+func harHeaders(headers map[string]string) []harNameValue {
+	list := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		list = append(list, harNameValue{Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// headerValue 不区分大小写读取请求头
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func mimeType(headers map[string]string) string {
+	if ct := headerValue(headers, "Content-Type"); ct != "" {
+		return ct
+	}
+	return "application/json"
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// 回放时单个事件间隔的上限，避免时间戳异常导致长时间等待
+const maxReplayDelay = 30 * time.Second
+
+// handleReplay GET /api/v1/requests/{request_id}/replay?log_type=&speed=&interval_ms=
+// 按事件逐个回放存储的流式响应。事件带有时间戳时按原始间隔（除以 speed）发送，
+// 否则按 interval_ms 间隔发送
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("request_id")
+	q := r.URL.Query()
+
+	speed := 1.0
+	if v := q.Get("speed"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid speed: "+v)
+			return
+		}
+		speed = f
+	}
+	var interval time.Duration
+	if v := q.Get("interval_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			writeError(w, http.StatusBadRequest, "invalid interval_ms: "+v)
+			return
+		}
+		interval = time.Duration(ms) * time.Millisecond
+	}
+
+	detail, err := s.store.GetRequest(r.Context(), requestID, false)
+	if err != nil {
+		log.Printf("Error getting request %s: %v", requestID, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	record := streamedRecord(detail.APILogs, q.Get("log_type"))
+	if record == nil {
+		writeError(w, http.StatusNotFound, "no streaming response for request")
+		return
+	}
+	events := parser.SSEEvents(record.ResponseBody)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var prev time.Time
+	for i, event := range events {
+		if i > 0 {
+			delay := interval
+			if !event.Time.IsZero() && !prev.IsZero() && event.Time.After(prev) {
+				delay = time.Duration(float64(event.Time.Sub(prev)) / speed)
+			}
+			if delay > maxReplayDelay {
+				delay = maxReplayDelay
+			}
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+		if !event.Time.IsZero() {
+			prev = event.Time
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\n\n", event.Raw); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// streamedRecord 选取响应为 SSE 的日志，可按日志类型筛选
+func streamedRecord(records []storage.APILogRecord, logType string) *storage.APILogRecord {
+	for i := range records {
+		rec := &records[i]
+		if logType != "" && rec.LogType != logType {
+			continue
+		}
+		if strings.Contains(rec.ResponseBody, "data:") {
+			return rec
+		}
+	}
+	return nil
+}
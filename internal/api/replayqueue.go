@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// handleListReplayQueue GET /admin/replay-queue?state=&limit= 列出重放队列中的请求及其最新状态
+func (s *Server) handleListReplayQueue(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := storage.ReplayFilter{State: q.Get("state"), Limit: defaultLimit}
+	switch f.State {
+	case "", storage.ReplayPending, storage.ReplaySucceeded, storage.ReplayFailed:
+	default:
+		writeError(w, http.StatusBadRequest, "unknown state: "+f.State)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit: "+v)
+			return
+		}
+		if f.Limit > maxLimit {
+			f.Limit = maxLimit
+		}
+	}
+
+	items, err := s.store.ReplayQueue(r.Context(), f)
+	if err != nil {
+		log.Printf("Error listing replay queue: %v", err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	if items == nil {
+		items = []storage.ReplayItem{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"requests": items})
+}
+
+// handleReplayQueue POST /admin/replay-queue/replay {"request_ids": [...], "limit": 100}
+// 后台重新发送失败的请求，未指定 request_ids 时按时间顺序重放待处理的请求
+func (s *Server) handleReplayQueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequestIDs []string `json:"request_ids"`
+		Limit      int      `json:"limit"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+
+	target := "pending"
+	if len(req.RequestIDs) > 0 {
+		target = strconv.Itoa(len(req.RequestIDs)) + " requests"
+	}
+	j := s.jobs.start("replay", target, func(ctx context.Context) ([]string, error) {
+		outcomes, err := s.replayer.Run(ctx, req.RequestIDs, req.Limit)
+		results := make([]string, len(outcomes))
+		for i, o := range outcomes {
+			results[i] = o.String()
+		}
+		return results, err
+	})
+	writeJSON(w, http.StatusAccepted, j)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// handleSearchRequests GET /api/v1/requests?model=&status=&log_type=&client_ip=&host=&streamed=&since=&until=&limit=
+func (s *Server) handleSearchRequests(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.RequestFilter{
+		Model:    q.Get("model"),
+		LogType:  q.Get("log_type"),
+		ClientIP: q.Get("client_ip"),
+		Host:     q.Get("host"),
+		Limit:    defaultLimit,
+	}
+
+	var err error
+	if v := q.Get("status"); v != "" {
+		if filter.Status, filter.StatusClass, err = storage.ParseStatus(v); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if v := q.Get("streamed"); v != "" {
+		streamed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid streamed: "+v)
+			return
+		}
+		filter.Streamed = &streamed
+	}
+	if filter.Since, err = parseTimeParam(q.Get("since")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Until, err = parseTimeParam(q.Get("until")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit: "+v)
+			return
+		}
+		if filter.Limit > maxLimit {
+			filter.Limit = maxLimit
+		}
+	}
+
+	results, err := s.store.SearchRequests(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error searching requests: %v", err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"requests": results})
+}
+
+// handleGetRequest GET /api/v1/requests/{request_id}
+func (s *Server) handleGetRequest(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("request_id")
+
+	// main 日志写入 VictoriaLogs 时 ClickHouse 中没有 main_logs
+	includeMain := s.cfg.MainLogSink == "clickhouse"
+	detail, err := s.store.GetRequest(r.Context(), requestID, includeMain)
+	if err != nil {
+		log.Printf("Error getting request %s: %v", requestID, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	if len(detail.APILogs) == 0 && len(detail.MainLogs) == 0 {
+		writeError(w, http.StatusNotFound, "request not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// parseTimeParam 解析时间参数：RFC3339 时间，或相对当前的时长（如 1h、30m）
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s (use RFC3339 or a duration like 1h)", v)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/k0ngk0ng/cpa-logger/internal/alert"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// handleListSearches GET /api/v1/searches 列出保存的查询及告警状态
+func (s *Server) handleListSearches(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"searches": s.alerts.List()})
+}
+
+// handleListSLOs GET /api/v1/slos 列出 SLO 的合规比例、剩余错误预算和 burn rate
+func (s *Server) handleListSLOs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"slos": s.alerts.SLOs()})
+}
+
+// handleRunSearch GET /api/v1/searches/{name}?limit= 执行保存的查询
+func (s *Server) handleRunSearch(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit: "+v)
+			return
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+
+	name := r.PathValue("name")
+	result, err := s.alerts.Run(r.Context(), name, limit)
+	if errors.Is(err, alert.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error running saved search %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handlePutSearch PUT /api/v1/searches/{name} 新建或更新保存的查询
+func (s *Server) handlePutSearch(w http.ResponseWriter, r *http.Request) {
+	var ss config.SavedSearch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ss); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	ss.Name = r.PathValue("name")
+
+	search, err := s.alerts.Save(ss)
+	switch {
+	case errors.Is(err, alert.ErrReadOnly):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, search)
+	}
+}
+
+// handleDeleteSearch DELETE /api/v1/searches/{name}
+func (s *Server) handleDeleteSearch(w http.ResponseWriter, r *http.Request) {
+	err := s.alerts.Delete(r.PathValue("name"))
+	switch {
+	case errors.Is(err, alert.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, alert.ErrReadOnly):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		log.Printf("Error deleting saved search: %v", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
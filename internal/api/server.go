@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/internal/alert"
+	"github.com/k0ngk0ng/cpa-logger/internal/replay"
+	"github.com/k0ngk0ng/cpa-logger/pkg/collector"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// Server REST 查询 API，内部工具无需 ClickHouse 账号即可查询采集的请求
+type Server struct {
+	cfg       *config.Config
+	store     *storage.ClickHouseStorage
+	collector *collector.Collector
+	alerts    *alert.Manager
+	// 未启用重放队列时为 nil
+	replayer *replay.Replayer
+	jobs     *jobRegistry
+	tokens   *tokenStore
+	http     *http.Server
+}
+
+// alerts 为 nil 时不启用保存的查询接口
+func NewServer(cfg *config.Config, store *storage.ClickHouseStorage, col *collector.Collector, alerts *alert.Manager) (*Server, error) {
+	tokens, err := newTokenStore(&cfg.API)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		store:     store,
+		collector: col,
+		alerts:    alerts,
+		jobs:      newJobRegistry(),
+		tokens:    tokens,
+	}
+	if cfg.ReplayQueue.Enabled {
+		s.replayer = replay.New(&cfg.ReplayQueue, store)
+	}
+
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.authorize(roleRead, h)
+	}
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.authorize(roleAdmin, s.audited(h))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/requests", read(s.handleSearchRequests))
+	mux.HandleFunc("GET /api/v1/requests/{request_id}", read(s.handleGetRequest))
+	mux.HandleFunc("GET /api/v1/requests/{request_id}/replay", read(s.handleReplay))
+	mux.HandleFunc("GET /api/v1/requests/{request_id}/har", read(s.handleExportHAR))
+	mux.HandleFunc("GET /api/v1/requests/{request_id}/diff", read(s.handleDiff))
+	mux.HandleFunc("GET /api/v1/billing", read(s.handleBilling))
+
+	// Grafana JSON datasource
+	mux.HandleFunc("GET /grafana/{$}", read(s.handleGrafanaTest))
+	mux.HandleFunc("POST /grafana/search", read(s.handleGrafanaSearch))
+	mux.HandleFunc("POST /grafana/metrics", read(s.handleGrafanaMetrics))
+	mux.HandleFunc("POST /grafana/query", read(s.handleGrafanaQuery))
+	mux.HandleFunc("POST /grafana/annotations", read(s.handleGrafanaAnnotations))
+
+	if alerts != nil {
+		mux.HandleFunc("GET /api/v1/searches", read(s.handleListSearches))
+		mux.HandleFunc("GET /api/v1/searches/{name}", read(s.handleRunSearch))
+		mux.HandleFunc("GET /api/v1/slos", read(s.handleListSLOs))
+	}
+
+	// 管理接口，未配置管理员 token 时不启用
+	if tokens.hasAdmin() {
+		mux.HandleFunc("POST /admin/backfill", admin(s.handleBackfill))
+		mux.HandleFunc("POST /admin/reprocess", admin(s.handleReprocess))
+		mux.HandleFunc("GET /admin/jobs/{id}", admin(s.handleGetJob))
+		mux.HandleFunc("GET /admin/config", admin(s.handleGetConfig))
+		mux.HandleFunc("PATCH /admin/config", admin(s.handlePatchConfig))
+		if s.replayer != nil {
+			mux.HandleFunc("GET /admin/replay-queue", admin(s.handleListReplayQueue))
+			mux.HandleFunc("POST /admin/replay-queue/replay", admin(s.handleReplayQueue))
+		}
+		if alerts != nil {
+			mux.HandleFunc("PUT /api/v1/searches/{name}", admin(s.handlePutSearch))
+			mux.HandleFunc("DELETE /api/v1/searches/{name}", admin(s.handleDeleteSearch))
+		}
+	}
+
+	s.http = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Serve 在指定地址上监听，阻塞直到 Stop 被调用
+func (s *Server) Serve(addr string) error {
+	s.http.Addr = addr
+	if err := s.http.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Stop 等待进行中的请求结束后关闭，取消进行中的管理任务并等待其结束
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+	s.jobs.stop(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,244 @@
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/internal/alert"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// 原始数据保留天数，与 api_logs 等数据表的 TTL 一致，用于估算过期释放的磁盘空间
+const retentionDays = 90
+
+// usage 每天的请求数和 token 数
+type usage struct {
+	requests float64
+	tokens   float64
+}
+
+// Forecaster 定时预测每日用量和磁盘占用，预计在 warn_days 内超限时告警
+type Forecaster struct {
+	cfg    *config.ForecastConfig
+	store  *storage.ClickHouseStorage
+	alerts *alert.Manager
+	// 超限中的检查项，键为 目标/指标
+	firing map[string]bool
+}
+
+// alerts 为 nil 时只记录日志
+func New(cfg *config.ForecastConfig, store *storage.ClickHouseStorage, alerts *alert.Manager) *Forecaster {
+	return &Forecaster{cfg: cfg, store: store, alerts: alerts, firing: make(map[string]bool)}
+}
+
+// check 某个目标的预测值及上限；cumulative 为 true 时按自然月累计
+type check struct {
+	target     string
+	metric     string
+	limit      float64
+	projection []float64
+	// 本月截至昨天的实际用量
+	monthToDate float64
+	cumulative  bool
+}
+
+// Run 拟合历史用量，写入未来 horizon_days 天的预测，并检查是否将超过磁盘或配额上限
+func (f *Forecaster) Run(ctx context.Context) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, 0, -max(f.cfg.HistoryDays, retentionDays))
+
+	volumes, err := f.store.DailyVolumes(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to query daily volumes: %w", err)
+	}
+	total := make(map[time.Time]usage)
+	quotas := make([]map[time.Time]usage, len(f.cfg.Quotas))
+	for i := range quotas {
+		quotas[i] = make(map[time.Time]usage)
+	}
+	for _, v := range volumes {
+		day := time.Date(v.Day.Year(), v.Day.Month(), v.Day.Day(), 0, 0, 0, 0, time.UTC)
+		u := usage{requests: float64(v.Requests), tokens: float64(v.Tokens)}
+		total[day] = add(total[day], u)
+		for i, q := range f.cfg.Quotas {
+			if strings.HasPrefix(v.Model, q.Model) {
+				quotas[i][day] = add(quotas[i][day], u)
+			}
+		}
+	}
+
+	var points []storage.ForecastPoint
+	var checks []check
+	record := func(metric, scope string, projection []float64) {
+		for d, v := range projection {
+			points = append(points, storage.ForecastPoint{Day: today.AddDate(0, 0, d), Metric: metric, Scope: scope, Value: v})
+		}
+	}
+
+	reqs := f.project(total, today, func(u usage) float64 { return u.requests })
+	if reqs == nil {
+		// 还没有历史数据
+		return nil
+	}
+	record("requests", "", reqs)
+	record("tokens", "", f.project(total, today, func(u usage) float64 { return u.tokens }))
+
+	for i, q := range f.cfg.Quotas {
+		for _, m := range []struct {
+			metric string
+			limit  uint64
+			value  func(usage) float64
+		}{
+			{"requests", q.Requests, func(u usage) float64 { return u.requests }},
+			{"tokens", q.Tokens, func(u usage) float64 { return u.tokens }},
+		} {
+			if m.limit == 0 {
+				continue
+			}
+			projection := f.project(quotas[i], today, m.value)
+			if projection == nil {
+				continue
+			}
+			record(m.metric, q.Name, projection)
+			c := check{target: q.Name, metric: m.metric, limit: float64(m.limit), projection: projection}
+			if q.Period == "month" {
+				c.cumulative = true
+				for day := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC); day.Before(today); day = day.AddDate(0, 0, 1) {
+					c.monthToDate += m.value(quotas[i][day])
+				}
+			}
+			checks = append(checks, c)
+		}
+	}
+
+	if disk, limit, err := f.projectDisk(ctx, total, today, reqs); err != nil {
+		return fmt.Errorf("failed to query disk usage: %w", err)
+	} else if disk != nil {
+		record("disk_bytes", "", disk)
+		checks = append(checks, check{target: "disk", metric: "disk_bytes", limit: limit, projection: disk})
+	}
+
+	if err := f.store.WriteForecast(ctx, today, points); err != nil {
+		return fmt.Errorf("failed to write forecast: %w", err)
+	}
+
+	for _, c := range checks {
+		f.evaluate(c, today, now)
+	}
+	return nil
+}
+
+// project 按历史数据预测从 today 开始 horizon_days 天的每日值，没有历史数据时返回 nil
+// 历史从窗口内第一天有数据的日期到昨天，今天的数据不完整不参与拟合
+func (f *Forecaster) project(series map[time.Time]usage, today time.Time, value func(usage) float64) []float64 {
+	start := today
+	for day := today.AddDate(0, 0, -f.cfg.HistoryDays); day.Before(today); day = day.AddDate(0, 0, 1) {
+		if _, ok := series[day]; ok {
+			start = day
+			break
+		}
+	}
+	if !start.Before(today) {
+		return nil
+	}
+	var history []float64
+	for day := start; day.Before(today); day = day.AddDate(0, 0, 1) {
+		history = append(history, value(series[day]))
+	}
+
+	model := Fit(start, history)
+	projection := make([]float64, f.cfg.HorizonDays)
+	for d := range projection {
+		projection[d] = model.At(today.AddDate(0, 0, d))
+	}
+	return projection
+}
+
+// projectDisk 按每个客户端请求占用的平均磁盘空间预测每天结束时的磁盘占用，
+// 新增数据按预测请求数估算，并扣除 TTL 过期释放的数据；还没有数据时返回 nil
+func (f *Forecaster) projectDisk(ctx context.Context, total map[time.Time]usage, today time.Time, reqs []float64) ([]float64, float64, error) {
+	used, free, err := f.store.DiskUsage(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var retained float64
+	for day := today.AddDate(0, 0, -retentionDays); !day.After(today); day = day.AddDate(0, 0, 1) {
+		retained += total[day].requests
+	}
+	if used == 0 || retained == 0 {
+		return nil, 0, nil
+	}
+	limit := float64(used + free)
+	if f.cfg.DiskLimitGB > 0 {
+		limit = f.cfg.DiskLimitGB * 1e9
+	}
+
+	perRequest := float64(used) / retained
+	disk := make([]float64, len(reqs))
+	bytes := float64(used)
+	for d, r := range reqs {
+		day := today.AddDate(0, 0, d)
+		bytes += (r - total[day.AddDate(0, 0, -retentionDays)].requests) * perRequest
+		disk[d] = max(0, bytes)
+	}
+	return disk, limit, nil
+}
+
+// evaluate 检查 warn_days 内是否超限，状态变化时记录日志并发送通知
+func (f *Forecaster) evaluate(c check, today, now time.Time) {
+	var exceedsOn *time.Time
+	var projected float64
+	cum := c.monthToDate
+	for d := 0; d < f.cfg.WarnDays && d < len(c.projection); d++ {
+		day := today.AddDate(0, 0, d)
+		v := c.projection[d]
+		if c.cumulative {
+			if day.Day() == 1 {
+				cum = 0
+			}
+			cum += v
+			v = cum
+		}
+		if v > c.limit {
+			exceedsOn = &day
+			projected = v
+			break
+		}
+	}
+
+	key := c.target + "/" + c.metric
+	firing := exceedsOn != nil
+	if firing == f.firing[key] {
+		return
+	}
+	f.firing[key] = firing
+
+	w := alert.CapacityWarning{
+		Target:    c.target,
+		State:     "resolved",
+		Metric:    c.metric,
+		Limit:     c.limit,
+		Projected: projected,
+		ExceedsOn: exceedsOn,
+		Time:      now,
+	}
+	if firing {
+		w.State = "firing"
+		log.Printf("Capacity forecast: %s %s projected to exceed %.0f on %s (projected %.0f)",
+			c.target, c.metric, c.limit, exceedsOn.Format("2006-01-02"), projected)
+	} else {
+		log.Printf("Capacity forecast: %s %s no longer projected to exceed %.0f", c.target, c.metric, c.limit)
+	}
+	if f.alerts != nil && len(f.cfg.Channels) > 0 {
+		f.alerts.NotifyCapacity(f.cfg.Channels, w)
+	}
+}
+
+func add(a, b usage) usage {
+	return usage{requests: a.requests + b.requests, tokens: a.tokens + b.tokens}
+}
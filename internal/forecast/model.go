@@ -0,0 +1,82 @@
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+// 计算季节因子所需的最少历史天数
+const minSeasonalDays = 14
+
+// Model 每日用量预测模型：线性趋势乘以按星期的季节因子
+type Model struct {
+	start     time.Time
+	intercept float64
+	slope     float64
+	seasonal  [7]float64
+}
+
+// Fit 拟合从 start 开始每天一个值的历史数据
+func Fit(start time.Time, values []float64) Model {
+	m := Model{start: start}
+	for i := range m.seasonal {
+		m.seasonal[i] = 1
+	}
+	if len(values) == 0 {
+		return m
+	}
+
+	// 最小二乘线性趋势
+	n := float64(len(values))
+	var sx, sy, sxx, sxy float64
+	for i, v := range values {
+		x := float64(i)
+		sx += x
+		sy += v
+		sxx += x * x
+		sxy += x * v
+	}
+	if d := n*sxx - sx*sx; d != 0 {
+		m.slope = (n*sxy - sx*sy) / d
+	}
+	m.intercept = (sy - m.slope*sx) / n
+	if len(values) < minSeasonalDays {
+		return m
+	}
+
+	// 季节因子为各星期几的实际值与趋势值之比的均值，归一化为平均 1
+	var sum [7]float64
+	var count [7]int
+	for i, v := range values {
+		trend := m.trend(i)
+		if trend <= 0 {
+			continue
+		}
+		wd := start.AddDate(0, 0, i).Weekday()
+		sum[wd] += v / trend
+		count[wd]++
+	}
+	var total float64
+	for wd := range m.seasonal {
+		if count[wd] > 0 {
+			m.seasonal[wd] = sum[wd] / float64(count[wd])
+		}
+		total += m.seasonal[wd]
+	}
+	if total > 0 {
+		for wd := range m.seasonal {
+			m.seasonal[wd] *= 7 / total
+		}
+	}
+	return m
+}
+
+func (m Model) trend(i int) float64 {
+	return m.intercept + m.slope*float64(i)
+}
+
+// At 预测某天的值，不小于 0
+func (m Model) At(day time.Time) float64 {
+	i := int(math.Round(day.Sub(m.start).Hours() / 24))
+	return max(0, m.trend(i)*m.seasonal[day.Weekday()])
+}
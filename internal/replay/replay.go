@@ -0,0 +1,166 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// Header 重放请求携带的请求头，值为原 request_id；代理记录的重放请求不会再次加入队列
+const Header = "X-Cpa-Replay"
+
+// 读取响应体的上限，流式响应读完后才算重放结束
+const maxResponseBytes = 64 << 20
+
+// 不随重放请求转发的请求头
+var skipHeaders = map[string]bool{
+	"host":              true,
+	"content-length":    true,
+	"connection":        true,
+	"keep-alive":        true,
+	"transfer-encoding": true,
+	"accept-encoding":   true,
+	"upgrade":           true,
+}
+
+// Outcome 单个请求的重放结果
+type Outcome struct {
+	RequestID string `json:"request_id"`
+	LogType   string `json:"log_type"`
+	State     string `json:"state"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (o Outcome) String() string {
+	s := fmt.Sprintf("%s %s: %s", o.RequestID, o.LogType, o.State)
+	if o.Status != 0 {
+		s += fmt.Sprintf(" (%d)", o.Status)
+	}
+	if o.Error != "" {
+		s += ": " + o.Error
+	}
+	return s
+}
+
+// Replayer 将 replay_queue 中的失败请求重新发送到 target_url 并记录结果
+type Replayer struct {
+	cfg    *config.ReplayQueueConfig
+	store  *storage.ClickHouseStorage
+	client *http.Client
+}
+
+func New(cfg *config.ReplayQueueConfig, store *storage.ClickHouseStorage) *Replayer {
+	return &Replayer{
+		cfg:    cfg,
+		store:  store,
+		client: &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+	}
+}
+
+// Run 按请求时间顺序重放最多 limit 个待处理请求；指定 requestIDs 时重放这些请求（已成功的除外）
+func (r *Replayer) Run(ctx context.Context, requestIDs []string, limit int) ([]Outcome, error) {
+	f := storage.ReplayFilter{RequestIDs: requestIDs, Limit: limit}
+	if len(requestIDs) == 0 {
+		f.State = storage.ReplayPending
+	}
+	items, err := r.store.ReplayQueue(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay queue: %w", err)
+	}
+
+	var outcomes []Outcome
+	for _, item := range items {
+		if item.State == storage.ReplaySucceeded {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return outcomes, err
+		}
+		outcome := r.replay(ctx, &item)
+		if err := r.store.RecordReplay(ctx, item); err != nil {
+			return outcomes, fmt.Errorf("failed to record replay of %s: %w", item.RequestID, err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, nil
+}
+
+// replay 发送一次请求并更新 item 的状态
+func (r *Replayer) replay(ctx context.Context, item *storage.ReplayItem) Outcome {
+	status, err := r.send(ctx, item)
+	now := time.Now()
+	item.Attempts++
+	item.ReplayedAt = &now
+	item.LastStatus = uint16(status)
+	item.LastError = ""
+	if err != nil {
+		item.LastError = err.Error()
+	}
+
+	switch {
+	case err == nil && status/100 == 2:
+		item.State = storage.ReplaySucceeded
+	case (err != nil || r.cfg.Retryable(status)) && int(item.Attempts) < r.cfg.MaxAttempts:
+		item.State = storage.ReplayPending
+	default:
+		item.State = storage.ReplayFailed
+	}
+	return Outcome{RequestID: item.RequestID, LogType: item.LogType, State: item.State, Status: status, Error: item.LastError}
+}
+
+func (r *Replayer) send(ctx context.Context, item *storage.ReplayItem) (int, error) {
+	target, err := targetURL(r.cfg.TargetURL, item.URL)
+	if err != nil {
+		return 0, err
+	}
+	method := item.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(item.RequestBody))
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range item.Headers {
+		if !skipHeaders[strings.ToLower(k)] {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range r.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(Header, item.RequestID)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes)); err != nil {
+		return resp.StatusCode, fmt.Errorf("reading response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// targetURL 将原请求的路径和查询参数拼接到重放目标地址
+func targetURL(base, original string) (string, error) {
+	u, err := url.Parse(original)
+	if err != nil {
+		return "", fmt.Errorf("invalid request url %q: %w", original, err)
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid replay_queue.target_url: %w", err)
+	}
+	b.Path = strings.TrimSuffix(b.Path, "/") + u.Path
+	b.RawQuery = u.RawQuery
+	return b.String(), nil
+}
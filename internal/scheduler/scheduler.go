@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// 单次任务执行的超时时间
+const jobTimeout = 10 * time.Minute
+
+// Job 定时执行的后台任务
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// 启动后是否立即执行一次
+	RunOnStart bool
+	Run        func(ctx context.Context) error
+}
+
+// Scheduler 按固定间隔执行后台任务（汇总、检测等），各任务独立运行，上一次未结束时跳过
+type Scheduler struct {
+	jobs []Job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel}
+}
+
+// Add 注册任务，须在 Start 之前调用
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Stop 取消进行中的任务并等待退出
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(job Job) {
+	defer s.wg.Done()
+
+	if job.RunOnStart {
+		s.run(job)
+	}
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.run(job)
+		}
+	}
+}
+
+func (s *Scheduler) run(job Job) {
+	ctx, cancel := context.WithTimeout(s.ctx, jobTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		if s.ctx.Err() == nil {
+			log.Printf("Job %s failed after %v: %v", job.Name, time.Since(start).Round(time.Millisecond), err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName gRPC 服务名，方法: /cpalogger.v1.LogStream/Subscribe
+const ServiceName = "cpalogger.v1.LogStream"
+
+// 消息使用 JSON 编码，客户端需指定 content-subtype "json"
+// （grpc-go: grpc.CallContentSubtype("json")）
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// logStreamServer 供 ServiceDesc 做类型检查的服务接口
+type logStreamServer interface {
+	subscribe(filter *Filter, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*logStreamServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       subscribeHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	filter := new(Filter)
+	if err := stream.RecvMsg(filter); err != nil {
+		return err
+	}
+	return srv.(logStreamServer).subscribe(filter, stream)
+}
+
+// Server gRPC 订阅服务
+type Server struct {
+	hub  *Hub
+	grpc *grpc.Server
+}
+
+// NewServer token 不为空时订阅方须在 authorization 元数据中带 "Bearer <token>"
+func NewServer(hub *Hub, token string) *Server {
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts, grpc.StreamInterceptor(tokenInterceptor(token)))
+	}
+	s := &Server{
+		hub:  hub,
+		grpc: grpc.NewServer(opts...),
+	}
+	s.grpc.RegisterService(&serviceDesc, s)
+	return s
+}
+
+// tokenInterceptor 校验 authorization 元数据中的 Bearer token
+func tokenInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		for _, v := range md.Get("authorization") {
+			if got, ok := strings.CutPrefix(v, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return handler(srv, ss)
+			}
+		}
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+}
+
+// Serve 在指定地址上监听，阻塞直到 Stop 被调用
+func (s *Server) Serve(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.grpc.Serve(lis)
+}
+
+// Stop 立即断开所有订阅（订阅流不会自行结束，不能用 GracefulStop）
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+func (s *Server) subscribe(filter *Filter, stream grpc.ServerStream) error {
+	records, cancel := s.hub.Subscribe(*filter)
+	defer cancel()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case r, ok := <-records:
+			if !ok {
+				return nil
+			}
+			if err := stream.SendMsg(r); err != nil {
+				return err
+			}
+		}
+	}
+}
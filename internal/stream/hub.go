@@ -0,0 +1,190 @@
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// Record 推送给订阅方的一条已解析记录
+type Record struct {
+	LogType string                 `json:"log_type"`
+	LogFile string                 `json:"log_file"`
+	Model   string                 `json:"model,omitempty"`
+	Status  int                    `json:"status,omitempty"`
+	Main    *parser.MainLogEntry   `json:"main,omitempty"`
+	API     *parser.APILogEntry    `json:"api,omitempty"`
+	Event   map[string]interface{} `json:"event,omitempty"`
+	// 自定义 json_lines 类型的一行
+	JSONLine *parser.JSONLineRecord `json:"json_line,omitempty"`
+}
+
+// Filter 订阅方的服务端过滤条件，空字段表示不过滤
+type Filter struct {
+	LogTypes  []string `json:"log_types,omitempty"`
+	Models    []string `json:"models,omitempty"`
+	Statuses  []int    `json:"statuses,omitempty"`
+	MinStatus int      `json:"min_status,omitempty"`
+	MaxStatus int      `json:"max_status,omitempty"`
+}
+
+// Match 判断记录是否满足过滤条件
+func (f *Filter) Match(r *Record) bool {
+	if len(f.LogTypes) > 0 && !containsString(f.LogTypes, r.LogType) {
+		return false
+	}
+	if len(f.Models) > 0 && !containsString(f.Models, r.Model) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !containsInt(f.Statuses, r.Status) {
+		return false
+	}
+	if f.MinStatus > 0 && r.Status < f.MinStatus {
+		return false
+	}
+	if f.MaxStatus > 0 && r.Status > f.MaxStatus {
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	ch     chan *Record
+}
+
+// Hub 将采集到的记录分发给所有订阅方
+type Hub struct {
+	mu         sync.RWMutex
+	subs       map[*subscriber]struct{}
+	bufferSize int
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	return &Hub{
+		subs:       make(map[*subscriber]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscribe 注册订阅，返回记录通道和取消函数
+func (h *Hub) Subscribe(filter Filter) (<-chan *Record, func()) {
+	sub := &subscriber{
+		filter: filter,
+		ch:     make(chan *Record, h.bufferSize),
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, sub)
+			h.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+	return sub.ch, cancel
+}
+
+// Publish 分发一条记录，订阅方缓冲区已满时丢弃，不阻塞采集
+func (h *Hub) Publish(r *Record) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if !sub.filter.Match(r) {
+			continue
+		}
+		select {
+		case sub.ch <- r:
+		default:
+		}
+	}
+}
+
+// PublishMainLogs 分发 main 日志条目
+func (h *Hub) PublishMainLogs(entries []parser.MainLogEntry, logFile string) {
+	for i := range entries {
+		h.Publish(&Record{
+			LogType: string(parser.LogTypeMain),
+			LogFile: logFile,
+			Status:  entries[i].StatusCode,
+			Main:    &entries[i],
+		})
+	}
+}
+
+// PublishAPILog 分发 API 日志条目
+func (h *Hub) PublishAPILog(entry *parser.APILogEntry, logFile string) {
+	h.Publish(&Record{
+		LogType: string(entry.LogType),
+		LogFile: logFile,
+		Model:   requestModel(entry.RequestBody),
+		Status:  entry.ResponseStatus,
+		API:     entry,
+	})
+}
+
+// PublishEventBatch 分发事件日志，每个事件一条记录
+func (h *Hub) PublishEventBatch(entry *parser.EventBatchEntry, logFile string) {
+	for _, evt := range entry.Events {
+		var model string
+		if eventData, ok := evt["event_data"].(map[string]interface{}); ok {
+			model, _ = eventData["model"].(string)
+		}
+		h.Publish(&Record{
+			LogType: string(parser.LogTypeEventBatch),
+			LogFile: logFile,
+			Model:   model,
+			Event:   evt,
+		})
+	}
+}
+
+// PublishJSONLines 分发自定义 json_lines 类型的记录，每行一条记录
+func (h *Hub) PublishJSONLines(logType parser.LogType, records []parser.JSONLineRecord, logFile string) {
+	for i := range records {
+		h.Publish(&Record{
+			LogType:  string(logType),
+			LogFile:  logFile,
+			JSONLine: &records[i],
+		})
+	}
+}
+
+// requestModel 从请求体中提取 model 字段
+func requestModel(body string) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal([]byte(body), &req) != nil {
+		return ""
+	}
+	return req.Model
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Backfill 补采指定路径的日志：目录（递归）、单个 .log 文件，
+// 或 .tar/.tar.gz/.tgz/.zip 归档（在内存中逐条读取，不解压到磁盘）
+// ctx 取消后不再处理后续文件，返回 ctx 的错误
+func (c *Collector) Backfill(ctx context.Context, root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	// 返回前等待异步写入完成
+	defer c.waitWrites()
+	if !info.IsDir() {
+		return c.backfillFile(ctx, root)
+	}
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if err := c.backfillFile(ctx, p); err != nil {
+			log.Printf("Error backfilling %s: %v", p, err)
+		}
+		return nil
+	})
+}
+
+func (c *Collector) backfillFile(ctx context.Context, p string) error {
+	switch {
+	case isZipArchive(p):
+		return c.ingestZip(ctx, p)
+	case isTarArchive(p):
+		return c.ingestTar(ctx, p)
+	case strings.HasSuffix(p, ".log"):
+		c.processFile(ctx, p, nil)
+	}
+	return nil
+}
+
+func isZipArchive(p string) bool {
+	return strings.HasSuffix(p, ".zip")
+}
+
+func isTarArchive(p string) bool {
+	return strings.HasSuffix(p, ".tar") || strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// archiveEntryPath 归档条目在 processed_files 等表中记录的路径
+func archiveEntryPath(archive, name string) string {
+	return archive + "!/" + name
+}
+
+// ingestTar 逐条读取 tar（可 gzip 压缩）归档中的日志
+func (c *Collector) ingestTar(ctx context.Context, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(p, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	log.Printf("Backfilling archive: %s", p)
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".log") {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		c.ingest(ctx, &logSource{
+			path:    archiveEntryPath(p, path.Clean(hdr.Name)),
+			size:    hdr.Size,
+			modTime: hdr.ModTime,
+			data:    data,
+		})
+	}
+}
+
+// ingestZip 逐条读取 zip 归档中的日志
+func (c *Collector) ingestZip(ctx context.Context, p string) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	log.Printf("Backfilling archive: %s", p)
+	for _, zf := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if zf.FileInfo().IsDir() || !strings.HasSuffix(zf.Name, ".log") {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", zf.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", zf.Name, err)
+		}
+		c.ingest(ctx, &logSource{
+			path:    archiveEntryPath(p, path.Clean(zf.Name)),
+			size:    int64(zf.UncompressedSize64),
+			modTime: zf.Modified,
+			data:    data,
+		})
+	}
+	return nil
+}
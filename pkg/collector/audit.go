@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// beginAudit 开始记录一次文件处理尝试
+func (c *Collector) beginAudit(filePath, logType string, size int64) *storage.IngestAudit {
+	c.auditMu.Lock()
+	retries := c.attempts[filePath]
+	c.auditMu.Unlock()
+
+	return &storage.IngestAudit{
+		FilePath:   filePath,
+		LogType:    logType,
+		StartTime:  time.Now(),
+		Bytes:      uint64(size),
+		RetryCount: uint32(retries),
+	}
+}
+
+// failAudit 记录失败原因
+func failAudit(a *storage.IngestAudit, outcome string, err error) {
+	a.Outcome = outcome
+	a.Error = err.Error()
+}
+
+// finishAudit 写入审计记录，失败的尝试计入该文件的重试次数
+func (c *Collector) finishAudit(a *storage.IngestAudit) {
+	a.EndTime = time.Now()
+
+	c.auditMu.Lock()
+	switch a.Outcome {
+	case "success", "incomplete", "sampled_out":
+		delete(c.attempts, a.FilePath)
+	default:
+		c.attempts[a.FilePath]++
+	}
+	c.auditMu.Unlock()
+
+	auditor, ok := storage.As[storage.IngestAuditor](c.storage)
+	if !ok {
+		return
+	}
+	// 处理本身可能已超时，审计使用独立的 context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := auditor.InsertIngestAudit(ctx, a); err != nil {
+		log.Printf("Error inserting ingest audit: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// 背压指标，通过 /debug/vars 暴露
+var (
+	backpressureStats  = expvar.NewMap("backpressure")
+	backpressurePauses = new(expvar.Int)
+	// main 日志批次大小的缩小倍数，1 表示未缩小
+	backpressureShrink = new(expvar.Int)
+)
+
+func init() {
+	backpressureStats.Set("pauses", backpressurePauses)
+	backpressureStats.Set("batch_shrink", backpressureShrink)
+	backpressureShrink.Set(1)
+}
+
+// 批次大小最多缩小的倍数
+const maxBatchShrink = 64
+
+// backpressure ClickHouse 资源不足时的退避状态：暂停处理新文件，并缩小 main 日志批次
+type backpressure struct {
+	cfg config.BackpressureConfig
+
+	mu sync.Mutex
+	// 当前退避时间，为 0 表示最近一次写入成功
+	delay time.Duration
+	until time.Time
+	// 批次大小的缩小倍数
+	shrink int
+}
+
+func newBackpressure(cfg config.BackpressureConfig) *backpressure {
+	return &backpressure{cfg: cfg, shrink: 1}
+}
+
+// failed 记录一次资源错误，延长暂停时间并将批次缩小一半
+// 暂停期间失败的写入（暂停前已开始）不再延长暂停
+func (b *backpressure) failed(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.until) {
+		return
+	}
+	if b.delay == 0 {
+		b.delay = time.Duration(b.cfg.MinBackoffSeconds) * time.Second
+	} else {
+		b.delay = min(b.delay*2, time.Duration(b.cfg.MaxBackoffSeconds)*time.Second)
+	}
+	b.until = now.Add(b.delay)
+	b.shrink = min(b.shrink*2, maxBatchShrink)
+	backpressurePauses.Add(1)
+	backpressureShrink.Set(int64(b.shrink))
+	log.Printf("ClickHouse is short of resources (%v), pausing ingestion for %s", err, b.delay)
+}
+
+// succeeded 记录一次成功的写入：结束退避，批次大小每次成功恢复一倍
+func (b *backpressure) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.delay > 0 {
+		b.delay = 0
+		log.Println("ClickHouse inserts succeeded, resuming ingestion")
+	}
+	if b.shrink > 1 {
+		b.shrink /= 2
+		backpressureShrink.Set(int64(b.shrink))
+	}
+}
+
+// wait 暂停期间阻塞，ctx 取消时返回 false
+func (b *backpressure) wait(ctx context.Context) bool {
+	b.mu.Lock()
+	pause := time.Until(b.until)
+	b.mu.Unlock()
+	if pause <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(pause)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// batchSize 按当前缩小倍数调整批次大小，不小于 min_batch_size（配置的批次更小时保持不变）
+func (b *backpressure) batchSize(n int) int {
+	b.mu.Lock()
+	shrink := b.shrink
+	b.mu.Unlock()
+	return min(n, max(n/shrink, b.cfg.MinBatchSize))
+}
@@ -0,0 +1,778 @@
+// Package collector 监控日志目录（及可选的 S3 来源），解析新文件并写入存储，记录已处理的文件
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// MainLogWriter main 日志写入目标
+type MainLogWriter interface {
+	InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error
+}
+
+// Publisher 接收写入成功的日志，用于实时分发（如 gRPC 订阅）
+type Publisher interface {
+	PublishMainLogs(entries []parser.MainLogEntry, logFile string)
+	PublishAPILog(entry *parser.APILogEntry, logFile string)
+	PublishEventBatch(entry *parser.EventBatchEntry, logFile string)
+	PublishJSONLines(logType parser.LogType, records []parser.JSONLineRecord, logFile string)
+}
+
+type Collector struct {
+	cfg     *config.Config
+	storage storage.Storage
+	// main 日志写入目标，默认为 storage
+	mainLogs MainLogWriter
+	// 实时订阅分发，未启用时为 nil
+	hub     Publisher
+	watcher *fsnotify.Watcher
+	// 采集生命周期，Stop 或 Start 传入的 ctx 取消后结束，不再处理新文件
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Stop 的 ctx 到期后取消，中断仍在进行的写入
+	writes      context.Context
+	abortWrites context.CancelFunc
+	wg          sync.WaitGroup
+	// 未写完文件的复查状态
+	rechecks  map[string]*recheckState
+	recheckMu sync.Mutex
+	// 待处理文件队列
+	queue *fileQueue
+	// 异步写入，未启用时为 nil
+	pipeline *insertPipeline
+	// ClickHouse 资源不足时的退避，未启用时为 nil
+	backpressure *backpressure
+	// 可在运行时调整的配置
+	runtime *runtimeState
+	// S3 日志来源，未启用时为 nil
+	s3 *s3Source
+	// 正在等待重建的目录
+	rewatching map[string]bool
+	rewatchMu  sync.Mutex
+	// 各文件失败的处理次数，写入审计记录
+	attempts map[string]int
+	auditMu  sync.Mutex
+	// 存储的健康状态，未设置时为 nil
+	health *storageHealth
+}
+
+// New 创建采集器，hub 为 nil 时不分发写入的日志
+func New(cfg *config.Config, store storage.Storage, hub Publisher) (*Collector, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var mainLogs MainLogWriter = store
+	// 离线模式下 main 日志也写入 spool
+	if cfg.MainLogSink == "victorialogs" && cfg.Storage.Type != "spool" {
+		mainLogs, err = storage.NewVictoriaLogsStorage(&cfg.VictoriaLogs, storage.Labels{Host: cfg.Host, Instance: cfg.Instance})
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.Queue.OverflowPolicy == "spill" {
+		if err := os.MkdirAll(cfg.Queue.SpillDir, 0755); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	var s3 *s3Source
+	if cfg.S3Source.Enabled {
+		s3, err = newS3Source(&cfg.S3Source)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	var pipeline *insertPipeline
+	if cfg.Queue.InsertWriters > 0 {
+		pipeline = newInsertPipeline(cfg.Queue.InsertWriters, cfg.Queue.MaxInFlightBatches)
+	}
+
+	var bp *backpressure
+	if cfg.Backpressure.Enabled {
+		bp = newBackpressure(cfg.Backpressure)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writes, abortWrites := context.WithCancel(context.Background())
+	return &Collector{
+		pipeline:     pipeline,
+		backpressure: bp,
+		cfg:          cfg,
+		storage:      store,
+		mainLogs:     mainLogs,
+		hub:          hub,
+		watcher:      watcher,
+		ctx:          ctx,
+		cancel:       cancel,
+		writes:       writes,
+		abortWrites:  abortWrites,
+		rechecks:     make(map[string]*recheckState),
+		queue:        newFileQueue(cfg.Queue, ctx.Done()),
+		s3:           s3,
+		runtime:      newRuntimeState(cfg),
+		attempts:     make(map[string]int),
+		rewatching:   make(map[string]bool),
+	}, nil
+}
+
+// Start 处理现有文件并开始监控目录，ctx 取消时停止处理新文件（与 Stop 相同，但不等待退出）
+func (c *Collector) Start(ctx context.Context) error {
+	context.AfterFunc(ctx, c.cancel)
+	c.startWorkers()
+
+	// 首先处理现有文件
+	log.Println("Processing existing log files...")
+	if err := c.processExistingFiles(); err != nil {
+		log.Printf("Warning: error processing existing files: %v", err)
+	}
+
+	// 添加目录监控
+	for _, dir := range c.logDirs() {
+		if err := c.watcher.Add(dir); err != nil {
+			log.Printf("Error watching directory %s: %v", dir, err)
+			c.rewatch(dir)
+			continue
+		}
+		log.Printf("Watching directory: %s", dir)
+	}
+
+	// 启动文件监控
+	c.wg.Add(1)
+	go c.watchLoop()
+
+	if c.s3 != nil {
+		c.wg.Add(1)
+		go c.s3Loop()
+	}
+
+	if c.health != nil {
+		c.wg.Add(1)
+		go c.deferredDeleteLoop()
+	}
+
+	return nil
+}
+
+// Stop 停止处理新文件，等待处理中的文件写完后关闭存储连接；
+// ctx 到期后中断仍在进行的写入（未标记已处理的文件下次启动时重新处理），并返回 ctx 的错误
+func (c *Collector) Stop(ctx context.Context) error {
+	c.cancel()
+	c.watcher.Close()
+	abort := context.AfterFunc(ctx, c.abortWrites)
+	defer abort()
+
+	c.wg.Wait()
+	// 处理协程退出后写完已提交的批次
+	if c.pipeline != nil {
+		c.pipeline.close()
+	}
+	c.storage.Close()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("collector stopped before pending writes finished: %w", err)
+	}
+	log.Println("Collector stopped")
+	return nil
+}
+
+func (c *Collector) processExistingFiles() error {
+	for _, dir := range c.logDirs() {
+		c.scanDir(dir)
+	}
+	return nil
+}
+
+// scanDir 将目录下的日志文件加入处理队列
+func (c *Collector) scanDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: error reading directory %s: %v", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+
+		filePath := filepath.Join(dir, entry.Name())
+		c.queue.enqueue(filePath, 0)
+	}
+}
+
+// logDirs 需要监控的目录：log_dir 及 log_type_dirs 中配置的目录
+func (c *Collector) logDirs() []string {
+	dirs := []string{c.cfg.LogDir}
+	seen := map[string]bool{filepath.Clean(c.cfg.LogDir): true}
+	for dir := range c.cfg.LogTypeDirPaths() {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs[1:])
+	return dirs
+}
+
+func (c *Collector) watchLoop() {
+	defer c.wg.Done()
+
+	// 防止重复处理的去重 map
+	recentlyProcessed := make(map[string]time.Time)
+	var mu sync.Mutex
+
+	// 定期清理去重 map
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	// 定期检查目录监控是否丢失
+	watchCheck := time.NewTicker(watchCheckInterval)
+	defer watchCheck.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+
+			// 日志目录被删除或移走（如代理重新部署），等待目录重建后重新监控
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && c.isLogDir(event.Name) {
+				c.rewatch(event.Name)
+				continue
+			}
+
+			// 只处理创建和写入事件
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+
+			// 只处理 .log 文件
+			if !strings.HasSuffix(event.Name, ".log") {
+				continue
+			}
+
+			// 去重：避免短时间内重复处理同一文件
+			mu.Lock()
+			lastProcessed, exists := recentlyProcessed[event.Name]
+			if exists && time.Since(lastProcessed) < 2*time.Second {
+				mu.Unlock()
+				continue
+			}
+			recentlyProcessed[event.Name] = time.Now()
+			mu.Unlock()
+
+			// 延迟处理，确保文件写入完成；队列满时按 overflow_policy 处理
+			c.queue.enqueue(event.Name, 500*time.Millisecond)
+
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+
+		case <-watchCheck.C:
+			c.checkWatches()
+
+		case <-ticker.C:
+			// 清理超过 10 分钟的去重记录
+			mu.Lock()
+			cutoff := time.Now().Add(-10 * time.Minute)
+			for k, v := range recentlyProcessed {
+				if v.Before(cutoff) {
+					delete(recentlyProcessed, k)
+				}
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// processFile 处理单个日志文件，返回文件是否被解析（已处理或未启用时返回 false）
+// onDone 同 logSource.onDone，可为 nil
+func (c *Collector) processFile(ctx context.Context, filePath string, onDone func(ok bool)) bool {
+	// 获取文件信息
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Printf("Error getting file info %s: %v", filePath, err)
+		if onDone != nil {
+			onDone(false)
+		}
+		return false
+	}
+
+	return c.ingest(ctx, &logSource{
+		path:    filePath,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		inode:   fileInode(info),
+		info:    info,
+		onDone:  onDone,
+	})
+}
+
+// ingestWait 同 ingest，但等待写入确认，返回文件是否已标记为已处理
+func (c *Collector) ingestWait(ctx context.Context, src *logSource) bool {
+	result := make(chan bool, 1)
+	src.onDone = func(ok bool) { result <- ok }
+	c.ingest(ctx, src)
+	return <-result
+}
+
+// ingest 解析并写入一个日志来源，返回是否被解析（已处理或未启用时返回 false）
+// 启用异步写入时写入在后台完成，src.onDone 在写入确认并标记已处理后（或无需写入时）调用
+// ctx 取消后停止检查和解析；已开始的写入不随 ctx 取消，见 write
+func (c *Collector) ingest(ctx context.Context, src *logSource) bool {
+	// ClickHouse 资源不足时暂停处理新文件
+	if c.backpressure != nil && !c.backpressure.wait(ctx) {
+		src.done(false)
+		return false
+	}
+	if seconds := c.cfg.ClickHouse.Timeouts.Process; seconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+		defer cancel()
+	}
+
+	filePath := src.path
+	// 写入交给 write 后由 commit 调用 onDone
+	handedOff := false
+	written := false
+	defer func() {
+		if !handedOff {
+			src.done(written)
+		}
+	}()
+
+	// 检查是否已处理
+	if !src.force {
+		processed, err := c.storage.IsFileProcessed(ctx, filePath, src.size, src.modTime, src.inode)
+		if err != nil {
+			log.Printf("Error checking file status %s: %v", filePath, err)
+			return false
+		}
+		if processed {
+			return false
+		}
+	}
+	logType := parser.DetermineLogType(filePath)
+	kind := parser.KindOf(logType)
+	if src.info != nil && !src.force {
+		last, grown := c.detectReplaced(ctx, filePath, src.size, src.inode)
+		// main、json_lines 日志只追加，增长后只解析新增部分，避免重复写入之前的行
+		if grown && (kind == parser.KindMain || kind == parser.KindJSONLines) {
+			src.offset = last.Size
+			src.prevRecords = last.RecordCount
+		}
+	}
+	logTypeStr := string(logType)
+	var recordCount uint32
+	// 文件尚未写完（缺少响应部分）
+	var incomplete bool
+
+	// 检查该日志类型是否启用采集
+	typeConfig := c.cfg.GetLogTypeConfig(logTypeStr)
+	if !typeConfig.Enabled {
+		return false
+	}
+
+	// 暂停的类型不处理也不标记，恢复后重新扫描
+	if c.runtime.paused(logTypeStr) {
+		return false
+	}
+
+	log.Printf("Processing file: %s (type: %s)", filepath.Base(filePath), logType)
+
+	audit := c.beginAudit(filePath, logTypeStr, src.size)
+
+	// 按 request_id 采样 API 请求日志，未采中的文件标记为已处理（0 条记录）
+	if isAPIKind(kind) && !c.runtime.sampled(sampleKey(filePath)) {
+		audit.Outcome = "sampled_out"
+		if err := c.markProcessed(ctx, src, 0); err != nil {
+			log.Printf("Error marking file as processed: %v", err)
+			failAudit(audit, "mark_error", err)
+		} else {
+			written = true
+		}
+		c.finishAudit(audit)
+		return true
+	}
+
+	// 各批次写入成功后执行的关联、发布等操作
+	var inserts []insertFunc
+	var afterInsert func(ctx context.Context)
+
+	switch kind {
+	case parser.KindMain:
+		entries, err := src.parseMain()
+		if err != nil {
+			log.Printf("Error parsing main log %s: %v", filePath, err)
+			failAudit(audit, "parse_error", err)
+			c.finishAudit(audit)
+			return false
+		}
+		if src.end == src.offset && src.end < src.size {
+			// 只有正在写入的不完整行，行稳定后重新检查
+			audit.Outcome = "incomplete"
+			c.finishAudit(audit)
+			c.scheduleRecheck(filePath, src.settleDelay())
+			return false
+		}
+		if src.offset > 0 {
+			log.Printf("Ingesting %d appended bytes of %s", src.end-src.offset, filepath.Base(filePath))
+		}
+
+		// 批量插入
+		batchSize := c.batchSize(logTypeStr)
+		for i := 0; i < len(entries); i += batchSize {
+			end := i + batchSize
+			if end > len(entries) {
+				end = len(entries)
+			}
+
+			batch, token := entries[i:end], src.insertToken(i)
+			inserts = append(inserts, func(ctx context.Context) error {
+				if err := c.mainLogs.InsertMainLogs(storage.WithInsertToken(ctx, c.storage, token), batch, filePath); err != nil {
+					return fmt.Errorf("main logs: %w", err)
+				}
+				return nil
+			})
+		}
+		recordCount = uint32(len(entries))
+
+		afterInsert = func(ctx context.Context) {
+			if c.hub != nil {
+				c.hub.PublishMainLogs(entries, filePath)
+			}
+		}
+
+	case parser.KindAPI:
+		entry, err := src.parseAPI(logType)
+		if err != nil {
+			log.Printf("Error parsing API log %s: %v", filePath, err)
+			failAudit(audit, "parse_error", err)
+			c.finishAudit(audit)
+			return false
+		}
+		entry.PrefixFingerprints, entry.PrefixLength = parser.PrefixFingerprints(entry, c.cfg.PrefixFingerprintChars)
+		stored := c.sampleBodies(entry, sampleKey(filePath))
+
+		inserts = append(inserts, func(ctx context.Context) error {
+			if err := c.storage.InsertAPILog(storage.WithInsertToken(ctx, c.storage, src.insertToken(0)), stored, filePath); err != nil {
+				return fmt.Errorf("API log: %w", err)
+			}
+			return nil
+		})
+		recordCount = 1
+		incomplete = entry.Incomplete
+
+		afterInsert = func(ctx context.Context) {
+			// 关联数据为派生数据，失败只记录日志，不影响本文件的处理结果
+			if linker, ok := storage.As[storage.APILinker](c.storage); ok {
+				if err := linker.LinkAPILog(ctx, entry); err != nil {
+					log.Printf("Error linking API log %s: %v", filepath.Base(filePath), err)
+				}
+			}
+			if queue, ok := storage.As[storage.ReplayQueuer](c.storage); ok && c.shouldQueueReplay(entry) {
+				if err := queue.EnqueueReplay(ctx, entry); err != nil {
+					log.Printf("Error queueing replay of %s: %v", entry.RequestID, err)
+				}
+			}
+
+			if c.hub != nil {
+				c.hub.PublishAPILog(entry, filePath)
+			}
+		}
+
+	case parser.KindEmbedding:
+		entry, err := src.parseEmbedding()
+		if err != nil {
+			log.Printf("Error parsing embeddings log %s: %v", filePath, err)
+			failAudit(audit, "parse_error", err)
+			c.finishAudit(audit)
+			return false
+		}
+
+		stored := entry
+		if api := c.sampleBodies(entry.API, sampleKey(filePath)); api != entry.API {
+			trimmed := *entry
+			trimmed.API = api
+			stored = &trimmed
+		}
+
+		inserts = append(inserts, func(ctx context.Context) error {
+			if err := c.storage.InsertEmbeddingLog(storage.WithInsertToken(ctx, c.storage, src.insertToken(0)), stored, filePath); err != nil {
+				return fmt.Errorf("embeddings log: %w", err)
+			}
+			return nil
+		})
+		recordCount = 1
+		incomplete = entry.API.Incomplete
+
+		afterInsert = func(ctx context.Context) {
+			if c.hub != nil {
+				c.hub.PublishAPILog(entry.API, filePath)
+			}
+		}
+
+	case parser.KindEvent:
+		entry, err := src.parseEventBatch()
+		if err != nil {
+			log.Printf("Error parsing event batch log %s: %v", filePath, err)
+			failAudit(audit, "parse_error", err)
+			c.finishAudit(audit)
+			return false
+		}
+
+		// 按批次写入事件，每批保留请求的 request_id 和时间戳
+		batchSize := c.batchSize(logTypeStr)
+		for i := 0; i < len(entry.Events); i += batchSize {
+			batch := *entry
+			batch.Events = entry.Events[i:min(i+batchSize, len(entry.Events))]
+			batch.Offset = i
+			token := src.insertToken(i)
+			inserts = append(inserts, func(ctx context.Context) error {
+				if err := c.storage.InsertEventBatch(storage.WithInsertToken(ctx, c.storage, token), &batch, filePath); err != nil {
+					return fmt.Errorf("event batch: %w", err)
+				}
+				return nil
+			})
+		}
+		recordCount = uint32(len(entry.Events))
+
+		afterInsert = func(ctx context.Context) {
+			if c.hub != nil {
+				c.hub.PublishEventBatch(entry, filePath)
+			}
+		}
+
+	case parser.KindJSONLines:
+		def, _ := c.cfg.CustomLogType(logTypeStr)
+		records, err := src.parseJSONLines(def.TimestampField, def.RequestIDField)
+		if err != nil {
+			log.Printf("Error parsing JSON lines log %s: %v", filePath, err)
+			failAudit(audit, "parse_error", err)
+			c.finishAudit(audit)
+			return false
+		}
+		if src.end == src.offset && src.end < src.size {
+			// 只有正在写入的不完整行，行稳定后重新检查
+			audit.Outcome = "incomplete"
+			c.finishAudit(audit)
+			c.scheduleRecheck(filePath, src.settleDelay())
+			return false
+		}
+
+		batchSize := c.batchSize(logTypeStr)
+		for i := 0; i < len(records); i += batchSize {
+			end := min(i+batchSize, len(records))
+			batch, token := records[i:end], src.insertToken(i)
+			inserts = append(inserts, func(ctx context.Context) error {
+				if err := c.storage.InsertJSONLines(storage.WithInsertToken(ctx, c.storage, token), logType, batch, filePath); err != nil {
+					return fmt.Errorf("JSON lines: %w", err)
+				}
+				return nil
+			})
+		}
+		recordCount = uint32(len(records))
+
+		afterInsert = func(ctx context.Context) {
+			if c.hub != nil {
+				c.hub.PublishJSONLines(logType, records, filePath)
+			}
+		}
+	}
+
+	audit.Rows = recordCount
+	handedOff = true
+	return c.write(ctx, &fileWrite{
+		inserts: inserts,
+		commit: func(ctx context.Context, err error) bool {
+			if err != nil {
+				log.Printf("Error inserting %v", err)
+				failAudit(audit, "insert_error", err)
+				if c.backpressure != nil && storage.IsResourceError(err) {
+					audit.Outcome = "backpressure"
+					c.backpressure.failed(err)
+					// 队列中的文件处理结束后放回队列，暂停结束后重新处理；此处在写入协程中，不能阻塞入队
+					if src.info != nil && c.queue.requeue(filePath) {
+						log.Printf("Will retry %s after backoff", filepath.Base(filePath))
+					}
+				}
+				c.finishAudit(audit)
+				src.done(false)
+				return false
+			}
+			if c.backpressure != nil {
+				c.backpressure.succeeded()
+			}
+			if afterInsert != nil {
+				afterInsert(ctx)
+			}
+			ok := c.commit(ctx, src, audit, recordCount, incomplete)
+			c.finishAudit(audit)
+			src.done(ok)
+			return true
+		},
+	})
+}
+
+// commit 标记文件已处理，并按结果复查或删除文件，返回是否已标记
+func (c *Collector) commit(ctx context.Context, src *logSource, audit *storage.IngestAudit, recordCount uint32, incomplete bool) bool {
+	filePath := src.path
+	logTypeStr := audit.LogType
+
+	if err := c.markProcessed(ctx, src, recordCount); err != nil {
+		log.Printf("Error marking file as processed: %v", err)
+		failAudit(audit, "mark_error", err)
+		return false
+	}
+	log.Printf("Processed %s: %d records", filepath.Base(filePath), recordCount)
+	audit.Outcome = "success"
+
+	// 归档中的条目不复查也不删除
+	if src.info == nil {
+		return true
+	}
+
+	if incomplete {
+		audit.Outcome = "incomplete"
+		// 未写完的文件不删除，稳定后重新解析
+		log.Printf("File is incomplete, will recheck: %s", filepath.Base(filePath))
+		c.scheduleRecheck(filePath, c.recheckDelay())
+		return true
+	}
+	if src.partial() {
+		// 末尾的行仍在写入：写入方停止写入后不再有文件事件，行稳定后复查，写入最后一行；文件不删除
+		log.Printf("File has a partial last line, will recheck: %s", filepath.Base(filePath))
+		c.resetRecheck(filePath)
+		c.scheduleRecheck(filePath, src.settleDelay())
+		return true
+	}
+	if c.clearRecheck(filePath) {
+		c.replaceIncomplete(ctx, filePath, logTypeStr)
+	}
+
+	// 根据配置决定是否删除文件（支持按类型单独配置）
+	if c.cfg.ShouldDeleteAfterCollect(logTypeStr) {
+		c.tryDeleteFile(filePath, src.info)
+	}
+	return true
+}
+
+// batchSize 返回日志类型每批写入的行数：log_types 单独配置的值或运行时的 batch_size，背压时缩小
+func (c *Collector) batchSize(logType string) int {
+	n := c.cfg.BatchSizeFor(logType, c.runtime.batchSize())
+	if c.backpressure != nil {
+		n = c.backpressure.batchSize(n)
+	}
+	return n
+}
+
+// isAPIKind 是否为单个请求的 API 日志（参与采样）
+func isAPIKind(kind parser.LogKind) bool {
+	return kind == parser.KindAPI || kind == parser.KindEmbedding
+}
+
+// sampleKey 采样使用的键：request_id，无法提取时使用文件路径
+func sampleKey(filePath string) string {
+	if id := parser.ExtractRequestIDFromFilename(filePath); id != "" {
+		return id
+	}
+	return filePath
+}
+
+// markProcessed 标记文件已处理，失败时重试；
+// 重试仍失败时文件会在之后重新处理，写入带有去重令牌，不会产生重复数据
+func (c *Collector) markProcessed(ctx context.Context, src *logSource, recordCount uint32) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		err = c.storage.MarkFileProcessed(ctx, src.path, src.processedSize(), src.modTime, src.inode, src.prevRecords+recordCount)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// detectReplaced 检测文件被截断（大小变小）或同名文件被替换（inode 变化），
+// 此时按新文件处理，清除旧文件的复查和重试计数；否则返回上次处理的记录（文件可能只是追加了内容）
+func (c *Collector) detectReplaced(ctx context.Context, filePath string, size int64, inode uint64) (storage.ProcessedFile, bool) {
+	last, found, err := c.storage.LastProcessedFile(ctx, filePath)
+	if err != nil {
+		log.Printf("Error checking previous file state %s: %v", filePath, err)
+		return storage.ProcessedFile{}, false
+	}
+	if !found {
+		return storage.ProcessedFile{}, false
+	}
+
+	switch {
+	case inode != 0 && last.Inode != 0 && inode != last.Inode:
+		log.Printf("File replaced (inode %d -> %d), treating as new: %s", last.Inode, inode, filepath.Base(filePath))
+	case size < last.Size:
+		log.Printf("File truncated (%d -> %d bytes), treating as new: %s", last.Size, size, filepath.Base(filePath))
+	default:
+		return last, true
+	}
+
+	c.clearRecheck(filePath)
+	c.auditMu.Lock()
+	delete(c.attempts, filePath)
+	c.auditMu.Unlock()
+	return storage.ProcessedFile{}, false
+}
+
+// tryDeleteFile 尝试删除已处理的日志文件
+func (c *Collector) tryDeleteFile(filePath string, info os.FileInfo) {
+	// 检查文件年龄，避免删除正在写入的文件
+	minAge := time.Duration(c.cfg.DeleteMinAge) * time.Second
+	if time.Since(info.ModTime()) < minAge {
+		log.Printf("Skipping delete (file too new): %s", filepath.Base(filePath))
+		return
+	}
+
+	// 不删除 main.log（当前正在写入的主日志）
+	if filepath.Base(filePath) == "main.log" {
+		return
+	}
+
+	if c.deferDelete(filePath, info) {
+		return
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		log.Printf("Error deleting file %s: %v", filepath.Base(filePath), err)
+	} else {
+		log.Printf("Deleted processed file: %s", filepath.Base(filePath))
+	}
+}
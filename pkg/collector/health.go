@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 存储恢复可用后检查暂缓删除的文件的间隔
+const deferredDeleteInterval = 10 * time.Second
+
+// storageHealth 存储不可用期间暂缓删除已处理的原始日志，恢复后再删除
+type storageHealth struct {
+	healthy func() bool
+
+	mu sync.Mutex
+	// 暂缓删除的文件 -> 处理时的文件信息
+	deferred map[string]os.FileInfo
+}
+
+// SetStorageHealth 设置存储的健康状态，healthy 返回 false 期间不删除原始日志；须在 Start 之前调用
+func (c *Collector) SetStorageHealth(healthy func() bool) {
+	c.health = &storageHealth{healthy: healthy, deferred: make(map[string]os.FileInfo)}
+}
+
+// deferDelete 存储不可用时记录待删除的文件并返回 true
+func (c *Collector) deferDelete(filePath string, info os.FileInfo) bool {
+	h := c.health
+	if h == nil || h.healthy() {
+		return false
+	}
+	h.mu.Lock()
+	h.deferred[filePath] = info
+	h.mu.Unlock()
+	log.Printf("Deferring delete (storage unhealthy): %s", filepath.Base(filePath))
+	return true
+}
+
+// deferredDeleteLoop 存储恢复可用后删除暂缓的文件
+func (c *Collector) deferredDeleteLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(deferredDeleteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+		h := c.health
+		if !h.healthy() {
+			continue
+		}
+		h.mu.Lock()
+		deferred := h.deferred
+		h.deferred = make(map[string]os.FileInfo)
+		h.mu.Unlock()
+		for path, info := range deferred {
+			// 期间被替换或修改的文件不删除，由之后的处理决定
+			if cur, err := os.Stat(path); err != nil || !cur.ModTime().Equal(info.ModTime()) || cur.Size() != info.Size() {
+				continue
+			}
+			c.tryDeleteFile(path, info)
+		}
+	}
+}
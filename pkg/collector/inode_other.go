@@ -0,0 +1,10 @@
+//go:build !unix
+
+package collector
+
+import "os"
+
+// fileInode 非 Unix 平台不支持 inode，返回 0 表示未知
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
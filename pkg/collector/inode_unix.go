@@ -0,0 +1,16 @@
+//go:build unix
+
+package collector
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode 返回文件的 inode，用于识别同名文件被替换
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
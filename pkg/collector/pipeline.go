@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// 写入指标，通过 /debug/vars 暴露
+var (
+	insertStats     = expvar.NewMap("inserts")
+	insertsInFlight = new(expvar.Int)
+	insertsAcked    = new(expvar.Int)
+	insertsFailed   = new(expvar.Int)
+)
+
+func init() {
+	insertStats.Set("in_flight", insertsInFlight)
+	insertStats.Set("acked", insertsAcked)
+	insertStats.Set("failed", insertsFailed)
+}
+
+// insertFunc 写入一个批次
+type insertFunc func(ctx context.Context) error
+
+// fileWrite 一个文件解析后的全部写入批次，所有批次确认后调用 commit：
+// err 为第一个失败批次的错误，为 nil 时 commit 标记文件已处理
+type fileWrite struct {
+	inserts []insertFunc
+	commit  func(ctx context.Context, err error) bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending int
+	err     error
+}
+
+// ack 确认一个批次；有批次失败时取消其余批次，最后一个批次确认后提交
+func (w *fileWrite) ack(err error) {
+	w.mu.Lock()
+	if err != nil && w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+	w.pending--
+	last := w.pending == 0
+	w.mu.Unlock()
+
+	if last {
+		w.commit(w.ctx, w.err)
+		w.cancel()
+	}
+}
+
+// insertBatch 待写入的批次
+type insertBatch struct {
+	file   *fileWrite
+	insert insertFunc
+}
+
+// insertPipeline 异步写入：解析完成的批次交给固定数量的写入协程，
+// 未确认的批次数有上限，达到上限时提交方阻塞，使解析与写入重叠又不会无限堆积
+type insertPipeline struct {
+	batches chan insertBatch
+	writers sync.WaitGroup
+	// 已提交未完成提交的文件
+	files sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newInsertPipeline(writers, maxInFlight int) *insertPipeline {
+	p := &insertPipeline{batches: make(chan insertBatch, maxInFlight)}
+	for i := 0; i < writers; i++ {
+		p.writers.Add(1)
+		go p.writer()
+	}
+	return p
+}
+
+func (p *insertPipeline) writer() {
+	defer p.writers.Done()
+
+	for b := range p.batches {
+		err := b.insert(b.file.ctx)
+		insertsInFlight.Add(-1)
+		if err != nil {
+			insertsFailed.Add(1)
+		} else {
+			insertsAcked.Add(1)
+		}
+		b.file.ack(err)
+	}
+}
+
+// submit 提交文件的全部批次，返回 false 表示写入已关闭，由调用方同步写入
+// w.ctx 和 w.cancel 由调用方设置，提交后由写入协程在最后一个批次确认后取消
+func (p *insertPipeline) submit(w *fileWrite) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+
+	p.files.Add(1)
+	commit := w.commit
+	w.commit = func(ctx context.Context, err error) bool {
+		defer p.files.Done()
+		return commit(ctx, err)
+	}
+	w.pending = len(w.inserts)
+	if w.pending == 0 {
+		w.commit(w.ctx, nil)
+		w.cancel()
+		return true
+	}
+	for _, insert := range w.inserts {
+		insertsInFlight.Add(1)
+		p.batches <- insertBatch{file: w, insert: insert}
+	}
+	return true
+}
+
+// wait 等待已提交的文件全部写入并提交
+func (p *insertPipeline) wait() {
+	p.files.Wait()
+}
+
+// close 停止接收新的批次，写完已提交的批次后返回
+func (p *insertPipeline) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.batches)
+	p.writers.Wait()
+}
+
+// write 写入文件的全部批次后提交，各批次遇到临时错误时按 insert_retry 重试。启用异步写入时提交到写入协程后立即返回 true，
+// 否则在当前协程依次写入，返回提交结果
+// 写入保留 ctx 中的值但不随其取消，避免文件只写入部分批次；只在 Stop 的 ctx 到期后中断
+func (c *Collector) write(ctx context.Context, w *fileWrite) bool {
+	w.ctx, w.cancel = c.writeContext(ctx)
+	for i, insert := range w.inserts {
+		w.inserts[i] = c.withRetry(insert)
+	}
+	if c.pipeline != nil && c.pipeline.submit(w) {
+		return true
+	}
+
+	ctx = w.ctx
+	defer w.cancel()
+	for _, insert := range w.inserts {
+		if err := insert(ctx); err != nil {
+			return w.commit(ctx, err)
+		}
+	}
+	return w.commit(ctx, nil)
+}
+
+// waitWrites 等待已提交的异步写入全部完成
+func (c *Collector) waitWrites() {
+	if c.pipeline != nil {
+		c.pipeline.wait()
+	}
+}
+
+// writeContext 返回一个文件的写入 context：不随 ctx 取消，
+// 超过 clickhouse.timeouts.insert_seconds（含标记已处理）或 abortWrites 后取消
+func (c *Collector) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	var cancel context.CancelFunc
+	if seconds := c.cfg.ClickHouse.Timeouts.Insert; seconds > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	stop := context.AfterFunc(c.writes, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
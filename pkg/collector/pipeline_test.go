@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+func TestInsertPipelineCommit(t *testing.T) {
+	errInsert := errors.New("insert failed")
+	tests := []struct {
+		name    string
+		results []error
+		want    error
+	}{
+		{name: "no batches"},
+		{name: "all succeed", results: []error{nil, nil, nil}},
+		{name: "one fails", results: []error{nil, errInsert, nil}, want: errInsert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newInsertPipeline(2, 1)
+			defer p.close()
+
+			var commits atomic.Int32
+			var committed error
+			w := &fileWrite{commit: func(ctx context.Context, err error) bool {
+				commits.Add(1)
+				committed = err
+				return err == nil
+			}}
+			w.ctx, w.cancel = context.WithCancel(context.Background())
+			for _, result := range tt.results {
+				w.inserts = append(w.inserts, func(ctx context.Context) error { return result })
+			}
+			if !p.submit(w) {
+				t.Fatal("submit returned false")
+			}
+			p.wait()
+			if n := commits.Load(); n != 1 {
+				t.Fatalf("commit called %d times, want 1", n)
+			}
+			if !errors.Is(committed, tt.want) {
+				t.Errorf("commit err = %v, want %v", committed, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertPipelineMaxInFlight(t *testing.T) {
+	p := newInsertPipeline(1, 1)
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(1)
+	w := &fileWrite{commit: func(ctx context.Context, err error) bool { return true }}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	block := func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+	first := true
+	w.inserts = []insertFunc{
+		func(ctx context.Context) error {
+			if first {
+				first = false
+				started.Done()
+			}
+			return block(ctx)
+		},
+		block,
+		block,
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		p.submit(w)
+		close(submitted)
+	}()
+	started.Wait()
+	// 一个批次写入中、一个在通道中，第三个批次的提交方阻塞
+	select {
+	case <-submitted:
+		t.Fatal("submit did not block at max_in_flight_batches")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-submitted
+	p.wait()
+	p.close()
+	if p.submit(w) {
+		t.Error("submit after close returned true")
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	cfg := config.BackpressureConfig{Enabled: true, MinBackoffSeconds: 1, MaxBackoffSeconds: 4, MinBatchSize: 10}
+	tests := []struct {
+		name string
+		// 依次记录的写入结果：true 为成功，false 为资源错误
+		results []bool
+		delay   time.Duration
+		// 配置的批次大小和调整后的批次大小
+		n     int
+		batch int
+	}{
+		{name: "initial", n: 1000, batch: 1000},
+		{name: "one failure", results: []bool{false}, delay: time.Second, n: 1000, batch: 500},
+		{name: "capped backoff", results: []bool{false, false, false, false}, delay: 4 * time.Second, n: 1000, batch: 62},
+		{name: "recovered", results: []bool{false, false, true}, n: 1000, batch: 500},
+		{name: "shrink limit", results: []bool{false, false, false, false, false, false, false}, delay: 4 * time.Second, n: 1000, batch: 15},
+		{name: "configured below min", results: []bool{false}, delay: time.Second, n: 5, batch: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackpressure(cfg)
+			for _, ok := range tt.results {
+				if ok {
+					b.succeeded()
+					continue
+				}
+				b.failed(errors.New("TOO_MANY_PARTS"))
+				// 暂停期间的失败不延长暂停，模拟暂停结束后的写入
+				b.until = time.Time{}
+			}
+			if b.delay != tt.delay {
+				t.Errorf("delay = %s, want %s", b.delay, tt.delay)
+			}
+			if got := b.batchSize(tt.n); got != tt.batch {
+				t.Errorf("batchSize(%d) = %d, want %d", tt.n, got, tt.batch)
+			}
+		})
+	}
+}
+
+func TestBackpressureWait(t *testing.T) {
+	b := newBackpressure(config.BackpressureConfig{MinBackoffSeconds: 60, MaxBackoffSeconds: 60})
+	if !b.wait(context.Background()) {
+		t.Fatal("wait without pause returned false")
+	}
+	b.failed(errors.New("MEMORY_LIMIT_EXCEEDED"))
+	until := b.until
+	// 暂停期间失败的写入不延长暂停
+	b.failed(errors.New("MEMORY_LIMIT_EXCEEDED"))
+	if !b.until.Equal(until) {
+		t.Error("failure during pause extended the pause")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if b.wait(ctx) {
+		t.Error("wait during pause returned true after ctx was cancelled")
+	}
+}
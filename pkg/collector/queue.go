@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"bufio"
+	"expvar"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 队列指标，通过 /debug/vars 暴露
+var (
+	queueStats   = expvar.NewMap("queue")
+	queueDepth   = new(expvar.Int)
+	queueDropped = new(expvar.Int)
+	queueSpilled = new(expvar.Int)
+)
+
+func init() {
+	queueStats.Set("depth", queueDepth)
+	queueStats.Set("dropped", queueDropped)
+	queueStats.Set("spilled", queueSpilled)
+}
+
+const spillFileName = "queue-spill.txt"
+
+// 队列满时放回文件的重试间隔
+const resubmitRetry = 200 * time.Millisecond
+
+// queueItem 待处理的文件
+type queueItem struct {
+	path string
+	// 最早处理时间，给写入方留出完成写入的时间
+	notBefore time.Time
+	// 未写完文件的复查，文件未变化时继续安排复查
+	recheck bool
+}
+
+// fileQueue 文件发现与处理之间的有界队列。
+// 未到处理时间的文件由定时器持有，同样占用队列容量：入队时占用 slots 中的一个位置，处理协程开始处理时释放
+type fileQueue struct {
+	cfg   config.QueueConfig
+	items chan queueItem
+	slots chan struct{}
+	done  <-chan struct{}
+
+	mu sync.Mutex
+	// 已入队或正在处理的文件；处理中再次收到事件时标记为 true，处理完后重新入队
+	pending map[string]bool
+
+	spillMu   sync.Mutex
+	spillPath string
+}
+
+func newFileQueue(cfg config.QueueConfig, done <-chan struct{}) *fileQueue {
+	q := &fileQueue{
+		cfg:     cfg,
+		items:   make(chan queueItem, cfg.Size),
+		slots:   make(chan struct{}, cfg.Size),
+		done:    done,
+		pending: make(map[string]bool),
+	}
+	if cfg.OverflowPolicy == "spill" {
+		q.spillPath = filepath.Join(cfg.SpillDir, spillFileName)
+		// 上次运行遗留的溢出记录无需恢复，启动时会重新扫描日志目录
+		os.Remove(q.spillPath)
+	}
+	return q
+}
+
+// enqueue 将文件加入队列，队列已满时按 overflow_policy 处理
+func (q *fileQueue) enqueue(path string, delay time.Duration) {
+	q.mu.Lock()
+	if _, ok := q.pending[path]; ok {
+		q.pending[path] = true
+		q.mu.Unlock()
+		return
+	}
+	q.pending[path] = false
+	q.mu.Unlock()
+
+	item := queueItem{path: path, notBefore: time.Now().Add(delay)}
+	if q.tryAcquire() {
+		q.send(item)
+		return
+	}
+
+	switch q.cfg.OverflowPolicy {
+	case "spill":
+		if err := q.spill(path); err != nil {
+			log.Printf("Error spilling queue item %s: %v", filepath.Base(path), err)
+			q.push(item)
+			return
+		}
+		q.release(path)
+		queueSpilled.Add(1)
+	case "drop":
+		if q.droppable(path) {
+			// 未标记已处理，下次启动扫描时会重新采集
+			q.release(path)
+			queueDropped.Add(1)
+			log.Printf("Queue full, dropped: %s", filepath.Base(path))
+			return
+		}
+		q.push(item)
+	default:
+		q.push(item)
+	}
+}
+
+// requeue 标记正在处理的文件在处理结束后重新入队（由 finish 经 resubmit 放回，不阻塞写入协程），
+// 文件不在队列中时返回 false
+func (q *fileQueue) requeue(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[path]; !ok {
+		return false
+	}
+	q.pending[path] = true
+	return true
+}
+
+// tryAcquire 占用队列中的一个位置，队列已满时返回 false
+func (q *fileQueue) tryAcquire() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// send 将已占用位置的文件放入 items；占用位置的文件不超过 items 的容量，不会阻塞
+func (q *fileQueue) send(item queueItem) {
+	select {
+	case q.items <- item:
+	case <-q.done:
+	}
+	q.updateDepth()
+}
+
+// take 处理协程开始处理文件，释放其占用的位置
+func (q *fileQueue) take() {
+	<-q.slots
+	q.updateDepth()
+}
+
+// updateDepth 队列深度包括 items 中和定时器持有的文件
+func (q *fileQueue) updateDepth() {
+	queueDepth.Set(int64(len(q.slots)))
+}
+
+// delay 已占用位置、未到处理时间的文件由定时器持有，到时放回 items
+func (q *fileQueue) delay(item queueItem, wait time.Duration) {
+	time.AfterFunc(wait, func() { q.send(item) })
+}
+
+// resubmit delay 后将仍在 pending 中的文件放回队列，队列满时稍后重试，不阻塞调用方。
+// 处理协程不能阻塞等待空位：队列只由处理协程取出，全部阻塞时无人取出
+func (q *fileQueue) resubmit(item queueItem, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+		if q.tryAcquire() {
+			q.send(item)
+		} else {
+			q.resubmit(item, resubmitRetry)
+		}
+	})
+}
+
+// recheck 将未写完的文件放回队列复查，不阻塞调用方；
+// 文件已在队列中或正在处理时返回 false，由调用方稍后重试，避免复查并入普通事件后丢失
+func (q *fileQueue) recheck(path string) bool {
+	q.mu.Lock()
+	if _, ok := q.pending[path]; ok {
+		q.mu.Unlock()
+		return false
+	}
+	q.pending[path] = false
+	q.mu.Unlock()
+
+	q.resubmit(queueItem{path: path, notBefore: time.Now(), recheck: true}, 0)
+	return true
+}
+
+// push 阻塞入队直到有空位或采集器停止
+func (q *fileQueue) push(item queueItem) {
+	select {
+	case q.slots <- struct{}{}:
+		q.send(item)
+	case <-q.done:
+	}
+}
+
+// droppable 队列满时该文件的日志类型是否可丢弃
+func (q *fileQueue) droppable(path string) bool {
+	logType := string(parser.DetermineLogType(path))
+	for _, t := range q.cfg.DropTypes {
+		if t == logType {
+			return true
+		}
+	}
+	return false
+}
+
+// release 文件未进入队列（溢出到磁盘或丢弃），从 pending 中移除
+func (q *fileQueue) release(path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, path)
+}
+
+// finish 文件处理结束；处理期间又收到事件时保留在 pending 中，delay 后经 resubmit 放回队列。
+// 在写入协程中调用（异步写入时），不阻塞
+func (q *fileQueue) finish(path string, delay time.Duration) {
+	q.mu.Lock()
+	dirty := q.pending[path]
+	if dirty {
+		q.pending[path] = false
+	} else {
+		delete(q.pending, path)
+	}
+	q.mu.Unlock()
+
+	if dirty {
+		q.resubmit(queueItem{path: path, notBefore: time.Now().Add(delay)}, delay)
+	}
+}
+
+// spill 将溢出的文件路径追加到磁盘
+func (q *fileQueue) spill(path string) error {
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+
+	f, err := os.OpenFile(q.spillPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(path + "\n")
+	return err
+}
+
+// takeSpilled 读出并清空磁盘上的溢出记录
+func (q *fileQueue) takeSpilled() []string {
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+
+	f, err := os.Open(q.spillPath)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	f.Close()
+	os.Remove(q.spillPath)
+	return paths
+}
+
+// drainSpill 队列有空位时将溢出记录重新入队
+func (q *fileQueue) drainSpill() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			if len(q.slots) > cap(q.slots)/2 {
+				continue
+			}
+			for _, path := range q.takeSpilled() {
+				q.enqueue(path, 0)
+			}
+		}
+	}
+}
+
+// startWorkers 启动处理协程
+func (c *Collector) startWorkers() {
+	for i := 0; i < c.cfg.Queue.Workers; i++ {
+		c.wg.Add(1)
+		go c.worker()
+	}
+	if c.cfg.Queue.OverflowPolicy == "spill" {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.queue.drainSpill()
+		}()
+	}
+}
+
+func (c *Collector) worker() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case item := <-c.queue.items:
+			if wait := time.Until(item.notBefore); wait > 0 {
+				// 未到处理时间的文件到时再放回队列，不占用处理协程，仍占用队列中的位置
+				c.queue.delay(item, wait)
+				continue
+			}
+			c.queue.take()
+			// 写入确认后才释放，期间再次收到的事件在释放后重新入队
+			path := item.path
+			parsed := c.processFile(c.ctx, path, func(bool) {
+				c.queue.finish(path, 500*time.Millisecond)
+			})
+			if item.recheck && !parsed {
+				// 文件未变化，继续等待
+				c.scheduleRecheck(path, c.recheckDelay())
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+func TestFileQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		// 队列满时入队的文件
+		path string
+		// 入队是否阻塞直到有空位
+		blocks  bool
+		dropped bool
+		spilled bool
+	}{
+		{name: "block", policy: "block", path: "/logs/v1-messages-1.log", blocks: true},
+		{name: "drop droppable type", policy: "drop", path: "/logs/v1-embeddings-1.log", dropped: true},
+		{name: "drop keeps other types", policy: "drop", path: "/logs/v1-messages-1.log", blocks: true},
+		{name: "spill", policy: "spill", path: "/logs/v1-messages-1.log", spilled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			defer close(done)
+			q := newFileQueue(config.QueueConfig{
+				Size:           1,
+				OverflowPolicy: tt.policy,
+				SpillDir:       t.TempDir(),
+				DropTypes:      []string{"v1_embeddings"},
+			}, done)
+			q.enqueue("/logs/main.log", 0)
+
+			returned := make(chan struct{})
+			go func() {
+				q.enqueue(tt.path, 0)
+				close(returned)
+			}()
+			select {
+			case <-returned:
+				if tt.blocks {
+					t.Fatal("enqueue returned while the queue was full")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.blocks {
+					t.Fatal("enqueue blocked")
+				}
+				// 取出一个文件后阻塞的入队继续
+				<-q.items
+				q.take()
+				<-returned
+			}
+
+			q.mu.Lock()
+			_, pending := q.pending[tt.path]
+			q.mu.Unlock()
+			if want := !tt.dropped && !tt.spilled; pending != want {
+				t.Errorf("pending = %v, want %v", pending, want)
+			}
+			spilled := q.takeSpilled()
+			if tt.spilled != (len(spilled) == 1 && spilled[0] == tt.path) {
+				t.Errorf("spilled = %v", spilled)
+			}
+		})
+	}
+}
+
+func TestFileQueueDelayedItemsCountTowardBound(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	q := newFileQueue(config.QueueConfig{Size: 2, OverflowPolicy: "drop", DropTypes: []string{"v1_embeddings"}}, done)
+	q.enqueue("/logs/v1-embeddings-1.log", time.Hour)
+	q.enqueue("/logs/v1-embeddings-2.log", time.Hour)
+
+	// 处理协程将未到处理时间的文件交给定时器
+	for i := 0; i < 2; i++ {
+		item := <-q.items
+		q.delay(item, time.Until(item.notBefore))
+	}
+	if got := queueDepth.Value(); got != 2 {
+		t.Errorf("depth = %d, want 2", got)
+	}
+
+	before := queueDropped.Value()
+	q.enqueue("/logs/v1-embeddings-3.log", 0)
+	if got := queueDropped.Value() - before; got != 1 {
+		t.Errorf("dropped = %d, want 1: delayed items must count toward queue.size", got)
+	}
+}
+
+func TestFileQueueRequeueOrder(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	q := newFileQueue(config.QueueConfig{Size: 10, OverflowPolicy: "block"}, done)
+
+	next := func() queueItem {
+		t.Helper()
+		select {
+		case item := <-q.items:
+			q.take()
+			return item
+		case <-time.After(time.Second):
+			t.Fatal("no item in queue")
+			return queueItem{}
+		}
+	}
+
+	q.enqueue("/logs/a.log", 0)
+	q.enqueue("/logs/b.log", 0)
+	if item := next(); item.path != "/logs/a.log" {
+		t.Fatalf("first item = %s, want a.log", item.path)
+	}
+	// 处理中再次收到事件：不重复入队，处理结束后放回
+	q.enqueue("/logs/a.log", 0)
+	if item := next(); item.path != "/logs/b.log" {
+		t.Fatalf("second item = %s, want b.log", item.path)
+	}
+	q.finish("/logs/b.log", 0)
+	q.finish("/logs/a.log", 0)
+	if item := next(); item.path != "/logs/a.log" {
+		t.Fatalf("requeued item = %s, want a.log", item.path)
+	}
+	q.finish("/logs/a.log", 0)
+
+	// 处理结束且没有新事件时从 pending 中移除，之后的事件正常入队
+	q.mu.Lock()
+	n := len(q.pending)
+	q.mu.Unlock()
+	if n != 0 {
+		t.Errorf("pending = %d, want 0", n)
+	}
+	if !q.recheck("/logs/a.log") {
+		t.Fatal("recheck of idle file returned false")
+	}
+	if q.recheck("/logs/a.log") {
+		t.Error("recheck of queued file returned true")
+	}
+	if item := next(); !item.recheck {
+		t.Error("recheck item not marked as recheck")
+	}
+}
+
+func TestFileQueueSpillFileRemovedOnStart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, spillFileName)
+	if err := os.WriteFile(path, []byte("/logs/old.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	q := newFileQueue(config.QueueConfig{Size: 1, OverflowPolicy: "spill", SpillDir: dir}, done)
+	if spilled := q.takeSpilled(); len(spilled) != 0 {
+		t.Errorf("spilled = %v, want none", spilled)
+	}
+}
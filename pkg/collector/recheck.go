@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// recheckState 未写完文件的复查状态
+type recheckState struct {
+	attempts int
+	// 已安排复查、尚未放回队列
+	scheduled bool
+}
+
+// scheduleRecheck 文件未写完（缺少响应部分、末尾有不完整的行）时，delay 后放回队列重新检查；
+// 文件大小或修改时间变化后 processFile 会重新解析。已安排复查时不重复安排
+func (c *Collector) scheduleRecheck(filePath string, delay time.Duration) {
+	c.recheckMu.Lock()
+	state := c.rechecks[filePath]
+	if state == nil {
+		state = &recheckState{}
+		c.rechecks[filePath] = state
+	}
+	if state.scheduled {
+		c.recheckMu.Unlock()
+		return
+	}
+	state.attempts++
+	if state.attempts > c.cfg.IncompleteMaxRechecks {
+		delete(c.rechecks, filePath)
+		c.recheckMu.Unlock()
+		log.Printf("Giving up on incomplete file after %d rechecks: %s", state.attempts-1, filepath.Base(filePath))
+		return
+	}
+	state.scheduled = true
+	c.recheckMu.Unlock()
+
+	c.armRecheck(filePath, delay)
+}
+
+// recheckDelay 未写完的请求日志的复查间隔
+func (c *Collector) recheckDelay() time.Duration {
+	return time.Duration(c.cfg.IncompleteRecheckSeconds) * time.Second
+}
+
+// resetRecheck 文件有新的完整内容写入后重新计算复查次数，持续写入的文件不会达到复查上限
+func (c *Collector) resetRecheck(filePath string) {
+	c.recheckMu.Lock()
+	if state := c.rechecks[filePath]; state != nil {
+		state.attempts = 0
+	}
+	c.recheckMu.Unlock()
+}
+
+// armRecheck delay 后将文件放回队列复查，由队列的处理协程处理，与其他文件共用并发限制；
+// 文件仍在队列中或正在处理时稍后再试，期间已完整处理（复查状态被清除）时不再复查
+func (c *Collector) armRecheck(filePath string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.recheckMu.Lock()
+		defer c.recheckMu.Unlock()
+		state := c.rechecks[filePath]
+		if state == nil {
+			return
+		}
+		// 入队前清除标记，处理协程发现文件未变化时可以再次安排
+		state.scheduled = false
+		if !c.queue.recheck(filePath) {
+			state.scheduled = true
+			c.armRecheck(filePath, resubmitRetry)
+		}
+	})
+}
+
+// clearRecheck 文件已完整处理，清除复查状态，返回文件之前是否未写完
+func (c *Collector) clearRecheck(filePath string) bool {
+	c.recheckMu.Lock()
+	defer c.recheckMu.Unlock()
+	_, ok := c.rechecks[filePath]
+	delete(c.rechecks, filePath)
+	return ok
+}
+
+// replaceIncomplete 未写完时写入过的请求日志文件写入完整的行后，删除之前 incomplete = 1 的行，
+// 排序键不同（时间戳按文件修改时间修正等）时后台合并无法替换。失败只记录日志，查询时可按 incomplete 排除
+func (c *Collector) replaceIncomplete(ctx context.Context, filePath, logType string) {
+	if parser.KindOf(parser.LogType(logType)) != parser.KindAPI {
+		return
+	}
+	deleter, ok := storage.As[storage.IncompleteRowDeleter](c.storage)
+	if !ok {
+		return
+	}
+	if err := deleter.DeleteIncompleteRows(ctx, filePath); err != nil {
+		log.Printf("Error deleting incomplete rows of %s: %v", filepath.Base(filePath), err)
+	}
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+func newRecheckCollector(t *testing.T, maxRechecks int) *Collector {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Collector{
+		cfg:      &config.Config{IncompleteMaxRechecks: maxRechecks},
+		ctx:      ctx,
+		cancel:   cancel,
+		rechecks: make(map[string]*recheckState),
+		queue:    newFileQueue(config.QueueConfig{Size: 10, OverflowPolicy: "block"}, ctx.Done()),
+	}
+}
+
+func TestScheduleRecheck(t *testing.T) {
+	const path = "/logs/v1-messages-1.log"
+	tests := []struct {
+		name        string
+		maxRechecks int
+		// 复查后文件仍未写完、再次安排复查的次数
+		rounds int
+		// 每次安排后是否放回队列
+		queued []bool
+	}{
+		{name: "within limit", maxRechecks: 3, rounds: 3, queued: []bool{true, true, true}},
+		{name: "give up after limit", maxRechecks: 2, rounds: 3, queued: []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRecheckCollector(t, tt.maxRechecks)
+			for i := 0; i < tt.rounds; i++ {
+				c.scheduleRecheck(path, 20*time.Millisecond)
+				if tt.queued[i] {
+					// 已安排时不重复安排
+					c.scheduleRecheck(path, 20*time.Millisecond)
+				}
+				select {
+				case item := <-c.queue.items:
+					c.queue.take()
+					if !tt.queued[i] {
+						t.Fatalf("round %d: recheck queued after the limit", i)
+					}
+					if item.path != path || !item.recheck {
+						t.Fatalf("round %d: item = %+v", i, item)
+					}
+					c.queue.finish(path, 0)
+				case <-time.After(200 * time.Millisecond):
+					if tt.queued[i] {
+						t.Fatalf("round %d: recheck not queued", i)
+					}
+				}
+				if n := len(c.queue.items); n != 0 {
+					t.Fatalf("round %d: %d extra items queued", i, n)
+				}
+			}
+		})
+	}
+}
+
+func TestRecheckClearedBeforeFiring(t *testing.T) {
+	const path = "/logs/v1-messages-1.log"
+	c := newRecheckCollector(t, 3)
+	c.scheduleRecheck(path, 50*time.Millisecond)
+	if !c.clearRecheck(path) {
+		t.Fatal("clearRecheck = false for a scheduled file")
+	}
+	select {
+	case item := <-c.queue.items:
+		t.Fatalf("recheck queued after the file was processed: %+v", item)
+	case <-time.After(150 * time.Millisecond):
+	}
+	if c.clearRecheck(path) {
+		t.Error("clearRecheck = true for a file without recheck state")
+	}
+}
+
+func TestResetRecheck(t *testing.T) {
+	const path = "/logs/main.log"
+	c := newRecheckCollector(t, 1)
+	for i := 0; i < 3; i++ {
+		c.scheduleRecheck(path, time.Millisecond)
+		select {
+		case <-c.queue.items:
+			c.queue.take()
+			c.queue.finish(path, 0)
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("round %d: recheck not queued", i)
+		}
+		// 文件写入了新的完整行，复查次数重新计算，持续写入的文件不会达到上限
+		c.resetRecheck(path)
+	}
+}
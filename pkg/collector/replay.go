@@ -0,0 +1,26 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/k0ngk0ng/cpa-logger/internal/replay"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// shouldQueueReplay 客户端请求（v1_*）以可重试状态码失败时加入重放队列；
+// 重放产生的请求（带重放请求头）不再加入，避免循环
+func (c *Collector) shouldQueueReplay(entry *parser.APILogEntry) bool {
+	rq := &c.cfg.ReplayQueue
+	if !rq.Enabled || entry.Incomplete || !strings.HasPrefix(string(entry.LogType), "v1_") {
+		return false
+	}
+	if !rq.Retryable(entry.ResponseStatus) {
+		return false
+	}
+	for k := range entry.Headers {
+		if strings.EqualFold(k, replay.Header) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// Reprocess 删除文件已写入的数据后重新解析写入（如修复解析问题后），文件须仍在磁盘上
+func (c *Collector) Reprocess(ctx context.Context, filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", filePath)
+	}
+
+	deleter, ok := storage.As[storage.FileRowDeleter](c.storage)
+	if !ok {
+		return storage.ErrUnsupported
+	}
+	// main 日志写入 VictoriaLogs 时无法删除已写入的行，重新写入会产生重复
+	if _, vl := c.mainLogs.(*storage.VictoriaLogsStorage); vl && parser.KindOf(parser.DetermineLogType(filePath)) == parser.KindMain {
+		return storage.ErrUnsupported
+	}
+	if err := deleter.DeleteFileRows(ctx, filePath); err != nil {
+		return fmt.Errorf("failed to delete existing rows: %w", err)
+	}
+	// 已删除文件的数据，重新处理未完成时本地记录不应再将文件视为已处理
+	if forgetter, ok := storage.As[storage.ProcessedForgetter](c.storage); ok {
+		if err := forgetter.ForgetProcessedFile(ctx, filePath); err != nil {
+			return fmt.Errorf("failed to clear local state: %w", err)
+		}
+	}
+
+	log.Printf("Reprocessing file: %s", filepath.Base(filePath))
+	ok = c.ingestWait(ctx, &logSource{
+		path:       filePath,
+		size:       info.Size(),
+		modTime:    info.ModTime(),
+		inode:      fileInode(info),
+		info:       info,
+		force:      true,
+		generation: time.Now().UnixNano(),
+	})
+	if !ok {
+		return fmt.Errorf("file was not ingested (log type disabled or processing failed, see ingest_audit)")
+	}
+	return nil
+}
+
+// ReprocessRequest 重新处理 request_id 对应的 API/事件日志文件，返回处理的文件
+func (c *Collector) ReprocessRequest(ctx context.Context, requestID string) ([]string, error) {
+	deleter, ok := storage.As[storage.FileRowDeleter](c.storage)
+	if !ok {
+		return nil, storage.ErrUnsupported
+	}
+	files, err := deleter.RequestLogFiles(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no log files found for request %s", requestID)
+	}
+
+	for _, f := range files {
+		if err := c.Reprocess(ctx, f); err != nil {
+			return files, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	return files, nil
+}
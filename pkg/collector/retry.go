@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/storage"
+)
+
+// 重试的写入次数，通过 /debug/vars 的 inserts.retried 暴露
+var insertsRetried = new(expvar.Int)
+
+func init() {
+	insertStats.Set("retried", insertsRetried)
+}
+
+// retryable 是否重试写入错误：临时错误总是重试；未启用背压时资源不足的错误也重试，
+// 启用时由背压暂停并重新排队
+func (c *Collector) retryable(err error) bool {
+	return storage.IsTransientError(err) || (c.backpressure == nil && storage.IsResourceError(err))
+}
+
+// withRetry 返回按 insert_retry 重试的写入：可重试的错误按指数退避（带随机浮动）重试，
+// 达到 max_attempts 或 ctx 取消时返回最后一次的错误
+func (c *Collector) withRetry(insert insertFunc) insertFunc {
+	r := c.cfg.InsertRetry
+	if r.MaxAttempts <= 1 {
+		return insert
+	}
+	return func(ctx context.Context) error {
+		backoff := time.Duration(r.InitialBackoffMs) * time.Millisecond
+		for attempt := 1; ; attempt++ {
+			err := insert(ctx)
+			if err == nil || attempt >= r.MaxAttempts || !c.retryable(err) {
+				return err
+			}
+			delay := time.Duration(float64(backoff) * (1 + r.Jitter*(2*rand.Float64()-1)))
+			log.Printf("Insert failed (attempt %d/%d, retrying in %v): %v",
+				attempt, r.MaxAttempts, delay.Round(time.Millisecond), err)
+			insertsRetried.Add(1)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return err
+			}
+			backoff = min(backoff*2, time.Duration(r.MaxBackoffMs)*time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	rewatchMinBackoff = time.Second
+	rewatchMaxBackoff = time.Minute
+	// 定期检查监控是否丢失（如目录被删除但未收到事件）
+	watchCheckInterval = 30 * time.Second
+)
+
+// isLogDir 是否为监控的日志目录
+func (c *Collector) isLogDir(path string) bool {
+	path = filepath.Clean(path)
+	for _, dir := range c.logDirs() {
+		if filepath.Clean(dir) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWatches 重新监控已丢失的目录
+func (c *Collector) checkWatches() {
+	watched := make(map[string]bool)
+	for _, dir := range c.watcher.WatchList() {
+		watched[filepath.Clean(dir)] = true
+	}
+	for _, dir := range c.logDirs() {
+		if !watched[filepath.Clean(dir)] {
+			c.rewatch(dir)
+		}
+	}
+}
+
+// rewatch 目录被删除后按退避间隔重试监控，目录重新出现后重新扫描
+func (c *Collector) rewatch(dir string) {
+	c.rewatchMu.Lock()
+	if c.rewatching[dir] {
+		c.rewatchMu.Unlock()
+		return
+	}
+	c.rewatching[dir] = true
+	c.rewatchMu.Unlock()
+
+	log.Printf("Lost watch on directory, waiting for it to reappear: %s", dir)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.rewatchMu.Lock()
+			delete(c.rewatching, dir)
+			c.rewatchMu.Unlock()
+		}()
+
+		backoff := rewatchMinBackoff
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				err := c.watcher.Add(dir)
+				if err == nil {
+					log.Printf("Directory reappeared, watching again: %s", dir)
+					c.scanDir(dir)
+					return
+				}
+				log.Printf("Error re-adding watch %s: %v", dir, err)
+			}
+
+			backoff *= 2
+			if backoff > rewatchMaxBackoff {
+				backoff = rewatchMaxBackoff
+			}
+		}
+	}()
+}
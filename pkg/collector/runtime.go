@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// RuntimeSettings 可在运行时调整（无需重启）的配置
+type RuntimeSettings struct {
+	BatchSize   int      `json:"batch_size"`
+	SampleRate  float64  `json:"sample_rate"`
+	PausedTypes []string `json:"paused_types"`
+}
+
+// RuntimePatch 运行时配置的修改，nil 字段保持不变
+type RuntimePatch struct {
+	BatchSize   *int      `json:"batch_size"`
+	SampleRate  *float64  `json:"sample_rate"`
+	PausedTypes *[]string `json:"paused_types"`
+}
+
+// runtimeState 运行时配置，初始值来自配置文件
+type runtimeState struct {
+	mu       sync.RWMutex
+	settings RuntimeSettings
+}
+
+func newRuntimeState(cfg *config.Config) *runtimeState {
+	paused := append([]string{}, cfg.PausedTypes...)
+	return &runtimeState{settings: RuntimeSettings{
+		BatchSize:   cfg.BatchSize,
+		SampleRate:  cfg.SampleRate,
+		PausedTypes: paused,
+	}}
+}
+
+func (r *runtimeState) get() RuntimeSettings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := r.settings
+	s.PausedTypes = append([]string{}, s.PausedTypes...)
+	return s
+}
+
+func (r *runtimeState) batchSize() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.BatchSize
+}
+
+func (r *runtimeState) paused(logType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.settings.PausedTypes {
+		if t == logType {
+			return true
+		}
+	}
+	return false
+}
+
+// sampled 按 key 的哈希确定性采样，同一请求的多个日志文件结果一致
+func (r *runtimeState) sampled(key string) bool {
+	r.mu.RLock()
+	rate := r.settings.SampleRate
+	r.mu.RUnlock()
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// RuntimeSettings 返回当前的运行时配置
+func (c *Collector) RuntimeSettings() RuntimeSettings {
+	return c.runtime.get()
+}
+
+// UpdateRuntime 校验并应用运行时配置，恢复暂停的类型时重新扫描日志目录
+func (c *Collector) UpdateRuntime(p RuntimePatch) (RuntimeSettings, error) {
+	if p.BatchSize != nil && *p.BatchSize <= 0 {
+		return RuntimeSettings{}, fmt.Errorf("batch_size must be positive")
+	}
+	if p.SampleRate != nil && (*p.SampleRate <= 0 || *p.SampleRate > 1) {
+		return RuntimeSettings{}, fmt.Errorf("sample_rate must be in (0, 1]")
+	}
+	if p.PausedTypes != nil {
+		for _, t := range *p.PausedTypes {
+			if !c.cfg.HasLogType(t) {
+				return RuntimeSettings{}, fmt.Errorf("unknown log type: %s", t)
+			}
+		}
+	}
+
+	r := c.runtime
+	r.mu.Lock()
+	if p.BatchSize != nil {
+		r.settings.BatchSize = *p.BatchSize
+	}
+	if p.SampleRate != nil {
+		r.settings.SampleRate = *p.SampleRate
+	}
+	var resumed bool
+	if p.PausedTypes != nil {
+		next := make(map[string]bool)
+		for _, t := range *p.PausedTypes {
+			next[t] = true
+		}
+		for _, t := range r.settings.PausedTypes {
+			if !next[t] {
+				resumed = true
+			}
+		}
+		r.settings.PausedTypes = append([]string{}, *p.PausedTypes...)
+	}
+	settings := r.settings
+	r.mu.Unlock()
+
+	if resumed {
+		go c.processExistingFiles()
+	}
+	return settings, nil
+}
@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// s3Source 从 S3 存储桶前缀下列举并采集新上传的日志对象
+type s3Source struct {
+	cfg    *config.S3SourceConfig
+	client *minio.Client
+	// 已连续处理到的最后一个对象键，按字典序从其之后继续列举
+	marker string
+}
+
+func newS3Source(cfg *config.S3SourceConfig) (*s3Source, error) {
+	var creds *credentials.Credentials
+	if cfg.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	s := &s3Source{cfg: cfg, client: client}
+	if cfg.MarkerFile != "" {
+		if data, err := os.ReadFile(cfg.MarkerFile); err == nil {
+			s.marker = strings.TrimSpace(string(data))
+		}
+	}
+	return s, nil
+}
+
+// objectPath 对象在 processed_files 等表中记录的路径
+func (s *s3Source) objectPath(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, key)
+}
+
+// saveMarker 持久化列举位置
+func (s *s3Source) saveMarker(key string) {
+	s.marker = key
+	if s.cfg.MarkerFile == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cfg.MarkerFile), 0755); err != nil {
+		log.Printf("Error saving S3 marker: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.cfg.MarkerFile, []byte(key+"\n"), 0644); err != nil {
+		log.Printf("Error saving S3 marker: %v", err)
+	}
+}
+
+// s3Loop 定期列举并采集 S3 对象
+func (c *Collector) s3Loop() {
+	defer c.wg.Done()
+
+	log.Printf("Polling S3 source: s3://%s/%s", c.s3.cfg.Bucket, c.s3.cfg.Prefix)
+	ticker := time.NewTicker(time.Duration(c.s3.cfg.PollInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		c.pollS3()
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollS3 采集 marker 之后的新对象，成功处理的连续对象推进 marker
+func (c *Collector) pollS3() {
+	ctx := c.ctx
+	src := c.s3
+	advance := true
+	for obj := range src.client.ListObjects(ctx, src.cfg.Bucket, minio.ListObjectsOptions{
+		Prefix:     src.cfg.Prefix,
+		StartAfter: src.marker,
+		Recursive:  true,
+	}) {
+		if obj.Err != nil {
+			log.Printf("Error listing S3 objects: %v", obj.Err)
+			return
+		}
+		// 非日志对象和未启用采集的日志类型跳过，同样推进 marker，否则之后的对象每次都要重新列举
+		if !strings.HasSuffix(obj.Key, ".log") || !c.s3TypeEnabled(obj.Key) {
+			if advance {
+				src.saveMarker(obj.Key)
+			}
+			continue
+		}
+
+		ok := c.ingestS3Object(ctx, obj)
+		if ok && advance {
+			src.saveMarker(obj.Key)
+		} else if !ok {
+			// 失败的对象之后不再推进 marker，下次从这里重新列举（已处理的对象会被跳过）
+			advance = false
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// s3TypeEnabled 对象的日志类型是否启用采集
+func (c *Collector) s3TypeEnabled(key string) bool {
+	logType := parser.DetermineLogType(c.s3.objectPath(key))
+	return c.cfg.GetLogTypeConfig(string(logType)).Enabled
+}
+
+// ingestS3Object 下载并采集单个对象，返回对象是否已处理完成
+func (c *Collector) ingestS3Object(ctx context.Context, obj minio.ObjectInfo) bool {
+	src := c.s3
+	path := src.objectPath(obj.Key)
+
+	processed, err := c.storage.IsFileProcessed(ctx, path, obj.Size, obj.LastModified, 0)
+	if err != nil {
+		log.Printf("Error checking file status %s: %v", path, err)
+		return false
+	}
+
+	if !processed {
+		reader, err := src.client.GetObject(ctx, src.cfg.Bucket, obj.Key, minio.GetObjectOptions{})
+		if err != nil {
+			log.Printf("Error downloading %s: %v", path, err)
+			return false
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			log.Printf("Error downloading %s: %v", path, err)
+			return false
+		}
+
+		// 类型暂停或处理失败时不会标记为已处理
+		if !c.ingestWait(ctx, &logSource{
+			path:    path,
+			size:    obj.Size,
+			modTime: obj.LastModified,
+			data:    data,
+		}) {
+			return false
+		}
+	}
+
+	switch src.cfg.AfterIngest {
+	case "tag":
+		t, _ := tags.MapToObjectTags(map[string]string{"cpa-logger": "processed"})
+		if err := src.client.PutObjectTagging(ctx, src.cfg.Bucket, obj.Key, t, minio.PutObjectTaggingOptions{}); err != nil {
+			log.Printf("Error tagging %s: %v", path, err)
+		}
+	case "delete":
+		if err := src.client.RemoveObject(ctx, src.cfg.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error deleting %s: %v", path, err)
+		} else {
+			log.Printf("Deleted processed object: %s", path)
+		}
+	}
+	return true
+}
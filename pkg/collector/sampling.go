@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// sampleBodies 按请求结果采样 body：失败、未写完和慢请求保留完整 body，
+// 快速成功的请求按 success_rate 保留，未采中时返回去掉 body 的副本。
+// 原 entry 不修改，关联、重放和实时订阅仍使用完整内容
+func (c *Collector) sampleBodies(entry *parser.APILogEntry, key string) *parser.APILogEntry {
+	bs := &c.cfg.BodySampling
+	if !bs.Enabled || !fastSuccess(entry, bs.SlowMs) || keepBody(key, bs.SuccessRate) {
+		return entry
+	}
+
+	trimmed := *entry
+	// 查询按请求体提取模型名，只保留 model 字段
+	trimmed.RequestBody = modelStub(entry.RequestBody)
+	trimmed.ResponseBody, trimmed.FullResponse = "", ""
+	if len(entry.UpstreamRequests) > 0 {
+		trimmed.UpstreamRequests = make([]parser.UpstreamCall, len(entry.UpstreamRequests))
+		for i, call := range entry.UpstreamRequests {
+			call.Body, call.RespBody = "", ""
+			trimmed.UpstreamRequests[i] = call
+		}
+	}
+	return &trimmed
+}
+
+// fastSuccess 是否为已写完的 2xx 请求且响应时长低于 slowMs；时长未知时按快速请求处理
+func fastSuccess(entry *parser.APILogEntry, slowMs uint32) bool {
+	if entry.Incomplete || entry.ResponseStatus < 200 || entry.ResponseStatus >= 300 {
+		return false
+	}
+	return slowMs == 0 || entry.Throughput.ResponseMs < slowMs
+}
+
+// keepBody 按 key 的哈希确定性采样，与 sample_rate 的采样相互独立
+func keepBody(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte("body:" + key))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// modelStub 只包含请求体中 model 字段的 JSON，没有模型名时为空
+func modelStub(body string) string {
+	var b struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal([]byte(body), &b) != nil || b.Model == "" {
+		return ""
+	}
+	stub, _ := json.Marshal(b)
+	return string(stub)
+}
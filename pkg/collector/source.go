@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 文件末尾没有换行且超过该时间未修改时，视为已写完的最后一行
+const lineSettleTime = 30 * time.Second
+
+// logSource 待处理的日志：磁盘文件或归档中的条目
+type logSource struct {
+	// 磁盘文件为文件路径，归档条目为 "<归档路径>!/<条目名>"
+	path    string
+	size    int64
+	modTime time.Time
+	// inode 为 0 表示未知
+	inode uint64
+	// 磁盘文件的信息，归档条目为 nil
+	info os.FileInfo
+	// 归档条目的内容，磁盘文件为 nil（从 path 读取）
+	data []byte
+	// 重新处理：跳过已处理检查，generation 参与去重令牌使写入不被当作重复
+	force      bool
+	generation int64
+	// main、json_lines 日志追加处理：offset 为上次处理到的位置，prevRecords 为之前已写入的行数；
+	// end 为本次解析到的位置（最后一个完整行之后）
+	offset      int64
+	prevRecords uint32
+	end         int64
+	// 处理结束时调用（启用异步写入时在写入确认之后），ok 表示已标记为已处理；可为 nil
+	onDone func(ok bool)
+}
+
+func (s *logSource) done(ok bool) {
+	if s.onDone != nil {
+		s.onDone(ok)
+	}
+}
+
+// insertToken 写入去重令牌，由文件路径、大小、修改时间和批次起始行确定
+func (s *logSource) insertToken(offset int) string {
+	token := fmt.Sprintf("%s:%d:%d:%d", s.path, s.size, s.modTime.UnixNano(), offset)
+	if s.generation != 0 {
+		token += fmt.Sprintf(":%d", s.generation)
+	}
+	return token
+}
+
+// processedSize 标记已处理时记录的文件大小：磁盘上按行追加的日志为已解析的位置，
+// 下次从这里继续解析追加的内容
+func (s *logSource) processedSize() int64 {
+	if s.end > 0 {
+		return s.end
+	}
+	return s.size
+}
+
+// partial 按行解析的磁盘文件末尾是否还有未解析的不完整行
+func (s *logSource) partial() bool {
+	return s.data == nil && s.end > 0 && s.end < s.size
+}
+
+// settleDelay 末尾不完整的行视为写完（超过 lineSettleTime 未修改）之前的等待时间
+func (s *logSource) settleDelay() time.Duration {
+	return max(time.Until(s.modTime.Add(lineSettleTime)), 0) + time.Second
+}
+
+// parseMain 解析 main 日志；磁盘文件从 offset 解析到 size 之前最后一个完整行，
+// 仍在写入的不完整行留到下次处理
+func (s *logSource) parseMain() ([]parser.MainLogEntry, error) {
+	var entries []parser.MainLogEntry
+	err := s.readLines(func(r io.Reader) (err error) {
+		entries, err = parser.ParseMainLogReader(r, s.modTime)
+		return err
+	})
+	// 磁盘文件从 offset 开始解析，行位置换算为在文件中的位置
+	if s.data == nil {
+		for i := range entries {
+			entries[i].Offset += s.offset
+		}
+	}
+	return entries, err
+}
+
+// parseJSONLines 与 parseMain 相同，按完整行增量解析 json_lines 日志
+func (s *logSource) parseJSONLines(tsField, idField string) ([]parser.JSONLineRecord, error) {
+	var records []parser.JSONLineRecord
+	err := s.readLines(func(r io.Reader) (err error) {
+		records, err = parser.ParseJSONLinesReader(r, s.modTime, tsField, idField)
+		return err
+	})
+	return records, err
+}
+
+// readLines 以 parse 解析按行追加的日志：归档条目解析全部内容，
+// 磁盘文件解析 [offset, end) 并设置 end
+func (s *logSource) readLines(parse func(r io.Reader) error) error {
+	if s.data != nil {
+		return parse(bytes.NewReader(s.data))
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.end, err = completeLinesEnd(f, s.offset, s.size, time.Since(s.modTime) >= lineSettleTime)
+	if err != nil {
+		return err
+	}
+	return parse(io.NewSectionReader(f, s.offset, s.end-s.offset))
+}
+
+func (s *logSource) parseAPI(logType parser.LogType) (*parser.APILogEntry, error) {
+	if s.data == nil {
+		return parser.ParseAPILog(s.path, logType)
+	}
+	return parser.ParseAPILogData(s.path, s.data, logType, s.modTime)
+}
+
+func (s *logSource) parseEmbedding() (*parser.EmbeddingLogEntry, error) {
+	if s.data == nil {
+		return parser.ParseEmbeddingLog(s.path)
+	}
+	return parser.ParseEmbeddingLogData(s.path, s.data, s.modTime)
+}
+
+func (s *logSource) parseEventBatch() (*parser.EventBatchEntry, error) {
+	if s.data == nil {
+		return parser.ParseEventBatchLog(s.path)
+	}
+	return parser.ParseEventBatchLogData(s.path, s.data, s.modTime)
+}
+
+// completeLinesEnd 返回 [offset, size) 中最后一个完整行（以换行结尾）之后的位置；
+// settled 为 true 时末尾没有换行的内容也视为完整
+func completeLinesEnd(r io.ReaderAt, offset, size int64, settled bool) (int64, error) {
+	if settled || size <= offset {
+		return max(size, offset), nil
+	}
+	buf := make([]byte, 64*1024)
+	for end := size; end > offset; {
+		start := max(end-int64(len(buf)), offset)
+		n, err := r.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return offset, nil
+}
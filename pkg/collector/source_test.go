@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testMainLine1 = `[2026-01-08 09:29:48] [a1b2c3d4] [info ] [gin_logger.go:58] 200 |          98ms |   127.0.0.1 | POST    "/v1/messages"` + "\n"
+	testMainLine2 = `[2026-01-08 09:29:49] [b1b2c3d4] [info ] [gin_logger.go:58] 200 |          12ms |   127.0.0.1 | GET     "/v1/models"` + "\n"
+	testMainLine3 = `[2026-01-08 09:29:50] [c1b2c3d4] [info ] [gin_logger.go:58] 404 |           1ms |   127.0.0.1 | GET     "/v1/unknown"`
+)
+
+func TestCompleteLinesEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		offset  int64
+		settled bool
+		want    int64
+	}{
+		{name: "complete lines", data: "a\nbb\n", want: 5},
+		{name: "partial last line", data: "a\nbb\nccc", want: 5},
+		{name: "partial last line settled", data: "a\nbb\nccc", settled: true, want: 8},
+		{name: "only partial line", data: "ccc", want: 0},
+		{name: "from offset", data: "a\nbb\nccc", offset: 2, want: 5},
+		{name: "partial line after offset", data: "a\nbb\nccc", offset: 5, want: 5},
+		{name: "offset at end", data: "a\n", offset: 2, want: 2},
+		// 超过一个读取缓冲区的不完整行
+		{name: "long partial line", data: "a\n" + strings.Repeat("x", 100*1024), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := completeLinesEnd(strings.NewReader(tt.data), tt.offset, int64(len(tt.data)), tt.settled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("completeLinesEnd = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogSourceParseMainOffsets(t *testing.T) {
+	recent := time.Now()
+	settled := time.Now().Add(-2 * lineSettleTime)
+	tests := []struct {
+		name    string
+		data    string
+		offset  int64
+		modTime time.Time
+		// 解析出的行在文件中的位置
+		offsets []int64
+		end     int64
+		partial bool
+	}{
+		{
+			name:    "whole file",
+			data:    testMainLine1 + testMainLine2,
+			modTime: recent,
+			offsets: []int64{0, int64(len(testMainLine1))},
+			end:     int64(len(testMainLine1 + testMainLine2)),
+		},
+		{
+			name:    "appended lines",
+			data:    testMainLine1 + testMainLine2,
+			offset:  int64(len(testMainLine1)),
+			modTime: recent,
+			offsets: []int64{int64(len(testMainLine1))},
+			end:     int64(len(testMainLine1 + testMainLine2)),
+		},
+		{
+			name:    "partial last line left for recheck",
+			data:    testMainLine1 + testMainLine2 + testMainLine3,
+			modTime: recent,
+			offsets: []int64{0, int64(len(testMainLine1))},
+			end:     int64(len(testMainLine1 + testMainLine2)),
+			partial: true,
+		},
+		{
+			name:    "settled partial last line",
+			data:    testMainLine1 + testMainLine2 + testMainLine3,
+			offset:  int64(len(testMainLine1 + testMainLine2)),
+			modTime: settled,
+			offsets: []int64{int64(len(testMainLine1 + testMainLine2))},
+			end:     int64(len(testMainLine1 + testMainLine2 + testMainLine3)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "main.log")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src := &logSource{path: path, size: int64(len(tt.data)), modTime: tt.modTime, offset: tt.offset}
+			entries, err := src.parseMain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.offsets) {
+				t.Fatalf("parsed %d entries, want %d", len(entries), len(tt.offsets))
+			}
+			for i, want := range tt.offsets {
+				if entries[i].Offset != want {
+					t.Errorf("entries[%d].Offset = %d, want %d", i, entries[i].Offset, want)
+				}
+			}
+			if src.end != tt.end {
+				t.Errorf("end = %d, want %d", src.end, tt.end)
+			}
+			if src.partial() != tt.partial {
+				t.Errorf("partial = %v, want %v", src.partial(), tt.partial)
+			}
+			// 下次从已解析的位置继续
+			if got := src.processedSize(); got != tt.end {
+				t.Errorf("processedSize = %d, want %d", got, tt.end)
+			}
+		})
+	}
+}
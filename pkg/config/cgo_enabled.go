@@ -0,0 +1,6 @@
+//go:build cgo
+
+package config
+
+// cgoEnabled 编译时是否启用了 cgo，SQLite、DuckDB 存储后端需要 cgo
+const cgoEnabled = true
@@ -0,0 +1,2286 @@
+// Package config 定义 cpa-logger 的配置及默认值
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	LogDir string `yaml:"log_dir"`
+	// 存储后端
+	Storage       StorageConfig    `yaml:"storage"`
+	ClickHouse    ClickHouseConfig `yaml:"clickhouse"`
+	BatchSize     int              `yaml:"batch_size"`
+	FlushInterval int              `yaml:"flush_interval_seconds"`
+	// 采集后是否删除原始日志文件
+	DeleteAfterCollect bool `yaml:"delete_after_collect"`
+	// 删除前保留的最小时间（秒），防止删除正在写入的文件
+	DeleteMinAge int `yaml:"delete_min_age_seconds"`
+	// 各类型日志的采集配置
+	LogTypes LogTypesConfig `yaml:"log_types"`
+	// API 请求日志的采样率 (0, 1]，按 request_id 确定性采样
+	SampleRate float64 `yaml:"sample_rate"`
+	// 按请求结果采样 body
+	BodySampling BodySamplingConfig `yaml:"body_sampling"`
+	// 暂停采集的日志类型，文件保留在目录中，恢复后重新扫描
+	PausedTypes []string `yaml:"paused_types"`
+	// 目录 -> 日志类型，按目录判断类型时优先于文件名前缀；相对路径基于 log_dir
+	LogTypeDirs map[string]string `yaml:"log_type_dirs"`
+	// 配置定义的日志类型，代理新增端点时无需修改代码
+	CustomLogTypes []CustomLogType `yaml:"custom_log_types"`
+	// 未写完文件（缺少响应部分）的复查间隔和最大复查次数
+	IncompleteRecheckSeconds int `yaml:"incomplete_recheck_seconds"`
+	IncompleteMaxRechecks    int `yaml:"incomplete_max_rechecks"`
+	// 提示前缀指纹的最大长度（字节），0 表示不计算
+	PrefixFingerprintChars int `yaml:"prefix_fingerprint_chars"`
+	// 非 UTF-8 内容尝试转码的编码（如 gbk），为空时替换非法字节
+	FallbackEncoding string `yaml:"fallback_encoding"`
+	// API 日志的段落标记和字段标签，代理调整日志格式时通过配置适配
+	APILogFormat APILogFormatConfig `yaml:"api_log_format"`
+	// main 日志时间戳格式（Go time 布局），按顺序尝试；秒之后可带小数部分，没有时区的格式按本地时间解析
+	MainLogTimestampLayouts []string `yaml:"main_log_timestamp_layouts"`
+	// gRPC 实时订阅服务
+	GRPC GRPCConfig `yaml:"grpc"`
+	// REST 查询 API
+	API APIConfig `yaml:"api"`
+	// main 日志写入目标: clickhouse 或 victorialogs
+	MainLogSink  string             `yaml:"main_log_sink"`
+	VictoriaLogs VictoriaLogsConfig `yaml:"victoria_logs"`
+	// 写入每行数据的主机名（默认 os.Hostname）和实例名，用于区分多台代理主机
+	Host     string `yaml:"host"`
+	Instance string `yaml:"instance"`
+	// 时间戳合理性校验
+	TimestampCheck TimestampCheckConfig `yaml:"timestamp_check"`
+	// 从 S3 存储桶采集日志
+	S3Source S3SourceConfig `yaml:"s3_source"`
+	// 文件发现与处理之间的有界队列
+	Queue QueueConfig `yaml:"queue"`
+	// ClickHouse 资源不足时暂停写入并缩小批次
+	Backpressure BackpressureConfig `yaml:"backpressure"`
+	// 写入遇到网络中断、超时等临时错误时的重试
+	InsertRetry InsertRetryConfig `yaml:"insert_retry"`
+	// 指标（expvar）HTTP 监听地址，为空时不启用
+	MetricsListen string `yaml:"metrics_listen"`
+	// 保存的查询和定时告警
+	Alerts AlertsConfig `yaml:"alerts"`
+	// 按客户端 IP 的小时用量汇总和滥用检测
+	ClientIPUsage ClientIPUsageConfig `yaml:"client_ip_usage"`
+	// 每日用量汇总，长期保留
+	DailyRollup DailyRollupConfig `yaml:"daily_rollup"`
+	// 模型价格（美元 / 百万 token），键为模型名或前缀，按最长前缀匹配
+	Pricing map[string]ModelPrice `yaml:"pricing"`
+	// 模型价格表（model_pricing），供费用查询关联
+	ModelPricing ModelPricingConfig `yaml:"model_pricing"`
+	// 按 API key 的月度账单导出
+	Billing BillingConfig `yaml:"billing"`
+	// 失败请求重放队列
+	ReplayQueue ReplayQueueConfig `yaml:"replay_queue"`
+	// 容量预测
+	Forecast ForecastConfig `yaml:"forecast"`
+	// 定时合并表的 part（OPTIMIZE）
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
+	// 按表的数据保留策略
+	Retention RetentionConfig `yaml:"retention"`
+	// 离线模式：解析结果写入本地 spool 目录，不连接 ClickHouse
+	Spool SpoolConfig `yaml:"spool"`
+	// 写入 ClickHouse 前先写入本地预写队列
+	WAL WALConfig `yaml:"wal"`
+	// 本地记录已处理的文件，检查文件是否已处理时不查询 ClickHouse
+	StateStore StateStoreConfig `yaml:"state_store"`
+}
+
+// StorageConfig 采集结果的存储后端
+type StorageConfig struct {
+	// 已注册的后端名称，默认 clickhouse；其他后端只运行采集器，
+	// 查询 API、告警、汇总等依赖 ClickHouse 的功能不可用
+	Type string `yaml:"type"`
+	// storage.type 为 elasticsearch 时的配置
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	// storage.type 为 loki 时的配置
+	Loki LokiConfig `yaml:"loki"`
+	// storage.type 为 parquet 时的配置，archive 为 true 时与 ClickHouse 同时写入
+	Parquet ParquetConfig `yaml:"parquet"`
+	// storage.type 为 sqlite 时的配置
+	SQLite SQLiteConfig `yaml:"sqlite"`
+	// storage.type 为 duckdb 时的配置
+	DuckDB DuckDBConfig `yaml:"duckdb"`
+	// storage.type 为 stdout 时的配置
+	Stdout StdoutConfig `yaml:"stdout"`
+	// 同时写入的其他存储后端，各自使用 storage 下同名的配置（可用 options 覆盖）
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
+// SinkConfig 附加的写入目标：storage.type 写入成功后异步写入，每个目标有独立的队列和重试，
+// 写入慢或失败不影响 storage.type 和其他目标；已处理文件的记录只使用 storage.type
+type SinkConfig struct {
+	// 名称，用于日志和 /debug/vars 的指标，默认与 type 相同；同一后端的多个目标须使用不同的名称
+	Name string `yaml:"name"`
+	// 已注册的后端名称，不能与 storage.type 相同
+	Type string `yaml:"type"`
+	// 覆盖 storage 下同名配置中的字段（如另一个 Elasticsearch 的 url、index_prefix）
+	Options yaml.Node `yaml:"options,omitempty"`
+	// 只写入这些日志类型，为空时写入所有类型
+	LogTypes []string `yaml:"log_types"`
+	// 待写入的批次数上限，队列满时丢弃新的批次，默认 1000
+	QueueSize int `yaml:"queue_size"`
+	// 写入失败后的重试次数，之后丢弃该批次，默认 3
+	MaxRetries int `yaml:"max_retries"`
+	// 丢弃的批次以 spool 压缩包写入该目录，下次启动时重新写入；为空时丢弃的批次不再写入
+	DeadLetterDir string `yaml:"dead_letter_dir"`
+	// 合并 options 后该目标使用的存储配置，由 Load 填充
+	Storage StorageConfig `yaml:"-"`
+}
+
+// sinkStorage 返回附加目标使用的存储配置：storage 下的配置合并 options 中的字段
+func sinkStorage(s *StorageConfig, sink *SinkConfig) (StorageConfig, error) {
+	merged := *s
+	merged.Sinks = nil
+	if sink.Options.IsZero() {
+		return merged, nil
+	}
+	var section interface{}
+	switch sink.Type {
+	case "elasticsearch":
+		// 模板映射与 storage.elasticsearch 共用，合并前复制
+		templates := make(map[string]string, len(s.Elasticsearch.Templates))
+		for k, v := range s.Elasticsearch.Templates {
+			templates[k] = v
+		}
+		merged.Elasticsearch.Templates = templates
+		section = &merged.Elasticsearch
+	case "loki":
+		section = &merged.Loki
+	case "parquet":
+		section = &merged.Parquet
+	case "sqlite":
+		section = &merged.SQLite
+	case "duckdb":
+		section = &merged.DuckDB
+	case "stdout":
+		section = &merged.Stdout
+	default:
+		return merged, fmt.Errorf("options are not supported for storage backend %s", sink.Type)
+	}
+	if err := sink.Options.Decode(section); err != nil {
+		return merged, fmt.Errorf("invalid options: %w", err)
+	}
+	return merged, nil
+}
+
+// StdoutConfig 将解析结果以 NDJSON 输出，用于检查解析结果
+type StdoutConfig struct {
+	// 为空时写到标准输出，否则追加到该文件
+	Path string `yaml:"path"`
+	// 已处理文件的记录，为空时只记录在内存中（每次启动重新输出所有文件）
+	StateFile string `yaml:"state_file"`
+}
+
+// SQLiteConfig 写入本地 SQLite 数据库文件（需使用 CGO_ENABLED=1 编译）
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// DuckDBConfig 写入本地 DuckDB 数据库文件（需使用 CGO_ENABLED=1 编译）
+type DuckDBConfig struct {
+	Path string `yaml:"path"`
+	// 空闲多少秒后关闭数据库文件，其他进程（DuckDB CLI、Python）此时可以打开；0 为一直打开
+	IdleCloseSeconds int `yaml:"idle_close_seconds"`
+}
+
+// ParquetConfig 将各表的行写为 Parquet 文件上传到 S3 / MinIO 或写入本地目录，
+// 对象键为 <prefix>/date=YYYY-MM-DD/<表>_<主机>_<时间>.parquet（日期为 UTC）
+type ParquetConfig struct {
+	// storage.type 为 clickhouse 时同时写入 Parquet 归档
+	Archive bool `yaml:"archive"`
+	// 本地目录，设置时文件写入 <dir>/<prefix>/date=YYYY-MM-DD/ 而不上传 S3，供本机的 DuckDB 等直接查询
+	Dir string `yaml:"dir"`
+	// 如 s3.amazonaws.com、minio.local:9000
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	Prefix   string `yaml:"prefix"`
+	// 为空时依次使用 AWS 环境变量和实例 IAM 角色
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	UseSSL          bool   `yaml:"use_ssl"`
+	// 缓冲的行数达到 max_rows 或距上次上传超过 flush_interval_seconds 时写出文件
+	MaxRows       int `yaml:"max_rows"`
+	FlushInterval int `yaml:"flush_interval_seconds"`
+	// 单独使用时本机已处理文件的记录，文件的数据上传后才记录
+	StateFile string `yaml:"state_file"`
+}
+
+// LokiConfig 通过 push API 写入 Grafana Loki，每行日志为一条 JSON 行，
+// log_type、level、method、status、host、instance 作为流标签
+type LokiConfig struct {
+	// 如 http://localhost:3100
+	URL string `yaml:"url"`
+	// 多租户时的 X-Scope-OrgID
+	TenantID string `yaml:"tenant_id"`
+	// Basic 认证（如 Grafana Cloud）
+	Username       string `yaml:"username"`
+	Password       string `yaml:"password"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+	// Loki 中无法查询已处理的文件，记录在本地文件中
+	StateFile string `yaml:"state_file"`
+}
+
+// ElasticsearchConfig 写入 Elasticsearch / OpenSearch，各表按天写入索引 <index_prefix>-<表>-YYYY.MM.DD（UTC），
+// 已处理文件记录在 <index_prefix>-processed_files
+type ElasticsearchConfig struct {
+	// 如 http://localhost:9200
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// API key（Base64 编码的 id:api_key），优先于用户名密码
+	APIKey         string `yaml:"api_key"`
+	IndexPrefix    string `yaml:"index_prefix"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+	// 表 -> 索引模板文件（_index_template 请求体的 JSON），未配置的表使用内置模板
+	Templates map[string]string `yaml:"templates"`
+	// ILM 策略名，非空时内置模板设置 index.lifecycle.name（OpenSearch 不支持 ILM，留空）
+	ILMPolicy string `yaml:"ilm_policy"`
+	// ILM 策略文件（_ilm/policy 请求体的 JSON）；为空且 ilm_delete_days 大于 0 时创建按索引天数删除的策略
+	ILMPolicyFile string `yaml:"ilm_policy_file"`
+	ILMDeleteDays int    `yaml:"ilm_delete_days"`
+}
+
+// WALConfig 预写队列：解析结果先以 spool 压缩包的格式写入本地目录（fsync）即视为写入成功，
+// 由后台协程按写入顺序写入 ClickHouse，ClickHouse 不可用时积压在磁盘上，恢复后继续写入
+type WALConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+	// 写入失败后的最长重试间隔（秒），从 1 秒开始翻倍
+	MaxBackoffSeconds int `yaml:"max_backoff_seconds"`
+	// 积压的压缩包总大小上限（MB），达到后写入返回错误（文件稍后重新处理），0 表示不限制
+	MaxSizeMB int `yaml:"max_size_mb"`
+}
+
+// StateStoreConfig 已处理文件的记录保存在本地 state_file 中，检查时只读取本地记录；
+// 标记已处理时同时写入 processed_files 表，写入失败的记录每隔 sync_interval_seconds 重试
+type StateStoreConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	StateFile string `yaml:"state_file"`
+	// 重试写入 processed_files 的间隔（秒）
+	SyncIntervalSeconds int `yaml:"sync_interval_seconds"`
+}
+
+// SpoolConfig 采集主机无法连接 ClickHouse 时，解析结果按文件压缩写入 spool 目录，
+// 将目录复制到联网主机后执行 cpa-logger -ship <目录> 上传
+type SpoolConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+// RetentionConfig 按表保留数据：启动时将各表的 TTL 改为保留天数（不重写已有的 part），
+// 并定时删除整个分区都已过期的按天分区，比等待 TTL 合并更快释放空间
+type RetentionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 删除过期分区的间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 表 -> 保留天数，表须有 timestamp 列
+	Tables map[string]int `yaml:"tables"`
+	// api_logs、embedding_logs 中 body 列的保留天数（列 TTL，过期后置空），0 表示与行一同过期
+	BodyDays int `yaml:"body_days"`
+}
+
+// MaintenanceConfig 在低峰时段对近期写入过的分区执行 OPTIMIZE ... FINAL，
+// 合并频繁小批量写入产生的 part，加快 FINAL 查询
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 检查间隔（秒），只在 quiet_hours 内执行
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 低峰时段（本地时间），如 02:00-05:00，可跨零点
+	QuietHours string `yaml:"quiet_hours"`
+	// 只合并最近多少天内有写入的分区
+	LookbackDays int `yaml:"lookback_days"`
+	// 需要合并的表，不存在的表跳过
+	Tables []string `yaml:"tables"`
+
+	// 由 quiet_hours 解析的起止时间（一天中的分钟数）
+	quietStart, quietEnd int
+}
+
+// InQuietHours t 是否处于低峰时段
+func (m *MaintenanceConfig) InQuietHours(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if m.quietStart <= m.quietEnd {
+		return minute >= m.quietStart && minute < m.quietEnd
+	}
+	return minute >= m.quietStart || minute < m.quietEnd
+}
+
+// ForecastConfig 按历史每日请求量和 token 量预测未来用量（capacity_forecast 表），
+// 预计在 warn_days 内超过 ClickHouse 磁盘或上游配额时告警
+type ForecastConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 预测间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 用于拟合的历史天数，不少于 14 天时计算按星期的季节因子
+	HistoryDays int `yaml:"history_days"`
+	// 预测天数
+	HorizonDays int `yaml:"horizon_days"`
+	// 预计在多少天内超限时告警
+	WarnDays int `yaml:"warn_days"`
+	// ClickHouse 可用磁盘上限（GB），0 表示取数据所在磁盘的已用 + 剩余空间
+	DiskLimitGB float64       `yaml:"disk_limit_gb"`
+	Quotas      []QuotaConfig `yaml:"quotas"`
+	// 告警通知渠道，引用 alerts.channels
+	Channels []string `yaml:"channels"`
+}
+
+// QuotaConfig 上游配额，统计客户端请求（v1_*），0 表示不检查该项
+type QuotaConfig struct {
+	Name string `yaml:"name"`
+	// 模型名前缀，为空时统计全部模型
+	Model string `yaml:"model"`
+	// 配额周期：day 或 month
+	Period   string `yaml:"period"`
+	Requests uint64 `yaml:"requests"`
+	// 输入 + 输出 token 数
+	Tokens uint64 `yaml:"tokens"`
+}
+
+// ReplayQueueConfig 上游失败（可重试状态码）的客户端请求写入 replay_queue 表，上游恢复后重新发送
+type ReplayQueueConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 视为可重试的响应状态码
+	Statuses []int `yaml:"statuses"`
+	// 重放目标地址（通常为代理地址），请求路径和查询参数沿用原请求
+	TargetURL string `yaml:"target_url"`
+	// 重放时覆盖的请求头，如日志中的 API key 已脱敏时指定可用的 key
+	Headers map[string]string `yaml:"headers"`
+	// 最大重放次数，仍失败时标记为 failed
+	MaxAttempts int `yaml:"max_attempts"`
+	// 单次重放的超时时间（秒）
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+}
+
+// Retryable 状态码是否可重试
+func (c *ReplayQueueConfig) Retryable(status int) bool {
+	for _, s := range c.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// BillingConfig 账单导出配置
+type BillingConfig struct {
+	// 在费用基础上加收的比例，如 0.1 表示加收 10%
+	Markup float64 `yaml:"markup"`
+	// 按 API key 标识（如 sk-ant-api03-...a1b2）覆盖加收比例
+	KeyMarkups map[string]float64 `yaml:"key_markups"`
+}
+
+// DailyRollupConfig 按天汇总请求数、token、费用和错误数到 daily_usage 表（不过期）
+type DailyRollupConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 汇总间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 每次重新计算最近多少天（含当天）
+	LookbackDays int `yaml:"lookback_days"`
+}
+
+// ModelPrice 模型单价（美元 / 百万 token）
+type ModelPrice struct {
+	Input      float64 `yaml:"input"`
+	Output     float64 `yaml:"output"`
+	CacheRead  float64 `yaml:"cache_read"`
+	CacheWrite float64 `yaml:"cache_write"`
+}
+
+// ModelPricingConfig 将模型价格写入 model_pricing 表，价格文件变化时刷新
+type ModelPricingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 价格文件（YAML 或 JSON），为空时写入 pricing 中的价格
+	File string `yaml:"file"`
+	// 检查价格文件变化的间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+}
+
+// ModelPricing 价格文件中的一条价格
+type ModelPricing struct {
+	Model      string `yaml:"model"`
+	ModelPrice `yaml:",inline"`
+	// 生效日期（YYYY-MM-DD），为空表示一直有效
+	EffectiveDate string `yaml:"effective_date"`
+}
+
+// ClientIPUsageConfig 客户端 IP 小时用量汇总（client_ip_hourly 表），超过阈值的 IP 写入 abuse_candidates 表
+type ClientIPUsageConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 汇总间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 每次重新计算最近多少小时（含当前小时），覆盖延迟采集的日志
+	LookbackHours int             `yaml:"lookback_hours"`
+	Abuse         AbuseThresholds `yaml:"abuse"`
+}
+
+// AbuseThresholds 单个 IP 每小时的滥用判定阈值，0 表示不检查该项
+type AbuseThresholds struct {
+	RequestsPerHour uint64 `yaml:"requests_per_hour"`
+	// 输入 + 输出 token 数
+	TokensPerHour uint64 `yaml:"tokens_per_hour"`
+	// 错误（状态码 >= 400）比例 (0, 1]
+	ErrorRate float64 `yaml:"error_rate"`
+	// 请求数达到该值时才检查错误比例
+	MinRequests uint64 `yaml:"min_requests"`
+}
+
+// AlertsConfig 保存的查询和定时告警规则
+type AlertsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 告警规则评估间隔（秒）
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// 通过 API 保存的查询写入该文件，重启后恢复
+	StateFile string         `yaml:"state_file"`
+	Channels  []AlertChannel `yaml:"channels"`
+	Searches  []SavedSearch  `yaml:"searches"`
+	SLOs      []SLOConfig    `yaml:"slos"`
+}
+
+// SLOConfig 服务等级目标，按错误预算消耗速度（burn rate）告警
+type SLOConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// 请求范围：日志类型（接口）和模型，都为空时统计全部客户端请求（v1_*）
+	LogType string `yaml:"log_type" json:"log_type,omitempty"`
+	Model   string `yaml:"model" json:"model,omitempty"`
+	// availability：状态码 < 500 为达标；latency：延迟不超过 latency_ms 为达标
+	Type      string `yaml:"type" json:"type"`
+	LatencyMs int    `yaml:"latency_ms" json:"latency_ms,omitempty"`
+	// 达标比例目标，如 0.999
+	Objective float64 `yaml:"objective" json:"objective"`
+	// 合规统计周期（天）
+	WindowDays int `yaml:"window_days" json:"window_days"`
+	// 多窗口 burn rate 告警，长短窗口的消耗速度都达到阈值时触发
+	BurnAlerts []BurnAlert `yaml:"burn_alerts" json:"burn_alerts"`
+	Channels   []string    `yaml:"channels" json:"channels,omitempty"`
+}
+
+// BurnAlert 错误预算消耗速度告警：burn rate 为不达标比例与 (1 - objective) 之比
+type BurnAlert struct {
+	LongWindowSeconds  int     `yaml:"long_window_seconds" json:"long_window_seconds"`
+	ShortWindowSeconds int     `yaml:"short_window_seconds" json:"short_window_seconds"`
+	BurnRate           float64 `yaml:"burn_rate" json:"burn_rate"`
+}
+
+// DefaultBurnAlerts 未配置 burn_alerts 时使用的多窗口告警（快速消耗 1h/5m，慢速消耗 6h/30m）
+var DefaultBurnAlerts = []BurnAlert{
+	{LongWindowSeconds: 3600, ShortWindowSeconds: 300, BurnRate: 14.4},
+	{LongWindowSeconds: 6 * 3600, ShortWindowSeconds: 1800, BurnRate: 6},
+}
+
+// AlertChannel 告警通知渠道
+type AlertChannel struct {
+	Name string `yaml:"name"`
+	// webhook（POST JSON）或 slack（incoming webhook）
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+}
+
+// SavedSearch 命名的 API 请求查询；配置了 channels 时作为告警规则定时评估
+type SavedSearch struct {
+	Name  string `yaml:"name" json:"name"`
+	Model string `yaml:"model" json:"model,omitempty"`
+	// 状态码（如 529）或类别（如 5xx）
+	Status   string `yaml:"status" json:"status,omitempty"`
+	LogType  string `yaml:"log_type" json:"log_type,omitempty"`
+	ClientIP string `yaml:"client_ip" json:"client_ip,omitempty"`
+	Host     string `yaml:"host" json:"host,omitempty"`
+	// 查询最近多少秒内的请求
+	WindowSeconds int `yaml:"window_seconds" json:"window_seconds"`
+	// 匹配数达到该值时告警
+	Threshold int `yaml:"threshold" json:"threshold,omitempty"`
+	// 告警通知的渠道名称
+	Channels []string `yaml:"channels" json:"channels,omitempty"`
+}
+
+// TimestampCheckConfig 时间戳校验配置，以文件修改时间为参照
+type TimestampCheckConfig struct {
+	// 晚于文件修改时间超过该秒数视为异常
+	MaxFutureSeconds int `yaml:"max_future_seconds"`
+	// 早于文件修改时间超过该天数视为异常，0 表示不检查
+	MaxPastDays int `yaml:"max_past_days"`
+	// 异常时间戳是否替换为文件修改时间
+	UseFileMtime bool `yaml:"use_file_mtime"`
+}
+
+// S3SourceConfig S3 日志来源配置
+type S3SourceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 如 s3.amazonaws.com、minio.local:9000
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	Prefix   string `yaml:"prefix"`
+	// 为空时依次使用 AWS 环境变量和实例 IAM 角色
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	UseSSL          bool   `yaml:"use_ssl"`
+	// 列举新对象的间隔（秒）
+	PollInterval int `yaml:"poll_interval_seconds"`
+	// 记录已处理到的对象键，重启后从该位置继续列举
+	MarkerFile string `yaml:"marker_file"`
+	// 处理后的操作: none、tag（添加 cpa-logger=processed 标签）、delete
+	AfterIngest string `yaml:"after_ingest"`
+}
+
+// QueueConfig 处理队列配置
+type QueueConfig struct {
+	// 队列容量（文件数）
+	Size int `yaml:"size"`
+	// 并发处理的文件数
+	Workers int `yaml:"workers"`
+	// 队列满时的策略: block（阻塞发现）、spill（溢出到磁盘）、drop（丢弃低优先级类型）
+	OverflowPolicy string `yaml:"overflow_policy"`
+	// spill 策略的溢出文件目录
+	SpillDir string `yaml:"spill_dir"`
+	// drop 策略下可丢弃的日志类型，其他类型仍阻塞等待
+	DropTypes []string `yaml:"drop_types"`
+	// 异步写入协程数，0 表示在处理协程中同步写入
+	InsertWriters int `yaml:"insert_writers"`
+	// 已提交未确认的最大写入批次数，达到后解析等待写入
+	MaxInFlightBatches int `yaml:"max_in_flight_batches"`
+}
+
+// BackpressureConfig ClickHouse 返回 TOO_MANY_PARTS、内存超限等资源错误时，
+// 按指数退避暂停处理新文件并缩小 main 日志批次，写入恢复成功后逐步恢复
+type BackpressureConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 首次暂停时间，之后每次仍失败时翻倍
+	MinBackoffSeconds int `yaml:"min_backoff_seconds"`
+	MaxBackoffSeconds int `yaml:"max_backoff_seconds"`
+	// 缩小后的最小批次大小
+	MinBatchSize int `yaml:"min_batch_size"`
+}
+
+// InsertRetryConfig 写入批次遇到可重试的错误（连接中断、超时、副本只读等）时按指数退避重试，
+// 重试的批次带同一去重令牌，不会产生重复数据
+type InsertRetryConfig struct {
+	// 每个批次最多尝试的次数，1 表示不重试
+	MaxAttempts int `yaml:"max_attempts"`
+	// 第一次重试前的等待时间，之后每次翻倍
+	InitialBackoffMs int `yaml:"initial_backoff_ms"`
+	MaxBackoffMs     int `yaml:"max_backoff_ms"`
+	// 等待时间的随机浮动比例（0-1），避免多个采集器同时重试
+	Jitter float64 `yaml:"jitter"`
+}
+
+// VictoriaLogsConfig VictoriaLogs JSON line 写入配置
+type VictoriaLogsConfig struct {
+	// 如 http://localhost:9428
+	URL string `yaml:"url"`
+	// 作为日志流标识的字段
+	StreamFields   []string `yaml:"stream_fields"`
+	AccountID      string   `yaml:"account_id"`
+	ProjectID      string   `yaml:"project_id"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+}
+
+// LogTypesConfig 各类型日志的采集配置
+type LogTypesConfig struct {
+	Main                LogTypeConfig `yaml:"main"`
+	V1Messages          LogTypeConfig `yaml:"v1_messages"`
+	V1CountTokens       LogTypeConfig `yaml:"v1_count_tokens"`
+	ProviderMessages    LogTypeConfig `yaml:"provider_messages"`
+	ProviderCountTokens LogTypeConfig `yaml:"provider_count_tokens"`
+	ProviderResponses   LogTypeConfig `yaml:"provider_responses"`
+	EventBatch          LogTypeConfig `yaml:"event_batch"`
+	V1Embeddings        LogTypeConfig `yaml:"v1_embeddings"`
+}
+
+// LogTypeConfig 单个日志类型配置
+type LogTypeConfig struct {
+	Enabled            bool  `yaml:"enabled"`
+	DeleteAfterCollect *bool `yaml:"delete_after_collect,omitempty"` // 覆盖全局配置
+	// 每批写入的行数，0 表示使用顶层 batch_size
+	BatchSize int `yaml:"batch_size,omitempty"`
+}
+
+// CustomLogType 配置定义的日志类型：按文件名前缀或正则匹配，使用内置解析方式之一，
+// 可写入独立的表。文件名匹配先于内置类型，log_type_dirs 的目录匹配仍然优先
+type CustomLogType struct {
+	Name string `yaml:"name"`
+	// 文件名前缀和文件名正则二选一
+	Prefix  string `yaml:"prefix"`
+	Pattern string `yaml:"pattern"`
+	// 解析方式: main、api、event 或 json_lines
+	Parser string `yaml:"parser"`
+	// 写入的表，为空时写入解析方式对应的默认表；json_lines 必须指定
+	Table string `yaml:"table"`
+	// 是否采集，默认 true
+	Enabled            *bool `yaml:"enabled"`
+	DeleteAfterCollect *bool `yaml:"delete_after_collect,omitempty"` // 覆盖全局配置
+	// 每批写入的行数，0 表示使用顶层 batch_size
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// json_lines 中时间戳和 request_id 的字段名
+	TimestampField string `yaml:"timestamp_field"`
+	RequestIDField string `yaml:"request_id_field"`
+}
+
+// APILogFormatConfig API 日志格式配置
+type APILogFormatConfig struct {
+	// 段落标记正则（按行匹配），须包含一个捕获段落名的分组
+	SectionPattern string       `yaml:"section_pattern"`
+	Sections       SectionNames `yaml:"sections"`
+	Labels         FieldLabels  `yaml:"labels"`
+}
+
+// SectionNames API 日志各段落的名称，api_request/api_response 为前缀（后接序号）
+type SectionNames struct {
+	RequestInfo string `yaml:"request_info"`
+	Headers     string `yaml:"headers"`
+	RequestBody string `yaml:"request_body"`
+	Response    string `yaml:"response"`
+	APIRequest  string `yaml:"api_request"`
+	APIResponse string `yaml:"api_response"`
+}
+
+// FieldLabels API 日志各字段的标签
+type FieldLabels struct {
+	Version         string `yaml:"version"`
+	URL             string `yaml:"url"`
+	Method          string `yaml:"method"`
+	Timestamp       string `yaml:"timestamp"`
+	Status          string `yaml:"status"`
+	UpstreamURL     string `yaml:"upstream_url"`
+	UpstreamMethod  string `yaml:"upstream_method"`
+	UpstreamHeaders string `yaml:"upstream_headers"`
+	UpstreamBody    string `yaml:"upstream_body"`
+}
+
+// DefaultMainLogTimestampLayouts main 日志默认的时间戳格式：[2026-01-08 09:29:48(.123)] 和 ISO-8601
+func DefaultMainLogTimestampLayouts() []string {
+	return []string{"2006-01-02 15:04:05", time.RFC3339}
+}
+
+// DefaultSectionNames CLIProxyAPI 默认的段落名称
+func DefaultSectionNames() SectionNames {
+	return SectionNames{
+		RequestInfo: "REQUEST INFO",
+		Headers:     "HEADERS",
+		RequestBody: "REQUEST BODY",
+		Response:    "RESPONSE",
+		APIRequest:  "API REQUEST",
+		APIResponse: "API RESPONSE",
+	}
+}
+
+// DefaultFieldLabels CLIProxyAPI 默认的字段标签
+func DefaultFieldLabels() FieldLabels {
+	return FieldLabels{
+		Version:         "Version:",
+		URL:             "URL:",
+		Method:          "Method:",
+		Timestamp:       "Timestamp:",
+		Status:          "Status:",
+		UpstreamURL:     "Upstream URL:",
+		UpstreamMethod:  "HTTP Method:",
+		UpstreamHeaders: "Headers:",
+		UpstreamBody:    "Body:",
+	}
+}
+
+// GRPCConfig gRPC 实时订阅服务配置
+type GRPCConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 默认只监听本机；监听其他地址时须配置 token
+	Listen string `yaml:"listen"`
+	// 订阅方须在 authorization 元数据中带 "Bearer <token>"，为空时不认证
+	Token string `yaml:"token"`
+	// 每个订阅方的缓冲条数，缓冲满时丢弃新记录
+	BufferSize int `yaml:"buffer_size"`
+}
+
+// APIConfig REST 查询 API 配置
+type APIConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 默认只监听本机；未配置任何 token 时只能监听本机地址
+	Listen string `yaml:"listen"`
+	// 管理员 token（等同于 role 为 admin 的 token）
+	AdminToken string `yaml:"admin_token"`
+	// 访问 token 及角色；未配置任何 token 时查询接口无需认证，管理接口不启用
+	Tokens []APIToken `yaml:"tokens"`
+	// 静态 token 文件，每行 "<name> <role> <token>"，# 开头为注释，修改后自动重新加载
+	TokenFile string `yaml:"token_file"`
+}
+
+// HasTokens 是否配置了任何 token
+func (c *APIConfig) HasTokens() bool {
+	return c.AdminToken != "" || len(c.Tokens) > 0 || c.TokenFile != ""
+}
+
+// APIToken API 访问 token
+type APIToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	// read（只读查询）或 admin（查询及管理操作）
+	Role string `yaml:"role"`
+}
+
+type ClickHouseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Database string `yaml:"database"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// 表名前缀，多个实例（如 staging、prod）共用一个数据库时区分各自的表
+	TablePrefix string `yaml:"table_prefix"`
+	// 数据表和 processed_files 表的表名（不含前缀），未配置的表使用原名
+	TableNames map[string]string `yaml:"table_names"`
+	// Buffer 表配置，用于削峰突发写入
+	Buffer BufferTableConfig `yaml:"buffer"`
+	// 在采集器内存中合并各文件的小批量写入
+	BatchInserts BatchInsertsConfig `yaml:"batch_inserts"`
+	// body 去重：相同 body 只在 bodies 表存一份，api_logs 仅存哈希
+	BodyDedup bool `yaml:"body_dedup"`
+	// 为 body 列添加 JSON 类型的 MATERIALIZED 列（request_json 等），需要 ClickHouse 24.8+
+	JSONColumns bool `yaml:"json_columns"`
+	// 关联同一 request_id 的 v1 日志和 provider 日志，记录实际提供服务的上游到 routing 表
+	RoutingTable bool `yaml:"routing_table"`
+	// v1 日志和 provider 日志都写入后生成端到端请求追踪（request_traces 表）
+	RequestTraces bool `yaml:"request_traces"`
+	// 表结构模式: managed（自动建表）、mapped（写入用户维护的表）或 staged（自动建表，经 Null 表和物化视图写入）
+	SchemaMode string `yaml:"schema_mode"`
+	// staged 模式下按 log_type 分流：每个类型另建 api_logs_<类型> 表，由物化视图从暂存表写入
+	StagedTypes []string `yaml:"staged_types"`
+	// 启动时已有的表缺少当前版本需要的列时自动 ALTER 补列；默认关闭，报告缺少的列及补列语句后退出
+	AutoAddColumns bool `yaml:"auto_add_columns"`
+	// 单个查询的最长执行时间（ClickHouse max_execution_time 设置），0 表示不限制
+	MaxExecutionTime int `yaml:"max_execution_time_seconds"`
+	// 建立连接的超时时间
+	DialTimeout int `yaml:"dial_timeout_seconds"`
+	// 连接池：最大连接数、最大空闲连接数和连接的最长使用时间（秒）
+	MaxOpenConns    int `yaml:"max_open_conns"`
+	MaxIdleConns    int `yaml:"max_idle_conns"`
+	ConnMaxLifetime int `yaml:"conn_max_lifetime_seconds"`
+	// 各类操作的客户端超时
+	Timeouts ClickHouseTimeouts `yaml:"timeouts"`
+	// 定时检查连接，失败时重新建立连接池
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	// body 列的应用层加密
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// 写入前按列脱敏
+	Masking MaskingConfig `yaml:"masking"`
+	// 按租户将请求日志写入独立的数据库或表
+	TenantRouting TenantRoutingConfig `yaml:"tenant_routing"`
+	// mapped 模式下各数据表（main_logs/api_logs/event_logs/embedding_logs）的映射
+	TableMappings map[string]TableMapping `yaml:"table_mappings"`
+	// 各数据表的分区方式和排序键，只在建表时生效
+	Partitioning map[string]PartitioningConfig `yaml:"partitioning"`
+	// 多节点部署
+	Cluster ClusterConfig `yaml:"cluster"`
+}
+
+// ClusterConfig 多节点部署：建表、补列等 DDL 带 ON CLUSTER 在集群所有节点执行，
+// 可使用 Replicated*MergeTree 引擎，并为数据表创建跨分片查询的 Distributed 表
+type ClusterConfig struct {
+	// remote_servers 中的集群名，为空时为单节点部署
+	Name string `yaml:"name"`
+	// 各表使用 Replicated*MergeTree 引擎（需要 ClickHouse Keeper / ZooKeeper）
+	Replicated bool `yaml:"replicated"`
+	// 副本在 Keeper 中的路径和副本名，可使用 {shard}、{replica}、{database}、{table} 宏
+	ZooKeeperPath string `yaml:"zookeeper_path"`
+	ReplicaName   string `yaml:"replica_name"`
+	// 为各数据表创建 <表>_all 的 Distributed 表用于跨分片查询，写入仍使用所连接节点的本地表
+	Distributed bool `yaml:"distributed"`
+	// Distributed 表的分片键
+	ShardingKey string `yaml:"sharding_key"`
+}
+
+// BodySamplingConfig 失败和慢请求始终保留完整 body，快速成功的请求只按比例保留，
+// 其余请求仍写入元数据（状态码、token 用量等），请求体只保留 model 字段
+type BodySamplingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 快速成功请求保留 body 的比例 [0, 1]，按 request_id 确定性采样
+	SuccessRate float64 `yaml:"success_rate"`
+	// 响应时长达到该值（毫秒）视为慢请求，0 表示不按时长区分
+	SlowMs uint32 `yaml:"slow_ms"`
+}
+
+// ClickHouseTimeouts 各类操作的客户端超时（秒），0 表示不限制（仍受调用方 context 约束）
+type ClickHouseTimeouts struct {
+	// 启动时的连接检查
+	Ping int `yaml:"ping_seconds"`
+	// 每条建表、补列等 DDL 语句
+	DDL int `yaml:"ddl_seconds"`
+	// 写入一个文件的全部批次（含标记已处理）
+	Insert int `yaml:"insert_seconds"`
+	// 检查文件是否已处理
+	DedupQuery int `yaml:"dedup_query_seconds"`
+	// 检查和解析一个文件（不含写入，写入受 Insert 限制）
+	Process int `yaml:"process_seconds"`
+}
+
+// HealthCheckConfig 定时 ping ClickHouse，连续失败达到阈值时标记为不可用并重新建立连接池；
+// 不可用期间采集器暂缓删除原始日志
+type HealthCheckConfig struct {
+	Enabled         bool `yaml:"enabled"`
+	IntervalSeconds int  `yaml:"interval_seconds"`
+	// 连续失败多少次后标记为不可用
+	FailureThreshold int `yaml:"failure_threshold"`
+}
+
+// EncryptionConfig 写入前用 AES-256-GCM 加密请求体、响应体等 body 列，查询和导出时解密。
+// 密钥为 base64 编码的 32 字节，从 key_env、key_file、key_command 中的一种读取
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 密钥标识，随密文一起存储，解密时校验，默认 default
+	KeyID string `yaml:"key_id"`
+	// 存放密钥的环境变量名
+	KeyEnv string `yaml:"key_env"`
+	// 密钥文件路径
+	KeyFile string `yaml:"key_file"`
+	// 输出密钥的命令（通过 sh -c 执行），用于从 KMS、Vault 等获取密钥
+	KeyCommand string `yaml:"key_command"`
+}
+
+// MaskingConfig 按列的脱敏策略，在存储层写入前统一执行
+type MaskingConfig struct {
+	// hash 动作计算哈希时附加的盐，防止通过枚举还原短值（如 IP）
+	Salt  string        `yaml:"salt"`
+	Rules []MaskingRule `yaml:"rules"`
+}
+
+// MaskingRule 一条脱敏规则；同一列按顺序取第一条 match 命中的规则
+type MaskingRule struct {
+	// 数据表：main_logs、api_logs、event_logs、embedding_logs
+	Table string `yaml:"table"`
+	// 字段名（与 managed 模式下的列名相同）
+	Column string `yaml:"column"`
+	// keep（保留原值）、hash（加盐 SHA-256）、truncate（截断到 length 字节）、drop（写入空值）
+	Action string `yaml:"action"`
+	Length int    `yaml:"length"`
+	// 只对这些字段等于指定值的行生效，如 api_key、host；为空时对所有行生效
+	Match map[string]string `yaml:"match"`
+}
+
+// TenantRoutingConfig 将每个租户的 api_logs、embedding_logs 写入独立的数据库（<database>_<租户>）
+// 或表（<表名>_<租户>），首次写入时创建，便于单独授权和删除；无法确定租户的请求写入默认表
+type TenantRoutingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// database 或 table_suffix
+	Mode string `yaml:"mode"`
+	// 租户标识来源：api_key（日志中记录的脱敏 key）或 header（请求头 header 的值）
+	Source string `yaml:"source"`
+	Header string `yaml:"header"`
+	// 标识 -> 租户名，未列出的标识写入默认表；source 为 header 且未配置时直接使用请求头的值作为租户名
+	Tenants map[string]string `yaml:"tenants"`
+}
+
+// TableMapping 将解析字段映射到用户维护的表
+type TableMapping struct {
+	// 目标表名，可带数据库前缀（db.table），默认与数据表同名
+	Table string `yaml:"table"`
+	// 字段名 -> 列名，映射为空字符串表示不写入；未列出的字段按同名列写入（列不存在则跳过）
+	Columns map[string]string `yaml:"columns"`
+}
+
+// PartitioningConfig 数据表的分区方式和排序键
+type PartitioningConfig struct {
+	// daily（按天，默认）或 monthly（按月）；数据量小时按月分区可减少 part 数量
+	PartitionBy string `yaml:"partition_by"`
+	// ORDER BY 的表达式（如 "timestamp, request_id"），为空时使用默认排序键
+	OrderBy string `yaml:"order_by"`
+}
+
+// BufferTableConfig 在 MergeTree 表前创建 Buffer 表，写入先进入内存缓冲，
+// 满足任一 max 条件或全部 min 条件时落盘，减少小 part 数量
+type BufferTableConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	NumLayers int  `yaml:"num_layers"`
+	MinTime   int  `yaml:"min_time_seconds"`
+	MaxTime   int  `yaml:"max_time_seconds"`
+	MinRows   int  `yaml:"min_rows"`
+	MaxRows   int  `yaml:"max_rows"`
+	MinBytes  int  `yaml:"min_bytes"`
+	MaxBytes  int  `yaml:"max_bytes"`
+}
+
+// BatchInsertsConfig 合并写入：相同目标表的行在内存中累积，达到 max_rows 行或经过 flush_interval_seconds 时
+// 一次写入。写入完成前不标记文件已处理，进程退出不会丢失数据；合并后的写入不带去重令牌
+type BatchInsertsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 默认为顶层 batch_size
+	MaxRows int `yaml:"max_rows"`
+	// 默认为顶层 flush_interval_seconds
+	FlushInterval int `yaml:"flush_interval_seconds"`
+	// 按表覆盖 max_rows（main_logs、api_logs、event_logs、embedding_logs）
+	TableMaxRows map[string]int `yaml:"table_max_rows"`
+}
+
+// Load 读取并解析配置文件
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析 YAML 配置，未配置的项使用默认值并校验；data 为空时返回全部默认值
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{
+		BatchSize:                1000,
+		FlushInterval:            5,
+		DeleteMinAge:             300, // 默认 5 分钟
+		IncompleteRecheckSeconds: 30,
+		IncompleteMaxRechecks:    20,
+		PrefixFingerprintChars:   65536,
+		SampleRate:               1,
+		BodySampling: BodySamplingConfig{
+			SuccessRate: 0.1,
+			SlowMs:      30000,
+		},
+		LogTypes: LogTypesConfig{
+			Main:                LogTypeConfig{Enabled: true},
+			V1Messages:          LogTypeConfig{Enabled: true},
+			V1CountTokens:       LogTypeConfig{Enabled: true},
+			ProviderMessages:    LogTypeConfig{Enabled: true},
+			ProviderCountTokens: LogTypeConfig{Enabled: true},
+			ProviderResponses:   LogTypeConfig{Enabled: true},
+			EventBatch:          LogTypeConfig{Enabled: true},
+			V1Embeddings:        LogTypeConfig{Enabled: true},
+		},
+		APILogFormat: APILogFormatConfig{
+			SectionPattern: `^=== (.+?) ===\s*$`,
+			Sections:       DefaultSectionNames(),
+			Labels:         DefaultFieldLabels(),
+		},
+		MainLogTimestampLayouts: DefaultMainLogTimestampLayouts(),
+		GRPC: GRPCConfig{
+			Listen:     "127.0.0.1:9090",
+			BufferSize: 1000,
+		},
+		API: APIConfig{
+			Listen: "127.0.0.1:8080",
+		},
+		ClickHouse: ClickHouseConfig{
+			MaxExecutionTime: 60,
+			DialTimeout:      30,
+			MaxOpenConns:     10,
+			MaxIdleConns:     5,
+			ConnMaxLifetime:  3600,
+			Timeouts: ClickHouseTimeouts{
+				Ping:       30,
+				DDL:        120,
+				Insert:     300,
+				DedupQuery: 30,
+				Process:    300,
+			},
+			HealthCheck: HealthCheckConfig{
+				IntervalSeconds:  10,
+				FailureThreshold: 3,
+			},
+			Cluster: ClusterConfig{
+				ZooKeeperPath: "/clickhouse/tables/{shard}/{database}/{table}",
+				ReplicaName:   "{replica}",
+				ShardingKey:   "rand()",
+			},
+		},
+		MainLogSink: "clickhouse",
+		VictoriaLogs: VictoriaLogsConfig{
+			StreamFields:   []string{"level", "source", "method"},
+			TimeoutSeconds: 30,
+		},
+		TimestampCheck: TimestampCheckConfig{
+			MaxFutureSeconds: 300,
+			MaxPastDays:      30,
+		},
+		S3Source: S3SourceConfig{
+			Endpoint:     "s3.amazonaws.com",
+			UseSSL:       true,
+			PollInterval: 60,
+			MarkerFile:   "/var/lib/cpa-logger/s3-marker",
+			AfterIngest:  "none",
+		},
+		Queue: QueueConfig{
+			Size:               1000,
+			Workers:            4,
+			OverflowPolicy:     "block",
+			SpillDir:           "/var/lib/cpa-logger",
+			DropTypes:          []string{"event_batch"},
+			MaxInFlightBatches: 16,
+		},
+		Backpressure: BackpressureConfig{
+			Enabled:           true,
+			MinBackoffSeconds: 5,
+			MaxBackoffSeconds: 300,
+			MinBatchSize:      50,
+		},
+		InsertRetry: InsertRetryConfig{
+			MaxAttempts:      3,
+			InitialBackoffMs: 500,
+			MaxBackoffMs:     10000,
+			Jitter:           0.2,
+		},
+		Alerts: AlertsConfig{
+			IntervalSeconds: 60,
+			StateFile:       "/var/lib/cpa-logger/saved-searches.json",
+		},
+		ClientIPUsage: ClientIPUsageConfig{
+			IntervalSeconds: 300,
+			LookbackHours:   2,
+			Abuse: AbuseThresholds{
+				MinRequests: 20,
+			},
+		},
+		DailyRollup: DailyRollupConfig{
+			IntervalSeconds: 3600,
+			LookbackDays:    2,
+		},
+		ModelPricing: ModelPricingConfig{
+			IntervalSeconds: 300,
+		},
+		ReplayQueue: ReplayQueueConfig{
+			Statuses:       []int{429, 500, 502, 503, 504, 529},
+			MaxAttempts:    3,
+			TimeoutSeconds: 300,
+		},
+		Forecast: ForecastConfig{
+			IntervalSeconds: 21600,
+			HistoryDays:     56,
+			HorizonDays:     30,
+			WarnDays:        14,
+		},
+		Storage: StorageConfig{
+			Type: "clickhouse",
+			Elasticsearch: ElasticsearchConfig{
+				IndexPrefix:    "cpa-logs",
+				TimeoutSeconds: 30,
+			},
+			Loki: LokiConfig{
+				TimeoutSeconds: 30,
+				StateFile:      "/var/lib/cpa-logger/loki-processed.jsonl",
+			},
+			Parquet: ParquetConfig{
+				Endpoint:      "s3.amazonaws.com",
+				UseSSL:        true,
+				Prefix:        "logs",
+				MaxRows:       100000,
+				FlushInterval: 300,
+				StateFile:     "/var/lib/cpa-logger/parquet-processed.jsonl",
+			},
+			SQLite: SQLiteConfig{
+				Path: "/var/lib/cpa-logger/cpa-logger.db",
+			},
+			DuckDB: DuckDBConfig{
+				Path:             "/var/lib/cpa-logger/cpa-logger.duckdb",
+				IdleCloseSeconds: 10,
+			},
+		},
+		Spool: SpoolConfig{
+			Dir: "/var/lib/cpa-logger/spool",
+		},
+		StateStore: StateStoreConfig{
+			StateFile:           "/var/lib/cpa-logger/processed.jsonl",
+			SyncIntervalSeconds: 30,
+		},
+		WAL: WALConfig{
+			Dir:               "/var/lib/cpa-logger/wal",
+			MaxBackoffSeconds: 60,
+		},
+		Retention: RetentionConfig{
+			IntervalSeconds: 3600,
+		},
+		Maintenance: MaintenanceConfig{
+			IntervalSeconds: 3600,
+			QuietHours:      "02:00-05:00",
+			LookbackDays:    7,
+			Tables: []string{"processed_files", "api_logs_hourly", "daily_usage", "client_ip_hourly", "abuse_candidates",
+				"capacity_forecast", "replay_queue", "routing"},
+		},
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	// spool.enabled 等同于 storage.type: spool
+	if cfg.Spool.Enabled {
+		if cfg.Storage.Type != "clickhouse" && cfg.Storage.Type != "spool" {
+			return nil, fmt.Errorf("spool.enabled conflicts with storage.type: %s", cfg.Storage.Type)
+		}
+		cfg.Storage.Type = "spool"
+	}
+	if cfg.Storage.Type == "" {
+		return nil, fmt.Errorf("storage.type is required")
+	}
+	if err := validateWAL(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateStateStore(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateStorageBackend(&cfg.Storage, cfg.Storage.Type); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.Parquet.Archive && cfg.Storage.Type != "clickhouse" {
+		return nil, fmt.Errorf("storage.parquet.archive requires storage.type clickhouse")
+	}
+	// 查询 API 读取 ClickHouse；其他存储后端只运行采集器，不启动 gRPC 订阅服务
+	if cfg.API.Enabled && cfg.Storage.Type != "clickhouse" {
+		return nil, fmt.Errorf("api.enabled requires storage.type clickhouse")
+	}
+	if cfg.GRPC.Enabled && cfg.Storage.Type != "clickhouse" {
+		return nil, fmt.Errorf("grpc.enabled requires storage.type clickhouse")
+	}
+	if cfg.Storage.Parquet.Archive {
+		if err := validateParquet(&cfg.Storage.Parquet); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.ClickHouse.Port == 0 {
+		cfg.ClickHouse.Port = 9000
+	}
+	if cfg.ClickHouse.Database == "" {
+		cfg.ClickHouse.Database = "cpa_logs"
+	}
+	applyBufferDefaults(&cfg.ClickHouse.Buffer)
+	if err := validateBatchInserts(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateClickHouseTimeouts(&cfg.ClickHouse); err != nil {
+		return nil, err
+	}
+	if err := validateConnPool(&cfg.ClickHouse); err != nil {
+		return nil, err
+	}
+	if h := cfg.ClickHouse.HealthCheck; h.Enabled && (h.IntervalSeconds <= 0 || h.FailureThreshold <= 0) {
+		return nil, fmt.Errorf("clickhouse.health_check.interval_seconds and failure_threshold must be positive")
+	}
+	if err := validateEncryption(&cfg.ClickHouse.Encryption); err != nil {
+		return nil, err
+	}
+	if err := validateMasking(&cfg.ClickHouse.Masking); err != nil {
+		return nil, err
+	}
+	if cfg.ClickHouse.TenantRouting.Enabled {
+		if err := validateTenantRouting(&cfg.ClickHouse); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTableNames(&cfg.ClickHouse); err != nil {
+		return nil, err
+	}
+	if err := validatePartitioning(cfg.ClickHouse.Partitioning); err != nil {
+		return nil, err
+	}
+	if c := cfg.ClickHouse.Cluster; c.Name == "" && (c.Replicated || c.Distributed) {
+		return nil, fmt.Errorf("clickhouse.cluster.name is required for replicated or distributed tables")
+	}
+
+	switch cfg.ClickHouse.SchemaMode {
+	case "":
+		cfg.ClickHouse.SchemaMode = "managed"
+	case "managed", "mapped":
+	case "staged":
+		if cfg.ClickHouse.Buffer.Enabled {
+			return nil, fmt.Errorf("clickhouse.schema_mode staged cannot be used with buffer")
+		}
+	default:
+		return nil, fmt.Errorf("unknown clickhouse.schema_mode: %s", cfg.ClickHouse.SchemaMode)
+	}
+	if len(cfg.ClickHouse.StagedTypes) > 0 && cfg.ClickHouse.SchemaMode != "staged" {
+		return nil, fmt.Errorf("clickhouse.staged_types requires clickhouse.schema_mode staged")
+	}
+	for _, t := range cfg.ClickHouse.StagedTypes {
+		if !IsIdentifier(t) {
+			return nil, fmt.Errorf("clickhouse.staged_types: invalid log type: %s", t)
+		}
+	}
+	for name := range cfg.ClickHouse.TableMappings {
+		switch name {
+		case "main_logs", "api_logs", "event_logs", "embedding_logs":
+		default:
+			return nil, fmt.Errorf("unknown table in clickhouse.table_mappings: %s", name)
+		}
+	}
+
+	if len(cfg.MainLogTimestampLayouts) == 0 {
+		cfg.MainLogTimestampLayouts = DefaultMainLogTimestampLayouts()
+	}
+	ref := time.Date(2026, 1, 8, 9, 29, 48, 0, time.UTC)
+	for _, layout := range cfg.MainLogTimestampLayouts {
+		// 格式须包含完整的日期和时间，按格式输出后能解析回同一时刻
+		if ts, err := time.Parse(layout, ref.Format(layout)); err != nil || !ts.Equal(ref) {
+			return nil, fmt.Errorf("main_log_timestamp_layouts entry %q must include the full date and time", layout)
+		}
+	}
+
+	switch cfg.MainLogSink {
+	case "clickhouse":
+	case "victorialogs":
+		if cfg.VictoriaLogs.URL == "" {
+			return nil, fmt.Errorf("victoria_logs.url is required when main_log_sink is victorialogs")
+		}
+	default:
+		return nil, fmt.Errorf("unknown main_log_sink: %s", cfg.MainLogSink)
+	}
+
+	if cfg.Host == "" {
+		cfg.Host, _ = os.Hostname()
+	}
+
+	for _, t := range cfg.API.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("api.tokens.%s: token is required", t.Name)
+		}
+		if t.Role != "read" && t.Role != "admin" {
+			return nil, fmt.Errorf("api.tokens.%s: unknown role: %s", t.Name, t.Role)
+		}
+	}
+	// 未认证的查询接口和订阅服务只允许本机访问
+	if cfg.API.Enabled && !cfg.API.HasTokens() && !IsLoopbackListen(cfg.API.Listen) {
+		return nil, fmt.Errorf("api.listen %s is not a loopback address: configure api.tokens, api.token_file or api.admin_token", cfg.API.Listen)
+	}
+	if cfg.GRPC.Enabled && cfg.GRPC.Token == "" && !IsLoopbackListen(cfg.GRPC.Listen) {
+		return nil, fmt.Errorf("grpc.listen %s is not a loopback address: configure grpc.token", cfg.GRPC.Listen)
+	}
+
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("sample_rate must be in (0, 1]: %v", cfg.SampleRate)
+	}
+	if r := cfg.BodySampling.SuccessRate; r < 0 || r > 1 {
+		return nil, fmt.Errorf("body_sampling.success_rate must be in [0, 1]: %v", r)
+	}
+	if err := validateCustomLogTypes(cfg.CustomLogTypes); err != nil {
+		return nil, err
+	}
+	for _, logType := range []string{"main", "v1_messages", "v1_count_tokens", "provider_messages",
+		"provider_count_tokens", "provider_responses", "event_batch", "v1_embeddings"} {
+		if n := cfg.GetLogTypeConfig(logType).BatchSize; n < 0 {
+			return nil, fmt.Errorf("log_types.%s.batch_size must not be negative: %d", logType, n)
+		}
+	}
+	for _, logType := range cfg.PausedTypes {
+		if !cfg.HasLogType(logType) {
+			return nil, fmt.Errorf("unknown log type in paused_types: %s", logType)
+		}
+	}
+
+	if err := validateSinks(cfg); err != nil {
+		return nil, err
+	}
+
+	for dir, logType := range cfg.LogTypeDirs {
+		if !cfg.HasLogType(logType) {
+			return nil, fmt.Errorf("unknown log type for log_type_dirs.%s: %s", dir, logType)
+		}
+	}
+
+	if cfg.S3Source.Enabled {
+		if cfg.S3Source.Bucket == "" {
+			return nil, fmt.Errorf("s3_source.bucket is required when s3_source is enabled")
+		}
+		switch cfg.S3Source.AfterIngest {
+		case "none", "tag", "delete":
+		default:
+			return nil, fmt.Errorf("unknown s3_source.after_ingest: %s", cfg.S3Source.AfterIngest)
+		}
+		if cfg.S3Source.PollInterval <= 0 {
+			cfg.S3Source.PollInterval = 60
+		}
+	}
+
+	if cfg.Queue.Size <= 0 {
+		cfg.Queue.Size = 1000
+	}
+	if cfg.Queue.Workers <= 0 {
+		cfg.Queue.Workers = 1
+	}
+	if cfg.Queue.InsertWriters < 0 {
+		return nil, fmt.Errorf("queue.insert_writers must not be negative: %d", cfg.Queue.InsertWriters)
+	}
+	if cfg.Queue.MaxInFlightBatches <= 0 {
+		cfg.Queue.MaxInFlightBatches = 16
+	}
+	switch cfg.Queue.OverflowPolicy {
+	case "block", "drop":
+	case "spill":
+		if cfg.Queue.SpillDir == "" {
+			return nil, fmt.Errorf("queue.spill_dir is required when queue.overflow_policy is spill")
+		}
+	default:
+		return nil, fmt.Errorf("unknown queue.overflow_policy: %s", cfg.Queue.OverflowPolicy)
+	}
+
+	if b := &cfg.Backpressure; b.Enabled {
+		if b.MinBackoffSeconds <= 0 {
+			b.MinBackoffSeconds = 5
+		}
+		if b.MaxBackoffSeconds < b.MinBackoffSeconds {
+			return nil, fmt.Errorf("backpressure.max_backoff_seconds must be at least min_backoff_seconds")
+		}
+		if b.MinBatchSize <= 0 {
+			b.MinBatchSize = 1
+		}
+	}
+
+	if err := validateInsertRetry(&cfg.InsertRetry); err != nil {
+		return nil, err
+	}
+
+	if cfg.Alerts.IntervalSeconds <= 0 {
+		cfg.Alerts.IntervalSeconds = 60
+	}
+	for _, ch := range cfg.Alerts.Channels {
+		switch ch.Type {
+		case "webhook", "slack":
+		default:
+			return nil, fmt.Errorf("unknown alerts.channels.%s.type: %s", ch.Name, ch.Type)
+		}
+		if ch.URL == "" {
+			return nil, fmt.Errorf("alerts.channels.%s.url is required", ch.Name)
+		}
+	}
+
+	for _, ss := range cfg.Alerts.Searches {
+		// 客户端 IP 通过 ClickHouse 的 main_logs 匹配，main 日志写入 VictoriaLogs 时查询结果恒为空
+		if ss.ClientIP != "" && cfg.MainLogSink != "clickhouse" {
+			return nil, fmt.Errorf("alerts.searches.%s: client_ip requires main_log_sink clickhouse", ss.Name)
+		}
+	}
+
+	for i := range cfg.Alerts.SLOs {
+		if err := validateSLO(cfg, &cfg.Alerts.SLOs[i]); err != nil {
+			return nil, fmt.Errorf("alerts.slos.%s: %w", cfg.Alerts.SLOs[i].Name, err)
+		}
+	}
+
+	if cfg.ClientIPUsage.Enabled {
+		if cfg.MainLogSink != "clickhouse" {
+			return nil, fmt.Errorf("client_ip_usage requires main_log_sink clickhouse")
+		}
+		if cfg.ClientIPUsage.IntervalSeconds <= 0 {
+			cfg.ClientIPUsage.IntervalSeconds = 300
+		}
+		if cfg.ClientIPUsage.LookbackHours <= 0 {
+			cfg.ClientIPUsage.LookbackHours = 2
+		}
+		if r := cfg.ClientIPUsage.Abuse.ErrorRate; r < 0 || r > 1 {
+			return nil, fmt.Errorf("client_ip_usage.abuse.error_rate must be in [0, 1]: %v", r)
+		}
+	}
+
+	if cfg.DailyRollup.IntervalSeconds <= 0 {
+		cfg.DailyRollup.IntervalSeconds = 3600
+	}
+	if cfg.DailyRollup.LookbackDays <= 0 {
+		cfg.DailyRollup.LookbackDays = 2
+	}
+	if cfg.ReplayQueue.Enabled {
+		if cfg.ReplayQueue.TargetURL == "" {
+			return nil, fmt.Errorf("replay_queue.target_url is required when replay_queue is enabled")
+		}
+		if cfg.ReplayQueue.MaxAttempts <= 0 {
+			cfg.ReplayQueue.MaxAttempts = 3
+		}
+		if cfg.ReplayQueue.TimeoutSeconds <= 0 {
+			cfg.ReplayQueue.TimeoutSeconds = 300
+		}
+	}
+
+	if cfg.Forecast.Enabled {
+		if err := validateForecast(&cfg.Forecast, &cfg.Alerts); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Maintenance.Enabled {
+		if err := validateMaintenance(&cfg.Maintenance); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Retention.Enabled {
+		if err := validateRetention(&cfg.Retention); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Billing.Markup < 0 {
+		return nil, fmt.Errorf("billing.markup must not be negative: %v", cfg.Billing.Markup)
+	}
+	for key, m := range cfg.Billing.KeyMarkups {
+		if m < 0 {
+			return nil, fmt.Errorf("billing.key_markups.%s must not be negative: %v", key, m)
+		}
+	}
+	for model, p := range cfg.Pricing {
+		if p.Input < 0 || p.Output < 0 || p.CacheRead < 0 || p.CacheWrite < 0 {
+			return nil, fmt.Errorf("pricing.%s: prices must not be negative", model)
+		}
+	}
+	if m := cfg.ModelPricing; m.Enabled {
+		if m.IntervalSeconds <= 0 {
+			return nil, fmt.Errorf("model_pricing.interval_seconds must be positive: %d", m.IntervalSeconds)
+		}
+		if _, err := cfg.ModelPrices(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// ModelPrices 返回写入 model_pricing 表的价格：配置了 model_pricing.file 时读取价格文件，否则为 pricing 中的价格
+func (c *Config) ModelPrices() ([]ModelPricing, error) {
+	if c.ModelPricing.File == "" {
+		prices := make([]ModelPricing, 0, len(c.Pricing))
+		for model, p := range c.Pricing {
+			prices = append(prices, ModelPricing{Model: model, ModelPrice: p})
+		}
+		sort.Slice(prices, func(i, j int) bool { return prices[i].Model < prices[j].Model })
+		return prices, nil
+	}
+	return LoadModelPricing(c.ModelPricing.File)
+}
+
+// LoadModelPricing 读取价格文件：YAML 或 JSON 格式的价格列表，同一模型可按生效日期配置多条
+func LoadModelPricing(path string) ([]ModelPricing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+	var prices []ModelPricing
+	if err := yaml.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(prices))
+	for i, p := range prices {
+		if p.Model == "" {
+			return nil, fmt.Errorf("pricing file %s: entry %d: model is required", path, i)
+		}
+		if p.Input < 0 || p.Output < 0 || p.CacheRead < 0 || p.CacheWrite < 0 {
+			return nil, fmt.Errorf("pricing file %s: %s: prices must not be negative", path, p.Model)
+		}
+		if p.EffectiveDate != "" {
+			if _, err := time.Parse("2006-01-02", p.EffectiveDate); err != nil {
+				return nil, fmt.Errorf("pricing file %s: %s: invalid effective_date: %q", path, p.Model, p.EffectiveDate)
+			}
+		}
+		key := p.Model + "@" + p.EffectiveDate
+		if seen[key] {
+			return nil, fmt.Errorf("pricing file %s: duplicate price for %s effective %q", path, p.Model, p.EffectiveDate)
+		}
+		seen[key] = true
+	}
+	return prices, nil
+}
+
+// validateSLO 校验 SLO 配置并填充默认值
+// IsLoopbackListen 监听地址是否只监听本机（127.0.0.0/8、::1 或 localhost），主机部分为空表示所有地址
+func IsLoopbackListen(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateSLO(cfg *Config, slo *SLOConfig) error {
+	if slo.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch slo.Type {
+	case "", "availability":
+		slo.Type = "availability"
+	case "latency":
+		if slo.LatencyMs <= 0 {
+			return fmt.Errorf("latency_ms is required for latency SLO")
+		}
+		if cfg.MainLogSink != "clickhouse" {
+			return fmt.Errorf("latency SLO requires main_log_sink clickhouse")
+		}
+	default:
+		return fmt.Errorf("unknown type: %s", slo.Type)
+	}
+	if slo.LogType != "" && !cfg.HasLogType(slo.LogType) {
+		return fmt.Errorf("unknown log_type: %s", slo.LogType)
+	}
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		return fmt.Errorf("objective must be in (0, 1): %v", slo.Objective)
+	}
+	if slo.WindowDays <= 0 {
+		slo.WindowDays = 30
+	}
+	if len(slo.BurnAlerts) == 0 {
+		slo.BurnAlerts = DefaultBurnAlerts
+	}
+	for _, b := range slo.BurnAlerts {
+		if b.LongWindowSeconds <= 0 || b.ShortWindowSeconds <= 0 || b.BurnRate <= 0 {
+			return fmt.Errorf("burn_alerts require long_window_seconds, short_window_seconds and burn_rate")
+		}
+	}
+	return nil
+}
+
+// validateMaintenance 解析低峰时段并校验表名
+func validateMaintenance(m *MaintenanceConfig) error {
+	if m.IntervalSeconds <= 0 {
+		return fmt.Errorf("maintenance.interval_seconds must be positive: %d", m.IntervalSeconds)
+	}
+	if m.LookbackDays <= 0 {
+		return fmt.Errorf("maintenance.lookback_days must be positive: %d", m.LookbackDays)
+	}
+	start, end, ok := strings.Cut(m.QuietHours, "-")
+	if !ok {
+		return fmt.Errorf("maintenance.quiet_hours must be HH:MM-HH:MM: %s", m.QuietHours)
+	}
+	var err error
+	if m.quietStart, err = parseClock(start); err == nil {
+		m.quietEnd, err = parseClock(end)
+	}
+	if err != nil || m.quietStart == m.quietEnd {
+		return fmt.Errorf("maintenance.quiet_hours must be HH:MM-HH:MM: %s", m.QuietHours)
+	}
+	for _, t := range m.Tables {
+		if !IsIdentifier(t) {
+			return fmt.Errorf("maintenance.tables: invalid table name: %s", t)
+		}
+	}
+	return nil
+}
+
+// validateRetention 检查表名和保留天数
+func validateRetention(r *RetentionConfig) error {
+	if r.IntervalSeconds <= 0 {
+		return fmt.Errorf("retention.interval_seconds must be positive: %d", r.IntervalSeconds)
+	}
+	if len(r.Tables) == 0 && r.BodyDays == 0 {
+		return fmt.Errorf("retention requires tables or body_days")
+	}
+	for t, days := range r.Tables {
+		if !IsIdentifier(t) {
+			return fmt.Errorf("retention.tables: invalid table name: %s", t)
+		}
+		if days <= 0 {
+			return fmt.Errorf("retention.tables.%s must be positive: %d", t, days)
+		}
+	}
+	if r.BodyDays < 0 {
+		return fmt.Errorf("retention.body_days must not be negative: %d", r.BodyDays)
+	}
+	return nil
+}
+
+// parseClock 解析 HH:MM，返回一天中的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// isIdentifier 是否为 ClickHouse 不需要转义的表名
+func IsIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateForecast 校验容量预测配置并填充默认值
+func validateForecast(f *ForecastConfig, alerts *AlertsConfig) error {
+	if f.IntervalSeconds <= 0 {
+		f.IntervalSeconds = 21600
+	}
+	if f.HistoryDays <= 0 {
+		f.HistoryDays = 56
+	}
+	if f.HorizonDays <= 0 {
+		f.HorizonDays = 30
+	}
+	if f.WarnDays <= 0 {
+		f.WarnDays = 14
+	}
+	if f.WarnDays > f.HorizonDays {
+		return fmt.Errorf("forecast.warn_days must not exceed forecast.horizon_days")
+	}
+	if f.DiskLimitGB < 0 {
+		return fmt.Errorf("forecast.disk_limit_gb must not be negative: %v", f.DiskLimitGB)
+	}
+	seen := make(map[string]bool)
+	for i := range f.Quotas {
+		q := &f.Quotas[i]
+		if q.Name == "" {
+			return fmt.Errorf("forecast.quotas: name is required")
+		}
+		if seen[q.Name] {
+			return fmt.Errorf("forecast.quotas.%s: duplicate name", q.Name)
+		}
+		seen[q.Name] = true
+		switch q.Period {
+		case "":
+			q.Period = "day"
+		case "day", "month":
+		default:
+			return fmt.Errorf("forecast.quotas.%s: unknown period: %s", q.Name, q.Period)
+		}
+		if q.Requests == 0 && q.Tokens == 0 {
+			return fmt.Errorf("forecast.quotas.%s: requests or tokens is required", q.Name)
+		}
+	}
+	if len(f.Channels) > 0 && !alerts.Enabled {
+		return fmt.Errorf("forecast.channels require alerts to be enabled")
+	}
+	for _, name := range f.Channels {
+		found := false
+		for _, ch := range alerts.Channels {
+			if ch.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("forecast.channels: unknown channel: %s", name)
+		}
+	}
+	return nil
+}
+
+// validateWAL 预写队列只用于 ClickHouse
+func validateWAL(cfg *Config) error {
+	w := &cfg.WAL
+	if !w.Enabled {
+		return nil
+	}
+	if cfg.Storage.Type != "clickhouse" {
+		return fmt.Errorf("wal requires storage.type clickhouse")
+	}
+	if w.Dir == "" {
+		return fmt.Errorf("wal.dir is required")
+	}
+	// 预写队列按顺序逐个写入压缩包，合并写入时每个压缩包都要等待 flush_interval_seconds
+	if cfg.ClickHouse.BatchInserts.Enabled {
+		return fmt.Errorf("wal conflicts with clickhouse.batch_inserts")
+	}
+	// 压缩包保存的是加密、脱敏之前的行，写入 ClickHouse 时才处理
+	if cfg.ClickHouse.Encryption.Enabled {
+		return fmt.Errorf("wal conflicts with clickhouse.encryption: bundles are stored in plaintext")
+	}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		return fmt.Errorf("wal conflicts with clickhouse.masking: bundles are stored unmasked")
+	}
+	if w.MaxBackoffSeconds <= 0 {
+		return fmt.Errorf("wal.max_backoff_seconds must be positive: %d", w.MaxBackoffSeconds)
+	}
+	if w.MaxSizeMB < 0 {
+		return fmt.Errorf("wal.max_size_mb must not be negative: %d", w.MaxSizeMB)
+	}
+	return nil
+}
+
+// validateStateStore 本地已处理记录只用于 ClickHouse，其他后端各自记录已处理的文件
+func validateStateStore(cfg *Config) error {
+	s := &cfg.StateStore
+	if !s.Enabled {
+		return nil
+	}
+	if cfg.Storage.Type != "clickhouse" {
+		return fmt.Errorf("state_store requires storage.type clickhouse")
+	}
+	if s.StateFile == "" {
+		return fmt.Errorf("state_store.state_file is required")
+	}
+	if s.SyncIntervalSeconds <= 0 {
+		return fmt.Errorf("state_store.sync_interval_seconds must be positive: %d", s.SyncIntervalSeconds)
+	}
+	return nil
+}
+
+// validateInsertRetry 检查重试次数、等待时间和浮动比例
+func validateInsertRetry(r *InsertRetryConfig) error {
+	if r.MaxAttempts <= 0 {
+		return fmt.Errorf("insert_retry.max_attempts must be positive: %d", r.MaxAttempts)
+	}
+	if r.InitialBackoffMs <= 0 {
+		return fmt.Errorf("insert_retry.initial_backoff_ms must be positive: %d", r.InitialBackoffMs)
+	}
+	if r.MaxBackoffMs < r.InitialBackoffMs {
+		return fmt.Errorf("insert_retry.max_backoff_ms must be at least initial_backoff_ms")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("insert_retry.jitter must be between 0 and 1: %v", r.Jitter)
+	}
+	return nil
+}
+
+// validateBatchInserts 填充合并写入的默认值：未配置时使用顶层 batch_size 和 flush_interval_seconds
+func validateBatchInserts(cfg *Config) error {
+	b := &cfg.ClickHouse.BatchInserts
+	if !b.Enabled {
+		return nil
+	}
+	if b.MaxRows == 0 {
+		b.MaxRows = cfg.BatchSize
+	}
+	if b.FlushInterval == 0 {
+		b.FlushInterval = cfg.FlushInterval
+	}
+	if b.MaxRows <= 0 {
+		return fmt.Errorf("clickhouse.batch_inserts.max_rows must be positive: %d", b.MaxRows)
+	}
+	if b.FlushInterval <= 0 {
+		return fmt.Errorf("clickhouse.batch_inserts.flush_interval_seconds must be positive: %d", b.FlushInterval)
+	}
+	for name, n := range b.TableMaxRows {
+		switch name {
+		case "main_logs", "api_logs", "event_logs", "embedding_logs":
+		default:
+			return fmt.Errorf("unknown table in clickhouse.batch_inserts.table_max_rows: %s", name)
+		}
+		if n <= 0 {
+			return fmt.Errorf("clickhouse.batch_inserts.table_max_rows.%s must be positive: %d", name, n)
+		}
+	}
+	// 每次写入等待所在批次写入完成，同步写入时每个处理协程每个间隔只能写入一个批次
+	if cfg.Queue.InsertWriters == 0 {
+		return fmt.Errorf("clickhouse.batch_inserts requires queue.insert_writers")
+	}
+	return nil
+}
+
+// validatePartitioning 检查分区配置的表名和分区方式
+func validatePartitioning(partitioning map[string]PartitioningConfig) error {
+	for name, p := range partitioning {
+		switch name {
+		case "main_logs", "api_logs", "event_logs", "embedding_logs":
+		default:
+			return fmt.Errorf("unknown table in clickhouse.partitioning: %s", name)
+		}
+		switch p.PartitionBy {
+		case "", "daily", "monthly":
+		default:
+			return fmt.Errorf("clickhouse.partitioning.%s.partition_by must be daily or monthly: %s", name, p.PartitionBy)
+		}
+	}
+	return nil
+}
+
+// validateTableNames 检查表名前缀和各表的表名
+func validateTableNames(ch *ClickHouseConfig) error {
+	if ch.TablePrefix != "" && !IsIdentifier(ch.TablePrefix) {
+		return fmt.Errorf("invalid clickhouse.table_prefix: %q", ch.TablePrefix)
+	}
+	for name, table := range ch.TableNames {
+		switch name {
+		case "main_logs", "api_logs", "event_logs", "embedding_logs", "processed_files":
+		default:
+			return fmt.Errorf("unknown table in clickhouse.table_names: %s", name)
+		}
+		if !IsIdentifier(table) {
+			return fmt.Errorf("invalid clickhouse.table_names.%s: %q", name, table)
+		}
+	}
+	seen := make(map[string]string)
+	for _, name := range []string{"main_logs", "api_logs", "event_logs", "embedding_logs", "processed_files"} {
+		table := ch.TableName(name)
+		if other, ok := seen[table]; ok {
+			return fmt.Errorf("clickhouse.table_names: %s and %s both use table %s", other, name, table)
+		}
+		seen[table] = name
+	}
+	return nil
+}
+
+// TableName 返回 cpa-logger 维护的表 name 的实际表名：table_names 中配置的名称或原名，加上 table_prefix
+func (ch *ClickHouseConfig) TableName(name string) string {
+	if table, ok := ch.TableNames[name]; ok {
+		name = table
+	}
+	return ch.TablePrefix + name
+}
+
+// validateClickHouseTimeouts 填充连接超时默认值并检查各超时不为负数
+func validateClickHouseTimeouts(ch *ClickHouseConfig) error {
+	if ch.DialTimeout <= 0 {
+		ch.DialTimeout = 30
+	}
+	for name, v := range map[string]int{
+		"max_execution_time_seconds":   ch.MaxExecutionTime,
+		"timeouts.ping_seconds":        ch.Timeouts.Ping,
+		"timeouts.ddl_seconds":         ch.Timeouts.DDL,
+		"timeouts.insert_seconds":      ch.Timeouts.Insert,
+		"timeouts.dedup_query_seconds": ch.Timeouts.DedupQuery,
+		"timeouts.process_seconds":     ch.Timeouts.Process,
+	} {
+		if v < 0 {
+			return fmt.Errorf("clickhouse.%s must not be negative: %d", name, v)
+		}
+	}
+	return nil
+}
+
+// validateConnPool 检查连接池配置：至少一个连接，空闲连接数不超过最大连接数
+func validateConnPool(ch *ClickHouseConfig) error {
+	if ch.MaxOpenConns < 1 {
+		return fmt.Errorf("clickhouse.max_open_conns must be at least 1: %d", ch.MaxOpenConns)
+	}
+	if ch.MaxIdleConns < 1 || ch.MaxIdleConns > ch.MaxOpenConns {
+		return fmt.Errorf("clickhouse.max_idle_conns must be between 1 and max_open_conns (%d): %d",
+			ch.MaxOpenConns, ch.MaxIdleConns)
+	}
+	if ch.ConnMaxLifetime <= 0 {
+		return fmt.Errorf("clickhouse.conn_max_lifetime_seconds must be positive: %d", ch.ConnMaxLifetime)
+	}
+	return nil
+}
+
+// validateEncryption 检查 body 加密的密钥来源，启用时必须且只能配置一种
+func validateEncryption(e *EncryptionConfig) error {
+	if !e.Enabled {
+		return nil
+	}
+	if e.KeyID == "" {
+		e.KeyID = "default"
+	}
+	if strings.Contains(e.KeyID, ":") {
+		return fmt.Errorf("clickhouse.encryption.key_id must not contain ':': %s", e.KeyID)
+	}
+	sources := 0
+	for _, v := range []string{e.KeyEnv, e.KeyFile, e.KeyCommand} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("clickhouse.encryption requires exactly one of key_env, key_file or key_command")
+	}
+	return nil
+}
+
+// validateTenantRouting 检查租户路由的模式、标识来源和租户名
+func validateTenantRouting(ch *ClickHouseConfig) error {
+	tr := &ch.TenantRouting
+	switch tr.Mode {
+	case "":
+		tr.Mode = "database"
+	case "database", "table_suffix":
+	default:
+		return fmt.Errorf("unknown clickhouse.tenant_routing.mode: %s", tr.Mode)
+	}
+	switch tr.Source {
+	case "api_key":
+		if len(tr.Tenants) == 0 {
+			return fmt.Errorf("clickhouse.tenant_routing.tenants is required when source is api_key")
+		}
+	case "header":
+		if tr.Header == "" {
+			return fmt.Errorf("clickhouse.tenant_routing.header is required when source is header")
+		}
+	default:
+		return fmt.Errorf("unknown clickhouse.tenant_routing.source: %s", tr.Source)
+	}
+	for id, name := range tr.Tenants {
+		if !IsIdentifier(name) {
+			return fmt.Errorf("clickhouse.tenant_routing.tenants.%s: invalid tenant name: %s", id, name)
+		}
+	}
+	// 共享的 bodies 表和用户维护的表无法按租户隔离
+	if ch.BodyDedup {
+		return fmt.Errorf("clickhouse.tenant_routing cannot be used with body_dedup")
+	}
+	if ch.SchemaMode != "" && ch.SchemaMode != "managed" {
+		return fmt.Errorf("clickhouse.tenant_routing requires schema_mode managed")
+	}
+	return nil
+}
+
+// validateElasticsearch 检查地址、索引前缀和 ILM 配置
+func validateElasticsearch(es *ElasticsearchConfig) error {
+	if es.URL == "" {
+		return fmt.Errorf("storage.elasticsearch.url is required")
+	}
+	// 索引名只能为小写，不能包含 \ / * ? " < > | , # 和空格
+	if es.IndexPrefix == "" || es.IndexPrefix != strings.ToLower(es.IndexPrefix) || strings.ContainsAny(es.IndexPrefix, `\/*?"<>|,# :`) {
+		return fmt.Errorf("storage.elasticsearch.index_prefix is not a valid index name: %q", es.IndexPrefix)
+	}
+	if (es.ILMPolicyFile != "" || es.ILMDeleteDays > 0) && es.ILMPolicy == "" {
+		return fmt.Errorf("storage.elasticsearch.ilm_policy is required with ilm_policy_file or ilm_delete_days")
+	}
+	if es.ILMDeleteDays < 0 {
+		return fmt.Errorf("storage.elasticsearch.ilm_delete_days must not be negative: %d", es.ILMDeleteDays)
+	}
+	return nil
+}
+
+// validateStorageBackend 检查存储后端 typ 所需的配置
+func validateStorageBackend(s *StorageConfig, typ string) error {
+	switch typ {
+	case "elasticsearch":
+		if err := validateElasticsearch(&s.Elasticsearch); err != nil {
+			return err
+		}
+	case "loki":
+		if s.Loki.URL == "" {
+			return fmt.Errorf("storage.loki.url is required")
+		}
+		if s.Loki.StateFile == "" {
+			return fmt.Errorf("storage.loki.state_file is required")
+		}
+	case "sqlite":
+		if !cgoEnabled {
+			return fmt.Errorf("storage type sqlite requires a binary built with cgo (make build-cgo)")
+		}
+		if s.SQLite.Path == "" {
+			return fmt.Errorf("storage.sqlite.path is required")
+		}
+	case "duckdb":
+		if !cgoEnabled {
+			return fmt.Errorf("storage type duckdb requires a binary built with cgo (make build-cgo)")
+		}
+		if s.DuckDB.Path == "" {
+			return fmt.Errorf("storage.duckdb.path is required")
+		}
+		if s.DuckDB.IdleCloseSeconds < 0 {
+			return fmt.Errorf("storage.duckdb.idle_close_seconds must not be negative: %d", s.DuckDB.IdleCloseSeconds)
+		}
+	case "parquet":
+		if s.Parquet.StateFile == "" {
+			return fmt.Errorf("storage.parquet.state_file is required")
+		}
+		return validateParquet(&s.Parquet)
+	}
+	return nil
+}
+
+// validateSinks 检查 storage.sinks 并填充默认值
+func validateSinks(cfg *Config) error {
+	seen := make(map[string]bool)
+	deadLetterDirs := make(map[string]bool)
+	for i := range cfg.Storage.Sinks {
+		sink := &cfg.Storage.Sinks[i]
+		if sink.Type == "" {
+			return fmt.Errorf("storage.sinks[%d]: type is required", i)
+		}
+		if sink.Type == cfg.Storage.Type {
+			return fmt.Errorf("storage.sinks[%d]: sink must differ from storage.type: %s", i, sink.Type)
+		}
+		if sink.Name == "" {
+			sink.Name = sink.Type
+		}
+		if !IsIdentifier(sink.Name) {
+			return fmt.Errorf("storage.sinks[%d]: invalid name: %s", i, sink.Name)
+		}
+		if seen[sink.Name] {
+			return fmt.Errorf("storage.sinks[%d]: duplicate sink name: %s", i, sink.Name)
+		}
+		seen[sink.Name] = true
+		if sink.Type == "parquet" && cfg.Storage.Parquet.Archive {
+			return fmt.Errorf("storage.sinks[%d]: parquet conflicts with storage.parquet.archive", i)
+		}
+		merged, err := sinkStorage(&cfg.Storage, sink)
+		if err != nil {
+			return fmt.Errorf("storage.sinks[%d]: %w", i, err)
+		}
+		sink.Storage = merged
+		if err := validateStorageBackend(&sink.Storage, sink.Type); err != nil {
+			return fmt.Errorf("storage.sinks[%d]: %w", i, err)
+		}
+		for _, logType := range sink.LogTypes {
+			if !cfg.HasLogType(logType) {
+				return fmt.Errorf("storage.sinks[%d]: unknown log type: %s", i, logType)
+			}
+		}
+		if sink.QueueSize == 0 {
+			sink.QueueSize = 1000
+		}
+		if sink.MaxRetries == 0 {
+			sink.MaxRetries = 3
+		}
+		if sink.QueueSize < 0 || sink.MaxRetries < 0 {
+			return fmt.Errorf("storage.sinks[%d]: queue_size and max_retries must not be negative", i)
+		}
+		if dir := sink.DeadLetterDir; dir != "" {
+			if deadLetterDirs[filepath.Clean(dir)] {
+				return fmt.Errorf("storage.sinks[%d]: duplicate dead_letter_dir: %s", i, dir)
+			}
+			deadLetterDirs[filepath.Clean(dir)] = true
+		}
+	}
+	return nil
+}
+
+// validateParquet 检查存储桶或本地目录和写出条件
+func validateParquet(p *ParquetConfig) error {
+	if p.Bucket == "" && p.Dir == "" {
+		return fmt.Errorf("storage.parquet.bucket or storage.parquet.dir is required")
+	}
+	if p.MaxRows <= 0 {
+		return fmt.Errorf("storage.parquet.max_rows must be positive: %d", p.MaxRows)
+	}
+	if p.FlushInterval <= 0 {
+		return fmt.Errorf("storage.parquet.flush_interval_seconds must be positive: %d", p.FlushInterval)
+	}
+	return nil
+}
+
+// validateCustomLogTypes 检查自定义日志类型的名称、匹配方式、解析方式和表名，并填充默认值
+func validateCustomLogTypes(types []CustomLogType) error {
+	seen := make(map[string]bool, len(types))
+	for i := range types {
+		t := &types[i]
+		if !IsIdentifier(t.Name) {
+			return fmt.Errorf("custom_log_types[%d]: invalid name: %q", i, t.Name)
+		}
+		if IsKnownLogType(t.Name) {
+			return fmt.Errorf("custom_log_types.%s: conflicts with a built-in log type", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("custom_log_types.%s: duplicate log type", t.Name)
+		}
+		seen[t.Name] = true
+
+		if (t.Prefix == "") == (t.Pattern == "") {
+			return fmt.Errorf("custom_log_types.%s: exactly one of prefix or pattern is required", t.Name)
+		}
+		if t.Pattern != "" {
+			if _, err := regexp.Compile(t.Pattern); err != nil {
+				return fmt.Errorf("custom_log_types.%s: invalid pattern: %w", t.Name, err)
+			}
+		}
+		switch t.Parser {
+		case "main", "api", "event":
+		case "json_lines":
+			if t.Table == "" {
+				return fmt.Errorf("custom_log_types.%s: table is required for json_lines", t.Name)
+			}
+			if t.TimestampField == "" {
+				t.TimestampField = "timestamp"
+			}
+			if t.RequestIDField == "" {
+				t.RequestIDField = "request_id"
+			}
+		default:
+			return fmt.Errorf("custom_log_types.%s: unknown parser: %s", t.Name, t.Parser)
+		}
+		if t.Table != "" && !IsIdentifier(t.Table) {
+			return fmt.Errorf("custom_log_types.%s: invalid table name: %s", t.Name, t.Table)
+		}
+		if t.Enabled == nil {
+			enabled := true
+			t.Enabled = &enabled
+		}
+		if t.BatchSize < 0 {
+			return fmt.Errorf("custom_log_types.%s: batch_size must not be negative: %d", t.Name, t.BatchSize)
+		}
+	}
+	return nil
+}
+
+// validateMasking 检查脱敏规则的表名、字段和动作
+func validateMasking(m *MaskingConfig) error {
+	for i, r := range m.Rules {
+		switch r.Table {
+		case "main_logs", "api_logs", "event_logs", "embedding_logs":
+		default:
+			return fmt.Errorf("clickhouse.masking.rules[%d]: unknown table: %s", i, r.Table)
+		}
+		if r.Column == "" {
+			return fmt.Errorf("clickhouse.masking.rules[%d]: column is required", i)
+		}
+		switch r.Action {
+		case "keep", "hash", "drop":
+		case "truncate":
+			if r.Length <= 0 {
+				return fmt.Errorf("clickhouse.masking.rules[%d]: truncate requires a positive length", i)
+			}
+		default:
+			return fmt.Errorf("clickhouse.masking.rules[%d]: unknown action: %s", i, r.Action)
+		}
+	}
+	return nil
+}
+
+// applyBufferDefaults 填充 Buffer 表阈值默认值（参考 ClickHouse 文档推荐值）
+func applyBufferDefaults(b *BufferTableConfig) {
+	if b.NumLayers == 0 {
+		b.NumLayers = 16
+	}
+	if b.MinTime == 0 {
+		b.MinTime = 10
+	}
+	if b.MaxTime == 0 {
+		b.MaxTime = 100
+	}
+	if b.MinRows == 0 {
+		b.MinRows = 10000
+	}
+	if b.MaxRows == 0 {
+		b.MaxRows = 1000000
+	}
+	if b.MinBytes == 0 {
+		b.MinBytes = 10 * 1024 * 1024
+	}
+	if b.MaxBytes == 0 {
+		b.MaxBytes = 100 * 1024 * 1024
+	}
+}
+
+// IsKnownLogType 是否为支持的日志类型
+func IsKnownLogType(logType string) bool {
+	switch logType {
+	case "main", "v1_messages", "v1_count_tokens", "provider_messages",
+		"provider_count_tokens", "provider_responses", "event_batch", "v1_embeddings":
+		return true
+	}
+	return false
+}
+
+// HasLogType 是否为内置或 custom_log_types 中定义的日志类型
+func (c *Config) HasLogType(logType string) bool {
+	_, ok := c.CustomLogType(logType)
+	return ok || IsKnownLogType(logType)
+}
+
+// CustomLogType 返回 custom_log_types 中定义的日志类型
+func (c *Config) CustomLogType(name string) (*CustomLogType, bool) {
+	for i := range c.CustomLogTypes {
+		if c.CustomLogTypes[i].Name == name {
+			return &c.CustomLogTypes[i], true
+		}
+	}
+	return nil, false
+}
+
+// LogTypeDirPaths 返回 log_type_dirs 中各目录的完整路径 -> 日志类型
+func (c *Config) LogTypeDirPaths() map[string]string {
+	paths := make(map[string]string, len(c.LogTypeDirs))
+	for dir, logType := range c.LogTypeDirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(c.LogDir, dir)
+		}
+		paths[filepath.Clean(dir)] = logType
+	}
+	return paths
+}
+
+// GetLogTypeConfig 获取指定日志类型的配置
+func (c *Config) GetLogTypeConfig(logType string) LogTypeConfig {
+	switch logType {
+	case "main":
+		return c.LogTypes.Main
+	case "v1_messages":
+		return c.LogTypes.V1Messages
+	case "v1_count_tokens":
+		return c.LogTypes.V1CountTokens
+	case "provider_messages":
+		return c.LogTypes.ProviderMessages
+	case "provider_count_tokens":
+		return c.LogTypes.ProviderCountTokens
+	case "provider_responses":
+		return c.LogTypes.ProviderResponses
+	case "event_batch":
+		return c.LogTypes.EventBatch
+	case "v1_embeddings":
+		return c.LogTypes.V1Embeddings
+	}
+	if t, ok := c.CustomLogType(logType); ok {
+		return LogTypeConfig{Enabled: t.Enabled == nil || *t.Enabled, DeleteAfterCollect: t.DeleteAfterCollect, BatchSize: t.BatchSize}
+	}
+	return LogTypeConfig{Enabled: true}
+}
+
+// BatchSizeFor 返回指定日志类型每批写入的行数，未单独配置时返回 global
+func (c *Config) BatchSizeFor(logType string, global int) int {
+	if n := c.GetLogTypeConfig(logType).BatchSize; n > 0 {
+		return n
+	}
+	return global
+}
+
+// ShouldDeleteAfterCollect 判断指定日志类型是否应该在采集后删除
+func (c *Config) ShouldDeleteAfterCollect(logType string) bool {
+	typeConfig := c.GetLogTypeConfig(logType)
+	// 如果单独配置了，使用单独配置
+	if typeConfig.DeleteAfterCollect != nil {
+		return *typeConfig.DeleteAfterCollect
+	}
+	// 否则使用全局配置
+	return c.DeleteAfterCollect
+}
+
+// secretKeys 输出配置时需要隐藏的字段
+var secretKeys = map[string]bool{
+	"password":          true,
+	"secret_access_key": true,
+	"admin_token":       true,
+	"token":             true,
+	"salt":              true,
+	"api_key":           true,
+}
+
+// secretPaths 按路径隐藏的字段（列表元素不计入路径），字段名本身不敏感、只在该位置包含凭据，
+// 如告警渠道的 webhook URL
+var secretPaths = map[string]bool{
+	"alerts.channels.url": true,
+}
+
+// secretMaps 值全部隐藏的字段（如 replay_queue.headers 中的 API key）
+var secretMaps = map[string]bool{
+	"headers": true,
+}
+
+// Masked 返回按 yaml 字段名组织的配置，敏感字段替换为 ******
+func (c *Config) Masked() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	maskSecrets(m, "")
+	return m, nil
+}
+
+// maskSecrets 隐藏 v 中的敏感字段，path 为 v 在配置中的路径
+func maskSecrets(v interface{}, path string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if s, ok := val.(string); ok && (secretKeys[k] || secretPaths[p]) && s != "" {
+				v[k] = "******"
+				continue
+			}
+			if h, ok := val.(map[string]interface{}); ok && secretMaps[k] {
+				for name := range h {
+					h[name] = "******"
+				}
+				continue
+			}
+			maskSecrets(val, p)
+		}
+	case []interface{}:
+		for _, item := range v {
+			maskSecrets(item, path)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// 为空表示应通过校验
+		wantErr string
+	}{
+		{name: "defaults"},
+		{
+			name:    "spool conflicts with storage type",
+			yaml:    "spool:\n  enabled: true\nstorage:\n  type: stdout\n",
+			wantErr: "spool.enabled conflicts with storage.type",
+		},
+		{
+			name:    "spill without spill_dir",
+			yaml:    "queue:\n  overflow_policy: spill\n  spill_dir: \"\"\n",
+			wantErr: "queue.spill_dir is required",
+		},
+		{
+			name: "spill with spill_dir",
+			yaml: "queue:\n  overflow_policy: spill\n  spill_dir: /tmp/spill\n",
+		},
+		{
+			name:    "unknown overflow policy",
+			yaml:    "queue:\n  overflow_policy: discard\n",
+			wantErr: "unknown queue.overflow_policy",
+		},
+		{
+			name:    "negative insert writers",
+			yaml:    "queue:\n  insert_writers: -1\n",
+			wantErr: "queue.insert_writers must not be negative",
+		},
+		{
+			name:    "backpressure backoff range",
+			yaml:    "backpressure:\n  enabled: true\n  min_backoff_seconds: 10\n  max_backoff_seconds: 5\n",
+			wantErr: "backpressure.max_backoff_seconds must be at least min_backoff_seconds",
+		},
+		{
+			name:    "loki without url",
+			yaml:    "storage:\n  type: loki\n",
+			wantErr: "storage.loki.url is required",
+		},
+		{
+			name:    "negative duckdb idle close",
+			yaml:    "storage:\n  type: duckdb\n  duckdb:\n    idle_close_seconds: -1\n",
+			wantErr: "storage.duckdb.idle_close_seconds must not be negative",
+		},
+		{
+			name: "api on loopback without tokens",
+			yaml: "api:\n  enabled: true\n  listen: \"127.0.0.1:8080\"\n",
+		},
+		{
+			name:    "api on all interfaces without tokens",
+			yaml:    "api:\n  enabled: true\n  listen: \":8080\"\n",
+			wantErr: "api.listen :8080 is not a loopback address",
+		},
+		{
+			name: "api on all interfaces with token file",
+			yaml: "api:\n  enabled: true\n  listen: \":8080\"\n  token_file: /etc/cpa-logger/tokens\n",
+		},
+		{
+			name:    "grpc on all interfaces without token",
+			yaml:    "grpc:\n  enabled: true\n  listen: \"0.0.0.0:9090\"\n",
+			wantErr: "grpc.listen 0.0.0.0:9090 is not a loopback address",
+		},
+		{
+			name: "grpc on all interfaces with token",
+			yaml: "grpc:\n  enabled: true\n  listen: \":9090\"\n  token: secret\n",
+		},
+		{
+			name:    "api with non-clickhouse backend",
+			yaml:    "storage:\n  type: stdout\napi:\n  enabled: true\n",
+			wantErr: "api.enabled requires storage.type clickhouse",
+		},
+		{
+			name:    "grpc with spool",
+			yaml:    "spool:\n  enabled: true\ngrpc:\n  enabled: true\n",
+			wantErr: "grpc.enabled requires storage.type clickhouse",
+		},
+		{
+			name:    "unknown main log sink",
+			yaml:    "main_log_sink: files\n",
+			wantErr: "unknown main_log_sink",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !cgoEnabled && strings.Contains(tt.yaml, "duckdb") {
+				tt.wantErr = "requires a binary built with cgo"
+			}
+			_, err := Parse([]byte(tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Parse: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsLoopbackListen(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "127.0.0.1:8080", want: true},
+		{addr: "127.0.0.2:8080", want: true},
+		{addr: "[::1]:8080", want: true},
+		{addr: "localhost:8080", want: true},
+		{addr: ":8080", want: false},
+		{addr: "0.0.0.0:8080", want: false},
+		{addr: "10.0.0.1:8080", want: false},
+		{addr: "example.com:8080", want: false},
+		{addr: "8080", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := IsLoopbackListen(tt.addr); got != tt.want {
+				t.Errorf("IsLoopbackListen(%q) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{name: "queue.size", got: cfg.Queue.Size, want: 1000},
+		{name: "queue.workers", got: cfg.Queue.Workers, want: 4},
+		{name: "queue.max_in_flight_batches", got: cfg.Queue.MaxInFlightBatches, want: 16},
+		{name: "incomplete_recheck_seconds", got: cfg.IncompleteRecheckSeconds, want: 30},
+		{name: "incomplete_max_rechecks", got: cfg.IncompleteMaxRechecks, want: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// 非 JSON 错误响应（网关返回的 HTML、纯文本等）保留的最大长度
+const errorMessageLimit = 1024
+
+// 错误来源
+const (
+	// 上游返回了错误，代理转发给客户端
+	ErrorSourceUpstream = "upstream"
+	// 代理自身返回的错误：未调用上游（鉴权失败、没有可用凭据等），或上游成功但代理处理失败
+	ErrorSourceProxy = "proxy"
+)
+
+// APIError 失败请求（状态码 >= 400）的错误信息
+type APIError struct {
+	// 错误类型（Claude/OpenAI 的 error.type，Gemini 的 error.status）
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message,omitempty"`
+	// 上游返回的错误码（error.code），数字错误码转为字符串
+	Code   string `json:"code,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// errorObject 各格式 error 对象的字段
+type errorObject struct {
+	Type    string          `json:"type"`
+	Message string          `json:"message"`
+	Code    json.RawMessage `json:"code"`
+	Status  string          `json:"status"`
+}
+
+// extractAPIError 解析失败请求的错误信息：类型和消息优先取客户端响应（没有错误类型时取上游响应），
+// 错误码优先取上游响应；最后一次上游调用返回 >= 400 时来源为 upstream，否则为 proxy
+func extractAPIError(entry *APILogEntry) APIError {
+	if entry.ResponseStatus < 400 {
+		return APIError{}
+	}
+	e := parseErrorBody(entry.ResponseBody)
+	e.Source = ErrorSourceProxy
+	if n := len(entry.UpstreamRequests); n > 0 && entry.UpstreamRequests[n-1].Status >= 400 {
+		e.Source = ErrorSourceUpstream
+		up := parseErrorBody(entry.UpstreamRequests[n-1].RespBody)
+		if up.Code != "" {
+			e.Code = up.Code
+		}
+		// 客户端响应没有错误类型（网关页面、纯文本等）时使用上游的错误
+		if e.Type == "" && (up.Type != "" || up.Message != "") {
+			e.Type, e.Message = up.Type, up.Message
+		}
+	}
+	return e
+}
+
+// parseErrorBody 解析错误响应体：JSON 对象、SSE 中的 error 事件，其他文本截断后作为消息
+func parseErrorBody(body string) APIError {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case trimmed == "":
+		return APIError{}
+	case strings.HasPrefix(trimmed, "{"):
+		e, _ := parseErrorJSON(trimmed)
+		return e
+	case strings.HasPrefix(trimmed, "event:") || strings.HasPrefix(trimmed, "data:"):
+		for _, line := range strings.Split(trimmed, "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if !strings.HasPrefix(data, "{") || !strings.Contains(data, `"error"`) {
+				continue
+			}
+			if e, ok := parseErrorJSON(data); ok {
+				return e
+			}
+		}
+		return APIError{}
+	}
+	if len(trimmed) > errorMessageLimit {
+		trimmed = strings.ToValidUTF8(trimmed[:errorMessageLimit], "")
+	}
+	return APIError{Message: trimmed}
+}
+
+// parseErrorJSON 解析 {"error": {...}}（Claude、OpenAI、Gemini）、{"error": "..."} 和
+// 顶层 message 的错误对象，没有错误信息时返回 ok=false
+func parseErrorJSON(data string) (APIError, bool) {
+	var holder struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if json.Unmarshal([]byte(data), &holder) != nil {
+		return APIError{}, false
+	}
+
+	var obj errorObject
+	if json.Unmarshal(holder.Error, &obj) != nil {
+		// error 为字符串
+		json.Unmarshal(holder.Error, &obj.Message)
+	}
+	if obj.Message == "" {
+		obj.Message = holder.Message
+	}
+	e := APIError{Type: obj.Type, Message: obj.Message, Code: errorCode(obj.Code)}
+	if e.Type == "" {
+		e.Type = obj.Status
+	}
+	return e, e != APIError{}
+}
+
+// errorCode 错误码可能是字符串或数字
+func errorCode(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String()
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package parser
+
+import "strings"
+
+// keyHeaders 携带客户端 API key 的请求头，按顺序取第一个非空值
+var keyHeaders = []string{"x-api-key", "authorization", "x-goog-api-key"}
+
+// extractAPIKey 从客户端请求头提取 API key 标识，用于按 key 统计用量
+// 只保留类型前缀和末 4 位（如 sk-ant-api03-...a1b2），代理已脱敏的值（含 * 或 ...）原样保留
+func extractAPIKey(headers map[string]string) string {
+	for _, name := range keyHeaders {
+		for key, value := range headers {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if strings.EqualFold(name, "authorization") {
+				token, ok := cutPrefixFold(value, "Bearer ")
+				if !ok {
+					continue
+				}
+				value = strings.TrimSpace(token)
+			}
+			if value != "" {
+				return maskAPIKey(value)
+			}
+		}
+	}
+	return ""
+}
+
+func maskAPIKey(key string) string {
+	if strings.Contains(key, "*") || strings.Contains(key, "...") {
+		return key
+	}
+	if len(key) <= 12 {
+		return "..." + key[max(0, len(key)-4):]
+	}
+	prefixLen := 4
+	if i := strings.LastIndexByte(key[:min(len(key)-8, 16)], '-'); i >= 0 {
+		prefixLen = i + 1
+	}
+	return key[:prefixLen] + "..." + key[len(key)-4:]
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
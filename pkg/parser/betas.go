@@ -0,0 +1,43 @@
+package parser
+
+import "strings"
+
+// featureHeaders 携带 beta/功能开关的请求头，值为逗号分隔列表
+var featureHeaders = []string{"anthropic-beta", "openai-beta"}
+
+// extractBetas 汇总客户端请求头和上游请求头中启用的 beta 功能，去重并保持出现顺序
+func extractBetas(entry *APILogEntry) []string {
+	var betas []string
+	seen := make(map[string]bool)
+
+	collect := func(headers map[string]string) {
+		for key, value := range headers {
+			if !isFeatureHeader(key) {
+				continue
+			}
+			for _, beta := range strings.Split(value, ",") {
+				beta = strings.TrimSpace(beta)
+				if beta == "" || seen[beta] {
+					continue
+				}
+				seen[beta] = true
+				betas = append(betas, beta)
+			}
+		}
+	}
+
+	collect(entry.Headers)
+	for _, call := range entry.UpstreamRequests {
+		collect(call.Headers)
+	}
+	return betas
+}
+
+func isFeatureHeader(key string) bool {
+	for _, h := range featureHeaders {
+		if strings.EqualFold(key, h) {
+			return true
+		}
+	}
+	return false
+}
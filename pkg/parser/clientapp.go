@@ -0,0 +1,66 @@
+package parser
+
+import "strings"
+
+// ClientUnknown 无法识别客户端时 client_app 的值
+const ClientUnknown = "unknown"
+
+// clientAgents User-Agent 特征 -> 客户端名，按顺序取第一个匹配的（小写子串）；
+// 基于 SDK 的工具排在 SDK 之前
+var clientAgents = []struct {
+	marker string
+	app    string
+}{
+	{"claude-cli/", "claude-code"},
+	{"claude-code", "claude-code"},
+	{"cursor", "cursor"},
+	{"cline", "cline"},
+	{"roo-code", "roo-code"},
+	{"kilo-code", "kilo-code"},
+	{"continue", "continue"},
+	{"aider", "aider"},
+	{"zed/", "zed"},
+	{"langchain", "langchain"},
+	{"llamaindex", "llamaindex"},
+	{"litellm", "litellm"},
+	{"anthropic/python", "anthropic-python"},
+	{"anthropic/js", "anthropic-node"},
+	{"openai/python", "openai-python"},
+	{"openai/js", "openai-node"},
+	{"curl/", "curl"},
+	{"python-requests/", "python-requests"},
+	{"python-httpx/", "python-httpx"},
+	{"go-http-client/", "go-http-client"},
+}
+
+// detectClientApp 识别发起请求的客户端：x-app 请求头优先，其次为 User-Agent，
+// 最后为请求体 metadata.user_id 的格式（Claude Code 为 user_..._session_...）
+func detectClientApp(headers map[string]string, metadataUserID string) string {
+	var app, agent string
+	for key, value := range headers {
+		switch {
+		case strings.EqualFold(key, "x-app"):
+			app = strings.ToLower(strings.TrimSpace(value))
+		case strings.EqualFold(key, "user-agent"):
+			agent = strings.ToLower(value)
+		}
+	}
+
+	// Claude Code 发送 x-app: cli
+	switch app {
+	case "":
+	case "cli":
+		return "claude-code"
+	default:
+		return app
+	}
+	for _, c := range clientAgents {
+		if strings.Contains(agent, c.marker) {
+			return c.app
+		}
+	}
+	if strings.HasPrefix(metadataUserID, "user_") && strings.Contains(metadataUserID, "_session_") {
+		return "claude-code"
+	}
+	return ClientUnknown
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// LogKind 日志的解析方式，内置类型和 custom_log_types 中定义的类型各对应一种
+type LogKind string
+
+const (
+	KindMain      LogKind = "main"
+	KindAPI       LogKind = "api"
+	KindEmbedding LogKind = "embedding"
+	KindEvent     LogKind = "event"
+	KindJSONLines LogKind = "json_lines"
+)
+
+// customType custom_log_types 中定义的日志类型
+type customType struct {
+	name    LogType
+	prefix  string
+	pattern *regexp.Regexp
+	kind    LogKind
+}
+
+// customTypes 自定义日志类型，按配置顺序匹配，启动时由 Configure 设置
+var customTypes []customType
+
+// configureCustomTypes 编译自定义日志类型的文件名匹配规则
+func configureCustomTypes(types []config.CustomLogType) error {
+	customTypes = make([]customType, 0, len(types))
+	for _, t := range types {
+		ct := customType{name: LogType(t.Name), prefix: t.Prefix, kind: LogKind(t.Parser)}
+		if t.Pattern != "" {
+			re, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid custom_log_types.%s.pattern: %w", t.Name, err)
+			}
+			ct.pattern = re
+		}
+		customTypes = append(customTypes, ct)
+	}
+	return nil
+}
+
+// customLogType 按文件名匹配自定义日志类型
+func customLogType(base string) (LogType, bool) {
+	for _, t := range customTypes {
+		if t.pattern != nil && t.pattern.MatchString(base) ||
+			t.pattern == nil && strings.HasPrefix(base, t.prefix) {
+			return t.name, true
+		}
+	}
+	return "", false
+}
+
+// KindOf 返回日志类型的解析方式，未知类型按 main 日志解析（与 DetermineLogType 的默认值一致）
+func KindOf(logType LogType) LogKind {
+	switch logType {
+	case LogTypeV1Messages, LogTypeV1CountTokens, LogTypeProviderMessages,
+		LogTypeProviderCountTokens, LogTypeProviderResponses:
+		return KindAPI
+	case LogTypeV1Embeddings:
+		return KindEmbedding
+	case LogTypeEventBatch:
+		return KindEvent
+	}
+	for _, t := range customTypes {
+		if t.name == logType {
+			return t.kind
+		}
+	}
+	return KindMain
+}
+
+// JSONLineRecord json_lines 日志的一行，Data 为原始 JSON 对象
+type JSONLineRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	Data      string    `json:"data"`
+	// 时间戳校验结果
+	TimestampCheck
+}
+
+// ParseJSONLinesReader 解析每行一个 JSON 对象的日志，跳过空行和无法解析的行；
+// 时间戳和 request_id 取自 tsField、idField 字段，缺少时间戳的行使用 modTime
+func ParseJSONLinesReader(r io.Reader, modTime time.Time, tsField, idField string) ([]JSONLineRecord, error) {
+	var records []JSONLineRecord
+	scanner := bufio.NewScanner(r)
+	// 增大缓冲区以处理长行
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(normalizeLine(scanner.Bytes(), first))
+		first = false
+		if line == "" {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if json.Unmarshal([]byte(line), &obj) != nil {
+			continue
+		}
+
+		rec := JSONLineRecord{Data: line, Timestamp: modTime}
+		if ts, ok := jsonTimestamp(obj[tsField]); ok {
+			rec.Timestamp = ts
+		}
+		if raw, ok := obj[idField]; ok {
+			var id interface{}
+			if json.Unmarshal(raw, &id) == nil && id != nil {
+				rec.RequestID = fmt.Sprint(id)
+			}
+		}
+		rec.Timestamp, rec.TimestampCheck = CheckTimestamp(rec.Timestamp, modTime)
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+// jsonTimestamp 解析 RFC3339、main 日志格式的时间字符串，或 Unix 秒 / 毫秒数值
+func jsonTimestamp(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) == 0 {
+		return time.Time{}, false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return ts, true
+		}
+		return parseMainTimestamp(s)
+	}
+	n, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// 大于 1e12 的数值按毫秒处理
+	if n > 1e12 {
+		return time.UnixMilli(int64(n)), true
+	}
+	sec := int64(n)
+	return time.Unix(sec, int64((n-float64(sec))*1e9)), true
+}
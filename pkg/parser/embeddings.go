@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EmbeddingLogEntry /v1/embeddings 请求日志
+type EmbeddingLogEntry struct {
+	API *APILogEntry `json:"api"`
+	// 请求中的模型和输入条数
+	Model      string `json:"model"`
+	InputCount int    `json:"input_count"`
+	// 响应 usage 及返回的向量信息
+	PromptTokens   int `json:"prompt_tokens"`
+	TotalTokens    int `json:"total_tokens"`
+	EmbeddingCount int `json:"embedding_count"`
+	Dimensions     int `json:"dimensions"`
+}
+
+// ParseEmbeddingLog 解析 embeddings 日志
+func ParseEmbeddingLog(filepath string) (*EmbeddingLogEntry, error) {
+	api, err := ParseAPILog(filepath, LogTypeV1Embeddings)
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddingFromAPI(api), nil
+}
+
+// ParseEmbeddingLogData 解析 embeddings 日志内容
+func ParseEmbeddingLogData(name string, data []byte, modTime time.Time) (*EmbeddingLogEntry, error) {
+	api, err := ParseAPILogData(name, data, LogTypeV1Embeddings, modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddingFromAPI(api), nil
+}
+
+// embeddingFromAPI 从 API 日志中提取 embeddings 请求和响应信息
+func embeddingFromAPI(api *APILogEntry) *EmbeddingLogEntry {
+	entry := &EmbeddingLogEntry{API: api}
+
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if json.Unmarshal([]byte(api.RequestBody), &req) == nil {
+		entry.Model = req.Model
+		entry.InputCount = countEmbeddingInputs(req.Input)
+	}
+
+	var resp struct {
+		Model string `json:"model"`
+		Data  []struct {
+			Embedding json.RawMessage `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal([]byte(api.ResponseBody), &resp) == nil {
+		if entry.Model == "" {
+			entry.Model = resp.Model
+		}
+		entry.PromptTokens = resp.Usage.PromptTokens
+		entry.TotalTokens = resp.Usage.TotalTokens
+		entry.EmbeddingCount = len(resp.Data)
+		if len(resp.Data) > 0 {
+			// base64 编码的向量无法得知维度
+			var vec []float64
+			if json.Unmarshal(resp.Data[0].Embedding, &vec) == nil {
+				entry.Dimensions = len(vec)
+			}
+		}
+	}
+
+	return entry
+}
+
+// countEmbeddingInputs 统计 input 条数：字符串或 token 数组为 1 条，字符串数组或二维 token 数组为多条
+func countEmbeddingInputs(input json.RawMessage) int {
+	if len(input) == 0 {
+		return 0
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(input, &list) != nil {
+		return 1
+	}
+	if len(list) > 0 {
+		var n float64
+		if json.Unmarshal(list[0], &n) == nil {
+			return 1
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// fallbackEncoding 非 UTF-8 内容尝试转码的编码，nil 表示直接替换非法字节
+var fallbackEncoding encoding.Encoding
+
+// setFallbackEncoding 按 WHATWG 编码名（如 gbk、gb18030、big5）设置转码编码
+func setFallbackEncoding(name string) error {
+	if name == "" {
+		fallbackEncoding = nil
+		return nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return fmt.Errorf("unknown fallback_encoding %q: %w", name, err)
+	}
+	fallbackEncoding = enc
+	return nil
+}
+
+// normalizeLine 统一单行内容：去除首行 BOM 和行尾 \r、修正非 UTF-8 内容
+func normalizeLine(line []byte, first bool) string {
+	if first {
+		line = bytes.TrimPrefix(line, bomUTF8)
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(toUTF8(line))
+}
+
+// toUTF8 非 UTF-8 内容优先用 fallbackEncoding 转码，失败时替换非法字节
+func toUTF8(b []byte) []byte {
+	if utf8.Valid(b) {
+		return b
+	}
+	if fallbackEncoding != nil {
+		if out, err := fallbackEncoding.NewDecoder().Bytes(b); err == nil && utf8.Valid(out) {
+			return out
+		}
+	}
+	return []byte(strings.ToValidUTF8(string(b), "�"))
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// 前缀指纹的第一个检查点长度，之后每个检查点翻倍
+const fingerprintFirstCheckpoint = 1024
+
+// 多项式滚动哈希的基数
+const fingerprintBase = 1099511628211
+
+// PrefixFingerprints 计算请求提示前缀在 1K、2K、4K... 字节处的滚动哈希，直到 maxChars
+// 前缀按缓存顺序拼接 tools、system、messages（OpenAI 为 instructions、input），
+// 相同下标的指纹相等即表示两个请求在该长度内前缀相同。返回指纹和前缀总长度
+func PrefixFingerprints(entry *APILogEntry, maxChars int) ([]uint64, int) {
+	if maxChars <= 0 {
+		return nil, 0
+	}
+
+	var req struct {
+		Tools        json.RawMessage `json:"tools"`
+		System       json.RawMessage `json:"system"`
+		Messages     json.RawMessage `json:"messages"`
+		Instructions json.RawMessage `json:"instructions"`
+		Input        json.RawMessage `json:"input"`
+	}
+	if json.Unmarshal([]byte(entry.RequestBody), &req) != nil {
+		return nil, 0
+	}
+
+	// 统一压缩空白，避免客户端格式差异影响指纹
+	var prefix bytes.Buffer
+	for _, part := range []json.RawMessage{req.Tools, req.System, req.Instructions, req.Messages, req.Input} {
+		if len(part) > 0 {
+			json.Compact(&prefix, part)
+		}
+	}
+
+	data := prefix.Bytes()
+	var fingerprints []uint64
+	var h uint64
+	checkpoint := fingerprintFirstCheckpoint
+	for i, b := range data {
+		if i >= maxChars || checkpoint > maxChars {
+			break
+		}
+		h = h*fingerprintBase + uint64(b)
+		if i+1 == checkpoint {
+			fingerprints = append(fingerprints, h)
+			checkpoint *= 2
+		}
+	}
+	return fingerprints, len(data)
+}
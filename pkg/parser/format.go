@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// apiLogFormat API 日志的段落标记和字段标签
+type apiLogFormat struct {
+	sectionPattern *regexp.Regexp
+	sections       config.SectionNames
+	labels         config.FieldLabels
+}
+
+// format 当前使用的 API 日志格式，启动时由 Configure 设置
+var format = apiLogFormat{
+	sectionPattern: regexp.MustCompile(`(?m)^=== (.+?) ===\s*$`),
+	sections:       config.DefaultSectionNames(),
+	labels:         config.DefaultFieldLabels(),
+}
+
+// typeDirs 目录 -> 日志类型，启动时由 Configure 设置
+var typeDirs map[string]LogType
+
+// Configure 设置解析配置，须在开始解析前调用
+func Configure(cfg *config.Config) error {
+	if err := setFallbackEncoding(cfg.FallbackEncoding); err != nil {
+		return err
+	}
+
+	f := &cfg.APILogFormat
+	re, err := regexp.Compile("(?m)" + f.SectionPattern)
+	if err != nil {
+		return fmt.Errorf("invalid api_log_format.section_pattern: %w", err)
+	}
+	if re.NumSubexp() != 1 {
+		return fmt.Errorf("api_log_format.section_pattern must have exactly one capture group for the section name")
+	}
+
+	format = apiLogFormat{
+		sectionPattern: re,
+		sections:       f.Sections,
+		labels:         f.Labels,
+	}
+
+	timestampCheck = cfg.TimestampCheck
+	mainLogLayouts = cfg.MainLogTimestampLayouts
+
+	typeDirs = make(map[string]LogType, len(cfg.LogTypeDirs))
+	for dir, logType := range cfg.LogTypeDirPaths() {
+		typeDirs[dir] = LogType(logType)
+	}
+	return configureCustomTypes(cfg.CustomLogTypes)
+}
@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// mainLogDateTime main 日志默认的时间戳格式，由 scanDateTime 解析
+const mainLogDateTime = "2006-01-02 15:04:05"
+
+// mainLogLayouts main 日志时间戳格式，按顺序尝试，启动时由 Configure 设置
+var mainLogLayouts = config.DefaultMainLogTimestampLayouts()
+
+// parseMainTimestamp 按 mainLogLayouts 依次解析时间戳，保留小数秒；没有时区的格式按本地时间解析
+func parseMainTimestamp(s string) (time.Time, bool) {
+	for _, layout := range mainLogLayouts {
+		if layout == mainLogDateTime {
+			if ts, ok := scanDateTime(s); ok {
+				return ts, true
+			}
+			continue
+		}
+		if ts, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// scanMainLogLine 手写扫描 main 日志行，与 mainLogPattern / httpLogPattern 的匹配结果一致，
+// 字段均为 line 的子串，不额外分配内存
+func scanMainLogLine(line string) (MainLogEntry, bool) {
+	// [2026-01-08 09:29:48]、[2026-01-08 09:29:48.123] 或其他配置的格式
+	tsText, rest, ok := scanBracket(line)
+	if !ok {
+		return MainLogEntry{}, false
+	}
+	ts, ok := parseMainTimestamp(tsText)
+	if !ok {
+		return MainLogEntry{}, false
+	}
+	requestID, rest, ok := scanBracket(rest)
+	if !ok {
+		return MainLogEntry{}, false
+	}
+	level, rest, ok := scanBracket(rest)
+	if !ok {
+		return MainLogEntry{}, false
+	}
+	// 级别为 \w+ 后跟可选空白
+	n := 0
+	for n < len(level) && isWordChar(level[n]) {
+		n++
+	}
+	if n == 0 || !isSpaces(level[n:]) {
+		return MainLogEntry{}, false
+	}
+	source, message, ok := scanBracket(rest)
+	if !ok || strings.IndexByte(message, '\n') >= 0 {
+		return MainLogEntry{}, false
+	}
+
+	entry := MainLogEntry{
+		Timestamp: ts,
+		RequestID: requestID,
+		Level:     level[:n],
+		Source:    source,
+		Message:   message,
+	}
+	scanHTTPLog(message, &entry)
+	return entry, true
+}
+
+// scanBracket 读取 "[非空内容] "，返回内容和其后的剩余部分
+func scanBracket(s string) (string, string, bool) {
+	if len(s) < 4 || s[0] != '[' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[1:], ']') + 1
+	if end <= 1 || end+1 >= len(s) || s[end+1] != ' ' {
+		return "", "", false
+	}
+	return s[1:end], s[end+2:], true
+}
+
+// scanDateTime 解析 2006-01-02 15:04:05 格式的本地时间，秒之后可带 1-9 位小数；
+// 格式正确但日期不合法时返回零值（时间戳校验标记为 zero）
+func scanDateTime(s string) (time.Time, bool) {
+	if len(s) < 19 {
+		return time.Time{}, false
+	}
+	nsec := 0
+	if frac := s[19:]; frac != "" {
+		if frac[0] != '.' || len(frac) < 2 || len(frac) > 10 {
+			return time.Time{}, false
+		}
+		for i := 1; i < len(frac); i++ {
+			if !isDigit(frac[i]) {
+				return time.Time{}, false
+			}
+		}
+		nsec = atoi(frac[1:])
+		for i := len(frac) - 1; i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	for i := 0; i < 19; i++ {
+		switch i {
+		case 4, 7:
+			if s[i] != '-' {
+				return time.Time{}, false
+			}
+		case 10:
+			if s[i] != ' ' {
+				return time.Time{}, false
+			}
+		case 13, 16:
+			if s[i] != ':' {
+				return time.Time{}, false
+			}
+		default:
+			if !isDigit(s[i]) {
+				return time.Time{}, false
+			}
+		}
+	}
+	year := atoi(s[0:4])
+	month := time.Month(atoi(s[5:7]))
+	day, hour, min, sec := atoi(s[8:10]), atoi(s[11:13]), atoi(s[14:16]), atoi(s[17:19])
+	if month < 1 || month > 12 || day < 1 || day > daysIn(month, year) || hour > 23 || min > 59 || sec > 59 {
+		return time.Time{}, true
+	}
+	return time.Date(year, month, day, hour, min, sec, nsec, time.Local), true
+}
+
+// scanHTTPLog 从消息中查找 HTTP 访问记录：404 |          98ms |   58.246.36.130 | POST    "/path"
+// 与 httpLogPattern 一样取最左边的匹配
+func scanHTTPLog(message string, entry *MainLogEntry) {
+	for from := 0; ; {
+		i := strings.Index(message[from:], " |")
+		if i < 0 {
+			return
+		}
+		i += from
+		from = i + 1
+		if i < 3 || !isDigit(message[i-3]) || !isDigit(message[i-2]) || !isDigit(message[i-1]) {
+			continue
+		}
+		if scanHTTPFields(message[i-3:], entry) {
+			return
+		}
+	}
+}
+
+// scanHTTPFields 从 s 开头匹配 HTTP 访问记录各字段
+func scanHTTPFields(s string, entry *MainLogEntry) bool {
+	rest := s[5:]
+	latency, rest, ok := scanPipeField(rest)
+	if !ok {
+		return false
+	}
+	clientIP, rest, ok := scanPipeField(rest)
+	if !ok || len(rest) == 0 || rest[0] != ' ' {
+		return false
+	}
+	rest = rest[1:]
+	n := 0
+	for n < len(rest) && isWordChar(rest[n]) {
+		n++
+	}
+	method := rest[:n]
+	rest = rest[n:]
+	m := 0
+	for m < len(rest) && isSpace(rest[m]) {
+		m++
+	}
+	if n == 0 || m == 0 || m >= len(rest) || rest[m] != '"' {
+		return false
+	}
+	rest = rest[m+1:]
+	end := strings.IndexByte(rest, '"')
+	if end <= 0 {
+		return false
+	}
+
+	entry.StatusCode = atoi(s[:3])
+	entry.Latency = strings.TrimSpace(latency)
+	entry.ClientIP = strings.TrimSpace(clientIP)
+	entry.Method = method
+	entry.Path = rest[:end]
+	return true
+}
+
+// scanPipeField 读取到下一个 | 为止的非空字段（\s*[^|]+\|），返回字段和 | 之后的部分
+func scanPipeField(s string) (string, string, bool) {
+	end := strings.IndexByte(s, '|')
+	if end <= 0 {
+		return "", "", false
+	}
+	return s[:end], s[end+1:], true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordChar 与正则 \w 一致
+func isWordChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// isSpace 与正则 \s 一致
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isSpaces(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isSpace(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// atoi 解析纯数字字符串，调用方保证 s 只包含数字
+func atoi(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n = n*10 + int(s[i]-'0')
+	}
+	return n
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
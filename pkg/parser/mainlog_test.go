@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var mainLogLines = []string{
+	`[2026-01-08 09:29:48] [a1b2c3d4] [info ] [gin_logger.go:58] 200 |          98ms |   58.246.36.130 | POST    "/v1/messages?beta=true"`,
+	`[2026-01-08 09:29:48.123] [a1b2c3d4] [warn ] [gin_logger.go:58] 404 |     1.234567s |   ::1 | GET     "/v1/models"`,
+	`[2026-01-08 09:29:48] [--------] [info ] [server.go:112] API server started successfully on: :8317`,
+	`[2026-01-08T09:29:48+08:00] [a1b2c3d4] [debug] [conductor.go:301] selected credential | provider=claude | model=claude-sonnet-4`,
+}
+
+func TestScanMainLogLineMatchesRegex(t *testing.T) {
+	local := func(year int, month time.Month, day, hour, min, sec, nsec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, nsec, time.Local)
+	}
+	tests := []struct {
+		name string
+		// 为空时使用默认格式
+		layouts []string
+		line    string
+		ok      bool
+		ts      time.Time
+	}{
+		{
+			name: "http",
+			line: mainLogLines[0],
+			ok:   true,
+			ts:   local(2026, 1, 8, 9, 29, 48, 0),
+		},
+		{
+			name: "milliseconds",
+			line: mainLogLines[1],
+			ok:   true,
+			ts:   local(2026, 1, 8, 9, 29, 48, 123000000),
+		},
+		{
+			name: "nanoseconds",
+			line: `[2026-01-08 09:29:48.123456789] [a1b2c3d4] [info] [gin_logger.go:58] 200 | 5ms | 10.0.0.1 | GET "/healthz"`,
+			ok:   true,
+			ts:   local(2026, 1, 8, 9, 29, 48, 123456789),
+		},
+		{
+			name: "plain message",
+			line: mainLogLines[2],
+			ok:   true,
+			ts:   local(2026, 1, 8, 9, 29, 48, 0),
+		},
+		{
+			name: "pipes without http record",
+			line: mainLogLines[3],
+			ok:   true,
+			ts:   time.Date(2026, 1, 8, 9, 29, 48, 0, time.FixedZone("", 8*3600)),
+		},
+		{
+			name: "http record after text",
+			line: `[2026-01-08 09:29:48] [a1b2c3d4] [info ] [proxy.go:77] upstream done 502 |   30.001s |   127.0.0.1 | POST    "/v1/chat/completions"`,
+			ok:   true,
+			ts:   local(2026, 1, 8, 9, 29, 48, 0),
+		},
+		{
+			name: "rfc3339 utc",
+			line: `[2026-01-08T01:29:48Z] [a1b2c3d4] [error] [handler.go:9] request failed`,
+			ok:   true,
+			ts:   time.Date(2026, 1, 8, 1, 29, 48, 0, time.UTC),
+		},
+		{
+			// 格式正确但日期不合法，时间戳为零值（由时间戳校验标记）
+			name: "invalid date",
+			line: `[2026-02-30 09:29:48] [a1b2c3d4] [info ] [server.go:1] started`,
+			ok:   true,
+		},
+		{
+			name:    "configured layout",
+			layouts: []string{"02/Jan/2006:15:04:05 -0700", "2006/01/02 15:04:05.000"},
+			line:    `[08/Jan/2026:09:29:48 +0800] [a1b2c3d4] [info ] [gin_logger.go:58] 200 | 98ms | 58.246.36.130 | POST "/v1/messages"`,
+			ok:      true,
+			ts:      time.Date(2026, 1, 8, 9, 29, 48, 0, time.FixedZone("", 8*3600)),
+		},
+		{
+			name:    "configured layout milliseconds",
+			layouts: []string{"02/Jan/2006:15:04:05 -0700", "2006/01/02 15:04:05.000"},
+			line:    `[2026/01/08 09:29:48.250] [a1b2c3d4] [info ] [server.go:1] started`,
+			ok:      true,
+			ts:      local(2026, 1, 8, 9, 29, 48, 250000000),
+		},
+		{
+			name:    "default layout not configured",
+			layouts: []string{time.RFC3339},
+			line:    mainLogLines[0],
+		},
+		{name: "continuation line", line: `    at handler (proxy.go:77)`},
+		{name: "missing source", line: `[2026-01-08 09:29:48] [a1b2c3d4] [info ] message`},
+		{name: "empty request id", line: `[2026-01-08 09:29:48] [] [info ] [server.go:1] started`},
+		{name: "invalid level", line: `[2026-01-08 09:29:48] [a1b2c3d4] [in-fo] [server.go:1] started`},
+		{name: "unknown timestamp", line: `[Jan 8 09:29:48] [a1b2c3d4] [info ] [server.go:1] started`},
+	}
+
+	defaults := mainLogLayouts
+	defer func() { mainLogLayouts = defaults }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mainLogLayouts = defaults
+			if tt.layouts != nil {
+				mainLogLayouts = tt.layouts
+			}
+			scanned, scanOK := scanMainLogLine(tt.line)
+			matched, regexOK := parseMainLogLineRegex(tt.line)
+			if scanOK != tt.ok || regexOK != tt.ok {
+				t.Fatalf("ok: scanner %v, regex %v, want %v", scanOK, regexOK, tt.ok)
+			}
+			if !reflect.DeepEqual(scanned, matched) {
+				t.Fatalf("scanner and regex differ:\nscanner %+v\nregex   %+v", scanned, matched)
+			}
+			if tt.ok && !scanned.Timestamp.Equal(tt.ts) {
+				t.Fatalf("timestamp %v, want %v", scanned.Timestamp, tt.ts)
+			}
+		})
+	}
+}
+
+func BenchmarkParseMainLogLine(b *testing.B) {
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			scanMainLogLine(mainLogLines[i%len(mainLogLines)])
+		}
+	})
+	b.Run("regex", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parseMainLogLineRegex(mainLogLines[i%len(mainLogLines)])
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Claude Code 中 MCP 工具的命名格式: mcp__<server>__<tool>
+const mcpToolPrefix = "mcp__"
+
+// SplitMCPToolName 拆分 MCP 工具名，非 MCP 工具返回 ok=false
+func SplitMCPToolName(name string) (server, tool string, ok bool) {
+	if !strings.HasPrefix(name, mcpToolPrefix) {
+		return "", "", false
+	}
+	server, tool, ok = strings.Cut(strings.TrimPrefix(name, mcpToolPrefix), "__")
+	if !ok || server == "" {
+		return "", "", false
+	}
+	return server, tool, true
+}
+
+// contentBlock 消息 content 中的内容块
+type contentBlock struct {
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	ServerName string `json:"server_name"`
+}
+
+// extractMCPUsage 提取请求中可用的 MCP server 和最近一轮调用的 MCP 工具
+func extractMCPUsage(entry *APILogEntry, req *requestFields) {
+	servers := make(map[string]bool)
+	addServer := func(name string) {
+		if name != "" && !servers[name] {
+			servers[name] = true
+			entry.MCPServers = append(entry.MCPServers, name)
+		}
+	}
+
+	// MCP connector 直接声明的 server
+	for _, s := range req.MCPServers {
+		addServer(s.Name)
+	}
+	// 客户端以 mcp__ 前缀提供的工具
+	for _, name := range entry.ToolNames {
+		if server, _, ok := SplitMCPToolName(name); ok {
+			addServer(server)
+		}
+	}
+
+	// 只取最后一条 assistant 消息中的调用，避免历史轮次在每个请求中重复计数
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role != "assistant" {
+			continue
+		}
+		var blocks []contentBlock
+		if json.Unmarshal(req.Messages[i].Content, &blocks) != nil {
+			break
+		}
+		for _, b := range blocks {
+			switch b.Type {
+			case "tool_use":
+				if server, _, ok := SplitMCPToolName(b.Name); ok {
+					addServer(server)
+					entry.MCPToolCalls = append(entry.MCPToolCalls, b.Name)
+				}
+			case "mcp_tool_use":
+				addServer(b.ServerName)
+				entry.MCPToolCalls = append(entry.MCPToolCalls, mcpToolPrefix+b.ServerName+"__"+b.Name)
+			}
+		}
+		break
+	}
+}
+
+// EventMCPInfo 从事件数据中提取 MCP server 和工具名
+func EventMCPInfo(eventData map[string]interface{}) (server, tool string) {
+	fields := []map[string]interface{}{eventData}
+	// 部分事件的附加信息是 JSON 字符串
+	if meta, ok := eventData["additional_metadata"].(string); ok {
+		var m map[string]interface{}
+		if json.Unmarshal([]byte(meta), &m) == nil {
+			fields = append(fields, m)
+		}
+	} else if m, ok := eventData["additional_metadata"].(map[string]interface{}); ok {
+		fields = append(fields, m)
+	}
+
+	for _, f := range fields {
+		for _, key := range []string{"mcp_server_name", "mcpServerName", "server_name"} {
+			if v, ok := f[key].(string); ok && v != "" && server == "" {
+				server = v
+			}
+		}
+		for _, key := range []string{"tool_name", "toolName"} {
+			v, ok := f[key].(string)
+			if !ok || tool != "" {
+				continue
+			}
+			if s, _, isMCP := SplitMCPToolName(v); isMCP {
+				tool = v
+				if server == "" {
+					server = s
+				}
+			}
+		}
+	}
+	return server, tool
+}
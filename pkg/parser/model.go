@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// modelHolder 响应中模型名可能出现的位置：顶层（Claude 非流式、OpenAI）、
+// message_start 的 message、Responses 事件的 response
+type modelHolder struct {
+	Model   string `json:"model"`
+	Message *struct {
+		Model string `json:"model"`
+	} `json:"message"`
+	Response *struct {
+		Model string `json:"model"`
+	} `json:"response"`
+}
+
+func (h modelHolder) model() string {
+	switch {
+	case h.Model != "":
+		return h.Model
+	case h.Message != nil && h.Message.Model != "":
+		return h.Message.Model
+	case h.Response != nil:
+		return h.Response.Model
+	}
+	return ""
+}
+
+// extractResponseModel 从响应体（JSON 或 SSE）中提取实际使用的模型名，流式响应取第一个带模型名的事件
+func extractResponseModel(body string) string {
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") {
+		var h modelHolder
+		json.Unmarshal([]byte(trimmed), &h)
+		return h.model()
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if !strings.HasPrefix(data, "{") || !strings.Contains(data, `"model"`) {
+			continue
+		}
+		var h modelHolder
+		if json.Unmarshal([]byte(data), &h) == nil {
+			if model := h.model(); model != "" {
+				return model
+			}
+		}
+	}
+	return ""
+}
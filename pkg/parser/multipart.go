@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"unicode/utf8"
+)
+
+// 文本字段保留的最大长度，文件内容不保留
+const multipartValueLimit = 4096
+
+// MultipartPart multipart/form-data 请求中的一个 part
+type MultipartPart struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	// 非文件字段的文本值（截断），文件内容不保留
+	Value string `json:"value,omitempty"`
+}
+
+// parseMultipartBody 解析 multipart 请求体，非 multipart 时返回 ok=false
+func parseMultipartBody(headers map[string]string, body string) ([]MultipartPart, bool) {
+	var contentType string
+	for k, v := range headers {
+		if strings.EqualFold(k, "Content-Type") {
+			contentType = v
+			break
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, false
+	}
+
+	var parts []MultipartPart
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			// 日志中的 body 可能不完整，保留已解析的 part
+			break
+		}
+
+		part := MultipartPart{
+			Name:        p.FormName(),
+			Filename:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+		}
+		if part.Filename == "" && isTextContentType(part.ContentType) {
+			value, _ := io.ReadAll(io.LimitReader(p, multipartValueLimit))
+			rest, _ := io.Copy(io.Discard, p)
+			part.Size = int64(len(value)) + rest
+			if utf8.Valid(value) {
+				part.Value = string(value)
+			}
+		} else {
+			part.Size, _ = io.Copy(io.Discard, p)
+		}
+		p.Close()
+		parts = append(parts, part)
+	}
+	return parts, true
+}
+
+func isTextContentType(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/json")
+}
+
+// multipartSummary 替代原始 multipart body 存储的摘要
+func multipartSummary(parts []MultipartPart, bodySize int) string {
+	return fmt.Sprintf("[multipart body: %d parts, %d bytes, content dropped]", len(parts), bodySize)
+}
+
+// stripMultipartBodies 解析 multipart 请求体的 part 信息，并将原始 body 替换为摘要
+func stripMultipartBodies(entry *APILogEntry) {
+	if parts, ok := parseMultipartBody(entry.Headers, entry.RequestBody); ok {
+		entry.MultipartParts = parts
+		entry.RequestBody = multipartSummary(parts, len(entry.RequestBody))
+	}
+	for i := range entry.UpstreamRequests {
+		call := &entry.UpstreamRequests[i]
+		if parts, ok := parseMultipartBody(call.Headers, call.Body); ok {
+			call.Body = multipartSummary(parts, len(call.Body))
+		}
+	}
+}
@@ -0,0 +1,594 @@
+// Package parser 解析 CLIProxyAPI 的 main 日志、API 请求日志、embeddings 日志和事件日志
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogType 日志类型
+type LogType string
+
+const (
+	LogTypeMain              LogType = "main"
+	LogTypeV1Messages        LogType = "v1_messages"
+	LogTypeV1CountTokens     LogType = "v1_count_tokens"
+	LogTypeProviderMessages  LogType = "provider_messages"
+	LogTypeProviderCountTokens LogType = "provider_count_tokens"
+	LogTypeProviderResponses LogType = "provider_responses"
+	LogTypeEventBatch        LogType = "event_batch"
+	LogTypeV1Embeddings      LogType = "v1_embeddings"
+)
+
+// MainLogEntry main.log 日志条目
+type MainLogEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	RequestID   string    `json:"request_id"`
+	Level       string    `json:"level"`
+	Source      string    `json:"source"`
+	Message     string    `json:"message"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Latency     string    `json:"latency,omitempty"`
+	ClientIP    string    `json:"client_ip,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	// 行在文件中的字节位置，与文件路径和 request_id 生成去重键
+	Offset int64 `json:"offset,omitempty"`
+	// 时间戳校验结果
+	TimestampCheck
+}
+
+// APILogEntry API 请求日志条目
+type APILogEntry struct {
+	LogType      LogType   `json:"log_type"`
+	RequestID    string    `json:"request_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Version      string    `json:"version"`
+	URL          string    `json:"url"`
+	Method       string    `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	RequestBody  string    `json:"request_body"`
+	ResponseStatus int     `json:"response_status"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody string    `json:"response_body"`
+	// 对于流式响应，拼接后的完整内容
+	FullResponse string    `json:"full_response,omitempty"`
+	// 响应中模型发起的工具调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// 上游 API 请求/响应（用于 provider 类型）
+	UpstreamRequests []UpstreamCall `json:"upstream_requests,omitempty"`
+	// 响应头中的限流信息
+	RateLimit RateLimitInfo `json:"rate_limit"`
+	// 请求启用的 beta 功能（anthropic-beta 等请求头）
+	Betas []string `json:"betas,omitempty"`
+	// multipart 请求体的 part 信息，原始 body 替换为摘要
+	MultipartParts []MultipartPart `json:"multipart_parts,omitempty"`
+	// 请求中提供的工具名称
+	ToolNames []string `json:"tool_names,omitempty"`
+	// 请求涉及的 MCP server，以及最近一轮调用的 MCP 工具（mcp__server__tool）
+	MCPServers   []string `json:"mcp_servers,omitempty"`
+	MCPToolCalls []string `json:"mcp_tool_calls,omitempty"`
+	// 提示前缀在 1K、2K、4K... 处的指纹及前缀总长度，由 PrefixFingerprints 计算
+	PrefixFingerprints []uint64 `json:"prefix_fingerprints,omitempty"`
+	PrefixLength       int      `json:"prefix_length,omitempty"`
+	// 文件尚未写完（请求仍在进行中，缺少响应部分）
+	Incomplete bool `json:"incomplete,omitempty"`
+	// 请求体、响应体等段落超过上限被截断
+	Truncated bool `json:"truncated,omitempty"`
+	// 时间戳校验结果
+	TimestampCheck
+	// 请求体中的模型名，以及响应中实际使用的模型名
+	Model         string `json:"model,omitempty"`
+	ResponseModel string `json:"response_model,omitempty"`
+	// 响应的结束原因（Claude stop_reason、OpenAI finish_reason）
+	StopReason string `json:"stop_reason,omitempty"`
+	// 失败请求的错误信息
+	Error APIError `json:"error"`
+	// 响应中的 token 用量
+	Usage Usage `json:"usage"`
+	// 流式请求（请求体 stream 为 true 或响应为 SSE）
+	Streamed bool `json:"streamed"`
+	// 客户端 API key 标识（脱敏）
+	APIKey string `json:"api_key,omitempty"`
+	// 发起请求的客户端（claude-code、cursor、openai-python 等），无法识别时为 unknown
+	ClientApp string `json:"client_app,omitempty"`
+	// 响应时长和输出速度
+	Throughput Throughput `json:"throughput"`
+}
+
+// UpstreamCall 上游 API 调用
+type UpstreamCall struct {
+	Index       int       `json:"index"`
+	Timestamp   time.Time `json:"timestamp"`
+	URL         string    `json:"url"`
+	Method      string    `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	Body        string    `json:"body"`
+	Status      int       `json:"status"`
+	RespHeaders map[string]string `json:"resp_headers"`
+	RespBody    string    `json:"resp_body"`
+}
+
+// EventBatchEntry 事件批量日志
+type EventBatchEntry struct {
+	RequestID   string    `json:"request_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Events      []map[string]interface{} `json:"events"`
+	// 第一个事件在文件中的序号（按批次拆分写入时不为 0），用于生成去重键
+	Offset int `json:"offset,omitempty"`
+	// 文件修改时间，作为各事件时间戳的校验参照
+	ModTime time.Time `json:"-"`
+}
+
+// 正则表达式
+var (
+	// main.log 格式: [2026-01-08 09:29:48] [a3523f75] [info ] [main.go:413] message
+	mainLogPattern = regexp.MustCompile(`^\[([^\]]+)\] \[([^\]]+)\] \[(\w+)\s*\] \[([^\]]+)\] (.*)$`)
+	// HTTP 日志格式: 404 |          98ms |   58.246.36.130 | POST    "/path"
+	httpLogPattern = regexp.MustCompile(`(\d{3}) \|\s*([^\|]+)\|\s*([^\|]+)\| (\w+)\s+"([^"]+)"`)
+	// 文件名匹配: v1-messages-2026-01-08T103603-6dcb09d0.log
+	apiLogFilePattern = regexp.MustCompile(`^(.+)-(\d{4}-\d{2}-\d{2}T\d{6})-([a-f0-9]{8})\.log$`)
+	// main 日志文件名: main-2026-01-08T12-44-49.243.log
+	mainLogFilePattern = regexp.MustCompile(`^main-(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3})\.log$`)
+)
+
+// DetermineLogType 根据所在目录或文件名判断日志类型，自定义类型的文件名匹配先于内置类型
+func DetermineLogType(filename string) LogType {
+	if logType, ok := logTypeFromDir(filename); ok {
+		return logType
+	}
+
+	base := filepath.Base(filename)
+
+	if logType, ok := customLogType(base); ok {
+		return logType
+	}
+
+	if mainLogFilePattern.MatchString(base) || base == "main.log" {
+		return LogTypeMain
+	}
+
+	// 按前缀匹配
+	switch {
+	case strings.HasPrefix(base, "api-provider-agy-api-event_logging-batch"):
+		return LogTypeEventBatch
+	case strings.HasPrefix(base, "api-provider-agy-v1-messages-count_tokens"):
+		return LogTypeProviderCountTokens
+	case strings.HasPrefix(base, "api-provider-agy-responses"):
+		return LogTypeProviderResponses
+	case strings.HasPrefix(base, "api-provider-agy"):
+		return LogTypeProviderMessages
+	case strings.HasPrefix(base, "v1-embeddings"):
+		return LogTypeV1Embeddings
+	case strings.HasPrefix(base, "v1-messages-count_tokens"):
+		return LogTypeV1CountTokens
+	case strings.HasPrefix(base, "v1-messages"):
+		return LogTypeV1Messages
+	}
+
+	return LogTypeMain
+}
+
+// logTypeFromDir 按 log_type_dirs 配置判断类型，匹配最近的上级目录
+func logTypeFromDir(filename string) (LogType, bool) {
+	if len(typeDirs) == 0 {
+		return "", false
+	}
+	dir := filepath.Dir(filepath.Clean(filename))
+	for {
+		if logType, ok := typeDirs[dir]; ok {
+			return logType, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// ExtractRequestIDFromFilename 从文件名提取 request_id
+func ExtractRequestIDFromFilename(filename string) string {
+	base := filepath.Base(filename)
+	matches := apiLogFilePattern.FindStringSubmatch(base)
+	if len(matches) >= 4 {
+		return matches[3]
+	}
+	return ""
+}
+
+// ParseMainLog 解析 main.log
+func ParseMainLog(filepath string) ([]MainLogEntry, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseMainLogReader(file, fileModTime(filepath))
+}
+
+// ParseMainLogReader 从 r 解析 main 日志，modTime 为时间戳校验的参照时间，各条目的 Offset 为行在 r 中的位置
+func ParseMainLogReader(r io.Reader, modTime time.Time) ([]MainLogEntry, error) {
+	var entries []MainLogEntry
+	scanner := bufio.NewScanner(r)
+	// 增大缓冲区以处理长行
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	// 记录每行在 r 中的起始位置
+	var offset, next int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		offset = next
+		next += int64(advance)
+		return advance, token, err
+	})
+
+	first := true
+	for scanner.Scan() {
+		line := normalizeLine(scanner.Bytes(), first)
+		first = false
+		entry, ok := parseMainLogLine(line)
+		if ok {
+			entry.Timestamp, entry.TimestampCheck = CheckTimestamp(entry.Timestamp, modTime)
+			entry.Offset = offset
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+func parseMainLogLine(line string) (MainLogEntry, bool) {
+	if entry, ok := scanMainLogLine(line); ok {
+		return entry, true
+	}
+	// 正则要求行首为 [，其余行（如多行消息的续行）无需再匹配
+	if !strings.HasPrefix(line, "[") {
+		return MainLogEntry{}, false
+	}
+	return parseMainLogLineRegex(line)
+}
+
+// parseMainLogLineRegex 正则解析 main 日志行，作为 scanMainLogLine 的兜底
+func parseMainLogLineRegex(line string) (MainLogEntry, bool) {
+	matches := mainLogPattern.FindStringSubmatch(line)
+	if len(matches) < 6 {
+		return MainLogEntry{}, false
+	}
+
+	ts, ok := parseMainTimestamp(matches[1])
+	if !ok {
+		return MainLogEntry{}, false
+	}
+	entry := MainLogEntry{
+		Timestamp: ts,
+		RequestID: matches[2],
+		Level:     strings.TrimSpace(matches[3]),
+		Source:    matches[4],
+		Message:   matches[5],
+	}
+
+	// 尝试解析 HTTP 日志
+	httpMatches := httpLogPattern.FindStringSubmatch(matches[5])
+	if len(httpMatches) >= 6 {
+		entry.StatusCode, _ = strconv.Atoi(httpMatches[1])
+		entry.Latency = strings.TrimSpace(httpMatches[2])
+		entry.ClientIP = strings.TrimSpace(httpMatches[3])
+		entry.Method = strings.TrimSpace(httpMatches[4])
+		entry.Path = httpMatches[5]
+	}
+
+	return entry, true
+}
+
+// ParseAPILog 解析 API 日志，按段逐行读取文件，不一次性读入内存
+func ParseAPILog(filepath string, logType LogType) (*APILogEntry, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseAPILogReader(filepath, file, logType, fileModTime(filepath))
+}
+
+// ParseAPILogData 解析 API 日志内容，name 为文件名（用于提取 request_id），
+// modTime 为时间戳校验的参照时间
+func ParseAPILogData(name string, data []byte, logType LogType, modTime time.Time) (*APILogEntry, error) {
+	return ParseAPILogReader(name, bytes.NewReader(data), logType, modTime)
+}
+
+// ParseAPILogReader 从 r 解析 API 日志，参数同 ParseAPILogData
+func ParseAPILogReader(name string, r io.Reader, logType LogType, modTime time.Time) (*APILogEntry, error) {
+	entry := &APILogEntry{
+		LogType:   logType,
+		RequestID: ExtractRequestIDFromFilename(name),
+		Headers:   make(map[string]string),
+		ResponseHeaders: make(map[string]string),
+	}
+
+	// 分段解析
+	sections, truncated, err := readSections(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no sections found, check api_log_format.section_pattern")
+	}
+
+	for name, body := range sections {
+		switch {
+		case name == format.sections.RequestInfo:
+			parseRequestInfo(body, entry)
+		case name == format.sections.Headers:
+			entry.Headers = parseHeaders(body)
+		case name == format.sections.RequestBody:
+			entry.RequestBody = strings.TrimSpace(body)
+		case name == format.sections.Response:
+			parseResponse(body, entry)
+		case strings.HasPrefix(name, format.sections.APIRequest):
+			idx := extractIndex(name)
+			upstream := parseUpstreamRequest(body, idx)
+			entry.UpstreamRequests = append(entry.UpstreamRequests, upstream)
+		case strings.HasPrefix(name, format.sections.APIResponse):
+			idx := extractIndex(name)
+			if idx > 0 && idx <= len(entry.UpstreamRequests) {
+				parseUpstreamResponse(body, &entry.UpstreamRequests[idx-1])
+			}
+		}
+	}
+
+	entry.Timestamp, entry.TimestampCheck = CheckTimestamp(entry.Timestamp, modTime)
+	entry.Incomplete = isIncomplete(sections, entry)
+	entry.Truncated = truncated
+
+	// 文件上传等 multipart 请求只保留 part 信息
+	stripMultipartBodies(entry)
+
+	// 提取请求体中的元数据
+	parseRequestFields(entry)
+
+	// 处理流式响应：拼接完整内容
+	entry.FullResponse = extractFullStreamResponse(entry.ResponseBody)
+	if !entry.Streamed {
+		entry.Streamed = isEventStream(entry)
+	}
+
+	// 提取限流响应头
+	entry.RateLimit = parseRateLimit(rateLimitHeaders(entry), entry.Timestamp)
+	entry.Betas = extractBetas(entry)
+	entry.APIKey = extractAPIKey(entry.Headers)
+
+	// 提取 token 用量，客户端响应中没有时使用最后一次上游响应
+	entry.Usage = extractUsage(entry.ResponseBody)
+	if entry.Usage == (Usage{}) && len(entry.UpstreamRequests) > 0 {
+		entry.Usage = extractUsage(entry.UpstreamRequests[len(entry.UpstreamRequests)-1].RespBody)
+	}
+	entry.ToolCalls = extractToolCalls(entry.ResponseBody)
+	if len(entry.ToolCalls) == 0 && len(entry.UpstreamRequests) > 0 {
+		entry.ToolCalls = extractToolCalls(entry.UpstreamRequests[len(entry.UpstreamRequests)-1].RespBody)
+	}
+	entry.ResponseModel = extractResponseModel(entry.ResponseBody)
+	if entry.ResponseModel == "" && len(entry.UpstreamRequests) > 0 {
+		entry.ResponseModel = extractResponseModel(entry.UpstreamRequests[len(entry.UpstreamRequests)-1].RespBody)
+	}
+	entry.StopReason = extractStopReason(entry.ResponseBody)
+	if entry.StopReason == "" && len(entry.UpstreamRequests) > 0 {
+		entry.StopReason = extractStopReason(entry.UpstreamRequests[len(entry.UpstreamRequests)-1].RespBody)
+	}
+	entry.Error = extractAPIError(entry)
+	entry.Throughput = computeThroughput(entry, modTime)
+
+	return entry, nil
+}
+
+// isIncomplete 判断 API 日志是否缺少响应部分：既没有 RESPONSE 段，
+// 最后一次上游调用也没有 API RESPONSE 段
+func isIncomplete(sections map[string]string, entry *APILogEntry) bool {
+	if _, ok := sections[format.sections.Response]; ok {
+		return false
+	}
+	if n := len(entry.UpstreamRequests); n > 0 && entry.UpstreamRequests[n-1].RespHeaders != nil {
+		return false
+	}
+	return true
+}
+
+// ParseEventBatchLog 解析事件批量日志
+func ParseEventBatchLog(filepath string) (*EventBatchEntry, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseEventBatchLogData(filepath, data, fileModTime(filepath))
+}
+
+// ParseEventBatchLogData 解析事件批量日志内容
+func ParseEventBatchLogData(name string, data []byte, modTime time.Time) (*EventBatchEntry, error) {
+	sections, _, err := readSections(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &EventBatchEntry{
+		RequestID: ExtractRequestIDFromFilename(name),
+		ModTime:   modTime,
+	}
+
+	// 解析时间戳
+	if info, ok := sections[format.sections.RequestInfo]; ok {
+		for _, line := range strings.Split(info, "\n") {
+			if strings.HasPrefix(line, format.labels.Timestamp) {
+				tsStr := strings.TrimSpace(strings.TrimPrefix(line, format.labels.Timestamp))
+				entry.Timestamp, _ = time.Parse(time.RFC3339Nano, tsStr)
+				break
+			}
+		}
+	}
+
+	// 解析事件
+	if body, ok := sections[format.sections.RequestBody]; ok {
+		body = strings.TrimSpace(body)
+		var eventData struct {
+			Events []map[string]interface{} `json:"events"`
+		}
+		if json.Unmarshal([]byte(body), &eventData) == nil {
+			entry.Events = eventData.Events
+		}
+	}
+
+	return entry, nil
+}
+
+func parseRequestInfo(body string, entry *APILogEntry) {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, format.labels.Version):
+			entry.Version = strings.TrimSpace(strings.TrimPrefix(line, format.labels.Version))
+		case strings.HasPrefix(line, format.labels.URL):
+			entry.URL = strings.TrimSpace(strings.TrimPrefix(line, format.labels.URL))
+		case strings.HasPrefix(line, format.labels.Method):
+			entry.Method = strings.TrimSpace(strings.TrimPrefix(line, format.labels.Method))
+		case strings.HasPrefix(line, format.labels.Timestamp):
+			tsStr := strings.TrimSpace(strings.TrimPrefix(line, format.labels.Timestamp))
+			entry.Timestamp, _ = time.Parse(time.RFC3339Nano, tsStr)
+		}
+	}
+}
+
+func parseHeaders(body string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if idx := strings.Index(line, ":"); idx > 0 {
+			key := strings.TrimSpace(line[:idx])
+			value := strings.TrimSpace(line[idx+1:])
+			headers[key] = value
+		}
+	}
+	return headers
+}
+
+func parseResponse(body string, entry *APILogEntry) {
+	lines := strings.Split(body, "\n")
+	headerDone := false
+	var bodyLines []string
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			headerDone = true
+			continue
+		}
+		if !headerDone {
+			if strings.HasPrefix(line, format.labels.Status) {
+				statusStr := strings.TrimSpace(strings.TrimPrefix(line, format.labels.Status))
+				entry.ResponseStatus, _ = strconv.Atoi(statusStr)
+			} else if idx := strings.Index(line, ":"); idx > 0 {
+				key := strings.TrimSpace(line[:idx])
+				value := strings.TrimSpace(line[idx+1:])
+				entry.ResponseHeaders[key] = value
+			}
+		} else {
+			bodyLines = append(bodyLines, line)
+		}
+	}
+	entry.ResponseBody = strings.Join(bodyLines, "\n")
+}
+
+func extractIndex(name string) int {
+	re := regexp.MustCompile(`(\d+)`)
+	if m := re.FindString(name); m != "" {
+		idx, _ := strconv.Atoi(m)
+		return idx
+	}
+	return 1
+}
+
+func parseUpstreamRequest(body string, idx int) UpstreamCall {
+	call := UpstreamCall{
+		Index:   idx,
+		Headers: make(map[string]string),
+	}
+
+	lines := strings.Split(body, "\n")
+	inHeaders := false
+	inBody := false
+	var bodyLines []string
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, format.labels.Timestamp):
+			tsStr := strings.TrimSpace(strings.TrimPrefix(trimmed, format.labels.Timestamp))
+			call.Timestamp, _ = time.Parse(time.RFC3339Nano, tsStr)
+		case strings.HasPrefix(trimmed, format.labels.UpstreamURL):
+			call.URL = strings.TrimSpace(strings.TrimPrefix(trimmed, format.labels.UpstreamURL))
+		case strings.HasPrefix(trimmed, format.labels.UpstreamMethod):
+			call.Method = strings.TrimSpace(strings.TrimPrefix(trimmed, format.labels.UpstreamMethod))
+		case trimmed == format.labels.UpstreamHeaders:
+			inHeaders = true
+			inBody = false
+		case trimmed == format.labels.UpstreamBody:
+			inHeaders = false
+			inBody = true
+		case inHeaders:
+			if colonIdx := strings.Index(trimmed, ":"); colonIdx > 0 {
+				key := strings.TrimSpace(trimmed[:colonIdx])
+				value := strings.TrimSpace(trimmed[colonIdx+1:])
+				call.Headers[key] = value
+			}
+		case inBody:
+			bodyLines = append(bodyLines, line)
+		}
+	}
+	call.Body = strings.TrimSpace(strings.Join(bodyLines, "\n"))
+
+	return call
+}
+
+func parseUpstreamResponse(body string, call *UpstreamCall) {
+	call.RespHeaders = make(map[string]string)
+
+	lines := strings.Split(body, "\n")
+	inHeaders := false
+	inBody := false
+	var bodyLines []string
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, format.labels.Status):
+			statusStr := strings.TrimSpace(strings.TrimPrefix(trimmed, format.labels.Status))
+			call.Status, _ = strconv.Atoi(statusStr)
+		case trimmed == format.labels.UpstreamHeaders:
+			inHeaders = true
+			inBody = false
+		case trimmed == format.labels.UpstreamBody:
+			inHeaders = false
+			inBody = true
+		case inHeaders:
+			if idx := strings.Index(trimmed, ":"); idx > 0 {
+				key := strings.TrimSpace(trimmed[:idx])
+				value := strings.TrimSpace(trimmed[idx+1:])
+				call.RespHeaders[key] = value
+			}
+		case inBody:
+			bodyLines = append(bodyLines, line)
+		}
+	}
+	call.RespBody = strings.TrimSpace(strings.Join(bodyLines, "\n"))
+}
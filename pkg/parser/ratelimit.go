@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitInfo 响应头中的限流信息，未返回的字段为 nil
+type RateLimitInfo struct {
+	RequestsLimit         *uint64    `json:"requests_limit,omitempty"`
+	RequestsRemaining     *uint64    `json:"requests_remaining,omitempty"`
+	RequestsReset         *time.Time `json:"requests_reset,omitempty"`
+	TokensLimit           *uint64    `json:"tokens_limit,omitempty"`
+	TokensRemaining       *uint64    `json:"tokens_remaining,omitempty"`
+	TokensReset           *time.Time `json:"tokens_reset,omitempty"`
+	InputTokensRemaining  *uint64    `json:"input_tokens_remaining,omitempty"`
+	OutputTokensRemaining *uint64    `json:"output_tokens_remaining,omitempty"`
+	RetryAfterSeconds     *uint32    `json:"retry_after_seconds,omitempty"`
+}
+
+// parseRateLimit 解析 Anthropic (anthropic-ratelimit-*) 和 OpenAI (x-ratelimit-*) 限流响应头
+// base 为响应时间，用于换算 OpenAI 以时长表示的重置时间
+func parseRateLimit(headers map[string]string, base time.Time) RateLimitInfo {
+	lower := make(map[string]string, len(headers))
+	for k, v := range headers {
+		lower[strings.ToLower(k)] = v
+	}
+
+	var info RateLimitInfo
+
+	// Anthropic: 重置时间为 RFC3339
+	info.RequestsLimit = parseUintHeader(lower["anthropic-ratelimit-requests-limit"])
+	info.RequestsRemaining = parseUintHeader(lower["anthropic-ratelimit-requests-remaining"])
+	info.RequestsReset = parseTimeHeader(lower["anthropic-ratelimit-requests-reset"])
+	info.TokensLimit = parseUintHeader(lower["anthropic-ratelimit-tokens-limit"])
+	info.TokensRemaining = parseUintHeader(lower["anthropic-ratelimit-tokens-remaining"])
+	info.TokensReset = parseTimeHeader(lower["anthropic-ratelimit-tokens-reset"])
+	info.InputTokensRemaining = parseUintHeader(lower["anthropic-ratelimit-input-tokens-remaining"])
+	info.OutputTokensRemaining = parseUintHeader(lower["anthropic-ratelimit-output-tokens-remaining"])
+
+	// OpenAI: 重置时间为时长，如 "1s"、"6m0s"
+	if info.RequestsLimit == nil {
+		info.RequestsLimit = parseUintHeader(lower["x-ratelimit-limit-requests"])
+	}
+	if info.RequestsRemaining == nil {
+		info.RequestsRemaining = parseUintHeader(lower["x-ratelimit-remaining-requests"])
+	}
+	if info.RequestsReset == nil {
+		info.RequestsReset = parseResetDuration(lower["x-ratelimit-reset-requests"], base)
+	}
+	if info.TokensLimit == nil {
+		info.TokensLimit = parseUintHeader(lower["x-ratelimit-limit-tokens"])
+	}
+	if info.TokensRemaining == nil {
+		info.TokensRemaining = parseUintHeader(lower["x-ratelimit-remaining-tokens"])
+	}
+	if info.TokensReset == nil {
+		info.TokensReset = parseResetDuration(lower["x-ratelimit-reset-tokens"], base)
+	}
+
+	if v := parseUintHeader(lower["retry-after"]); v != nil {
+		secs := uint32(*v)
+		info.RetryAfterSeconds = &secs
+	}
+
+	return info
+}
+
+// rateLimitHeaders 选取包含限流信息的响应头，优先使用最后一次上游响应
+func rateLimitHeaders(entry *APILogEntry) map[string]string {
+	for i := len(entry.UpstreamRequests) - 1; i >= 0; i-- {
+		if len(entry.UpstreamRequests[i].RespHeaders) > 0 {
+			return entry.UpstreamRequests[i].RespHeaders
+		}
+	}
+	return entry.ResponseHeaders
+}
+
+func parseUintHeader(value string) *uint64 {
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func parseTimeHeader(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func parseResetDuration(value string, base time.Time) *time.Time {
+	if value == "" || base.IsZero() {
+		return nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	t := base.Add(d)
+	return &t
+}
@@ -0,0 +1,58 @@
+package parser
+
+import "encoding/json"
+
+// requestFields 请求体中需要提取的字段（Claude Messages / OpenAI 格式）
+type requestFields struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+	Tools  []struct {
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Function *struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tools"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	MCPServers []struct {
+		Name string `json:"name"`
+	} `json:"mcp_servers"`
+	Metadata struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata"`
+}
+
+// parseRequestFields 解析请求体一次，填充从请求体派生的字段
+func parseRequestFields(entry *APILogEntry) {
+	var req requestFields
+	if json.Unmarshal([]byte(entry.RequestBody), &req) != nil {
+		entry.ClientApp = detectClientApp(entry.Headers, "")
+		return
+	}
+
+	entry.Model = req.Model
+	entry.Streamed = req.Stream
+
+	// 客户端提供的工具定义
+	for _, tool := range req.Tools {
+		name := tool.Name
+		if name == "" && tool.Function != nil {
+			name = tool.Function.Name
+		}
+		if name == "" {
+			// 服务端内置工具（如 web_search）可能只有 type
+			name = tool.Type
+		}
+		if name != "" {
+			entry.ToolNames = append(entry.ToolNames, name)
+		}
+	}
+
+	// MCP server 及工具调用
+	extractMCPUsage(entry, &req)
+
+	entry.ClientApp = detectClientApp(entry.Headers, req.Metadata.UserID)
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// 读取日志的缓冲区大小，超过缓冲区的长行分段读取
+const sectionReadBuffer = 64 * 1024
+
+// 单行和单个段落保留的最大字节数，超出部分丢弃，避免超大响应（单行的 JSON、流式响应）占用过多内存
+const sectionLimit = 64 * 1024 * 1024
+
+// readSections 逐行读取日志并按段落标记切分，各段内容去除首尾空白
+// 与一次性读入整个文件再切分相比，内存中只保留各段内容，不再额外持有原始文件和整体转码后的副本。
+// 段落标记须在同一行内，UTF-16 按 BOM 转码，各行按 normalizeLine 处理；
+// 行或段落超过 sectionLimit 时截断，truncated 为 true
+func readSections(r io.Reader) (sections map[string]string, truncated bool, err error) {
+	br := bufio.NewReaderSize(r, sectionReadBuffer)
+	// UTF-16 文件按 BOM 判断字节序转为 UTF-8
+	if head, _ := br.Peek(2); bytes.Equal(head, bomUTF16LE) || bytes.Equal(head, bomUTF16BE) {
+		dec := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+		br = bufio.NewReaderSize(transform.NewReader(br, dec), sectionReadBuffer)
+	}
+
+	sections = make(map[string]string)
+	var name string
+	var body strings.Builder
+	inSection := false
+	flush := func() {
+		if inSection {
+			sections[name] = strings.TrimSpace(body.String())
+		}
+		body = strings.Builder{}
+	}
+	write := func(text string) {
+		if n := sectionLimit - body.Len(); len(text) > n {
+			text = strings.ToValidUTF8(text[:max(n, 0)], "")
+			truncated = true
+		}
+		body.WriteString(text)
+	}
+
+	var line []byte
+	first := true
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if room := sectionLimit - len(line); len(chunk) > room {
+			truncated = true
+			line = append(line, chunk[:room]...)
+			// 保留行尾的换行
+			if chunk[len(chunk)-1] == '\n' {
+				line = append(line, '\n')
+			}
+		} else {
+			line = append(line, chunk...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		if len(line) > 0 {
+			text := normalizeLine(bytes.TrimSuffix(line, []byte("\n")), first)
+			first = false
+			if loc := format.sectionPattern.FindStringSubmatchIndex(text); loc != nil {
+				write(text[:loc[0]])
+				flush()
+				name = text[loc[2]:loc[3]]
+				inSection = true
+				write(text[loc[1]:])
+			} else if inSection {
+				write(text)
+			}
+			if inSection && line[len(line)-1] == '\n' {
+				write("\n")
+			}
+		}
+		line = line[:0]
+		if err == io.EOF {
+			break
+		}
+	}
+	flush()
+	return sections, truncated, nil
+}
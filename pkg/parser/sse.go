@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SSEEvent 流式响应中的一个事件
+type SSEEvent struct {
+	// 事件原文（event:/data: 等行），不含结尾空行
+	Raw string
+	// 事件数据中携带的时间戳，没有时为零值
+	Time time.Time
+}
+
+// streamChunk 流式响应数据中拼接完整文本所需的字段，其余字段解码时直接跳过
+type streamChunk struct {
+	Type string `json:"type"`
+	// Claude 格式: delta.text（content_block_delta）；
+	// OpenAI Responses 格式: response.output_text.delta 事件的 delta 为文本本身
+	Delta json.RawMessage `json:"delta"`
+	// OpenAI 格式: choices[0].delta.content
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// deltaText 返回 Claude 或 Responses 格式 delta 中的文本，
+// Responses 的其他 delta 事件（如 function_call_arguments、reasoning）不计入
+func (c streamChunk) deltaText() string {
+	if c.Type == "response.output_text.delta" {
+		var text string
+		json.Unmarshal(c.Delta, &text)
+		return text
+	}
+	var delta struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(c.Delta, &delta)
+	return delta.Text
+}
+
+// extractFullStreamResponse 提取流式响应中的完整文本内容
+func extractFullStreamResponse(body string) string {
+	// SSE 格式: data: {...}
+	var fullContent strings.Builder
+	for rest := body; rest != ""; {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(line[len("data:"):])
+		// 各格式的文本都在 delta 字段中，message_start、ping 等事件无需解码
+		if !strings.Contains(data, `"delta"`) {
+			continue
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			// 字段类型不符时其余字段仍已解码
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				continue
+			}
+		}
+		fullContent.WriteString(chunk.deltaText())
+		if len(chunk.Choices) > 0 {
+			fullContent.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	return fullContent.String()
+}
+
+// isEventStream 响应是否为 SSE：Content-Type 为 text/event-stream，或响应体以 event:/data: 行开头
+func isEventStream(entry *APILogEntry) bool {
+	for k, v := range entry.ResponseHeaders {
+		if strings.EqualFold(k, "Content-Type") && strings.HasPrefix(strings.ToLower(v), "text/event-stream") {
+			return true
+		}
+	}
+	body := strings.TrimSpace(entry.ResponseBody)
+	return strings.HasPrefix(body, "event:") || strings.HasPrefix(body, "data:")
+}
+
+// SSEEvents 将流式响应体按空行切分为事件
+func SSEEvents(body string) []SSEEvent {
+	var events []SSEEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+		event := SSEEvent{Raw: block}
+		for _, line := range strings.Split(block, "\n") {
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			if t := sseEventTime(strings.TrimSpace(strings.TrimPrefix(line, "data:"))); !t.IsZero() {
+				event.Time = t
+				break
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// sseEventTime 读取事件数据中的时间戳字段（created、created_at、timestamp），
+// 支持 Unix 秒/毫秒和 RFC3339 字符串
+func sseEventTime(data string) time.Time {
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(data), &fields) != nil {
+		return time.Time{}
+	}
+	for _, key := range []string{"timestamp", "created_at", "created"} {
+		switch v := fields[key].(type) {
+		case float64:
+			if v > 1e12 {
+				return time.UnixMilli(int64(v))
+			}
+			if v > 0 {
+				return time.Unix(int64(v), int64((v-float64(int64(v)))*1e9))
+			}
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mapStreamResponse 按 map 解码每个事件拼接文本（结构体解码之前的实现），
+// 加上 Responses 格式的 response.output_text.delta，作为 extractFullStreamResponse 的对照
+func mapStreamResponse(body string) string {
+	var fullContent strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		dataStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if dataStr == "[DONE]" {
+			continue
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+			continue
+		}
+		if data["type"] == "response.output_text.delta" {
+			if text, ok := data["delta"].(string); ok {
+				fullContent.WriteString(text)
+			}
+		}
+		if delta, ok := data["delta"].(map[string]interface{}); ok {
+			if text, ok := delta["text"].(string); ok {
+				fullContent.WriteString(text)
+			}
+		}
+		if choices, ok := data["choices"].([]interface{}); ok && len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok {
+				if delta, ok := choice["delta"].(map[string]interface{}); ok {
+					if content, ok := delta["content"].(string); ok {
+						fullContent.WriteString(content)
+					}
+				}
+			}
+		}
+	}
+	return fullContent.String()
+}
+
+// sseData 将各事件写成 SSE，event 为空时只有 data 行
+func sseData(events ...[2]string) string {
+	var b strings.Builder
+	for _, e := range events {
+		if e[0] != "" {
+			fmt.Fprintf(&b, "event: %s\n", e[0])
+		}
+		fmt.Fprintf(&b, "data: %s\n\n", e[1])
+	}
+	return b.String()
+}
+
+// chunkText 第 i 个片段的文本，包含需要转义的字符和多字节字符
+func chunkText(i int) string {
+	return fmt.Sprintf("片段 %d \"quoted\"\\n ", i)
+}
+
+func claudeStream(chunks int) string {
+	events := [][2]string{
+		{"message_start", `{"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":1200,"output_tokens":1}}}`},
+		{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`},
+		{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"let me think"}}`},
+		{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+		{"content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`},
+		{"ping", `{"type":"ping"}`},
+	}
+	for i := 0; i < chunks; i++ {
+		text, _ := json.Marshal(chunkText(i))
+		events = append(events, [2]string{"content_block_delta",
+			fmt.Sprintf(`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":%s}}`, text)})
+	}
+	return sseData(append(events,
+		[2]string{"content_block_stop", `{"type":"content_block_stop","index":1}`},
+		[2]string{"content_block_start", `{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_01","name":"get_weather","input":{}}}`},
+		[2]string{"content_block_delta", `{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"city\": \"Par"}}`},
+		[2]string{"content_block_stop", `{"type":"content_block_stop","index":2}`},
+		[2]string{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":2048}}`},
+		[2]string{"message_stop", `{"type":"message_stop"}`},
+	)...)
+}
+
+func chatCompletionsStream(chunks int) string {
+	events := [][2]string{
+		{"", `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1767835788,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`},
+	}
+	for i := 0; i < chunks; i++ {
+		text, _ := json.Marshal(chunkText(i))
+		events = append(events, [2]string{"",
+			fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1767835788,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":%s},"finish_reason":null}]}`, text)})
+	}
+	return sseData(append(events,
+		[2]string{"", `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1767835788,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]},"finish_reason":null}]}`},
+		[2]string{"", `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1767835788,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`},
+		[2]string{"", `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1767835788,"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":2048}}`},
+		[2]string{"", `[DONE]`},
+	)...)
+}
+
+func responsesStream(chunks int) string {
+	events := [][2]string{
+		{"response.created", `{"type":"response.created","sequence_number":0,"response":{"id":"resp_1","status":"in_progress","model":"gpt-5"}}`},
+		{"response.reasoning_summary_text.delta", `{"type":"response.reasoning_summary_text.delta","item_id":"rs_1","output_index":0,"summary_index":0,"delta":"thinking"}`},
+		{"response.output_item.added", `{"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1","role":"assistant","content":[]}}`},
+	}
+	for i := 0; i < chunks; i++ {
+		text, _ := json.Marshal(chunkText(i))
+		events = append(events, [2]string{"response.output_text.delta",
+			fmt.Sprintf(`{"type":"response.output_text.delta","sequence_number":%d,"item_id":"msg_1","output_index":1,"content_index":0,"delta":%s}`, i+1, text)})
+	}
+	return sseData(append(events,
+		[2]string{"response.output_item.added", `{"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","call_id":"call_1","name":"get_weather","arguments":""}}`},
+		[2]string{"response.function_call_arguments.delta", `{"type":"response.function_call_arguments.delta","output_index":2,"delta":"{\"city\":"}`},
+		[2]string{"response.completed", `{"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":1200,"output_tokens":2048}}}`},
+	)...)
+}
+
+func expectedText(chunks int) string {
+	var b strings.Builder
+	for i := 0; i < chunks; i++ {
+		b.WriteString(chunkText(i))
+	}
+	return b.String()
+}
+
+func TestExtractFullStreamResponseMatchesMap(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// 为空时只与 mapStreamResponse 比较
+		want string
+	}{
+		{name: "claude", body: claudeStream(50), want: expectedText(50)},
+		{name: "chat completions", body: chatCompletionsStream(50), want: expectedText(50)},
+		{name: "responses", body: responsesStream(50), want: expectedText(50)},
+		{name: "crlf", body: strings.ReplaceAll(claudeStream(3), "\n", "\r\n"), want: expectedText(3)},
+		{name: "no space after data", body: "data:{\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\ndata:{\"choices\":[{\"delta\":{\"content\":\"b\"}}]}\n", want: "ab"},
+		{name: "malformed json", body: "data: {\"delta\":{\"text\":\"a\"\ndata: {\"delta\":{\"text\":\"b\"}}\n", want: "b"},
+		{name: "non-string text", body: "data: {\"delta\":{\"text\":42},\"choices\":[{\"delta\":{\"content\":\"c\"}}]}\n", want: "c"},
+		{name: "mismatched choices", body: "data: {\"delta\":{\"text\":\"a\"},\"choices\":\"none\"}\n", want: "a"},
+		{name: "null content", body: "data: {\"choices\":[{\"delta\":{\"content\":null}}]}\ndata: {\"choices\":[{\"delta\":{\"content\":\"x\"}},{\"delta\":{\"content\":\"y\"}}]}\n", want: "x"},
+		{name: "string delta without responses type", body: "data: {\"type\":\"response.reasoning_text.delta\",\"delta\":\"r\"}\n"},
+		{name: "empty", body: ""},
+		{name: "json body", body: `{"content":[{"type":"text","text":"hello"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractFullStreamResponse(tt.body)
+			if want := mapStreamResponse(tt.body); got != want {
+				t.Fatalf("differs from map decoding:\ngot  %q\nwant %q", got, want)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkExtractFullStreamResponse(b *testing.B) {
+	streams := []struct {
+		name string
+		body string
+	}{
+		{"claude", claudeStream(2000)},
+		{"chat_completions", chatCompletionsStream(2000)},
+		{"responses", responsesStream(2000)},
+	}
+	for _, s := range streams {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(s.body)))
+			for i := 0; i < b.N; i++ {
+				extractFullStreamResponse(s.body)
+			}
+		})
+		b.Run(s.name+"/map", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(s.body)))
+			for i := 0; i < b.N; i++ {
+				mapStreamResponse(s.body)
+			}
+		})
+	}
+}
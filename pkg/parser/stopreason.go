@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// stopHolder 响应中结束原因可能出现的位置：Claude 顶层或 message_delta 的 delta（stop_reason）、
+// OpenAI choices（finish_reason）、Responses 事件的 response（incomplete_details.reason 或 status）
+type stopHolder struct {
+	StopReason string `json:"stop_reason"`
+	Delta      *struct {
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Response *struct {
+		Status            string `json:"status"`
+		IncompleteDetails *struct {
+			Reason string `json:"reason"`
+		} `json:"incomplete_details"`
+	} `json:"response"`
+}
+
+func (h stopHolder) reason() string {
+	switch {
+	case h.StopReason != "":
+		return h.StopReason
+	case h.Delta != nil && h.Delta.StopReason != "":
+		return h.Delta.StopReason
+	case h.Response != nil:
+		if d := h.Response.IncompleteDetails; d != nil && d.Reason != "" {
+			return d.Reason
+		}
+		// 进行中的事件（response.created 等）不计入
+		if h.Response.Status != "in_progress" && h.Response.Status != "queued" {
+			return h.Response.Status
+		}
+	}
+	for _, c := range h.Choices {
+		if c.FinishReason != "" {
+			return c.FinishReason
+		}
+	}
+	return ""
+}
+
+// extractStopReason 从响应体（JSON 或 SSE）中提取结束原因（end_turn、max_tokens、tool_use、stop、length 等），
+// 流式响应取最后一个带结束原因的事件
+func extractStopReason(body string) string {
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") {
+		var h stopHolder
+		json.Unmarshal([]byte(trimmed), &h)
+		return h.reason()
+	}
+	var reason string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if !strings.HasPrefix(data, "{") ||
+			!strings.Contains(data, "_reason") && !strings.Contains(data, `"status"`) {
+			continue
+		}
+		var h stopHolder
+		if json.Unmarshal([]byte(data), &h) == nil {
+			if r := h.reason(); r != "" {
+				reason = r
+			}
+		}
+	}
+	return reason
+}
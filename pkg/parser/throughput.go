@@ -0,0 +1,62 @@
+package parser
+
+import "time"
+
+// 超过该时长的响应时间视为不可信（文件在请求结束后被修改或复制）
+const maxResponseDuration = time.Hour
+
+// Throughput 请求的响应时长和输出速度
+type Throughput struct {
+	// 响应时长（毫秒），无法确定时为 0
+	ResponseMs uint32 `json:"response_ms,omitempty"`
+	// sse（流式事件时间戳的跨度）或 mtime（请求开始到日志文件写完）
+	Source string `json:"duration_source,omitempty"`
+	// 每秒输出 token 数，没有输出 token 或时长时为 0
+	OutputTokensPerSecond float64 `json:"output_tokens_per_second,omitempty"`
+}
+
+// computeThroughput 计算响应时长和输出速度：优先使用流式事件中时间戳的跨度（生成阶段，不含首 token 等待），
+// 否则使用请求开始（重试时为最后一次上游调用开始）到日志文件修改时间的间隔
+func computeThroughput(entry *APILogEntry, modTime time.Time) Throughput {
+	var t Throughput
+	if d := sseSpan(entry); d > 0 {
+		t.Source = "sse"
+		t.ResponseMs = uint32(d.Milliseconds())
+	} else if entry.Flag == "" && !entry.Incomplete && !modTime.IsZero() {
+		start := entry.Timestamp
+		if n := len(entry.UpstreamRequests); n > 0 {
+			if ts := entry.UpstreamRequests[n-1].Timestamp; ts.After(start) {
+				start = ts
+			}
+		}
+		if d := modTime.Sub(start); d > 0 && d <= maxResponseDuration {
+			t.Source = "mtime"
+			t.ResponseMs = uint32(d.Milliseconds())
+		}
+	}
+	if t.ResponseMs > 0 && entry.Usage.OutputTokens > 0 {
+		t.OutputTokensPerSecond = float64(entry.Usage.OutputTokens) / (float64(t.ResponseMs) / 1000)
+	}
+	return t
+}
+
+// sseSpan 流式响应中第一个和最后一个带时间戳事件的间隔，没有时为 0
+func sseSpan(entry *APILogEntry) time.Duration {
+	if !entry.Streamed {
+		return 0
+	}
+	var first, last time.Time
+	for _, e := range SSEEvents(entry.ResponseBody) {
+		if e.Time.IsZero() {
+			continue
+		}
+		if first.IsZero() {
+			first = e.Time
+		}
+		last = e.Time
+	}
+	if d := last.Sub(first); d > 0 && d <= maxResponseDuration {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"os"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// TimestampCheck 时间戳校验结果，正常的时间戳两个字段均为零值
+type TimestampCheck struct {
+	// zero（缺失或明显无效）、future（晚于文件修改时间）、past（远早于文件修改时间）
+	Flag string `json:"timestamp_flag,omitempty"`
+	// 原始时间戳与文件修改时间的差值（秒），flag 为 zero 时为 0
+	SkewSeconds int64 `json:"timestamp_skew_seconds,omitempty"`
+}
+
+// timestampCheck 当前的时间戳校验配置，启动时由 Configure 设置
+var timestampCheck = config.TimestampCheckConfig{
+	MaxFutureSeconds: 300,
+	MaxPastDays:      30,
+}
+
+// minValidTime 早于该时间的时间戳视为无效
+var minValidTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// CheckTimestamp 以文件修改时间 ref 为参照校验时间戳，
+// 启用 use_file_mtime 时异常的时间戳替换为 ref
+func CheckTimestamp(ts, ref time.Time) (time.Time, TimestampCheck) {
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+
+	var check TimestampCheck
+	switch {
+	case ts.Before(minValidTime):
+		check.Flag = "zero"
+	case ts.After(ref.Add(time.Duration(timestampCheck.MaxFutureSeconds) * time.Second)):
+		check.Flag = "future"
+	case timestampCheck.MaxPastDays > 0 &&
+		ts.Before(ref.AddDate(0, 0, -timestampCheck.MaxPastDays)):
+		check.Flag = "past"
+	default:
+		return ts, check
+	}
+
+	if check.Flag != "zero" {
+		check.SkewSeconds = ts.Unix() - ref.Unix()
+	}
+	if timestampCheck.UseFileMtime {
+		ts = ref
+	}
+	return ts, check
+}
+
+// fileModTime 返回文件修改时间，获取失败时返回零值（校验时使用当前时间）
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ToolCall 响应中模型发起的一次工具调用
+type ToolCall struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// 调用参数（JSON 文本），流式响应由各 delta 片段拼接
+	Input string `json:"input"`
+}
+
+// toolBlock Claude 的 tool_use / server_tool_use / mcp_tool_use 内容块
+type toolBlock struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	ServerName string          `json:"server_name"`
+	Input      json.RawMessage `json:"input"`
+}
+
+// call 非工具调用的内容块返回 ok=false，MCP 工具名按 mcp__<server>__<tool> 记录
+func (b toolBlock) call() (ToolCall, bool) {
+	name := b.Name
+	switch b.Type {
+	case "tool_use", "server_tool_use":
+	case "mcp_tool_use":
+		name = mcpToolPrefix + b.ServerName + "__" + b.Name
+	default:
+		return ToolCall{}, false
+	}
+	return ToolCall{ID: b.ID, Name: name, Input: string(b.Input)}, true
+}
+
+// openAIToolCall OpenAI Chat Completions 的 tool_calls 元素，流式响应中按 index 拼接
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// responsesItem OpenAI Responses 的 output 元素，工具调用为 function_call
+type responsesItem struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// toolResponse 非流式响应中工具调用所在的字段
+type toolResponse struct {
+	// Claude
+	Content []toolBlock `json:"content"`
+	// OpenAI Chat Completions
+	Choices []struct {
+		Message struct {
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+	// OpenAI Responses
+	Output []responsesItem `json:"output"`
+}
+
+// toolEvent 流式响应中与工具调用相关的事件字段
+type toolEvent struct {
+	Type string `json:"type"`
+	// Claude: content_block_start / content_block_delta 的内容块序号；
+	// Responses: output_item.added / function_call_arguments.delta 的 output_index
+	Index        int        `json:"index"`
+	OutputIndex  int        `json:"output_index"`
+	ContentBlock *toolBlock `json:"content_block"`
+	// Claude 的 input_json_delta；Responses 的 delta 为参数片段本身
+	Delta json.RawMessage `json:"delta"`
+	Item  *responsesItem  `json:"item"`
+	// OpenAI Chat Completions
+	Choices []struct {
+		Delta struct {
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// extractToolCalls 从响应体（JSON 或 SSE）中提取模型发起的工具调用，按出现顺序返回
+func extractToolCalls(body string) []ToolCall {
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") {
+		return responseToolCalls(trimmed)
+	}
+	return streamToolCalls(body)
+}
+
+func responseToolCalls(body string) []ToolCall {
+	var resp toolResponse
+	if json.Unmarshal([]byte(body), &resp) != nil {
+		return nil
+	}
+	var calls []ToolCall
+	for _, b := range resp.Content {
+		if call, ok := b.call(); ok {
+			calls = append(calls, call)
+		}
+	}
+	for _, c := range resp.Choices {
+		for _, tc := range c.Message.ToolCalls {
+			calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: tc.Function.Arguments})
+		}
+	}
+	for _, item := range resp.Output {
+		if item.Type == "function_call" {
+			calls = append(calls, ToolCall{ID: item.CallID, Name: item.Name, Input: item.Arguments})
+		}
+	}
+	return calls
+}
+
+// streamToolCalls 按内容块序号（Claude）、tool_calls 的 index（OpenAI）或 output_index（Responses）
+// 拼接各工具调用的参数片段
+func streamToolCalls(body string) []ToolCall {
+	var calls []ToolCall
+	// 各格式的序号到 calls 下标的映射
+	claude := make(map[int]int)
+	openAI := make(map[int]int)
+	responses := make(map[int]int)
+	// Claude 流式响应 content_block_start 中的 input 为 {}，收到 input_json_delta 后改为拼接的片段
+	var partial map[int]bool
+
+	for rest := body; rest != ""; {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(line[len("data:"):])
+		if !strings.Contains(data, "tool") && !strings.Contains(data, "function_call") && !strings.Contains(data, "input_json_delta") {
+			continue
+		}
+		var ev toolEvent
+		if json.Unmarshal([]byte(data), &ev) != nil {
+			continue
+		}
+
+		switch ev.Type {
+		case "content_block_start":
+			if ev.ContentBlock == nil {
+				continue
+			}
+			if call, ok := ev.ContentBlock.call(); ok {
+				claude[ev.Index] = len(calls)
+				calls = append(calls, call)
+			}
+		case "content_block_delta":
+			i, ok := claude[ev.Index]
+			if !ok {
+				continue
+			}
+			var delta struct {
+				Type        string `json:"type"`
+				PartialJSON string `json:"partial_json"`
+			}
+			if json.Unmarshal(ev.Delta, &delta) != nil || delta.Type != "input_json_delta" {
+				continue
+			}
+			if partial == nil {
+				partial = make(map[int]bool)
+			}
+			if !partial[i] {
+				partial[i] = true
+				calls[i].Input = ""
+			}
+			calls[i].Input += delta.PartialJSON
+		case "response.output_item.added", "response.output_item.done":
+			if ev.Item == nil || ev.Item.Type != "function_call" {
+				continue
+			}
+			call := ToolCall{ID: ev.Item.CallID, Name: ev.Item.Name, Input: ev.Item.Arguments}
+			i, ok := responses[ev.OutputIndex]
+			if !ok {
+				responses[ev.OutputIndex] = len(calls)
+				calls = append(calls, call)
+				continue
+			}
+			// output_item.done 带完整参数
+			if call.Input != "" {
+				calls[i] = call
+			}
+		case "response.function_call_arguments.delta":
+			i, ok := responses[ev.OutputIndex]
+			if !ok {
+				continue
+			}
+			var fragment string
+			if json.Unmarshal(ev.Delta, &fragment) == nil {
+				calls[i].Input += fragment
+			}
+		default:
+			if len(ev.Choices) == 0 {
+				continue
+			}
+			for _, tc := range ev.Choices[0].Delta.ToolCalls {
+				i, ok := openAI[tc.Index]
+				if !ok {
+					openAI[tc.Index] = len(calls)
+					calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: tc.Function.Arguments})
+					continue
+				}
+				calls[i].Input += tc.Function.Arguments
+			}
+		}
+	}
+	return calls
+}
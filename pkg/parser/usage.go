@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Usage 响应中的 token 用量
+// OpenAI 格式的 input_tokens（prompt_tokens）包含缓存命中部分，Claude 格式不包含
+type Usage struct {
+	InputTokens              uint64 `json:"input_tokens,omitempty"`
+	OutputTokens             uint64 `json:"output_tokens,omitempty"`
+	CacheReadInputTokens     uint64 `json:"cache_read_input_tokens,omitempty"`
+	CacheCreationInputTokens uint64 `json:"cache_creation_input_tokens,omitempty"`
+}
+
+// usageFields 各格式 usage 对象的字段
+type usageFields struct {
+	InputTokens              uint64 `json:"input_tokens"`
+	OutputTokens             uint64 `json:"output_tokens"`
+	CacheReadInputTokens     uint64 `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens uint64 `json:"cache_creation_input_tokens"`
+	// OpenAI Chat Completions
+	PromptTokens        uint64 `json:"prompt_tokens"`
+	CompletionTokens    uint64 `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens uint64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	// OpenAI Responses
+	InputTokensDetails struct {
+		CachedTokens uint64 `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+}
+
+// usageHolder usage 可能出现的位置：顶层、message_start 的 message、Responses 事件的 response
+type usageHolder struct {
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"message"`
+	Response *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"response"`
+}
+
+// extractUsage 从响应体（JSON 或 SSE）中提取 token 用量
+// 流式响应的用量分布在多个事件中（如 message_start 和 message_delta），各字段取最大值
+func extractUsage(body string) Usage {
+	var u Usage
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") {
+		u.merge(trimmed)
+		return u
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if strings.HasPrefix(data, "{") && strings.Contains(data, "tokens") {
+			u.merge(data)
+		}
+	}
+	return u
+}
+
+// merge 合并一个 JSON 对象中的 usage
+func (u *Usage) merge(data string) {
+	var h usageHolder
+	if json.Unmarshal([]byte(data), &h) != nil {
+		return
+	}
+	for _, f := range []*usageFields{h.Usage, messageUsage(h), responseUsage(h)} {
+		if f == nil {
+			continue
+		}
+		u.InputTokens = max(u.InputTokens, f.InputTokens, f.PromptTokens)
+		u.OutputTokens = max(u.OutputTokens, f.OutputTokens, f.CompletionTokens)
+		u.CacheReadInputTokens = max(u.CacheReadInputTokens, f.CacheReadInputTokens,
+			f.PromptTokensDetails.CachedTokens, f.InputTokensDetails.CachedTokens)
+		u.CacheCreationInputTokens = max(u.CacheCreationInputTokens, f.CacheCreationInputTokens)
+	}
+}
+
+func messageUsage(h usageHolder) *usageFields {
+	if h.Message == nil {
+		return nil
+	}
+	return h.Message.Usage
+}
+
+func responseUsage(h usageHolder) *usageFields {
+	if h.Response == nil {
+		return nil
+	}
+	return h.Response.Usage
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IngestAudit 单次文件处理尝试的审计记录
+type IngestAudit struct {
+	FilePath  string
+	LogType   string
+	StartTime time.Time
+	EndTime   time.Time
+	Rows      uint32
+	Bytes     uint64
+	// success / incomplete / sampled_out / parse_error / insert_error / mark_error
+	Outcome string
+	Error   string
+	// 该文件此前失败的尝试次数
+	RetryCount uint32
+}
+
+// AdminAudit 管理接口操作的审计记录
+type AdminAudit struct {
+	Time       time.Time
+	TokenName  string
+	Role       string
+	Method     string
+	Path       string
+	Body       string
+	Status     uint16
+	RemoteAddr string
+}
+
+// createAuditTable 创建文件处理审计表和管理操作审计表
+func (s *ClickHouseStorage) createAuditTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			file_path String,
+			log_type LowCardinality(String),
+			start_time DateTime64(3),
+			end_time DateTime64(3),
+			duration_ms UInt32,
+			rows UInt32,
+			bytes UInt64,
+			outcome LowCardinality(String),
+			error String,
+			retry_count UInt32,
+			host LowCardinality(String),
+			instance LowCardinality(String)
+		) ENGINE = MergeTree()
+		PARTITION BY toYYYYMM(start_time)
+		ORDER BY (start_time, file_path)
+		TTL toDateTime(start_time) + INTERVAL 90 DAY
+	`, s.table("ingest_audit"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create ingest_audit table: %w", err)
+	}
+
+	query = fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			time DateTime64(3),
+			token_name String,
+			role LowCardinality(String),
+			method LowCardinality(String),
+			path String,
+			body String,
+			status UInt16,
+			remote_addr String,
+			host LowCardinality(String)
+		) ENGINE = MergeTree()
+		PARTITION BY toYYYYMM(time)
+		ORDER BY time
+	`, s.table("admin_audit"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create admin_audit table: %w", err)
+	}
+	return nil
+}
+
+// InsertAdminAudit 记录一次管理操作
+func (s *ClickHouseStorage) InsertAdminAudit(ctx context.Context, a *AdminAudit) error {
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+		(time, token_name, role, method, path, body, status, remote_addr, host)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.table("admin_audit")),
+		a.Time, a.TokenName, a.Role, a.Method, a.Path, a.Body, a.Status, a.RemoteAddr, s.labels.Host)
+}
+
+// InsertIngestAudit 记录一次文件处理尝试
+func (s *ClickHouseStorage) InsertIngestAudit(ctx context.Context, a *IngestAudit) error {
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+		(file_path, log_type, start_time, end_time, duration_ms, rows, bytes, outcome, error, retry_count, host, instance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.table("ingest_audit")),
+		a.FilePath, a.LogType, a.StartTime, a.EndTime,
+		uint32(a.EndTime.Sub(a.StartTime).Milliseconds()),
+		a.Rows, a.Bytes, a.Outcome, a.Error, a.RetryCount,
+		s.labels.Host, s.labels.Instance)
+}
+
+// AdminActions 查询时间范围内的管理操作，按时间排序
+func (s *ClickHouseStorage) AdminActions(ctx context.Context, since, until time.Time, limit int) ([]AdminAudit, error) {
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(`
+		SELECT time, token_name, role, method, path, body, status, remote_addr
+		FROM %s
+		WHERE time >= ? AND time < ?
+		ORDER BY time
+		LIMIT %d
+	`, s.table("admin_audit"), limit), since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []AdminAudit
+	for rows.Next() {
+		var a AdminAudit
+		if err := rows.Scan(&a.Time, &a.TokenName, &a.Role, &a.Method, &a.Path, &a.Body, &a.Status, &a.RemoteAddr); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
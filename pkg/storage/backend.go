@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// ErrUnsupported 存储后端不支持的操作（如按文件删除已写入的行）
+var ErrUnsupported = errors.New("not supported by this storage backend")
+
+// Storage 采集器的写入目标及已处理文件记录，各后端都须实现。
+// 去重令牌、请求关联、重放队列、采集审计、按文件删除为可选能力，见下方的接口，由 As 查找
+type Storage interface {
+	InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error
+	InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error
+	InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error
+	InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error
+	InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error
+	MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error
+	IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error)
+	LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error)
+	Close() error
+}
+
+// Deduplicator 支持写入去重令牌：ctx 中带同一令牌的重复写入被丢弃
+type Deduplicator interface {
+	WithInsertToken(ctx context.Context, token string) context.Context
+}
+
+// APILinker 写入 API 日志后生成请求关联等派生数据
+type APILinker interface {
+	LinkAPILog(ctx context.Context, entry *parser.APILogEntry) error
+}
+
+// ReplayQueuer 将失败的请求加入重放队列
+type ReplayQueuer interface {
+	EnqueueReplay(ctx context.Context, entry *parser.APILogEntry) error
+}
+
+// IngestAuditor 记录每次文件处理的审计记录
+type IngestAuditor interface {
+	InsertIngestAudit(ctx context.Context, a *IngestAudit) error
+}
+
+// FileRowDeleter 按日志文件删除已写入的行，并按 request_id 查询日志文件（重新处理使用）
+type FileRowDeleter interface {
+	DeleteFileRows(ctx context.Context, logFile string) error
+	RequestLogFiles(ctx context.Context, requestID string) ([]string, error)
+}
+
+// IncompleteRowDeleter 删除文件未写完时写入的请求日志行（incomplete = 1），文件完整的行写入后调用
+type IncompleteRowDeleter interface {
+	DeleteIncompleteRows(ctx context.Context, logFile string) error
+}
+
+// ProcessedForgetter 删除文件在本地的已处理记录（state_store），重新处理未完成时文件仍按未处理的文件处理
+type ProcessedForgetter interface {
+	ForgetProcessedFile(ctx context.Context, filePath string) error
+}
+
+// As 查找 s 实现的可选接口 T；包装其他存储的存储（镜像、附加目标、本地状态）通过 Unwrap 返回被包装的存储，
+// 自身未实现时继续在被包装的存储上查找
+func As[T any](s Storage) (T, bool) {
+	for s != nil {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		w, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// WithInsertToken 为 ctx 中写入 s 的数据设置去重令牌，s 不支持去重时原样返回 ctx
+func WithInsertToken(ctx context.Context, s Storage, token string) context.Context {
+	if d, ok := As[Deduplicator](s); ok {
+		return d.WithInsertToken(ctx, token)
+	}
+	return ctx
+}
+
+// Factory 按配置创建存储后端，ctx 控制连接和建表的超时与取消
+type Factory func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Factory)
+)
+
+// Register 注册名为 name 的存储后端（storage.type 的取值），名称重复时 panic；在 init 中调用
+func Register(name string, f Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic("storage: backend registered twice: " + name)
+	}
+	backends[name] = f
+}
+
+// Backends 返回已注册的存储后端名称
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open 创建 storage.type 指定的存储后端
+func Open(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+	backendsMu.Lock()
+	f, ok := backends[cfg.Storage.Type]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage.type: %s (available: %s)", cfg.Storage.Type, strings.Join(Backends(), ", "))
+	}
+	return f(ctx, cfg, labels)
+}
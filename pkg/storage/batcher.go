@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pendingInsert 一条 INSERT 语句等待合并写入的行，写入完成后 close(done)
+type pendingInsert struct {
+	query  string
+	values [][]interface{}
+	timer  *time.Timer
+	done   chan struct{}
+	err    error
+}
+
+// insertBatcher 合并写入：相同 INSERT 语句（同一目标表和列）的行在内存中累积，
+// 达到 max_rows 行或第一行加入后经过 interval 时一次写入，减少小批量写入产生的 part。
+// add 等待所在批次写入完成后返回，调用方仍在数据写入后才标记文件已处理
+type insertBatcher struct {
+	send    func(ctx context.Context, query string, values [][]interface{}) error
+	maxRows int
+	// 按表覆盖 maxRows
+	tableMaxRows map[string]int
+	interval     time.Duration
+	// 单次写入的超时（秒），0 表示不限制
+	timeout int
+
+	mu      sync.Mutex
+	pending map[string]*pendingInsert
+	closed  bool
+}
+
+func newInsertBatcher(maxRows int, tableMaxRows map[string]int, interval time.Duration, timeout int,
+	send func(ctx context.Context, query string, values [][]interface{}) error) *insertBatcher {
+	return &insertBatcher{
+		send:         send,
+		maxRows:      maxRows,
+		tableMaxRows: tableMaxRows,
+		interval:     interval,
+		timeout:      timeout,
+		pending:      make(map[string]*pendingInsert),
+	}
+}
+
+// add 将数据表 table 的行加入 query 的批次并等待写入结果；ctx 取消时立即返回，行仍会随批次写入
+func (b *insertBatcher) add(ctx context.Context, table, query string, values [][]interface{}) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return b.send(ctx, query, values)
+	}
+	p := b.pending[query]
+	if p == nil {
+		p = &pendingInsert{query: query, done: make(chan struct{})}
+		b.pending[query] = p
+		p.timer = time.AfterFunc(b.interval, func() {
+			if b.take(p) {
+				b.flush(p)
+			}
+		})
+	}
+	p.values = append(p.values, values...)
+	maxRows := b.maxRows
+	if n, ok := b.tableMaxRows[table]; ok {
+		maxRows = n
+	}
+	full := len(p.values) >= maxRows
+	if full {
+		delete(b.pending, query)
+		p.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush(p)
+	}
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take 将批次移出待写入列表，已被其他协程取走时返回 false
+func (b *insertBatcher) take(p *pendingInsert) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending[p.query] != p {
+		return false
+	}
+	delete(b.pending, p.query)
+	return true
+}
+
+// flush 写入批次并通知等待的调用方
+func (b *insertBatcher) flush(p *pendingInsert) {
+	ctx, cancel := withTimeout(context.Background(), b.timeout)
+	defer cancel()
+	p.err = b.send(ctx, p.query, p.values)
+	close(p.done)
+}
+
+// flushAll 立即写入所有未写入的批次并等待完成
+func (b *insertBatcher) flushAll() {
+	b.mu.Lock()
+	pending := make([]*pendingInsert, 0, len(b.pending))
+	for query, p := range b.pending {
+		p.timer.Stop()
+		pending = append(pending, p)
+		delete(b.pending, query)
+	}
+	b.mu.Unlock()
+
+	for _, p := range pending {
+		b.flush(p)
+	}
+}
+
+// close 写入所有未写入的批次，之后的 add 直接写入
+func (b *insertBatcher) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.flushAll()
+}
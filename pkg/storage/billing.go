@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// BillingLine 某个 API key 在某个模型上的用量和费用（美元）
+type BillingLine struct {
+	APIKey                   string  `json:"api_key"`
+	Model                    string  `json:"model"`
+	Requests                 uint64  `json:"requests"`
+	InputTokens              uint64  `json:"input_tokens"`
+	OutputTokens             uint64  `json:"output_tokens"`
+	CacheReadInputTokens     uint64  `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens uint64  `json:"cache_creation_input_tokens"`
+	Cost                     float64 `json:"cost"`
+}
+
+// UsageByKey 统计 [from, to) 内客户端请求（v1_*）按 API key 和模型的用量和费用
+// fromRollup 为 true 时读取 daily_usage（费用为汇总时的价格），否则从 API 日志按 pricing 计算
+func (s *ClickHouseStorage) UsageByKey(ctx context.Context, from, to time.Time, fromRollup bool, pricing map[string]config.ModelPrice) ([]BillingLine, error) {
+	var query string
+	if fromRollup {
+		query = fmt.Sprintf(`
+			SELECT api_key, model, sum(requests), sum(input_tokens), sum(output_tokens),
+				sum(cache_read_input_tokens), sum(cache_creation_input_tokens), sum(cost)
+			FROM %s FINAL
+			WHERE startsWith(log_type, 'v1_') AND day >= toDate(?) AND day < toDate(?)
+			GROUP BY api_key, model
+			ORDER BY api_key, model
+		`, s.table("daily_usage"))
+	} else {
+		t := s.tables["api_logs"]
+		cols := []string{
+			t.selectColumn("log_type", "''"),
+			t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+			t.modelColumn(),
+			t.selectColumn("api_key", "''"),
+			t.selectColumn("incomplete", "toUInt8(0)"),
+			t.selectColumn("input_tokens", "toUInt64(0)"),
+			t.selectColumn("output_tokens", "toUInt64(0)"),
+			t.selectColumn("cache_read_input_tokens", "toUInt64(0)"),
+			t.selectColumn("cache_creation_input_tokens", "toUInt64(0)"),
+		}
+		query = fmt.Sprintf(`
+			SELECT api_key, model, count(), sum(input_tokens), sum(output_tokens),
+				sum(cache_read_input_tokens), sum(cache_creation_input_tokens), sum(%s)
+			FROM (SELECT %s FROM %s)
+			WHERE startsWith(log_type, 'v1_') AND incomplete = 0 AND timestamp >= ? AND timestamp < ?
+			GROUP BY api_key, model
+			ORDER BY api_key, model
+		`, costExpr(pricing), strings.Join(cols, ", "), s.readTable("api_logs"))
+	}
+
+	rows, err := s.conn.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []BillingLine
+	for rows.Next() {
+		var l BillingLine
+		if err := rows.Scan(&l.APIKey, &l.Model, &l.Requests, &l.InputTokens, &l.OutputTokens,
+			&l.CacheReadInputTokens, &l.CacheCreationInputTokens, &l.Cost); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// 已写入哈希缓存的最大条数和重置周期。缓存每天重置一次，保证仍被引用的
+	// body 至少每天重新写入一次，从而刷新 bodies 表的 TTL
+	seenBodiesLimit = 100000
+	seenBodiesReset = 24 * time.Hour
+)
+
+// bodyStore 按内容哈希去重存储 body
+type bodyStore struct {
+	mu        sync.Mutex
+	seen      map[string]struct{}
+	resetTime time.Time
+}
+
+func newBodyStore() *bodyStore {
+	return &bodyStore{
+		seen:      make(map[string]struct{}),
+		resetTime: time.Now(),
+	}
+}
+
+// hashBody 计算 body 的内容哈希，空 body 返回空字符串
+func hashBody(content string) string {
+	if content == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// markSeen 记录哈希，返回该哈希是否为新出现（需要写入）
+func (b *bodyStore) markSeen(hash string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.seen) >= seenBodiesLimit || time.Since(b.resetTime) > seenBodiesReset {
+		b.seen = make(map[string]struct{})
+		b.resetTime = time.Now()
+	}
+	if _, ok := b.seen[hash]; ok {
+		return false
+	}
+	b.seen[hash] = struct{}{}
+	return true
+}
+
+// forget 写入失败时移除哈希，下次重新写入
+func (b *bodyStore) forget(hashes []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, h := range hashes {
+		delete(b.seen, h)
+	}
+}
+
+func (s *ClickHouseStorage) createBodiesTable(ctx context.Context) error {
+	bodiesTable := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			hash String,
+			content String,
+			inserted_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(inserted_at)
+		ORDER BY hash
+		TTL toDateTime(inserted_at) + INTERVAL 91 DAY
+	`, s.table("bodies"))
+	if err := s.createTable(ctx, bodiesTable); err != nil {
+		return fmt.Errorf("failed to create bodies table: %w", err)
+	}
+	// 跨分片查询时 body 可能在其他分片的 bodies 表中；只用于读取，分片键不影响写入
+	apiLogs, bodies, join := s.tables["api_logs"].fullName(), s.table("bodies"), "LEFT JOIN"
+	if s.cluster.Distributed {
+		schema := &tableSchema{database: s.database, table: s.tableName("bodies")}
+		if err := s.syncDistributedTable(ctx, schema, "rand()"); err != nil {
+			return fmt.Errorf("failed to create bodies_all table: %w", err)
+		}
+		// 右表在发起查询的节点上读取后发送到各分片
+		apiLogs, bodies, join = apiLogs+"_all", bodies+"_all", "GLOBAL LEFT JOIN"
+	}
+
+	// api_logs 由用户维护时列名不确定，不创建还原视图
+	if s.tables["api_logs"].mapped {
+		log.Println("Skipping api_logs_resolved view: api_logs is a mapped table")
+		return nil
+	}
+
+	// api_logs_resolved 视图将哈希还原为 body，查询时与 api_logs 用法一致
+	resolvedView := fmt.Sprintf(`
+		CREATE OR REPLACE VIEW %[1]s AS
+		SELECT a.* REPLACE (
+			if(a.request_body_hash != '', rb.content, a.request_body) AS request_body,
+			if(a.response_body_hash != '', sb.content, a.response_body) AS response_body,
+			if(a.full_response_hash != '', fb.content, a.full_response) AS full_response
+		)
+		FROM %[2]s AS a
+		%[4]s (SELECT hash, any(content) AS content FROM %[3]s GROUP BY hash) AS rb
+			ON rb.hash = a.request_body_hash
+		%[4]s (SELECT hash, any(content) AS content FROM %[3]s GROUP BY hash) AS sb
+			ON sb.hash = a.response_body_hash
+		%[4]s (SELECT hash, any(content) AS content FROM %[3]s GROUP BY hash) AS fb
+			ON fb.hash = a.full_response_hash
+	`, s.table("api_logs_resolved"), apiLogs, bodies, join)
+	if err := s.execDDL(ctx, resolvedView); err != nil {
+		return fmt.Errorf("failed to create api_logs_resolved view: %w", err)
+	}
+	return nil
+}
+
+// dedupBodies 将 api_logs 行中的 body 写入 bodies 表，行内只保留哈希
+func (s *ClickHouseStorage) dedupBodies(ctx context.Context, r *row) error {
+	fields := [3]string{"request_body", "response_body", "full_response"}
+	contents := make([]string, len(fields))
+	for i, f := range fields {
+		v, _ := r.get(f)
+		contents[i], _ = v.(string)
+	}
+	hashes, err := s.storeBodies(ctx, contents...)
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		r.replace(f, "")
+		r.replace(f+"_hash", hashes[i])
+	}
+	return nil
+}
+
+// storeBodies 写入尚未写入过的 body，返回各 body 对应的哈希
+func (s *ClickHouseStorage) storeBodies(ctx context.Context, contents ...string) ([]string, error) {
+	hashes := make([]string, len(contents))
+	var pending []int
+	for i, content := range contents {
+		hashes[i] = hashBody(content)
+		if hashes[i] != "" && s.bodies.markSeen(hashes[i]) {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return hashes, nil
+	}
+
+	err := func() error {
+		batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf(
+			"INSERT INTO %s (hash, content) VALUES", s.table("bodies")))
+		if err != nil {
+			return err
+		}
+		for _, i := range pending {
+			// 哈希按明文计算，相同内容加密后仍能去重
+			content := contents[i]
+			if err := s.encryptBodies(&content); err != nil {
+				batch.Abort()
+				return err
+			}
+			if err := batch.Append(hashes[i], content); err != nil {
+				batch.Abort()
+				return err
+			}
+		}
+		return batch.Send()
+	}()
+	if err != nil {
+		failed := make([]string, 0, len(pending))
+		for _, i := range pending {
+			failed = append(failed, hashes[i])
+		}
+		s.bodies.forget(failed)
+		return nil, err
+	}
+	return hashes, nil
+}
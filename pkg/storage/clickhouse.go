@@ -0,0 +1,788 @@
+// Package storage 将解析后的日志写入 ClickHouse（main 日志也可写入 VictoriaLogs），并提供查询和汇总
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+func init() {
+	Register("clickhouse", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		s, err := NewClickHouseStorage(ctx, &cfg.ClickHouse, labels)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.CreateCustomTables(ctx, cfg.CustomLogTypes); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// Labels 写入每行数据的主机和实例标识，用于区分多台代理主机的日志
+type Labels struct {
+	Host     string
+	Instance string
+}
+
+type ClickHouseStorage struct {
+	conn     driver.Conn
+	database string
+	labels   Labels
+	buffer   config.BufferTableConfig
+	// 各数据表的写入目标及列映射
+	tables map[string]*tableSchema
+	// 启用 body 去重时非 nil
+	bodies *bodyStore
+	// 启用 body 加密时非 nil
+	cipher *bodyCipher
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 启用租户路由时非 nil
+	tenants *tenantRouter
+	// 配置了独立表的自定义日志类型 -> 写入目标，由 CreateCustomTables 设置
+	custom map[parser.LogType]*tableSchema
+	// 是否生成 routing 表和 request_traces 表
+	routing bool
+	traces  bool
+	// 表已存在但缺少列时自动补列，否则启动失败
+	autoAddColumns bool
+	timeouts       config.ClickHouseTimeouts
+	// 多节点部署的集群配置，未配置集群时 Name 为空
+	cluster config.ClusterConfig
+	// 表的实际表名（带 table_prefix）
+	tableName func(name string) string
+	// 启用 batch_inserts 时非 nil，合并各文件的小批量写入
+	batcher *insertBatcher
+	// 是否为 body 列添加 JSON 类型的列
+	jsonColumns bool
+	// schema_mode 为 staged 时写入 Null 引擎的暂存表，由物化视图写入数据表
+	staged bool
+	// staged 模式下另由物化视图写入 api_logs_<类型> 表的日志类型
+	stagedTypes []string
+	// 各数据表的分区方式和排序键
+	partitioning map[string]config.PartitioningConfig
+	// 启用健康检查时非 nil
+	health *healthChecker
+}
+
+// NewClickHouseStorage 连接 ClickHouse 并创建数据库和数据表，ctx 控制连接检查和建表的超时与取消
+func NewClickHouseStorage(ctx context.Context, cfg *config.ClickHouseConfig, labels Labels) (*ClickHouseStorage, error) {
+	settings := clickhouse.Settings{
+		"max_execution_time": cfg.MaxExecutionTime,
+	}
+	if cfg.JSONColumns {
+		// ClickHouse 25.3 之前 JSON 类型为实验特性
+		settings["allow_experimental_json_type"] = 1
+	}
+	opts := &clickhouse.Options{
+		Addr: []string{fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)},
+		Auth: clickhouse.Auth{
+			Database: cfg.Database,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		},
+		Settings:        settings,
+		DialTimeout:     time.Duration(cfg.DialTimeout) * time.Second,
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.ConnMaxLifetime) * time.Second,
+	}
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ClickHouse: %w", err)
+	}
+	// 启用健康检查时连接池可在检查失败后整体替换
+	var reconnecting *reconnectingConn
+	if cfg.HealthCheck.Enabled {
+		reconnecting = newReconnectingConn(opts, conn)
+		conn = reconnecting
+	}
+
+	pingCtx, cancel := withTimeout(ctx, cfg.Timeouts.Ping)
+	defer cancel()
+	if err := conn.Ping(pingCtx); err != nil {
+		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
+	}
+
+	s := &ClickHouseStorage{
+		conn:     conn,
+		database: cfg.Database,
+		labels:   labels,
+		buffer:   cfg.Buffer,
+		tables:   buildTableSchemas(cfg),
+		routing:  cfg.RoutingTable,
+		traces:   cfg.RequestTraces,
+
+		autoAddColumns: cfg.AutoAddColumns,
+		timeouts:       cfg.Timeouts,
+		cluster:        cfg.Cluster,
+		tableName:      cfg.TableName,
+		jsonColumns:    cfg.JSONColumns,
+		staged:         cfg.SchemaMode == "staged",
+		stagedTypes:    cfg.StagedTypes,
+		partitioning:   cfg.Partitioning,
+	}
+	if cfg.BodyDedup {
+		s.bodies = newBodyStore()
+	}
+	if cfg.Encryption.Enabled {
+		if s.cipher, err = newBodyCipher(&cfg.Encryption); err != nil {
+			return nil, fmt.Errorf("failed to load body encryption key: %w", err)
+		}
+	}
+	if cfg.TenantRouting.Enabled {
+		s.tenants = newTenantRouter(&cfg.TenantRouting)
+	}
+	if len(cfg.Masking.Rules) > 0 {
+		if s.masker, err = newMasker(&cfg.Masking); err != nil {
+			return nil, err
+		}
+	}
+	if b := cfg.BatchInserts; b.Enabled {
+		s.batcher = newInsertBatcher(b.MaxRows, b.TableMaxRows, time.Duration(b.FlushInterval)*time.Second, cfg.Timeouts.Insert, s.sendBatch)
+	}
+
+	if err := s.createTables(ctx); err != nil {
+		return nil, err
+	}
+	if reconnecting != nil {
+		s.health = newHealthChecker(reconnecting, cfg.HealthCheck, cfg.Timeouts.Ping)
+	}
+
+	return s, nil
+}
+
+var (
+	// 建表语句中的分区键和排序键
+	partitionPattern = regexp.MustCompile(`PARTITION BY toYYYYMMDD\(timestamp\)`)
+	orderByPattern   = regexp.MustCompile(`(?m)^\tORDER BY .*$`)
+)
+
+// dataTableDDL 返回数据表 name 按 partitioning 配置修改分区和排序键后的建表语句，%s 为表全名
+func (s *ClickHouseStorage) dataTableDDL(name string) string {
+	ddl := tableDDL[name]
+	p := s.partitioning[name]
+	if p.PartitionBy == "monthly" {
+		ddl = partitionPattern.ReplaceAllLiteralString(ddl, "PARTITION BY toYYYYMM(timestamp)")
+	}
+	if p.OrderBy != "" {
+		// 按 dedup_key 去重的表保留该列在排序键中，否则同一排序键的不同行会被合并
+		orderBy := p.OrderBy
+		if strings.Contains(orderByPattern.FindString(ddl), "dedup_key") && !strings.Contains(orderBy, "dedup_key") {
+			orderBy += ", dedup_key"
+		}
+		// 建表语句随后作为格式串使用，转义表达式中的 %
+		ddl = orderByPattern.ReplaceAllLiteralString(ddl, "\tORDER BY ("+strings.ReplaceAll(orderBy, "%", "%%")+")")
+	}
+	return ddl
+}
+
+// tableDDL 各数据表的建表语句，%s 为表全名
+var tableDDL = map[string]string{
+	// 主日志表
+	"main_logs": `
+	CREATE TABLE IF NOT EXISTS %s (
+		timestamp DateTime64(3),
+		request_id String,
+		level LowCardinality(String),
+		source String,
+		message String,
+		status_code UInt16,
+		latency String,
+		client_ip String,
+		method LowCardinality(String),
+		path String,
+		timestamp_flag LowCardinality(String),
+		timestamp_skew_seconds Int64,
+		log_file String,
+		host LowCardinality(String),
+		instance LowCardinality(String),
+		dedup_key UInt64,
+		inserted_at DateTime64(3) DEFAULT now64(3)
+	) ENGINE = ReplacingMergeTree(inserted_at)
+	PARTITION BY toYYYYMMDD(timestamp)
+	ORDER BY (timestamp, request_id, dedup_key)
+	TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	SETTINGS non_replicated_deduplication_window = 1000
+`,
+
+	// API 请求日志表，同一请求重复写入的行（重新处理文件、未写完时写入的行）在合并时只保留最后写入的行
+	"api_logs": `
+	CREATE TABLE IF NOT EXISTS %s (
+		log_type LowCardinality(String),
+		request_id String,
+		timestamp DateTime64(3),
+		version String,
+		url String,
+		method LowCardinality(String),
+		model LowCardinality(String) DEFAULT JSONExtractString(request_body, 'model'),
+		response_model LowCardinality(String),
+		stop_reason LowCardinality(String),
+		headers Map(String, String),
+		request_body String CODEC(ZSTD(3)),
+		response_status UInt16,
+		error_type LowCardinality(String),
+		error_message String,
+		error_code LowCardinality(String),
+		error_source LowCardinality(String),
+		response_headers Map(String, String),
+		response_body String CODEC(ZSTD(3)),
+		full_response String CODEC(ZSTD(3)),
+		upstream_requests String CODEC(ZSTD(3)),
+		request_body_hash String,
+		response_body_hash String,
+		full_response_hash String,
+		ratelimit_requests_limit Nullable(UInt64),
+		ratelimit_requests_remaining Nullable(UInt64),
+		ratelimit_requests_reset Nullable(DateTime64(3)),
+		ratelimit_tokens_limit Nullable(UInt64),
+		ratelimit_tokens_remaining Nullable(UInt64),
+		ratelimit_tokens_reset Nullable(DateTime64(3)),
+		ratelimit_input_tokens_remaining Nullable(UInt64),
+		ratelimit_output_tokens_remaining Nullable(UInt64),
+		retry_after_seconds Nullable(UInt32),
+		betas Array(LowCardinality(String)),
+		multipart_parts Nested(
+			name String,
+			filename String,
+			content_type String,
+			size UInt64,
+			value String
+		),
+		tool_names Array(String),
+		tool_count UInt16,
+		mcp_servers Array(LowCardinality(String)),
+		mcp_tool_calls Array(String),
+		tool_calls Nested(
+			id String,
+			name LowCardinality(String),
+			input String
+		),
+		prefix_fingerprints Array(UInt64),
+		prefix_length UInt32,
+		incomplete UInt8,
+		truncated UInt8,
+		timestamp_flag LowCardinality(String),
+		timestamp_skew_seconds Int64,
+		input_tokens UInt64,
+		output_tokens UInt64,
+		cache_read_input_tokens UInt64,
+		cache_creation_input_tokens UInt64,
+		streamed UInt8,
+		api_key String,
+		client_app LowCardinality(String),
+		response_ms Nullable(UInt32),
+		duration_source LowCardinality(String),
+		output_tokens_per_second Nullable(Float64),
+		log_file String,
+		host LowCardinality(String),
+		instance LowCardinality(String),
+		dedup_key UInt64,
+		inserted_at DateTime64(3) DEFAULT now64(3)
+	) ENGINE = ReplacingMergeTree(inserted_at)
+	PARTITION BY toYYYYMMDD(timestamp)
+	ORDER BY (timestamp, request_id, log_type)
+	TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	SETTINGS non_replicated_deduplication_window = 1000
+`,
+
+	// 事件批量日志表
+	"event_logs": `
+	CREATE TABLE IF NOT EXISTS %s (
+		request_id String,
+		timestamp DateTime64(3),
+		event_type String,
+		event_name String,
+		session_id String,
+		model String,
+		user_type String,
+		platform String,
+		device_id String,
+		mcp_server LowCardinality(String),
+		mcp_tool String,
+		event_data String,
+		timestamp_flag LowCardinality(String),
+		timestamp_skew_seconds Int64,
+		log_file String,
+		host LowCardinality(String),
+		instance LowCardinality(String),
+		dedup_key UInt64,
+		inserted_at DateTime64(3) DEFAULT now64(3)
+	) ENGINE = ReplacingMergeTree(inserted_at)
+	PARTITION BY toYYYYMMDD(timestamp)
+	ORDER BY (timestamp, session_id, event_name, dedup_key)
+	TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	SETTINGS non_replicated_deduplication_window = 1000
+`,
+
+	// Embeddings 请求日志表（不存储响应中的向量）
+	"embedding_logs": `
+	CREATE TABLE IF NOT EXISTS %s (
+		request_id String,
+		timestamp DateTime64(3),
+		url String,
+		method LowCardinality(String),
+		model LowCardinality(String),
+		input_count UInt32,
+		prompt_tokens UInt32,
+		total_tokens UInt32,
+		embedding_count UInt32,
+		dimensions UInt32,
+		response_status UInt16,
+		headers Map(String, String),
+		request_body String CODEC(ZSTD(3)),
+		error_body String CODEC(ZSTD(3)),
+		client_app LowCardinality(String),
+		timestamp_flag LowCardinality(String),
+		timestamp_skew_seconds Int64,
+		log_file String,
+		host LowCardinality(String),
+		instance LowCardinality(String),
+		dedup_key UInt64,
+		inserted_at DateTime64(3) DEFAULT now64(3)
+	) ENGINE = ReplacingMergeTree(inserted_at)
+	PARTITION BY toYYYYMMDD(timestamp)
+	ORDER BY (timestamp, request_id, dedup_key)
+	TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	SETTINGS non_replicated_deduplication_window = 1000
+`,
+}
+
+// table 返回 cpa-logger 维护的表 name 的全名（带数据库名和 table_prefix）
+func (s *ClickHouseStorage) table(name string) string {
+	return fmt.Sprintf("%s.%s", s.database, s.tableName(name))
+}
+
+// withTimeout 为 ctx 设置 seconds 秒的超时，0 表示不设置
+func withTimeout(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+}
+
+// execDDL 执行一条 DDL 语句，受 timeouts.ddl_seconds 限制；配置了集群时在所有节点执行
+func (s *ClickHouseStorage) execDDL(ctx context.Context, query string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.DDL)
+	defer cancel()
+	return s.conn.Exec(ctx, s.clusterDDL(query))
+}
+
+// processedFilesDDL 文件处理记录表，多个主机、实例共用同一张表时按 host、instance 区分同一路径的记录
+const processedFilesDDL = `
+		CREATE TABLE IF NOT EXISTS %s (
+			file_path String,
+			file_size UInt64,
+			file_mtime DateTime64(3),
+			file_inode UInt64,
+			processed_at DateTime64(3) DEFAULT now64(3),
+			record_count UInt32,
+			host LowCardinality(String),
+			instance LowCardinality(String)
+		) ENGINE = ReplacingMergeTree(processed_at)
+		ORDER BY (file_path, host, instance)
+	`
+
+func (s *ClickHouseStorage) createTables(ctx context.Context) error {
+	// 创建数据库
+	if err := s.execDDL(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", s.database)); err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+
+	// 数据表，mapped 模式下由用户维护的表不建表
+	for _, name := range dataTables {
+		if s.tables[name].mapped {
+			continue
+		}
+		if err := s.createTable(ctx, fmt.Sprintf(s.dataTableDDL(name), s.tables[name].fullName())); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", name, err)
+		}
+	}
+
+	// 文件处理记录表（用于避免重复处理）
+	fileTrackTable := fmt.Sprintf(processedFilesDDL, s.table("processed_files"))
+	if err := s.createTable(ctx, fileTrackTable); err != nil {
+		return fmt.Errorf("failed to create processed_files table: %w", err)
+	}
+
+	// 文件处理审计表
+	if err := s.createAuditTable(ctx); err != nil {
+		return err
+	}
+
+	// 可选的关联表
+	if s.routing {
+		if err := s.createRoutingTable(ctx); err != nil {
+			return err
+		}
+	}
+	if s.traces {
+		if err := s.createTracesTable(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkEngines(ctx); err != nil {
+		return err
+	}
+
+	// 执行尚未执行的表结构迁移（如启用插入去重窗口，配合 insert_deduplication_token 避免重复写入）
+	if err := s.migrate(ctx); err != nil {
+		return err
+	}
+
+	if err := s.loadMappedSchemas(ctx); err != nil {
+		return err
+	}
+	for _, name := range dataTables {
+		if err := s.loadHeaderTypes(ctx, s.tables[name]); err != nil {
+			return err
+		}
+	}
+
+	if s.jsonColumns {
+		if err := s.addJSONColumns(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.staged {
+		if err := s.createStagingTables(ctx); err != nil {
+			return err
+		}
+	}
+
+	// 按小时、模型汇总的用量
+	if err := s.createHourlyUsage(ctx); err != nil {
+		return err
+	}
+
+	// 还原 body 的视图读取 Distributed 表，须先创建
+	if s.cluster.Distributed {
+		if err := s.createDistributedTables(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.bodies != nil {
+		if err := s.createBodiesTable(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.buffer.Enabled {
+		if err := s.createBufferTables(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createBufferTables 为数据表创建同结构的 Buffer 表
+// Buffer 表的列或阈值与数据表、配置不一致时重建（DROP 时缓冲数据会落盘），否则保留已有的表
+func (s *ClickHouseStorage) createBufferTables(ctx context.Context) error {
+	b := s.buffer
+	for _, name := range dataTables {
+		t := s.tables[name]
+		engine := fmt.Sprintf("Buffer(%s, %s, %d, %d, %d, %d, %d, %d, %d)",
+			t.database, t.table, b.NumLayers,
+			b.MinTime, b.MaxTime, b.MinRows, b.MaxRows, b.MinBytes, b.MaxBytes)
+		current, err := s.bufferTableCurrent(ctx, t, engine)
+		if err != nil {
+			return err
+		}
+		if current {
+			continue
+		}
+		if err := s.execDDL(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s_buffer", t.fullName())); err != nil {
+			return fmt.Errorf("failed to drop %s_buffer table: %w", t.table, err)
+		}
+		query := fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s_buffer AS %s
+			ENGINE = %s
+		`, t.fullName(), t.fullName(), engine)
+		if err := s.execDDL(ctx, query); err != nil {
+			return fmt.Errorf("failed to create %s_buffer table: %w", t.table, err)
+		}
+	}
+	return nil
+}
+
+// bufferTableCurrent Buffer 表是否已存在，且 engine_full 与 engine 一致、列与数据表一致
+func (s *ClickHouseStorage) bufferTableCurrent(ctx context.Context, t *tableSchema, engine string) (bool, error) {
+	var actual string
+	err := s.conn.QueryRow(ctx, "SELECT engine_full FROM system.tables WHERE database = ? AND name = ?",
+		t.database, t.table+"_buffer").Scan(&actual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s_buffer engine: %w", t.table, err)
+	}
+	// engine_full 中的数据库名和表名带引号
+	if strings.NewReplacer("'", "", "`", "", " ", "").Replace(actual) != strings.ReplaceAll(engine, " ", "") {
+		return false, nil
+	}
+	bufferColumns, err := s.tableColumns(ctx, t.fullName()+"_buffer")
+	if err != nil {
+		return false, err
+	}
+	columns, err := s.tableColumns(ctx, t.fullName())
+	if err != nil {
+		return false, err
+	}
+	return maps.Equal(bufferColumns, columns), nil
+}
+
+// insertTable 返回写入目标表，启用 Buffer 时写入对应的 Buffer 表，staged 模式下写入暂存表
+func (s *ClickHouseStorage) insertTable(table string) string {
+	if s.staged {
+		return s.tables[table].fullName() + "_ingest"
+	}
+	if s.buffer.Enabled {
+		return s.tables[table].fullName() + "_buffer"
+	}
+	return s.tables[table].fullName()
+}
+
+// InsertMainLogs 批量插入主日志
+func (s *ClickHouseStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.insertTypedRows(ctx, "main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+// InsertAPILog 插入 API 日志
+func (s *ClickHouseStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insertRequestRows(ctx, "api_logs", entry, []*row{apiLogRow(entry, logFile)})
+}
+
+// InsertEventBatch 插入事件批量日志
+func (s *ClickHouseStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.insertTypedRows(ctx, "event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+// InsertEmbeddingLog 插入 embeddings 日志，响应体仅在失败时保留
+func (s *ClickHouseStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insertRequestRows(ctx, "embedding_logs", entry.API, []*row{embeddingLogRow(entry, logFile)})
+}
+
+// MarkFileProcessed 标记文件已处理，inode 为 0 表示未知
+func (s *ClickHouseStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (file_path, file_size, file_mtime, file_inode, record_count, host, instance)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, s.table("processed_files")), filePath, uint64(fileSize), mtime, inode, recordCount, s.labels.Host, s.labels.Instance)
+}
+
+// IsFileProcessed 检查本主机的文件是否已处理
+// 旧记录（inode 为 0、host 为空）只比较路径、大小和修改时间
+func (s *ClickHouseStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.DedupQuery)
+	defer cancel()
+	var count uint64
+	err := s.conn.QueryRow(ctx, fmt.Sprintf(`
+		SELECT count() FROM %s
+		WHERE file_path = ? AND file_size = ? AND file_mtime = ?
+			AND (file_inode = ? OR file_inode = 0 OR ? = 0)
+			AND (host = ? OR host = '')
+	`, s.table("processed_files")), filePath, uint64(fileSize), mtime, inode, inode, s.labels.Host).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ProcessedFile 文件最近一次处理的记录
+type ProcessedFile struct {
+	Size        int64
+	Inode       uint64
+	RecordCount uint32
+}
+
+// LastProcessedFile 返回该路径最近一次处理的记录，没有记录时 found 为 false
+func (s *ClickHouseStorage) LastProcessedFile(ctx context.Context, filePath string) (last ProcessedFile, found bool, err error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.DedupQuery)
+	defer cancel()
+	var fileSize uint64
+	err = s.conn.QueryRow(ctx, fmt.Sprintf(`
+		SELECT file_size, file_inode, record_count FROM %s
+		WHERE file_path = ? AND (host = ? OR host = '')
+		ORDER BY processed_at DESC
+		LIMIT 1
+	`, s.table("processed_files")), filePath, s.labels.Host).Scan(&fileSize, &last.Inode, &last.RecordCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ProcessedFile{}, false, nil
+	}
+	if err != nil {
+		return ProcessedFile{}, false, err
+	}
+	last.Size = int64(fileSize)
+	return last, true, nil
+}
+
+// processedRecords 返回本主机（及旧版本未记录主机）的全部已处理记录，按处理时间排列
+func (s *ClickHouseStorage) processedRecords(ctx context.Context) ([]processedRecord, error) {
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(`
+		SELECT file_path, file_size, file_mtime, file_inode, record_count FROM %s
+		WHERE host = ? OR host = ''
+		ORDER BY processed_at
+	`, s.table("processed_files")), s.labels.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processed files: %w", err)
+	}
+	defer rows.Close()
+	var records []processedRecord
+	for rows.Next() {
+		var r processedRecord
+		var size uint64
+		if err := rows.Scan(&r.Path, &size, &r.MTime, &r.Inode, &r.RecordCount); err != nil {
+			return nil, err
+		}
+		r.Size = int64(size)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// WithLabels 返回写入时使用其他 host/instance 标识的副本，共享连接和配置（用于上传其他主机的 spool）
+func (s *ClickHouseStorage) WithLabels(labels Labels) *ClickHouseStorage {
+	c := *s
+	c.labels = labels
+	return &c
+}
+
+func (s *ClickHouseStorage) Close() error {
+	if s.health != nil {
+		s.health.close()
+	}
+	if s.batcher != nil {
+		s.batcher.close()
+	}
+	return s.conn.Close()
+}
+
+// DeleteFileRows 删除本主机、本实例从某个日志文件写入各数据表的行，等待删除完成后返回。
+// 合并写入和 Buffer 表中尚未落盘的行先写入数据表，否则会在删除之后才写入
+func (s *ClickHouseStorage) DeleteFileRows(ctx context.Context, logFile string) error {
+	if err := s.flushPending(ctx); err != nil {
+		return err
+	}
+
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 1,
+	}))
+	targets := make([]*tableSchema, 0, len(dataTables)+1)
+	for _, name := range dataTables {
+		targets = append(targets, s.tables[name])
+	}
+	// 文件的行也可能写入了其日志类型的独立表
+	if t, ok := s.custom[parser.DetermineLogType(logFile)]; ok {
+		targets = append(targets, t)
+	}
+	targets = append(targets, s.stagedTypeTables()...)
+	// 启用租户路由时请求日志可能写入了租户的表
+	if s.tenants != nil {
+		tables, err := s.tenantTables(ctx, "api_logs", "embedding_logs")
+		if err != nil {
+			return err
+		}
+		targets = append(targets, tables...)
+	}
+	for _, t := range targets {
+		col := t.column("log_file")
+		if col == "" {
+			continue
+		}
+		// 其他主机、实例可能有同一路径的文件
+		query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE `%s` = ?", t.fullName(), col)
+		args := []interface{}{logFile}
+		if col := t.column("host"); col != "" {
+			query += fmt.Sprintf(" AND `%s` = ?", col)
+			args = append(args, s.labels.Host)
+		}
+		if col := t.column("instance"); col != "" {
+			query += fmt.Sprintf(" AND `%s` = ?", col)
+			args = append(args, s.labels.Instance)
+		}
+		if err := s.conn.Exec(ctx, s.clusterDDL(query), args...); err != nil {
+			return fmt.Errorf("failed to delete rows from %s: %w", t.fullName(), err)
+		}
+	}
+	return nil
+}
+
+// flushPending 写入合并批次和 Buffer 表中尚未落盘的行，之后的 DELETE 才能作用于这些行
+func (s *ClickHouseStorage) flushPending(ctx context.Context) error {
+	if s.batcher != nil {
+		s.batcher.flushAll()
+	}
+	if s.buffer.Enabled {
+		for _, name := range dataTables {
+			if err := s.conn.Exec(ctx, fmt.Sprintf("OPTIMIZE TABLE %s_buffer", s.tables[name].fullName())); err != nil {
+				return fmt.Errorf("failed to flush %s_buffer: %w", s.tables[name].table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteIncompleteRows 删除本主机、实例写入的该文件 incomplete = 1 的请求日志行（含独立表、staged_types 和租户的表），
+// 完整的行 incomplete = 0 不受影响；不等待删除完成
+func (s *ClickHouseStorage) DeleteIncompleteRows(ctx context.Context, logFile string) error {
+	if err := s.flushPending(ctx); err != nil {
+		return err
+	}
+	targets := append([]*tableSchema{s.tables["api_logs"]}, s.stagedTypeTables()...)
+	if t, ok := s.custom[parser.DetermineLogType(logFile)]; ok {
+		targets = append(targets, t)
+	}
+	if s.tenants != nil {
+		tables, err := s.tenantTables(ctx, "api_logs")
+		if err != nil {
+			return err
+		}
+		targets = append(targets, tables...)
+	}
+	for _, t := range targets {
+		fileCol, incompleteCol := t.column("log_file"), t.column("incomplete")
+		if fileCol == "" || incompleteCol == "" {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE `%s` = ? AND `%s` = 1", t.fullName(), fileCol, incompleteCol)
+		args := []interface{}{logFile}
+		if col := t.column("host"); col != "" {
+			query += fmt.Sprintf(" AND `%s` = ?", col)
+			args = append(args, s.labels.Host)
+		}
+		if col := t.column("instance"); col != "" {
+			query += fmt.Sprintf(" AND `%s` = ?", col)
+			args = append(args, s.labels.Instance)
+		}
+		if err := s.conn.Exec(ctx, s.clusterDDL(query), args...); err != nil {
+			return fmt.Errorf("failed to delete incomplete rows from %s: %w", t.fullName(), err)
+		}
+	}
+	return nil
+}
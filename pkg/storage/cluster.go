@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// DDL 语句的对象，ON CLUSTER 插入在对象名之后
+	ddlObjectPattern = regexp.MustCompile(`^\s*(?:CREATE DATABASE IF NOT EXISTS|CREATE TABLE IF NOT EXISTS|CREATE OR REPLACE TABLE|CREATE MATERIALIZED VIEW IF NOT EXISTS|ALTER TABLE|DROP TABLE IF EXISTS)\s+[\w.]+`)
+	// MergeTree 系列引擎及其参数
+	mergeTreePattern = regexp.MustCompile(`ENGINE = (\w*)MergeTree\(([^)]*)\)`)
+)
+
+// clusterDDL 配置了集群时改写 DDL：在对象名之后加 ON CLUSTER，启用副本时将 *MergeTree 引擎改为
+// Replicated*MergeTree（Keeper 路径中的 {database}、{table} 等宏由 ClickHouse 替换）
+func (s *ClickHouseStorage) clusterDDL(query string) string {
+	c := s.cluster
+	if c.Name == "" || strings.Contains(query, " ON CLUSTER ") {
+		return query
+	}
+	loc := ddlObjectPattern.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+	query = query[:loc[1]] + " ON CLUSTER " + quoteString(c.Name) + query[loc[1]:]
+	if !c.Replicated {
+		return query
+	}
+	return mergeTreePattern.ReplaceAllStringFunc(query, func(engine string) string {
+		m := mergeTreePattern.FindStringSubmatch(engine)
+		args := quoteString(c.ZooKeeperPath) + ", " + quoteString(c.ReplicaName)
+		if m[2] != "" {
+			args += ", " + m[2]
+		}
+		return fmt.Sprintf("ENGINE = Replicated%sMergeTree(%s)", m[1], args)
+	})
+}
+
+// createDistributedTables 为数据表创建 <表>_all 的 Distributed 表，查询时汇总各分片的数据
+func (s *ClickHouseStorage) createDistributedTables(ctx context.Context) error {
+	for _, name := range dataTables {
+		t := s.tables[name]
+		if t.mapped {
+			continue
+		}
+		if err := s.syncDistributedTable(ctx, t, s.cluster.ShardingKey); err != nil {
+			return fmt.Errorf("failed to create %s_all table: %w", t.table, err)
+		}
+	}
+	return nil
+}
+
+// syncDistributedTable 创建本地表 t 的 Distributed 表 <表>_all。Distributed 表不存储数据，
+// 列与本地表不一致（本地表补齐了列）时用 CREATE OR REPLACE 原子替换，一致时不做修改
+func (s *ClickHouseStorage) syncDistributedTable(ctx context.Context, t *tableSchema, shardingKey string) error {
+	local, err := s.tableColumns(ctx, t.fullName())
+	if err != nil {
+		return err
+	}
+	current, err := s.tableColumns(ctx, t.fullName()+"_all")
+	if err != nil {
+		return err
+	}
+	create := "CREATE TABLE IF NOT EXISTS"
+	if len(current) > 0 {
+		if sameColumns(local, current) {
+			return nil
+		}
+		create = "CREATE OR REPLACE TABLE"
+	}
+	return s.execDDL(ctx, fmt.Sprintf(`
+		%s %s_all AS %s
+		ENGINE = Distributed(%s, %s, %s, %s)
+	`, create, t.fullName(), t.fullName(),
+		quoteString(s.cluster.Name), t.database, t.table, shardingKey))
+}
+
+// sameColumns 两个表的列名和类型是否都相同
+func sameColumns(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for col, typ := range a {
+		if b[col] != typ {
+			return false
+		}
+	}
+	return true
+}
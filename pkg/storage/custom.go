@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// jsonLinesDDL json_lines 类型日志的表，每行保存原始 JSON
+const jsonLinesDDL = `
+	CREATE TABLE IF NOT EXISTS %s (
+		timestamp DateTime64(3),
+		request_id String,
+		log_type LowCardinality(String),
+		data String,
+		timestamp_flag LowCardinality(String),
+		timestamp_skew_seconds Int64,
+		log_file String,
+		host LowCardinality(String),
+		instance LowCardinality(String),
+		inserted_at DateTime64(3) DEFAULT now64(3)
+	) ENGINE = MergeTree()
+	PARTITION BY toYYYYMMDD(timestamp)
+	ORDER BY (timestamp, log_type, request_id)
+	TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	SETTINGS non_replicated_deduplication_window = 1000
+`
+
+// kindTables 解析方式 -> 默认写入的数据表
+var kindTables = map[string]string{
+	"main":  "main_logs",
+	"api":   "api_logs",
+	"event": "event_logs",
+}
+
+// renamedDDL 返回数据表 table 的建表语句，表建在 database.name
+func (s *ClickHouseStorage) renamedDDL(table, database, name string) string {
+	return fmt.Sprintf(s.dataTableDDL(table), database+"."+name)
+}
+
+// CreateCustomTables 为指定了 table 的自定义日志类型建表（与默认表结构相同，json_lines 为原始 JSON 表），
+// 之后这些类型的日志写入各自的表；须在开始写入前调用
+func (s *ClickHouseStorage) CreateCustomTables(ctx context.Context, types []config.CustomLogType) error {
+	for _, t := range types {
+		if t.Table == "" {
+			continue
+		}
+		schema := &tableSchema{database: s.database, table: s.tableName(t.Table)}
+		table, ddl := "json_lines", fmt.Sprintf(jsonLinesDDL, schema.fullName())
+		if name, ok := kindTables[t.Parser]; ok {
+			table, ddl = name, s.renamedDDL(name, schema.database, schema.table)
+		}
+		if err := s.createTable(ctx, ddl); err != nil {
+			return fmt.Errorf("failed to create %s table for log type %s: %w", schema.table, t.Name, err)
+		}
+		if err := s.migrateTable(ctx, table, schema); err != nil {
+			return err
+		}
+		if err := s.loadHeaderTypes(ctx, schema); err != nil {
+			return err
+		}
+		if s.custom == nil {
+			s.custom = make(map[parser.LogType]*tableSchema)
+		}
+		s.custom[parser.LogType(t.Name)] = schema
+	}
+	return nil
+}
+
+// insertTypedRows 写入数据表 table，日志类型配置了独立的表时写入该表
+func (s *ClickHouseStorage) insertTypedRows(ctx context.Context, table string, logType parser.LogType, rows []*row) error {
+	if schema, ok := s.custom[logType]; ok {
+		return s.insertRowsInto(ctx, table, schema, schema.fullName(), rows)
+	}
+	return s.insertRows(ctx, table, rows)
+}
+
+// InsertJSONLines 写入 json_lines 类型的日志，日志类型须已通过 CreateCustomTables 建表
+func (s *ClickHouseStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	schema, ok := s.custom[logType]
+	if !ok {
+		return fmt.Errorf("no table for log type %s", logType)
+	}
+	return s.insertRowsInto(ctx, "json_lines", schema, schema.fullName(), jsonLineRows(logType, records, logFile))
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// dedupWindow 数据表保留的最近插入块数量，用于识别重复插入
+const dedupWindow = 1000
+
+// WithInsertToken 为写入附加去重令牌：同一令牌的重复插入会被 ClickHouse 丢弃。
+// 令牌须由文件和批次唯一确定，使文件写入成功但标记已处理失败后重新处理时不会产生重复数据。
+// 令牌附加主机名以区分不同主机上的同名文件。Buffer 表不支持去重，启用 buffer 时令牌无效。
+func (s *ClickHouseStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"insert_deduplication_token": s.labels.Host + ":" + token,
+	}))
+}
+
+// dedupKey 行的去重键：主机、日志文件、行在文件中的位置和 request_id 的哈希。
+// 重新处理文件（包括 main 日志追加内容后批次不同）时同一行的去重键不变，由 ReplacingMergeTree 在合并时去重
+func (s *ClickHouseStorage) dedupKey(r *row) uint64 {
+	logFile, _ := r.get("log_file")
+	requestID, _ := r.get("request_id")
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%v\x00%d\x00%v", s.labels.Host, logFile, r.offset, requestID)
+	return h.Sum64()
+}
+
+// checkEngines 旧版本创建的数据表为 MergeTree，引擎无法原地修改，重新处理文件时仍会产生重复行
+func (s *ClickHouseStorage) checkEngines(ctx context.Context) error {
+	for _, name := range dataTables {
+		t := s.tables[name]
+		if t.mapped {
+			continue
+		}
+		var engine string
+		err := s.conn.QueryRow(ctx, "SELECT engine FROM system.tables WHERE database = ? AND name = ?",
+			t.database, t.table).Scan(&engine)
+		if err != nil {
+			return fmt.Errorf("failed to read %s engine: %w", t.fullName(), err)
+		}
+		if !strings.HasSuffix(engine, "ReplacingMergeTree") {
+			log.Printf("%s uses %s, duplicate rows of re-processed files are not collapsed "+
+				"(recreate the table to use ReplacingMergeTree)", t.fullName(), engine)
+		}
+	}
+	return nil
+}
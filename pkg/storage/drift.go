@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ddlColumn 建表语句中的一列
+type ddlColumn struct {
+	name string
+	// 列类型，不含 DEFAULT 等修饰
+	typ string
+	// 完整的列定义，补列时原样用于 ADD COLUMN
+	definition string
+}
+
+var createTablePattern = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS ([\w.]+)\s*\(`)
+
+// 列定义中类型之后的修饰
+var columnModifiers = []string{" DEFAULT ", " MATERIALIZED ", " ALIAS ", " EPHEMERAL", " CODEC(", " COMMENT ", " TTL "}
+
+// createTable 执行建表语句；表已存在时对比实际列，补齐旧版本建表时缺少的列，
+// 未启用 auto_add_columns 时返回缺少的列及补列语句
+func (s *ClickHouseStorage) createTable(ctx context.Context, query string) error {
+	if err := s.execDDL(ctx, query); err != nil {
+		return err
+	}
+	table, expected := parseDDLColumns(query)
+	if table == "" {
+		return nil
+	}
+	return s.syncColumns(ctx, table, expected)
+}
+
+// parseDDLColumns 解析 CREATE TABLE 语句，返回表全名和按顺序排列的各列
+func parseDDLColumns(query string) (string, []ddlColumn) {
+	loc := createTablePattern.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return "", nil
+	}
+	table := query[loc[2]:loc[3]]
+
+	var cols []ddlColumn
+	add := func(part string) {
+		def := strings.Join(strings.Fields(part), " ")
+		name, rest, _ := strings.Cut(def, " ")
+		switch name {
+		case "", "INDEX", "PROJECTION", "CONSTRAINT":
+			return
+		}
+		typ := rest
+		for _, m := range columnModifiers {
+			if i := strings.Index(typ, m); i >= 0 {
+				typ = typ[:i]
+			}
+		}
+		cols = append(cols, ddlColumn{name: strings.Trim(name, "`"), typ: typ, definition: def})
+	}
+
+	depth, start := 0, loc[1]
+	for i := loc[1]; i < len(query); i++ {
+		switch query[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				add(query[start:i])
+				return table, cols
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				add(query[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return table, cols
+}
+
+// isNested 是否为 Nested 列，写入表中后展开为 name.field 子列
+func (c ddlColumn) isNested() bool {
+	return strings.HasPrefix(c.typ, "Nested(")
+}
+
+// lastColumn 表中该列最后一个实际列名，用于 ADD COLUMN ... AFTER
+func (c ddlColumn) lastColumn() string {
+	if !c.isNested() {
+		return c.name
+	}
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(c.typ, "Nested("), ")"), ",")
+	last, _, _ := strings.Cut(strings.TrimSpace(fields[len(fields)-1]), " ")
+	return c.name + "." + last
+}
+
+// tableColumns 读取表的实际列，列名 -> 类型
+func (s *ClickHouseStorage) tableColumns(ctx context.Context, table string) (map[string]string, error) {
+	database, name, _ := strings.Cut(table, ".")
+	rows, err := s.conn.Query(ctx,
+		"SELECT name, type FROM system.columns WHERE database = ? AND table = ?", database, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := make(map[string]string)
+	for rows.Next() {
+		var col, typ string
+		if err := rows.Scan(&col, &typ); err != nil {
+			return nil, err
+		}
+		cols[col] = typ
+	}
+	return cols, rows.Err()
+}
+
+// syncColumns 对比表的实际列与建表语句，按建表语句中的位置补齐缺少的列
+// 类型不一致的列只记录警告，不自动修改
+func (s *ClickHouseStorage) syncColumns(ctx context.Context, table string, expected []ddlColumn) error {
+	actual, err := s.tableColumns(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	var missing, alters []string
+	prev := ""
+	for _, c := range expected {
+		typ, ok := actual[c.name]
+		switch {
+		case c.isNested():
+			ok = false
+			for col := range actual {
+				if strings.HasPrefix(col, c.name+".") {
+					ok = true
+					break
+				}
+			}
+		case ok && typ == "String" && strings.HasPrefix(c.typ, "Map("):
+			// 旧版本创建的请求头列，兼容写入 JSON 字符串
+		case ok && normalizeType(typ) != normalizeType(c.typ):
+			log.Printf("Warning: column %s.%s has type %s, expected %s", table, c.name, typ, c.typ)
+		}
+		if !ok {
+			alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", table, c.definition)
+			if prev != "" {
+				alter += " AFTER " + prev
+			}
+			missing = append(missing, c.name)
+			alters = append(alters, s.clusterDDL(alter))
+		}
+		prev = c.lastColumn()
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !s.autoAddColumns {
+		return fmt.Errorf("%s is missing columns expected by this version: %s "+
+			"(clickhouse.auto_add_columns is disabled; add them with: %s)",
+			table, strings.Join(missing, ", "), strings.Join(alters, "; "))
+	}
+	for i, alter := range alters {
+		log.Printf("Adding missing column %s to %s", missing[i], table)
+		if err := s.execDDL(ctx, alter); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", missing[i], table, err)
+		}
+	}
+	return nil
+}
+
+// normalizeType 去除类型中的空白，ClickHouse 返回的类型与建表语句的写法可能只差空格
+func normalizeType(typ string) string {
+	return strings.Join(strings.Fields(typ), "")
+}
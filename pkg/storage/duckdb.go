@@ -0,0 +1,497 @@
+//go:build cgo
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/marcboeker/go-duckdb"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+func init() {
+	Register("duckdb", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewDuckDBStorage(ctx, cfg, labels)
+	})
+}
+
+// DuckDBStorage 写入本地 DuckDB 数据库文件，表名和列与 ClickHouse 相同（数组等列为 JSON 字符串），
+// 供分析人员在采集主机上直接用 DuckDB / Python 查询。DuckDB 同时只允许一个进程打开文件写入，
+// 空闲 idle_close_seconds 后关闭数据库，下次写入时重新打开；写入串行执行
+type DuckDBStorage struct {
+	path   string
+	labels Labels
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 配置了独立表的自定义日志类型 -> 表名
+	custom map[parser.LogType]string
+	idle   time.Duration
+
+	mu sync.Mutex
+	// 空闲关闭后为 nil
+	db        *sql.DB
+	idleTimer *time.Timer
+	closed    bool
+}
+
+type duckdbTokenKey struct{}
+
+// NewDuckDBStorage 打开数据库并建表
+func NewDuckDBStorage(ctx context.Context, cfg *config.Config, labels Labels) (*DuckDBStorage, error) {
+	c := cfg.Storage.DuckDB
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return nil, err
+	}
+	s := &DuckDBStorage{
+		path:   c.Path,
+		labels: labels,
+		custom: make(map[parser.LogType]string),
+		idle:   time.Duration(c.IdleCloseSeconds) * time.Second,
+	}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		var err error
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			return nil, err
+		}
+	}
+
+	schemas := sampleRows()
+	tables := make(map[string]*row)
+	for _, name := range dataTables {
+		tables[name] = schemas[name]
+	}
+	for _, t := range cfg.CustomLogTypes {
+		if t.Table == "" {
+			continue
+		}
+		s.custom[parser.LogType(t.Name)] = t.Table
+		if table, ok := kindTables[t.Parser]; ok {
+			tables[t.Table] = schemas[table]
+		} else {
+			tables[t.Table] = schemas["json_lines"]
+		}
+	}
+	err := s.with(func(db *sql.DB) error {
+		return s.createTables(ctx, db, tables)
+	})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// with 在打开的数据库上执行 fn，数据库已空闲关闭时重新打开；fn 结束后重新计算空闲时间
+func (s *DuckDBStorage) with(fn func(db *sql.DB) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("duckdb storage is closed")
+	}
+	if s.db == nil {
+		db, err := sql.Open("duckdb", s.path)
+		if err != nil {
+			return fmt.Errorf("failed to open DuckDB database: %w", err)
+		}
+		db.SetMaxOpenConns(1)
+		s.db = db
+	}
+	err := fn(s.db)
+	if s.idle > 0 {
+		if s.idleTimer == nil {
+			s.idleTimer = time.AfterFunc(s.idle, s.release)
+		} else {
+			s.idleTimer.Reset(s.idle)
+		}
+	}
+	return err
+}
+
+// release 空闲时关闭数据库，释放文件锁
+func (s *DuckDBStorage) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return
+	}
+	s.db.Close()
+	s.db = nil
+}
+
+// createTables 按示例行建表，附加 host、instance、inserted_at 列；DuckDB 按列存储并自动维护 min/max 索引，不另建索引
+func (s *DuckDBStorage) createTables(ctx context.Context, db *sql.DB, tables map[string]*row) error {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS processed_files (
+			file_path VARCHAR NOT NULL,
+			file_size BIGINT NOT NULL,
+			file_mtime VARCHAR NOT NULL,
+			file_inode UBIGINT NOT NULL,
+			record_count UBIGINT NOT NULL,
+			host VARCHAR NOT NULL,
+			instance VARCHAR NOT NULL,
+			processed_at TIMESTAMP NOT NULL
+		)`,
+		// 写入去重令牌，只保留一天
+		`CREATE TABLE IF NOT EXISTS insert_tokens (
+			table_name VARCHAR NOT NULL,
+			token VARCHAR NOT NULL,
+			inserted_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (table_name, token)
+		)`,
+	}
+	for _, name := range names {
+		var cols []string
+		for i, f := range tables[name].fields {
+			cols = append(cols, fmt.Sprintf("%q %s", f, duckdbType(tables[name].values[i])))
+		}
+		cols = append(cols, `"host" VARCHAR`, `"instance" VARCHAR`, `"inserted_at" TIMESTAMP`)
+		stmts = append(stmts, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %q (\n\t%s\n)", name, strings.Join(cols, ",\n\t")))
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create DuckDB tables: %w", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM insert_tokens WHERE inserted_at < ?",
+		time.Now().UTC().AddDate(0, 0, -1)); err != nil {
+		return fmt.Errorf("failed to expire insert tokens: %w", err)
+	}
+	for _, name := range names {
+		if err := s.addMissingColumns(ctx, db, name, tables[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns 为旧版本创建的表补齐新增的字段
+func (s *DuckDBStorage) addMissingColumns(ctx context.Context, db *sql.DB, name string, sample *row) error {
+	rows, err := db.QueryContext(ctx, "SELECT column_name FROM information_schema.columns WHERE table_name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to describe %s: %w", name, err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[col] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, f := range sample.fields {
+		if existing[f] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", name, f, duckdbType(sample.values[i]))); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", f, name, err)
+		}
+	}
+	return nil
+}
+
+// duckdbType 按字段值的 Go 类型返回 DuckDB 列类型，数组等为 JSON 字符串
+func duckdbType(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "VARCHAR"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "TIMESTAMP"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "BIGINT"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "UBIGINT"
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE"
+	}
+	return "VARCHAR"
+}
+
+// duckdbValue 转换为 DuckDB 驱动支持的值：时间为 UTC，空指针为 NULL，数组等为 JSON
+func duckdbValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	switch x := rv.Interface().(type) {
+	case time.Time:
+		return x.UTC()
+	case string:
+		return x
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return duckdbUint(rv.Uint())
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	data, _ := json.Marshal(rv.Interface())
+	return string(data)
+}
+
+// duckdbUint database/sql 不接受最高位为 1 的 uint64，超过 int64 范围的值以字符串传入，由 DuckDB 转换为 UBIGINT
+func duckdbUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return strconv.FormatUint(v, 10)
+	}
+	return int64(v)
+}
+
+// WithInsertToken 记录去重令牌：同一表中已写入过的令牌再次写入时跳过
+func (s *DuckDBStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, duckdbTokenKey{}, s.labels.Host+":"+token)
+}
+
+// insert 在一个事务中写入数据表 table 的行，日志类型配置了独立的表时写入该表
+func (s *DuckDBStorage) insert(ctx context.Context, table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	target := table
+	if t, ok := s.custom[logType]; ok {
+		target = t
+	}
+
+	now := time.Now().UTC()
+	for _, r := range rows {
+		r.set("host", s.labels.Host)
+		r.set("instance", s.labels.Instance)
+		r.set("inserted_at", now)
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+	}
+	cols := make([]string, len(rows[0].fields))
+	for i, f := range rows[0].fields {
+		cols[i] = fmt.Sprintf("%q", f)
+	}
+	query := fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)",
+		target, strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+
+	return s.with(func(db *sql.DB) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if token, ok := ctx.Value(duckdbTokenKey{}).(string); ok {
+			res, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO insert_tokens (table_name, token, inserted_at) VALUES (?, ?, ?)",
+				target, token, now)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return nil
+			}
+		}
+
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert into %s: %w", target, err)
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			args := make([]interface{}, len(r.values))
+			for i, v := range r.values {
+				args[i] = duckdbValue(v)
+			}
+			if _, err := stmt.ExecContext(ctx, args...); err != nil {
+				return fmt.Errorf("failed to insert into %s: %w", target, err)
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+func (s *DuckDBStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.insert(ctx, "main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+func (s *DuckDBStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insert(ctx, "api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+func (s *DuckDBStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insert(ctx, "embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+func (s *DuckDBStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.insert(ctx, "event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+// InsertJSONLines 写入 json_lines 类型的日志，日志类型须配置了 table
+func (s *DuckDBStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if _, ok := s.custom[logType]; !ok {
+		return fmt.Errorf("no table for log type %s", logType)
+	}
+	return s.insert(ctx, "json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+// MarkFileProcessed 标记文件已处理，inode 为 0 表示未知
+func (s *DuckDBStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	return s.with(func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO processed_files (file_path, file_size, file_mtime, file_inode, record_count, host, instance, processed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, filePath, fileSize, mtime.UTC().Format(time.RFC3339Nano), duckdbUint(inode), int64(recordCount),
+			s.labels.Host, s.labels.Instance, time.Now().UTC())
+		return err
+	})
+}
+
+// IsFileProcessed 与 ClickHouseStorage 相同：路径、大小和修改时间一致，inode 一致或未知
+func (s *DuckDBStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	query := `
+		SELECT count(*) FROM processed_files
+		WHERE file_path = ? AND file_size = ? AND file_mtime = ? AND (host = ? OR host = '')`
+	args := []interface{}{filePath, fileSize, mtime.UTC().Format(time.RFC3339Nano), s.labels.Host}
+	if inode != 0 {
+		query += " AND (file_inode = CAST(? AS UBIGINT) OR file_inode = 0)"
+		args = append(args, strconv.FormatUint(inode, 10))
+	}
+	var count int64
+	err := s.with(func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, args...).Scan(&count)
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *DuckDBStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	var last ProcessedFile
+	var recordCount uint64
+	err := s.with(func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, `
+			SELECT file_size, file_inode, record_count FROM processed_files
+			WHERE file_path = ? AND (host = ? OR host = '')
+			ORDER BY processed_at DESC, rowid DESC
+			LIMIT 1
+		`, filePath, s.labels.Host).Scan(&last.Size, &last.Inode, &recordCount)
+	})
+	if err == sql.ErrNoRows {
+		return ProcessedFile{}, false, nil
+	}
+	if err != nil {
+		return ProcessedFile{}, false, err
+	}
+	last.RecordCount = uint32(recordCount)
+	return last, true, nil
+}
+
+// tables 所有数据表，包括自定义日志类型的表
+func (s *DuckDBStorage) tables() []string {
+	tables := append([]string{}, dataTables...)
+	for _, t := range s.custom {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// DeleteFileRows 删除某个日志文件已写入各数据表的行
+func (s *DuckDBStorage) DeleteFileRows(ctx context.Context, logFile string) error {
+	return s.with(func(db *sql.DB) error {
+		for _, t := range s.tables() {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %q WHERE log_file = ?", t), logFile); err != nil {
+				return fmt.Errorf("failed to delete rows from %s: %w", t, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RequestLogFiles 返回包含该请求的 API、embeddings 和事件日志文件
+func (s *DuckDBStorage) RequestLogFiles(ctx context.Context, requestID string) ([]string, error) {
+	var files []string
+	err := s.with(func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `
+			SELECT log_file FROM api_logs WHERE request_id = ?
+			UNION SELECT log_file FROM embedding_logs WHERE request_id = ?
+			UNION SELECT log_file FROM event_logs WHERE request_id = ?
+			ORDER BY 1
+		`, requestID, requestID, requestID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var f string
+			if err := rows.Scan(&f); err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+		return rows.Err()
+	})
+	return files, err
+}
+
+func (s *DuckDBStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
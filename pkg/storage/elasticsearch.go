@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// esTextFields 内置索引模板中按全文索引的字段，其余字符串字段为 keyword
+var esTextFields = []string{"message", "request_body", "response_body", "full_response", "error_body", "data"}
+
+type esTokenKey struct{}
+
+func init() {
+	Register("elasticsearch", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewElasticsearchStorage(ctx, cfg, labels)
+	})
+}
+
+// ElasticsearchStorage 通过 _bulk 接口将各表的行写入 Elasticsearch / OpenSearch 的按天索引，
+// 每行一个文档，字段与 ClickHouse 表的列相同
+type ElasticsearchStorage struct {
+	client *http.Client
+	base   string
+	cfg    *config.ElasticsearchConfig
+	labels Labels
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 配置了独立表的自定义日志类型 -> 表名
+	custom map[parser.LogType]string
+}
+
+// NewElasticsearchStorage 检查集群可达，创建 ILM 策略和各表的索引模板
+func NewElasticsearchStorage(ctx context.Context, cfg *config.Config, labels Labels) (*ElasticsearchStorage, error) {
+	es := &cfg.Storage.Elasticsearch
+	if _, err := url.Parse(es.URL); err != nil {
+		return nil, fmt.Errorf("invalid storage.elasticsearch.url: %w", err)
+	}
+	s := &ElasticsearchStorage{
+		client: &http.Client{Timeout: time.Duration(es.TimeoutSeconds) * time.Second},
+		base:   strings.TrimRight(es.URL, "/"),
+		cfg:    es,
+		labels: labels,
+		custom: make(map[parser.LogType]string),
+	}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		var err error
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := append([]string{}, dataTables...)
+	for _, t := range cfg.CustomLogTypes {
+		if t.Table != "" {
+			s.custom[parser.LogType(t.Name)] = t.Table
+			tables = append(tables, t.Table)
+		}
+	}
+
+	if err := s.do(ctx, http.MethodGet, "/", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to Elasticsearch: %w", err)
+	}
+	if err := s.putILMPolicy(ctx); err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if err := s.putIndexTemplate(ctx, table, s.cfg.IndexPrefix+"-"+table+"-*"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.putIndexTemplate(ctx, "processed_files", s.processedIndex()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// putILMPolicy 创建或更新 ILM 策略，未配置策略文件和删除天数时跳过（使用集群中已有的策略）
+func (s *ElasticsearchStorage) putILMPolicy(ctx context.Context) error {
+	var body []byte
+	switch {
+	case s.cfg.ILMPolicyFile != "":
+		data, err := os.ReadFile(s.cfg.ILMPolicyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ILM policy: %w", err)
+		}
+		body = data
+	case s.cfg.ILMDeleteDays > 0:
+		body, _ = json.Marshal(map[string]interface{}{
+			"policy": map[string]interface{}{
+				"phases": map[string]interface{}{
+					"hot": map[string]interface{}{"actions": map[string]interface{}{}},
+					"delete": map[string]interface{}{
+						"min_age": fmt.Sprintf("%dd", s.cfg.ILMDeleteDays),
+						"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+					},
+				},
+			},
+		})
+	default:
+		return nil
+	}
+	if err := s.do(ctx, http.MethodPut, "/_ilm/policy/"+url.PathEscape(s.cfg.ILMPolicy), body, nil); err != nil {
+		return fmt.Errorf("failed to put ILM policy %s: %w", s.cfg.ILMPolicy, err)
+	}
+	return nil
+}
+
+// putIndexTemplate 创建或更新表的索引模板，templates 中配置了文件时使用文件内容
+func (s *ElasticsearchStorage) putIndexTemplate(ctx context.Context, table, pattern string) error {
+	var body []byte
+	if file, ok := s.cfg.Templates[table]; ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read index template for %s: %w", table, err)
+		}
+		body = data
+	} else {
+		body = s.defaultTemplate(pattern)
+	}
+	name := s.cfg.IndexPrefix + "-" + table
+	if err := s.do(ctx, http.MethodPut, "/_index_template/"+url.PathEscape(name), body, nil); err != nil {
+		return fmt.Errorf("failed to put index template %s: %w", name, err)
+	}
+	return nil
+}
+
+// defaultTemplate 内置索引模板：timestamp 为日期，body 和消息为全文索引，其余字符串为 keyword
+func (s *ElasticsearchStorage) defaultTemplate(pattern string) []byte {
+	properties := map[string]interface{}{
+		"timestamp":    map[string]interface{}{"type": "date"},
+		"processed_at": map[string]interface{}{"type": "date"},
+		"file_mtime":   map[string]interface{}{"type": "date"},
+	}
+	for _, f := range esTextFields {
+		properties[f] = map[string]interface{}{"type": "text"}
+	}
+	settings := map[string]interface{}{}
+	if s.cfg.ILMPolicy != "" && pattern != s.processedIndex() {
+		settings["index.lifecycle.name"] = s.cfg.ILMPolicy
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{pattern},
+		"priority":       100,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match_mapping_type": "string",
+							"mapping":            map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+						},
+					},
+				},
+				"properties": properties,
+			},
+		},
+	})
+	return body
+}
+
+// processedIndex 已处理文件记录的索引（不按天拆分）
+func (s *ElasticsearchStorage) processedIndex() string {
+	return s.cfg.IndexPrefix + "-processed_files"
+}
+
+// do 发送请求，非 2xx 响应返回错误；out 非 nil 时解析响应 JSON
+func (s *ElasticsearchStorage) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	return s.doType(ctx, method, path, "application/json", body, out)
+}
+
+func (s *ElasticsearchStorage) doType(ctx context.Context, method, path, contentType string, body []byte, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Elasticsearch returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// WithInsertToken 记录去重令牌：同一令牌写入的文档 ID 相同，重复写入被跳过
+func (s *ElasticsearchStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, esTokenKey{}, s.labels.Host+":"+token)
+}
+
+// bulk 将数据表 table 的行写入按天索引（按各行的 timestamp 取 UTC 日期），
+// 日志类型配置了独立的表时写入该表的索引
+func (s *ElasticsearchStorage) bulk(ctx context.Context, table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	token, _ := ctx.Value(esTokenKey{}).(string)
+	target := table
+	if t, ok := s.custom[logType]; ok {
+		target = t
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for i, r := range rows {
+		r.set("host", s.labels.Host)
+		r.set("instance", s.labels.Instance)
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+		doc := make(map[string]interface{}, len(r.fields))
+		for j, f := range r.fields {
+			doc[f] = r.values[j]
+		}
+		ts, _ := doc["timestamp"].(time.Time)
+		meta := map[string]interface{}{
+			"_index": fmt.Sprintf("%s-%s-%s", s.cfg.IndexPrefix, target, ts.UTC().Format("2006.01.02")),
+		}
+		op := "index"
+		if token != "" {
+			// 令牌和行号确定文档 ID，create 遇到已存在的文档时返回 409
+			op = "create"
+			sum := sha1.Sum([]byte(fmt.Sprintf("%s:%s:%d", token, target, i)))
+			meta["_id"] = hex.EncodeToString(sum[:])
+		}
+		if err := enc.Encode(map[string]interface{}{op: meta}); err != nil {
+			return err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := s.doType(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("failed to bulk index into %s: %w", target, err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status/100 != 2 && result.Status != http.StatusConflict {
+				return fmt.Errorf("failed to index into %s: status %d: %s", target, result.Status, result.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// InsertMainLogs 批量写入主日志
+func (s *ElasticsearchStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.bulk(ctx, "main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+// InsertAPILog 写入 API 日志
+func (s *ElasticsearchStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.bulk(ctx, "api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+// InsertEmbeddingLog 写入 embeddings 日志
+func (s *ElasticsearchStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.bulk(ctx, "embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+// InsertEventBatch 写入事件批量日志
+func (s *ElasticsearchStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.bulk(ctx, "event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+// InsertJSONLines 写入 json_lines 类型的日志，日志类型须配置了 table
+func (s *ElasticsearchStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if _, ok := s.custom[logType]; !ok {
+		return fmt.Errorf("no table for log type %s", logType)
+	}
+	return s.bulk(ctx, "json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+// processedID 已处理文件记录的文档 ID，同一主机同一版本的文件只有一条记录
+func (s *ElasticsearchStorage) processedID(filePath string, fileSize int64, mtime time.Time, inode uint64) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d", s.labels.Host, filePath, fileSize, mtime.UnixNano(), inode)))
+	return hex.EncodeToString(sum[:])
+}
+
+// MarkFileProcessed 标记文件已处理，等待记录可被搜索后返回
+func (s *ElasticsearchStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	doc, _ := json.Marshal(map[string]interface{}{
+		"file_path":    filePath,
+		"file_size":    fileSize,
+		"file_mtime":   mtime,
+		"file_inode":   inode,
+		"record_count": recordCount,
+		"processed_at": time.Now(),
+		"host":         s.labels.Host,
+		"instance":     s.labels.Instance,
+	})
+	path := fmt.Sprintf("/%s/_doc/%s?refresh=wait_for", s.processedIndex(), s.processedID(filePath, fileSize, mtime, inode))
+	if err := s.do(ctx, http.MethodPut, path, doc, nil); err != nil {
+		return fmt.Errorf("failed to mark %s processed: %w", filePath, err)
+	}
+	return nil
+}
+
+// esHits 搜索结果中的文档
+type esHits struct {
+	Hits struct {
+		Hits []struct {
+			Source json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// searchProcessed 按条件搜索本主机的已处理文件记录，索引不存在时视为没有记录
+func (s *ElasticsearchStorage) searchProcessed(ctx context.Context, filters []interface{}, out *esHits) error {
+	filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"host": s.labels.Host}})
+	query, _ := json.Marshal(map[string]interface{}{
+		"size":  1,
+		"sort":  []interface{}{map[string]interface{}{"processed_at": "desc"}},
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+	})
+	return s.do(ctx, http.MethodPost, "/"+s.processedIndex()+"/_search?ignore_unavailable=true", query, out)
+}
+
+// IsFileProcessed 检查本主机的文件是否已处理，inode 为 0 表示未知，此时不比较 inode
+func (s *ElasticsearchStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"file_path": filePath}},
+		map[string]interface{}{"term": map[string]interface{}{"file_size": fileSize}},
+		map[string]interface{}{"term": map[string]interface{}{"file_mtime": mtime.Format(time.RFC3339Nano)}},
+	}
+	if inode != 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"file_inode": []uint64{inode, 0}}})
+	}
+	var hits esHits
+	if err := s.searchProcessed(ctx, filters, &hits); err != nil {
+		return false, err
+	}
+	return len(hits.Hits.Hits) > 0, nil
+}
+
+// LastProcessedFile 返回该路径最近一次处理的记录，没有记录时 found 为 false
+func (s *ElasticsearchStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	var hits esHits
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"file_path": filePath}},
+	}
+	if err := s.searchProcessed(ctx, filters, &hits); err != nil {
+		return ProcessedFile{}, false, err
+	}
+	if len(hits.Hits.Hits) == 0 {
+		return ProcessedFile{}, false, nil
+	}
+	var rec struct {
+		FileSize    int64  `json:"file_size"`
+		FileInode   uint64 `json:"file_inode"`
+		RecordCount uint32 `json:"record_count"`
+	}
+	if err := json.Unmarshal(hits.Hits.Hits[0].Source, &rec); err != nil {
+		return ProcessedFile{}, false, err
+	}
+	return ProcessedFile{Size: rec.FileSize, Inode: rec.FileInode, RecordCount: rec.RecordCount}, true, nil
+}
+
+// dataIndices 所有数据表的按天索引
+func (s *ElasticsearchStorage) dataIndices() string {
+	tables := append([]string{}, dataTables...)
+	for _, t := range s.custom {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	patterns := make([]string, len(tables))
+	for i, t := range tables {
+		patterns[i] = s.cfg.IndexPrefix + "-" + t + "-*"
+	}
+	return strings.Join(patterns, ",")
+}
+
+// DeleteFileRows 通过 _delete_by_query 删除某个日志文件已写入的文档，等待删除完成后返回
+func (s *ElasticsearchStorage) DeleteFileRows(ctx context.Context, logFile string) error {
+	query, _ := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"log_file": logFile}},
+	})
+	var resp struct {
+		Deleted  int64             `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	path := "/" + s.dataIndices() + "/_delete_by_query?refresh=true&conflicts=proceed&ignore_unavailable=true&allow_no_indices=true"
+	if err := s.do(ctx, http.MethodPost, path, query, &resp); err != nil {
+		return fmt.Errorf("failed to delete rows of %s: %w", logFile, err)
+	}
+	if len(resp.Failures) > 0 {
+		return fmt.Errorf("failed to delete rows of %s: %s", logFile, resp.Failures[0])
+	}
+	log.Printf("Deleted %d Elasticsearch documents of %s", resp.Deleted, logFile)
+	return nil
+}
+
+// RequestLogFiles 返回包含该请求的日志文件
+func (s *ElasticsearchStorage) RequestLogFiles(ctx context.Context, requestID string) ([]string, error) {
+	query, _ := json.Marshal(map[string]interface{}{
+		"size":  0,
+		"query": map[string]interface{}{"term": map[string]interface{}{"request_id": requestID}},
+		"aggs": map[string]interface{}{
+			"files": map[string]interface{}{"terms": map[string]interface{}{"field": "log_file", "size": 1000}},
+		},
+	})
+	var resp struct {
+		Aggregations struct {
+			Files struct {
+				Buckets []struct {
+					Key string `json:"key"`
+				} `json:"buckets"`
+			} `json:"files"`
+		} `json:"aggregations"`
+	}
+	path := "/" + s.dataIndices() + "/_search?ignore_unavailable=true&allow_no_indices=true"
+	if err := s.do(ctx, http.MethodPost, path, query, &resp); err != nil {
+		return nil, fmt.Errorf("failed to find log files of request %s: %w", requestID, err)
+	}
+	files := make([]string, 0, len(resp.Aggregations.Files.Buckets))
+	for _, b := range resp.Aggregations.Files.Buckets {
+		files = append(files, b.Key)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *ElasticsearchStorage) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// 加密后的 body 格式为 enc:v1:<key_id>:<base64(nonce + 密文)>，不带前缀的值按明文处理
+const encryptedPrefix = "enc:v1:"
+
+// bodyFields 各数据表中需要加密的 body 字段
+var bodyFields = map[string][]string{
+	"api_logs":       {"request_body", "response_body", "full_response"},
+	"embedding_logs": {"request_body", "error_body"},
+}
+
+// bodyCipher 使用 AES-256-GCM 加解密 body 列
+type bodyCipher struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+func newBodyCipher(cfg *config.EncryptionConfig) (*bodyCipher, error) {
+	key, err := loadEncryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes (AES-256), got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &bodyCipher{keyID: cfg.KeyID, aead: aead}, nil
+}
+
+// loadEncryptionKey 从环境变量、文件或命令输出读取 base64 编码的密钥
+func loadEncryptionKey(cfg *config.EncryptionConfig) ([]byte, error) {
+	var encoded string
+	switch {
+	case cfg.KeyEnv != "":
+		encoded = os.Getenv(cfg.KeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("encryption key environment variable %s is not set", cfg.KeyEnv)
+		}
+	case cfg.KeyFile != "":
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		encoded = string(data)
+	case cfg.KeyCommand != "":
+		// 由外部命令（如 KMS、Vault 客户端）解密并输出密钥，密钥不落盘
+		out, err := exec.Command("sh", "-c", cfg.KeyCommand).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run encryption key command: %w", err)
+		}
+		encoded = string(out)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+// encrypt 加密 body，空字符串保持为空
+func (c *bodyCipher) encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + c.keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt 解密 body；启用加密前写入的明文原样返回
+func (c *bodyCipher) decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted body")
+	}
+	if keyID != c.keyID {
+		return "", fmt.Errorf("body encrypted with unknown key %q", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted body: %w", err)
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("malformed encrypted body")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt body with key %q: %w", keyID, err)
+	}
+	return string(plaintext), nil
+}
+
+// encryptBodies 启用加密时原地加密各 body
+func (s *ClickHouseStorage) encryptBodies(bodies ...*string) error {
+	if s.cipher == nil {
+		return nil
+	}
+	for _, b := range bodies {
+		v, err := s.cipher.encrypt(*b)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt body: %w", err)
+		}
+		*b = v
+	}
+	return nil
+}
+
+// encryptRow 启用加密时加密待写入行中的 body 字段
+func (s *ClickHouseStorage) encryptRow(table string, r *row) error {
+	if s.cipher == nil {
+		return nil
+	}
+	for _, f := range bodyFields[table] {
+		v, _ := r.get(f)
+		body, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if err := s.encryptBodies(&body); err != nil {
+			return err
+		}
+		r.replace(f, body)
+	}
+	return nil
+}
+
+// decryptBodies 启用加密时原地解密各 body；未启用时加密的值原样返回
+func (s *ClickHouseStorage) decryptBodies(bodies ...*string) error {
+	if s.cipher == nil {
+		return nil
+	}
+	for _, b := range bodies {
+		v, err := s.cipher.decrypt(*b)
+		if err != nil {
+			return err
+		}
+		*b = v
+	}
+	return nil
+}
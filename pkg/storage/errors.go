@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// resourceErrorCodes ClickHouse 资源不足时返回的错误码，稍后重试通常可以成功
+var resourceErrorCodes = map[int32]bool{
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	252: true, // TOO_MANY_PARTS
+}
+
+// IsResourceError 是否为 ClickHouse 资源不足（part 过多、内存超限、并发查询过多）导致的错误
+func IsResourceError(err error) bool {
+	var ex *clickhouse.Exception
+	return errors.As(err, &ex) && resourceErrorCodes[ex.Code]
+}
+
+// transientErrorCodes ClickHouse 临时故障的错误码，重试同一批次通常可以成功
+var transientErrorCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	242: true, // TABLE_IS_READ_ONLY（副本与 Keeper 断开）
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	999: true, // KEEPER_EXCEPTION
+}
+
+// IsTransientError 是否为连接中断、超时、副本只读等临时错误，不含资源不足的错误（由背压处理）
+// 和 ctx 取消；写入带去重令牌，重试已写入的批次不会产生重复数据
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ex *clickhouse.Exception
+	if errors.As(err, &ex) {
+		return transientErrorCodes[ex.Code]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
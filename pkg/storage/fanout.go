@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 附加写入目标的指标，键为 <名称>_written / _failed / _dropped / _dead_letter / _depth，通过 /debug/vars 暴露
+var sinkStats = expvar.NewMap("sinks")
+
+type fanoutTokenKey struct{}
+
+// sinkWrite 待写入附加目标的一个批次
+type sinkWrite struct {
+	logFile string
+	// 去重令牌，重试时写入同一令牌
+	token string
+	write func(ctx context.Context, s Storage) error
+}
+
+// fanoutSink 一个附加目标及其写入队列
+type fanoutSink struct {
+	name     string
+	store    Storage
+	cfg      config.SinkConfig
+	logTypes map[parser.LogType]bool
+	queue    chan sinkWrite
+	// 丢弃的批次写入的死信目录，未配置 dead_letter_dir 时为 nil
+	deadLetter *SpoolStorage
+	// 关闭时 close，之后失败的批次不再重试
+	stop chan struct{}
+	done chan struct{}
+}
+
+// fanoutStorage 同步写入主存储，成功后将同样的日志放入各附加目标的队列，由各目标的 goroutine 异步写入；
+// 已处理文件、关联、重放队列等只使用主存储
+type fanoutStorage struct {
+	Storage
+	sinks []*fanoutSink
+}
+
+// FanOut 按 storage.sinks 创建附加目标，各目标使用合并了 options 的存储配置，
+// 返回同时写入主存储和各目标的存储；未配置时返回 primary。创建失败时关闭已创建的目标，不关闭 primary
+func FanOut(ctx context.Context, cfg *config.Config, labels Labels, primary Storage) (Storage, error) {
+	if len(cfg.Storage.Sinks) == 0 {
+		return primary, nil
+	}
+	f := &fanoutStorage{Storage: primary}
+	for _, sc := range cfg.Storage.Sinks {
+		backendsMu.Lock()
+		factory, ok := backends[sc.Type]
+		backendsMu.Unlock()
+		if !ok {
+			f.closeSinks()
+			return nil, fmt.Errorf("unknown storage sink: %s", sc.Type)
+		}
+		sinkCfg := *cfg
+		sinkCfg.Storage = sc.Storage
+		store, err := factory(ctx, &sinkCfg, labels)
+		if err != nil {
+			f.closeSinks()
+			return nil, fmt.Errorf("failed to open %s sink: %w", sc.Name, err)
+		}
+		sink := &fanoutSink{
+			name:  sc.Name,
+			store: store,
+			cfg:   sc,
+			queue: make(chan sinkWrite, sc.QueueSize),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		if len(sc.LogTypes) > 0 {
+			sink.logTypes = make(map[parser.LogType]bool)
+			for _, t := range sc.LogTypes {
+				sink.logTypes[parser.LogType(t)] = true
+			}
+		}
+		if sc.DeadLetterDir != "" {
+			if sink.deadLetter, err = NewSpoolStorage(sc.DeadLetterDir, labels); err != nil {
+				store.Close()
+				f.closeSinks()
+				return nil, fmt.Errorf("failed to open dead letter directory of %s sink: %w", sc.Name, err)
+			}
+		}
+		sinkStats.Set(sink.name+"_depth", expvar.Func(func() interface{} { return len(sink.queue) }))
+		f.sinks = append(f.sinks, sink)
+		go sink.run()
+	}
+	return f, nil
+}
+
+// run 先重新写入死信目录中以前丢弃的批次，再按顺序写入队列中的批次，
+// 失败时按 1s、2s、4s... 退避重试（最长 1 分钟）
+func (s *fanoutSink) run() {
+	defer close(s.done)
+	if s.deadLetter != nil {
+		s.replayDeadLetters()
+	}
+	for w := range s.queue {
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			ctx := context.Background()
+			if w.token != "" {
+				ctx = WithInsertToken(ctx, s.store, w.token)
+			}
+			err := w.write(ctx, s.store)
+			if err == nil {
+				sinkStats.Add(s.name+"_written", 1)
+				break
+			}
+			if attempt < s.cfg.MaxRetries {
+				log.Printf("Error writing %s to %s sink (retrying in %s): %v", filepath.Base(w.logFile), s.name, backoff, err)
+				select {
+				case <-time.After(backoff):
+					if backoff < time.Minute {
+						backoff *= 2
+					}
+					continue
+				case <-s.stop:
+				}
+			}
+			sinkStats.Add(s.name+"_failed", 1)
+			log.Printf("Error writing %s to %s sink: %v", filepath.Base(w.logFile), s.name, err)
+			s.drop(w)
+			break
+		}
+	}
+}
+
+// drop 丢弃一个批次：配置了死信目录时写入该目录，否则只计数
+func (s *fanoutSink) drop(w sinkWrite) {
+	if s.deadLetter == nil {
+		sinkStats.Add(s.name+"_dropped", 1)
+		log.Printf("Warning: dropped batch from %s for %s sink", filepath.Base(w.logFile), s.name)
+		return
+	}
+	ctx := context.Background()
+	if w.token != "" {
+		ctx = s.deadLetter.WithInsertToken(ctx, w.token)
+	}
+	if err := w.write(ctx, s.deadLetter); err != nil {
+		sinkStats.Add(s.name+"_dropped", 1)
+		log.Printf("Error writing %s to dead letter directory of %s sink, dropping batch: %v", filepath.Base(w.logFile), s.name, err)
+		return
+	}
+	sinkStats.Add(s.name+"_dead_letter", 1)
+}
+
+// replayDeadLetters 按写入顺序将死信目录中的压缩包写入附加目标，成功后删除；
+// 遇到失败时停止，剩余的压缩包在下次启动时重新写入
+func (s *fanoutSink) replayDeadLetters() {
+	names, err := spoolBundles(s.deadLetter.dir)
+	if err != nil {
+		log.Printf("Error listing dead letters of %s sink: %v", s.name, err)
+		return
+	}
+	for i, name := range names {
+		path := filepath.Join(s.deadLetter.dir, name)
+		b, err := readBundle(path)
+		if err == nil {
+			err = insertBundle(context.Background(), s.store, b)
+		}
+		if err != nil {
+			log.Printf("Error replaying dead letter %s to %s sink, %d left: %v", name, s.name, len(names)-i, err)
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error removing dead letter %s: %v", name, err)
+		}
+		sinkStats.Add(s.name+"_written", 1)
+	}
+	if len(names) > 0 {
+		log.Printf("Replayed %d dead letters to %s sink", len(names), s.name)
+	}
+}
+
+// enqueue 日志类型匹配时放入队列，队列满时丢弃，不阻塞主存储的写入
+func (s *fanoutSink) enqueue(logType parser.LogType, w sinkWrite) {
+	if s.logTypes != nil && !s.logTypes[logType] {
+		return
+	}
+	select {
+	case s.queue <- w:
+	default:
+		log.Printf("Warning: %s sink queue is full, dropping batch from %s", s.name, filepath.Base(w.logFile))
+		s.drop(w)
+	}
+}
+
+func (f *fanoutStorage) dispatch(ctx context.Context, logType parser.LogType, logFile string, write func(ctx context.Context, s Storage) error) {
+	token, _ := ctx.Value(fanoutTokenKey{}).(string)
+	for _, sink := range f.sinks {
+		sink.enqueue(logType, sinkWrite{logFile: logFile, token: token, write: write})
+	}
+}
+
+// WithInsertToken 为主存储设置令牌，并记录原始令牌供附加目标写入时使用
+func (f *fanoutStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(WithInsertToken(ctx, f.Storage, token), fanoutTokenKey{}, token)
+}
+
+// Unwrap 可选能力使用主存储的实现
+func (f *fanoutStorage) Unwrap() Storage {
+	return f.Storage
+}
+
+func (f *fanoutStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	if err := f.Storage.InsertMainLogs(ctx, entries, logFile); err != nil {
+		return err
+	}
+	f.dispatch(ctx, parser.DetermineLogType(logFile), logFile, func(ctx context.Context, s Storage) error {
+		return s.InsertMainLogs(ctx, entries, logFile)
+	})
+	return nil
+}
+
+func (f *fanoutStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if err := f.Storage.InsertAPILog(ctx, entry, logFile); err != nil {
+		return err
+	}
+	if entry != nil {
+		f.dispatch(ctx, entry.LogType, logFile, func(ctx context.Context, s Storage) error {
+			return s.InsertAPILog(ctx, entry, logFile)
+		})
+	}
+	return nil
+}
+
+func (f *fanoutStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if err := f.Storage.InsertEmbeddingLog(ctx, entry, logFile); err != nil {
+		return err
+	}
+	if entry != nil {
+		f.dispatch(ctx, entry.API.LogType, logFile, func(ctx context.Context, s Storage) error {
+			return s.InsertEmbeddingLog(ctx, entry, logFile)
+		})
+	}
+	return nil
+}
+
+func (f *fanoutStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if err := f.Storage.InsertEventBatch(ctx, entry, logFile); err != nil {
+		return err
+	}
+	f.dispatch(ctx, parser.DetermineLogType(logFile), logFile, func(ctx context.Context, s Storage) error {
+		return s.InsertEventBatch(ctx, entry, logFile)
+	})
+	return nil
+}
+
+func (f *fanoutStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if err := f.Storage.InsertJSONLines(ctx, logType, records, logFile); err != nil {
+		return err
+	}
+	f.dispatch(ctx, logType, logFile, func(ctx context.Context, s Storage) error {
+		return s.InsertJSONLines(ctx, logType, records, logFile)
+	})
+	return nil
+}
+
+// closeSinks 写完各队列中剩余的批次（不再重试）后关闭附加目标
+func (f *fanoutStorage) closeSinks() error {
+	var err error
+	for _, sink := range f.sinks {
+		close(sink.stop)
+		close(sink.queue)
+	}
+	for _, sink := range f.sinks {
+		<-sink.done
+		if sink.deadLetter != nil {
+			sink.deadLetter.Close()
+		}
+		if cerr := sink.store.Close(); cerr != nil {
+			log.Printf("Error closing %s sink: %v", sink.name, cerr)
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Close 先关闭附加目标，再关闭主存储
+func (f *fanoutStorage) Close() error {
+	err := f.closeSinks()
+	if perr := f.Storage.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
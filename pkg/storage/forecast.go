@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DailyVolume 某天某个模型的客户端请求量
+type DailyVolume struct {
+	Day      time.Time
+	Model    string
+	Requests uint64
+	// 输入 + 输出 token 数
+	Tokens uint64
+}
+
+// ForecastPoint 某个指标在某天的预测值
+type ForecastPoint struct {
+	Day time.Time
+	// requests、tokens 或 disk_bytes
+	Metric string
+	// 为空时为全部客户端请求，否则为配额名称
+	Scope string
+	Value float64
+}
+
+// CreateCapacityForecastTable 创建容量预测表，每次预测按 forecast_date 写入未来各天的预测值
+func (s *ClickHouseStorage) CreateCapacityForecastTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			forecast_date Date,
+			day Date,
+			metric LowCardinality(String),
+			scope String,
+			value Float64,
+			updated_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		PARTITION BY toYYYYMM(forecast_date)
+		ORDER BY (forecast_date, metric, scope, day)
+		TTL forecast_date + INTERVAL 365 DAY
+	`, s.table("capacity_forecast"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create capacity_forecast table: %w", err)
+	}
+	return nil
+}
+
+// DailyVolumes 统计 since 之后每天按模型的客户端请求（v1_*）数和 token 数，按日期排序
+func (s *ClickHouseStorage) DailyVolumes(ctx context.Context, since time.Time) ([]DailyVolume, error) {
+	t := s.tables["api_logs"]
+	cols := []string{
+		t.selectColumn("log_type", "''"),
+		t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		t.modelColumn(),
+		t.selectColumn("incomplete", "toUInt8(0)"),
+		t.selectColumn("input_tokens", "toUInt64(0)"),
+		t.selectColumn("output_tokens", "toUInt64(0)"),
+	}
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(`
+		SELECT toDate(timestamp) AS day, model, count(), sum(input_tokens + output_tokens)
+		FROM (SELECT %s FROM %s)
+		WHERE startsWith(log_type, 'v1_') AND incomplete = 0 AND timestamp >= ?
+		GROUP BY day, model
+		ORDER BY day, model
+	`, strings.Join(cols, ", "), s.readTable("api_logs")), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var volumes []DailyVolume
+	for rows.Next() {
+		var v DailyVolume
+		if err := rows.Scan(&v.Day, &v.Model, &v.Requests, &v.Tokens); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, rows.Err()
+}
+
+// DiskUsage 返回数据库占用的磁盘空间，以及数据所在磁盘的剩余空间（字节）
+func (s *ClickHouseStorage) DiskUsage(ctx context.Context) (used, free uint64, err error) {
+	if err = s.conn.QueryRow(ctx,
+		"SELECT sum(bytes_on_disk) FROM system.parts WHERE database = ? AND active",
+		s.database).Scan(&used); err != nil {
+		return 0, 0, err
+	}
+	if err = s.conn.QueryRow(ctx,
+		"SELECT sum(free_space) FROM system.disks WHERE name IN "+
+			"(SELECT DISTINCT disk_name FROM system.parts WHERE database = ? AND active)",
+		s.database).Scan(&free); err != nil {
+		return 0, 0, err
+	}
+	return used, free, nil
+}
+
+// WriteForecast 写入 forecastDate 当天的预测结果，同一天重新预测时覆盖
+func (s *ClickHouseStorage) WriteForecast(ctx context.Context, forecastDate time.Time, points []ForecastPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf(
+		"INSERT INTO %s (forecast_date, day, metric, scope, value)", s.table("capacity_forecast")))
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if err := batch.Append(forecastDate, p.Day, p.Metric, p.Scope, p.Value); err != nil {
+			batch.Abort()
+			return err
+		}
+	}
+	return batch.Send()
+}
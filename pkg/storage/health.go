@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// ClickHouse 连接的健康状态，通过 /debug/vars 的 clickhouse_health 暴露
+var (
+	healthStats      = expvar.NewMap("clickhouse_health")
+	healthHealthy    = new(expvar.Int)
+	healthFailures   = new(expvar.Int)
+	healthReconnects = new(expvar.Int)
+)
+
+func init() {
+	healthStats.Set("healthy", healthHealthy)
+	healthStats.Set("failures", healthFailures)
+	healthStats.Set("reconnects", healthReconnects)
+	healthHealthy.Set(1)
+}
+
+// 替换后旧连接池等待进行中的操作结束的最长时间，未结束（如未发送也未放弃的批次）时仍关闭
+const drainTimeout = 10 * time.Minute
+
+// connPool 连接池及正在使用它的操作数：查询返回的行、准备的批次在关闭、发送或放弃前占用连接池
+type connPool struct {
+	driver.Conn
+	users sync.WaitGroup
+}
+
+// drain 等待进行中的操作结束（最长 drainTimeout）后关闭连接池
+func (p *connPool) drain() {
+	done := make(chan struct{})
+	go func() {
+		p.users.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Printf("Closing replaced ClickHouse connection pool with operations still in flight after %s", drainTimeout)
+	}
+	if err := p.Close(); err != nil {
+		log.Printf("Error closing replaced ClickHouse connection pool: %v", err)
+	}
+}
+
+// reconnectingConn 可替换底层连接池的连接，健康检查重新建立连接后之后的操作使用新的连接池，
+// 旧连接池在其上进行中的操作结束后关闭
+type reconnectingConn struct {
+	opts *clickhouse.Options
+	mu   sync.RWMutex
+	pool *connPool
+}
+
+func newReconnectingConn(opts *clickhouse.Options, conn driver.Conn) *reconnectingConn {
+	return &reconnectingConn{opts: opts, pool: &connPool{Conn: conn}}
+}
+
+// acquire 返回当前连接池并计入一个使用者，使用结束后调用 release
+func (c *reconnectingConn) acquire() (p *connPool, release func()) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p = c.pool
+	// 在读锁内计数：替换连接池时持有写锁，之后不会再有使用者计入旧连接池
+	p.users.Add(1)
+	var once sync.Once
+	return p, func() { once.Do(p.users.Done) }
+}
+
+// reconnect 建立新的连接池，ping 成功后替换，旧的连接池在后台等待进行中的操作结束后关闭
+func (c *reconnectingConn) reconnect(ctx context.Context) error {
+	conn, err := clickhouse.Open(c.opts)
+	if err != nil {
+		return err
+	}
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return err
+	}
+	c.mu.Lock()
+	old := c.pool
+	c.pool = &connPool{Conn: conn}
+	c.mu.Unlock()
+	go old.drain()
+	return nil
+}
+
+func (c *reconnectingConn) Contributors() []string {
+	p, release := c.acquire()
+	defer release()
+	return p.Contributors()
+}
+
+func (c *reconnectingConn) ServerVersion() (*driver.ServerVersion, error) {
+	p, release := c.acquire()
+	defer release()
+	return p.ServerVersion()
+}
+
+func (c *reconnectingConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	p, release := c.acquire()
+	defer release()
+	return p.Select(ctx, dest, query, args...)
+}
+
+func (c *reconnectingConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	p, release := c.acquire()
+	rows, err := p.Query(ctx, query, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &trackedRows{Rows: rows, release: release}, nil
+}
+
+func (c *reconnectingConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	p, release := c.acquire()
+	return &trackedRow{Row: p.QueryRow(ctx, query, args...), release: release}
+}
+
+func (c *reconnectingConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	p, release := c.acquire()
+	batch, err := p.PrepareBatch(ctx, query, opts...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &trackedBatch{Batch: batch, release: release}, nil
+}
+
+func (c *reconnectingConn) Exec(ctx context.Context, query string, args ...any) error {
+	p, release := c.acquire()
+	defer release()
+	return p.Exec(ctx, query, args...)
+}
+
+func (c *reconnectingConn) AsyncInsert(ctx context.Context, query string, wait bool, args ...any) error {
+	p, release := c.acquire()
+	defer release()
+	return p.AsyncInsert(ctx, query, wait, args...)
+}
+
+func (c *reconnectingConn) Ping(ctx context.Context) error {
+	p, release := c.acquire()
+	defer release()
+	return p.Ping(ctx)
+}
+
+func (c *reconnectingConn) Stats() driver.Stats {
+	p, release := c.acquire()
+	defer release()
+	return p.Stats()
+}
+
+func (c *reconnectingConn) Close() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool.Close()
+}
+
+// trackedRows 关闭时释放连接池
+type trackedRows struct {
+	driver.Rows
+	release func()
+}
+
+func (r *trackedRows) Close() error {
+	defer r.release()
+	return r.Rows.Close()
+}
+
+// trackedRow 读取后释放连接池（QueryRow 的结果在 Scan 前已读取完）
+type trackedRow struct {
+	driver.Row
+	release func()
+}
+
+func (r *trackedRow) Scan(dest ...any) error {
+	defer r.release()
+	return r.Row.Scan(dest...)
+}
+
+func (r *trackedRow) ScanStruct(dest any) error {
+	defer r.release()
+	return r.Row.ScanStruct(dest)
+}
+
+// trackedBatch 发送或放弃后释放连接池
+type trackedBatch struct {
+	driver.Batch
+	release func()
+}
+
+func (b *trackedBatch) Send() error {
+	defer b.release()
+	return b.Batch.Send()
+}
+
+func (b *trackedBatch) Abort() error {
+	defer b.release()
+	return b.Batch.Abort()
+}
+
+// healthChecker 定时 ping ClickHouse：连续失败 failure_threshold 次后标记为不可用并重新建立连接池，
+// ping 成功后恢复可用
+type healthChecker struct {
+	conn      *reconnectingConn
+	cfg       config.HealthCheckConfig
+	timeout   int
+	healthy   atomic.Bool
+	failures  int
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newHealthChecker 启动健康检查，timeout 为每次 ping 的超时（秒），0 时使用检查间隔
+func newHealthChecker(conn *reconnectingConn, cfg config.HealthCheckConfig, timeout int) *healthChecker {
+	if timeout <= 0 {
+		timeout = cfg.IntervalSeconds
+	}
+	h := &healthChecker{
+		conn:    conn,
+		cfg:     cfg,
+		timeout: timeout,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	h.healthy.Store(true)
+	go h.run()
+	return h
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(time.Duration(h.cfg.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.check()
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *healthChecker) check() {
+	ctx, cancel := withTimeout(context.Background(), h.timeout)
+	defer cancel()
+	err := h.conn.Ping(ctx)
+	if err == nil {
+		h.failures = 0
+		if !h.healthy.Swap(true) {
+			log.Println("ClickHouse is healthy again")
+			healthHealthy.Set(1)
+		}
+		return
+	}
+
+	h.failures++
+	healthFailures.Add(1)
+	if h.failures < h.cfg.FailureThreshold {
+		return
+	}
+	if h.healthy.Swap(false) {
+		log.Printf("ClickHouse is unhealthy after %d failed pings: %v", h.failures, err)
+		healthHealthy.Set(0)
+	}
+	if err := h.conn.reconnect(ctx); err != nil {
+		log.Printf("Failed to reconnect to ClickHouse: %v", err)
+		return
+	}
+	healthReconnects.Add(1)
+	log.Println("Reconnected to ClickHouse")
+	h.failures = 0
+	h.healthy.Store(true)
+	healthHealthy.Set(1)
+}
+
+func (h *healthChecker) close() {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+		<-h.done
+	})
+}
+
+// Healthy 最近的健康检查是否成功；未启用健康检查时总是返回 true
+func (s *ClickHouseStorage) Healthy() bool {
+	return s.health == nil || s.health.healthy.Load()
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// createHourlyUsage 创建 api_logs_hourly 汇总表及其物化视图：每批写入 api_logs 的行按
+// (小时, 日志类型, 模型) 累加请求数、错误数和 token 用量，看板无需扫描原始 body。
+// 物化视图只汇总创建之后写入的行，不含未写完的请求；按写入累加，同一请求的重复写入都会计入，
+// api_logs 合并去重或删除行后不会减少，精确值需按 api_logs FINAL 统计
+func (s *ClickHouseStorage) createHourlyUsage(ctx context.Context) error {
+	t := s.tables["api_logs"]
+	if t.mapped {
+		log.Println("Skipping api_logs_hourly view: api_logs is a mapped table")
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			hour DateTime,
+			log_type LowCardinality(String),
+			model LowCardinality(String),
+			requests UInt64,
+			errors UInt64,
+			input_tokens UInt64,
+			output_tokens UInt64,
+			cache_read_input_tokens UInt64,
+			cache_creation_input_tokens UInt64
+		) ENGINE = SummingMergeTree()
+		PARTITION BY toYYYYMM(hour)
+		ORDER BY (hour, log_type, model)
+	`, s.table("api_logs_hourly"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create api_logs_hourly table: %w", err)
+	}
+
+	view := fmt.Sprintf(`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS %s TO %s AS
+		SELECT toStartOfHour(timestamp) AS hour, log_type, model,
+			count() AS requests, countIf(response_status >= 400) AS errors,
+			sum(input_tokens) AS input_tokens, sum(output_tokens) AS output_tokens,
+			sum(cache_read_input_tokens) AS cache_read_input_tokens,
+			sum(cache_creation_input_tokens) AS cache_creation_input_tokens
+		FROM %s
+		WHERE incomplete = 0
+		GROUP BY hour, log_type, model
+	`, s.table("api_logs_hourly_mv"), s.table("api_logs_hourly"), t.fullName())
+	if err := s.execDDL(ctx, view); err != nil {
+		return fmt.Errorf("failed to create api_logs_hourly_mv view: %w", err)
+	}
+	return nil
+}
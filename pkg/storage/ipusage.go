@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// CreateClientIPUsageTables 创建客户端 IP 小时汇总表和滥用候选表
+func (s *ClickHouseStorage) CreateClientIPUsageTables(ctx context.Context) error {
+	hourly := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			hour DateTime,
+			client_ip String,
+			requests UInt64,
+			errors UInt64,
+			input_tokens UInt64,
+			output_tokens UInt64,
+			cache_read_input_tokens UInt64,
+			cache_creation_input_tokens UInt64,
+			updated_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		PARTITION BY toYYYYMM(hour)
+		ORDER BY (hour, client_ip)
+		TTL hour + INTERVAL 365 DAY
+	`, s.table("client_ip_hourly"))
+	if err := s.createTable(ctx, hourly); err != nil {
+		return fmt.Errorf("failed to create client_ip_hourly table: %w", err)
+	}
+
+	candidates := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			hour DateTime,
+			client_ip String,
+			reasons Array(LowCardinality(String)),
+			requests UInt64,
+			errors UInt64,
+			error_rate Float64,
+			input_tokens UInt64,
+			output_tokens UInt64,
+			detected_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(detected_at)
+		PARTITION BY toYYYYMM(hour)
+		ORDER BY (hour, client_ip)
+		TTL hour + INTERVAL 365 DAY
+	`, s.table("abuse_candidates"))
+	if err := s.createTable(ctx, candidates); err != nil {
+		return fmt.Errorf("failed to create abuse_candidates table: %w", err)
+	}
+	return nil
+}
+
+// RollupClientIPUsage 重新计算最近 lookback_hours 小时（含当前小时）各客户端 IP 的用量，
+// 并将超过阈值的 IP 写入 abuse_candidates
+// 请求数和错误数来自 main 日志的 HTTP 访问记录，token 数按 request_id 关联 API 日志；
+// 重复计算的行由 ReplacingMergeTree 按 (hour, client_ip) 合并
+func (s *ClickHouseStorage) RollupClientIPUsage(ctx context.Context, cfg *config.ClientIPUsageConfig, now time.Time) error {
+	from := now.Truncate(time.Hour).Add(-time.Duration(cfg.LookbackHours-1) * time.Hour)
+
+	m := s.tables["main_logs"]
+	mainCols := []string{
+		m.selectColumn("request_id", "''"),
+		m.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		m.selectColumn("status_code", "toUInt16(0)"),
+		m.selectColumn("client_ip", "''"),
+		m.selectColumn("path", "''"),
+	}
+	a := s.tables["api_logs"]
+	apiCols := []string{
+		a.selectColumn("request_id", "''"),
+		a.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		a.selectColumn("input_tokens", "toUInt64(0)"),
+		a.selectColumn("output_tokens", "toUInt64(0)"),
+		a.selectColumn("cache_read_input_tokens", "toUInt64(0)"),
+		a.selectColumn("cache_creation_input_tokens", "toUInt64(0)"),
+	}
+
+	// provider 接口（/api/provider/...）是代理内部转发，不计入客户端请求
+	rollup := fmt.Sprintf(`
+		INSERT INTO %s
+		(hour, client_ip, requests, errors, input_tokens, output_tokens, cache_read_input_tokens, cache_creation_input_tokens)
+		SELECT toStartOfHour(m.timestamp) AS hour, m.client_ip, count(), countIf(m.status_code >= 400),
+			sum(a.input_tokens), sum(a.output_tokens), sum(a.cache_read_input_tokens), sum(a.cache_creation_input_tokens)
+		FROM (
+			SELECT %s FROM %s
+		) AS m
+		LEFT JOIN (
+			SELECT request_id, max(input_tokens) AS input_tokens, max(output_tokens) AS output_tokens,
+				max(cache_read_input_tokens) AS cache_read_input_tokens,
+				max(cache_creation_input_tokens) AS cache_creation_input_tokens
+			FROM (SELECT %s FROM %s)
+			WHERE request_id != '' AND timestamp >= ? AND timestamp < ?
+			GROUP BY request_id
+		) AS a ON a.request_id = m.request_id
+		WHERE m.status_code != 0 AND m.client_ip != '' AND NOT startsWith(m.path, '/api/provider/')
+			AND m.timestamp >= ? AND m.timestamp < ?
+		GROUP BY hour, m.client_ip
+	`, s.table("client_ip_hourly"), strings.Join(mainCols, ", "), s.readTable("main_logs"),
+		strings.Join(apiCols, ", "), s.readTable("api_logs"))
+	if err := s.conn.Exec(ctx, rollup, from.Add(-linkWindow), now.Add(linkWindow), from, now); err != nil {
+		return fmt.Errorf("failed to roll up client IP usage: %w", err)
+	}
+
+	reasons := abuseReasons(cfg.Abuse)
+	if len(reasons) == 0 {
+		return nil
+	}
+	flag := fmt.Sprintf(`
+		INSERT INTO %s
+		(hour, client_ip, reasons, requests, errors, error_rate, input_tokens, output_tokens)
+		SELECT hour, client_ip, arrayFilter(r -> r != '', [%s]) AS flagged, requests, errors,
+			if(requests = 0, 0, errors / requests), input_tokens, output_tokens
+		FROM %s FINAL
+		WHERE hour >= ? AND notEmpty(flagged)
+	`, s.table("abuse_candidates"), strings.Join(reasons, ", "), s.table("client_ip_hourly"))
+	if err := s.conn.Exec(ctx, flag, from); err != nil {
+		return fmt.Errorf("failed to flag abuse candidates: %w", err)
+	}
+	return nil
+}
+
+// abuseReasons 各项已启用阈值的判断表达式，命中时取值为原因名称
+func abuseReasons(t config.AbuseThresholds) []string {
+	var reasons []string
+	if t.RequestsPerHour > 0 {
+		reasons = append(reasons, fmt.Sprintf("if(requests >= %d, 'requests', '')", t.RequestsPerHour))
+	}
+	if t.TokensPerHour > 0 {
+		reasons = append(reasons, fmt.Sprintf("if(input_tokens + output_tokens >= %d, 'tokens', '')", t.TokensPerHour))
+	}
+	if t.ErrorRate > 0 {
+		reasons = append(reasons, fmt.Sprintf("if(requests >= %d AND errors / requests >= %g, 'error_rate', '')",
+			t.MinRequests, t.ErrorRate))
+	}
+	return reasons
+}
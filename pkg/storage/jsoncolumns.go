@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// jsonColumns 启用 json_columns 时添加的 JSON 类型列：列名 -> 来源的 String 列
+var jsonColumns = map[string][][2]string{
+	"api_logs":   {{"request_json", "request_body"}, {"response_json", "response_body"}},
+	"event_logs": {{"event_json", "event_data"}},
+}
+
+// addJSONColumns 为 body 列添加 JSON 类型的 MATERIALIZED 列，查询时可直接读取子字段（如 request_json.model）。
+// 原 String 列保持不变，写入时由 ClickHouse 计算，不是 JSON 对象的值（SSE 流、加密、去重后的空 body）为 {}；
+// 已有的 part 读取时按表达式计算，可执行 ALTER TABLE ... MATERIALIZE COLUMN 落盘
+func (s *ClickHouseStorage) addJSONColumns(ctx context.Context) error {
+	for _, name := range []string{"api_logs", "event_logs"} {
+		t := s.tables[name]
+		if t.mapped {
+			log.Printf("Skipping JSON columns on %s: it is a mapped table", t.fullName())
+			continue
+		}
+		for _, c := range jsonColumns[name] {
+			query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS `%s` JSON MATERIALIZED "+
+				"if(isValidJSON(`%[3]s`) AND startsWith(trimLeft(`%[3]s`), '{'), `%[3]s`, '{}')",
+				t.fullName(), c[0], c[1])
+			if err := s.execDDL(ctx, query); err != nil {
+				return fmt.Errorf("failed to add JSON column %s to %s: %w", c[0], t.fullName(), err)
+			}
+		}
+	}
+	return nil
+}
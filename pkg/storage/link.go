@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 查找另一侧日志的时间范围，api_logs 按 (timestamp, request_id) 排序，限定时间可利用主键
+const linkWindow = time.Hour
+
+// requestSide 同一请求在代理入口（v1_*）或 provider 接口（provider_*）一侧的日志
+type requestSide struct {
+	logType   string
+	timestamp time.Time
+	model     string
+	url       string
+	status    uint16
+	headers   map[string]string
+	upstream  []parser.UpstreamCall
+	usage     parser.Usage
+	streamed  bool
+}
+
+func sideFromEntry(entry *parser.APILogEntry) requestSide {
+	return requestSide{
+		logType:   string(entry.LogType),
+		timestamp: entry.Timestamp,
+		model:     bodyModel(entry.RequestBody),
+		url:       entry.URL,
+		status:    uint16(entry.ResponseStatus),
+		headers:   entry.Headers,
+		upstream:  entry.UpstreamRequests,
+		usage:     entry.Usage,
+		streamed:  entry.Streamed,
+	}
+}
+
+// lastUpstream 实际提供服务的上游调用：provider 一侧最后一次上游调用，没有时使用 v1 一侧的，
+// 同时返回上游调用次数
+func lastUpstream(client, provider requestSide) (parser.UpstreamCall, int) {
+	calls := provider.upstream
+	if len(calls) == 0 {
+		calls = client.upstream
+	}
+	if len(calls) == 0 {
+		return parser.UpstreamCall{}, 0
+	}
+	return calls[len(calls)-1], len(calls)
+}
+
+// upstreamModel 发往上游的模型，上游请求体中没有时使用 provider 一侧请求的模型
+func upstreamModel(provider requestSide, last parser.UpstreamCall) string {
+	if m := bodyModel(last.Body); m != "" {
+		return m
+	}
+	return provider.model
+}
+
+func bodyModel(body string) string {
+	var b struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal([]byte(body), &b)
+	return b.Model
+}
+
+// counterpartPrefix 另一侧日志类型的前缀，非 v1/provider 类型返回空
+func counterpartPrefix(logType parser.LogType) string {
+	switch {
+	case strings.HasPrefix(string(logType), "v1_"):
+		return "provider_"
+	case strings.HasPrefix(string(logType), "provider_"):
+		return "v1_"
+	}
+	return ""
+}
+
+// LinkAPILog 在同一 request_id 的 v1 日志和 provider 日志都已写入后生成关联数据（routing、request_traces 表）
+// 两侧文件的写入顺序不定：写入任一侧后都查询另一侧，先写入的一侧查不到时由后写入的一侧生成，
+// 两侧同时写入时可能都生成，关联表使用 ReplacingMergeTree 去重
+func (s *ClickHouseStorage) LinkAPILog(ctx context.Context, entry *parser.APILogEntry) error {
+	if (!s.routing && !s.traces) || entry == nil || entry.Incomplete {
+		return nil
+	}
+	prefix := counterpartPrefix(entry.LogType)
+	if prefix == "" {
+		return nil
+	}
+
+	others, err := s.requestSides(ctx, entry.RequestID, prefix, entry.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s* logs: %w", prefix, err)
+	}
+
+	self := sideFromEntry(entry)
+	for _, other := range others {
+		client, provider := self, other
+		if prefix == "v1_" {
+			client, provider = other, self
+		}
+		if s.routing {
+			if err := s.insertRouting(ctx, entry.RequestID, client, provider); err != nil {
+				return fmt.Errorf("failed to insert routing: %w", err)
+			}
+		}
+		if s.traces {
+			if err := s.insertTrace(ctx, entry.RequestID, client, provider); err != nil {
+				return fmt.Errorf("failed to insert request trace: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// requestSides 查询 request_id 在另一侧已写入的完整日志，每种日志类型取最后写入的一行
+func (s *ClickHouseStorage) requestSides(ctx context.Context, requestID, prefix string, ts time.Time) ([]requestSide, error) {
+	t := s.tables["api_logs"]
+	cols := append(t.summaryColumns(),
+		t.selectColumn("headers", "''"),
+		t.selectColumn("upstream_requests", "''"),
+		t.selectColumn("input_tokens", "toUInt64(0)"),
+		t.selectColumn("output_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_read_input_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_creation_input_tokens", "toUInt64(0)"),
+		t.selectColumn("streamed", "toUInt8(0)"),
+		t.selectColumn("incomplete", "toUInt8(0)"),
+		t.selectColumn("inserted_at", "toDateTime64(0, 3)"),
+	)
+	latest := func(col string) string {
+		return fmt.Sprintf("argMax(`%s`, `inserted_at`)", col)
+	}
+	fields := []string{"`log_type`"}
+	for _, col := range []string{"timestamp", "model", "url", "response_status", "headers", "upstream_requests",
+		"input_tokens", "output_tokens", "cache_read_input_tokens", "cache_creation_input_tokens", "streamed"} {
+		fields = append(fields, latest(col))
+	}
+	query := fmt.Sprintf("SELECT %s FROM (SELECT %s FROM %s) "+
+		"WHERE `request_id` = ? AND startsWith(`log_type`, ?) AND `incomplete` = 0 AND `timestamp` BETWEEN ? AND ? "+
+		"GROUP BY `log_type`",
+		strings.Join(fields, ", "), strings.Join(cols, ", "), s.readTable("api_logs"))
+
+	rows, err := s.conn.Query(ctx, query, requestID, prefix, ts.Add(-linkWindow), ts.Add(linkWindow))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sides []requestSide
+	for rows.Next() {
+		var side requestSide
+		var headers, upstream string
+		var streamed uint8
+		u := &side.usage
+		if err := rows.Scan(&side.logType, &side.timestamp, &side.model, &side.url, &side.status, &headers, &upstream,
+			&u.InputTokens, &u.OutputTokens, &u.CacheReadInputTokens, &u.CacheCreationInputTokens, &streamed); err != nil {
+			return nil, err
+		}
+		side.streamed = streamed == 1
+		json.Unmarshal([]byte(headers), &side.headers)
+		if upstream != "" {
+			if err := json.Unmarshal([]byte(upstream), &side.upstream); err != nil {
+				log.Printf("Ignoring malformed upstream_requests for %s: %v", requestID, err)
+			}
+		}
+		sides = append(sides, side)
+	}
+	return sides, rows.Err()
+}
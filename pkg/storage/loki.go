@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+func init() {
+	Register("loki", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewLokiStorage(cfg, labels)
+	})
+}
+
+// LokiStorage 通过 push API 将各表的行写入 Grafana Loki：每行一条日志，内容为除 timestamp 外各字段的 JSON，
+// log_type、level、method、status 和 host、instance 作为流标签。Loki 丢弃同一流中时间戳和内容都相同的重复日志，
+// 重新处理文件不会产生重复数据
+type LokiStorage struct {
+	client   *http.Client
+	endpoint string
+	cfg      *config.LokiConfig
+	labels   Labels
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 本机已处理的文件
+	processed *processedLog
+}
+
+// NewLokiStorage 加载已处理文件的记录，不检查 Loki 是否可达（写入失败时按批次重试）
+func NewLokiStorage(cfg *config.Config, labels Labels) (*LokiStorage, error) {
+	lc := &cfg.Storage.Loki
+	base, err := url.Parse(strings.TrimRight(lc.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage.loki.url: %w", err)
+	}
+	base.Path += "/loki/api/v1/push"
+
+	s := &LokiStorage{
+		client:   &http.Client{Timeout: time.Duration(lc.TimeoutSeconds) * time.Second},
+		endpoint: base.String(),
+		cfg:      lc,
+		labels:   labels,
+	}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			return nil, err
+		}
+	}
+	if s.processed, err = openProcessedLog(lc.StateFile); err != nil {
+		return nil, fmt.Errorf("failed to open storage.loki.state_file: %w", err)
+	}
+	return s, nil
+}
+
+// lokiStream push 请求中的一个流，values 为 [纳秒时间戳, 日志行]
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+	// 各条日志的纳秒时间戳，排序后写入 Values
+	times []int64
+}
+
+func (st *lokiStream) Len() int           { return len(st.times) }
+func (st *lokiStream) Less(i, j int) bool { return st.times[i] < st.times[j] }
+func (st *lokiStream) Swap(i, j int) {
+	st.times[i], st.times[j] = st.times[j], st.times[i]
+	st.Values[i], st.Values[j] = st.Values[j], st.Values[i]
+}
+
+// streamLabels 返回行的流标签，空值不作为标签
+func (s *LokiStorage) streamLabels(logType parser.LogType, r *row) map[string]string {
+	labels := map[string]string{"log_type": string(logType)}
+	set := func(name, value string) {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	set("host", s.labels.Host)
+	set("instance", s.labels.Instance)
+	if v, ok := r.get("level"); ok {
+		set("level", fmt.Sprint(v))
+	}
+	if v, ok := r.get("method"); ok {
+		set("method", fmt.Sprint(v))
+	}
+	for _, field := range []string{"status_code", "response_status"} {
+		if v, ok := r.get(field); ok && fmt.Sprint(v) != "0" {
+			set("status", fmt.Sprint(v))
+		}
+	}
+	return labels
+}
+
+// push 将数据表 table 的行按标签分组为流后写入
+func (s *LokiStorage) push(ctx context.Context, table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	streams := make(map[string]*lokiStream)
+	var keys []string
+	for _, r := range rows {
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+		var ts time.Time
+		line := make(map[string]interface{}, len(r.fields))
+		for i, f := range r.fields {
+			if f == "timestamp" {
+				ts, _ = r.values[i].(time.Time)
+				continue
+			}
+			line[f] = r.values[i]
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+
+		labels := s.streamLabels(logType, r)
+		key, _ := json.Marshal(labels)
+		stream, ok := streams[string(key)]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[string(key)] = stream
+			keys = append(keys, string(key))
+		}
+		stream.times = append(stream.times, ts.UnixNano())
+		stream.Values = append(stream.Values, [2]string{"", string(data)})
+	}
+
+	body := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		stream := streams[key]
+		// 同一流中的日志按时间排序
+		sort.Sort(stream)
+		for i, t := range stream.times {
+			stream.Values[i][0] = strconv.FormatInt(t, 10)
+		}
+		body.Streams = append(body.Streams, stream)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push to Loki: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Loki returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *LokiStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.push(ctx, "main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+func (s *LokiStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.push(ctx, "api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+func (s *LokiStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.push(ctx, "embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+func (s *LokiStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.push(ctx, "event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+func (s *LokiStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	return s.push(ctx, "json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+// MarkFileProcessed 记录到 state_file
+func (s *LokiStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	return s.processed.add(processedRecord{Path: filePath, Size: fileSize, MTime: mtime, Inode: inode, RecordCount: recordCount})
+}
+
+func (s *LokiStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	return s.processed.contains(filePath, fileSize, mtime, inode), nil
+}
+
+func (s *LokiStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	last, found := s.processed.last(filePath)
+	return last, found, nil
+}
+
+func (s *LokiStorage) Close() error {
+	s.client.CloseIdleConnections()
+	return s.processed.Close()
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// OptimizeRecentPartitions 对各表中 since 之后有写入、且有多个活跃 part 的分区执行 OPTIMIZE ... FINAL，
+// 不存在的表跳过；一个分区失败时继续处理其余分区，返回第一个错误
+func (s *ClickHouseStorage) OptimizeRecentPartitions(ctx context.Context, tables []string, since time.Time) error {
+	names := make([]string, len(tables))
+	for i, name := range tables {
+		names[i] = s.physicalName(name)
+	}
+	rows, err := s.conn.Query(ctx, `
+		SELECT table, partition_id, count() AS parts
+		FROM system.parts
+		WHERE active AND database = ? AND has(?, table)
+		GROUP BY table, partition_id
+		HAVING parts > 1 AND max(modification_time) >= ?
+		ORDER BY table, partition_id
+	`, s.database, names, since)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	type partition struct {
+		table, id string
+		parts     uint64
+	}
+	var partitions []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.table, &p.id, &p.parts); err != nil {
+			rows.Close()
+			return err
+		}
+		partitions = append(partitions, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, p := range partitions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		start := time.Now()
+		err := s.conn.Exec(ctx, fmt.Sprintf("OPTIMIZE TABLE %s.%s PARTITION ID '%s' FINAL", s.database, p.table, p.id))
+		if err != nil {
+			log.Printf("Failed to optimize %s partition %s: %v", p.table, p.id, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to optimize %s: %w", p.table, err)
+			}
+			continue
+		}
+		log.Printf("Optimized %s partition %s (%d parts) in %v", p.table, p.id, p.parts, time.Since(start).Round(time.Millisecond))
+	}
+	return firstErr
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// masker 写入前按规则对各数据表的列脱敏
+type masker struct {
+	salt string
+	// 表名 -> 按配置顺序排列的规则
+	rules map[string][]config.MaskingRule
+}
+
+// newMasker 校验规则中的字段都存在，hash 和 truncate 只能用于 String 列
+func newMasker(cfg *config.MaskingConfig) (*masker, error) {
+	m := &masker{salt: cfg.Salt, rules: make(map[string][]config.MaskingRule)}
+	for i, r := range cfg.Rules {
+		_, cols := parseDDLColumns(fmt.Sprintf(tableDDL[r.Table], "db"))
+		types := make(map[string]string, len(cols))
+		for _, c := range cols {
+			if !c.isNested() {
+				types[c.name] = c.typ
+			}
+		}
+
+		typ, ok := types[r.Column]
+		if !ok {
+			return nil, fmt.Errorf("masking rule %d: %s has no column %s", i, r.Table, r.Column)
+		}
+		if (r.Action == "hash" || r.Action == "truncate") && typ != "String" && typ != "LowCardinality(String)" {
+			return nil, fmt.Errorf("masking rule %d: %s requires a String column, %s.%s is %s", i, r.Action, r.Table, r.Column, typ)
+		}
+		for field := range r.Match {
+			if _, ok := types[field]; !ok {
+				return nil, fmt.Errorf("masking rule %d: %s has no column %s", i, r.Table, field)
+			}
+		}
+		m.rules[r.Table] = append(m.rules[r.Table], r)
+	}
+	return m, nil
+}
+
+// apply 对一行执行脱敏；规则按原始值匹配，同一列只执行第一条命中的规则
+func (m *masker) apply(table string, r *row) {
+	rules := m.rules[table]
+	if len(rules) == 0 {
+		return
+	}
+	matched := make(map[string]config.MaskingRule)
+	for _, rule := range rules {
+		if _, ok := matched[rule.Column]; ok {
+			continue
+		}
+		if matchesRow(r, rule.Match) {
+			matched[rule.Column] = rule
+		}
+	}
+	for col, rule := range matched {
+		v, ok := r.get(col)
+		if !ok {
+			continue
+		}
+		r.replace(col, m.mask(rule, v))
+	}
+}
+
+func matchesRow(r *row, match map[string]string) bool {
+	for field, want := range match {
+		v, ok := r.get(field)
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *masker) mask(rule config.MaskingRule, v interface{}) interface{} {
+	switch rule.Action {
+	case "drop":
+		return zeroValue(v)
+	case "hash":
+		s, _ := v.(string)
+		if s == "" {
+			return s
+		}
+		sum := sha256.Sum256([]byte(m.salt + s))
+		return hex.EncodeToString(sum[:])
+	case "truncate":
+		s, _ := v.(string)
+		return truncateUTF8(s, rule.Length)
+	}
+	return v
+}
+
+// zeroValue 与 v 同类型的空值，切片为空切片（ClickHouse 数组列不接受 nil）
+func zeroValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Slice {
+		return reflect.MakeSlice(t, 0, 0).Interface()
+	}
+	return reflect.Zero(t).Interface()
+}
+
+// truncateUTF8 截断到最多 n 字节，不拆分多字节字符
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
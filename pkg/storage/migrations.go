@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration 一个版本的表结构变更，须可重复执行（IF NOT EXISTS 等），多个采集器同时启动时可能都会执行。
+// statements 返回全局的 DDL；run 用于需要先查询表的当前状态再决定执行哪些 DDL 的变更；
+// table 返回按数据表 table 的建表语句创建的一张表 t 需要执行的 DDL，
+// 除默认的数据表外也用于 staged_types、自定义日志类型和各租户的表
+type migration struct {
+	version     uint32
+	description string
+	statements  func(s *ClickHouseStorage) []string
+	run         func(ctx context.Context, s *ClickHouseStorage) error
+	table       func(table string, t *tableSchema) []string
+}
+
+// migrations 按版本递增排列，只追加不修改已发布的版本。
+// 建表（CREATE TABLE IF NOT EXISTS）和补列不经过迁移：每次启动按当前建表语句建表，由 createTable 对比实际列自动补齐；
+// 修改已有表的列类型、索引、表设置等其他变更在此追加迁移
+var migrations = []migration{
+	{version: 1, description: "enable insert deduplication window on data tables", table: func(table string, t *tableSchema) []string {
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY SETTING non_replicated_deduplication_window = %d",
+			t.fullName(), dedupWindow)}
+	}},
+	{version: 2, description: "compress body columns with ZSTD", table: func(table string, t *tableSchema) []string {
+		var stmts []string
+		for _, col := range bodyColumns[table] {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN `%s` %s", t.fullName(), col, bodyCodec))
+		}
+		return stmts
+	}},
+	{version: 3, description: "order processed_files by host and instance", run: rebuildProcessedFiles},
+}
+
+// processedFilesSortingKey processedFilesDDL 的排序键，与 system.tables.sorting_key 的格式相同
+const processedFilesSortingKey = "file_path, host, instance"
+
+// rebuildProcessedFiles 排序键不能加入已有列，按新的排序键将 processed_files 复制到新表后交换。
+// 多个采集器可能同时执行：各自使用不同的临时表，交换前后检查排序键，已由其他采集器重建时不再交换；
+// 复制和交换之间其他采集器写入的记录会丢失，对应文件重新处理（由数据表去重）
+func rebuildProcessedFiles(ctx context.Context, s *ClickHouseStorage) error {
+	if s.cluster.Name != "" {
+		log.Printf("Warning: processed_files on cluster %s is not rebuilt; recreate it with ORDER BY (%s) manually",
+			s.cluster.Name, processedFilesSortingKey)
+		return nil
+	}
+	name := s.tableName("processed_files")
+	key, err := s.sortingKey(ctx, name)
+	if err != nil {
+		return err
+	}
+	if key == processedFilesSortingKey {
+		return nil
+	}
+
+	rebuiltName := fmt.Sprintf("%s_rebuild_%d", name, time.Now().UnixNano())
+	table, rebuilt := s.table("processed_files"), fmt.Sprintf("%s.%s", s.database, rebuiltName)
+	defer func() {
+		if err := s.execDDL(context.WithoutCancel(ctx), fmt.Sprintf("DROP TABLE IF EXISTS %s", rebuilt)); err != nil {
+			log.Printf("Warning: failed to drop %s: %v", rebuilt, err)
+		}
+	}()
+	if err := s.execDDL(ctx, fmt.Sprintf(processedFilesDDL, rebuilt)); err != nil {
+		return err
+	}
+	if key, err := s.sortingKey(ctx, rebuiltName); err != nil {
+		return err
+	} else if key != processedFilesSortingKey {
+		return fmt.Errorf("%s has sorting key (%s), expected (%s)", rebuilt, key, processedFilesSortingKey)
+	}
+	err = s.execDDL(ctx, fmt.Sprintf("INSERT INTO %[1]s (%[3]s) SELECT %[3]s FROM %[2]s", rebuilt, table,
+		"file_path, file_size, file_mtime, file_inode, processed_at, record_count, host, instance"))
+	if err != nil {
+		return err
+	}
+
+	// 复制期间其他采集器已完成交换时，processed_files 已是新的排序键，交换会换回旧表
+	if key, err := s.sortingKey(ctx, name); err != nil {
+		return err
+	} else if key == processedFilesSortingKey {
+		log.Printf("%s was rebuilt by another collector", table)
+		return nil
+	}
+	if err := s.execDDL(ctx, fmt.Sprintf("EXCHANGE TABLES %s AND %s", table, rebuilt)); err != nil {
+		return err
+	}
+	if key, err := s.sortingKey(ctx, name); err != nil {
+		return err
+	} else if key != processedFilesSortingKey {
+		return fmt.Errorf("%s has sorting key (%s) after rebuild, expected (%s)", table, key, processedFilesSortingKey)
+	}
+	return nil
+}
+
+// sortingKey 返回当前数据库中表 name 的排序键
+func (s *ClickHouseStorage) sortingKey(ctx context.Context, name string) (string, error) {
+	var key string
+	err := s.conn.QueryRow(ctx, "SELECT sorting_key FROM system.tables WHERE database = ? AND name = ?",
+		s.database, name).Scan(&key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s.%s sorting key: %w", s.database, name, err)
+	}
+	return key, nil
+}
+
+// createMigrationsTable 创建记录已执行迁移版本的表
+func (s *ClickHouseStorage) createMigrationsTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version UInt32,
+			description String,
+			applied_at DateTime64(3) DEFAULT now64(3),
+			host LowCardinality(String),
+			instance LowCardinality(String)
+		) ENGINE = ReplacingMergeTree(applied_at)
+		ORDER BY version
+	`, s.table("schema_migrations"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// schemaVersion 返回已执行的最大迁移版本，未执行过迁移时为 0
+func (s *ClickHouseStorage) schemaVersion(ctx context.Context) (uint32, error) {
+	var version uint32
+	err := s.conn.QueryRow(ctx, fmt.Sprintf("SELECT max(version) FROM %s", s.table("schema_migrations"))).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate 按版本顺序执行尚未执行的迁移，每个迁移成功后记录到 schema_migrations，
+// 失败时返回错误，下次启动从失败的版本重新执行
+func (s *ClickHouseStorage) migrate(ctx context.Context) error {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		if latest := migrations[len(migrations)-1].version; current > latest {
+			log.Printf("Warning: schema is at version %d, newer than this version of cpa-logger (%d)", current, latest)
+		}
+		return nil
+	}
+
+	// 以前创建的租户表在本次运行中可能不会写入，启动时一并迁移
+	var tenantTables map[string][]*tableSchema
+	if s.tenants != nil {
+		tenantTables = make(map[string][]*tableSchema)
+		for _, table := range []string{"api_logs", "embedding_logs"} {
+			if tenantTables[table], err = s.tenantTables(ctx, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range pending {
+		log.Printf("Applying schema migration %d: %s", m.version, m.description)
+		var stmts []string
+		if m.statements != nil {
+			stmts = m.statements(s)
+		}
+		if m.run != nil {
+			if err := m.run(ctx, s); err != nil {
+				return fmt.Errorf("schema migration %d failed: %w", m.version, err)
+			}
+		}
+		if m.table != nil {
+			for _, name := range dataTables {
+				if !s.tables[name].mapped {
+					stmts = append(stmts, m.table(name, s.tables[name])...)
+				}
+			}
+			for table, schemas := range tenantTables {
+				for _, t := range schemas {
+					stmts = append(stmts, m.table(table, t)...)
+				}
+			}
+		}
+		for _, stmt := range stmts {
+			if err := s.execDDL(ctx, stmt); err != nil {
+				return fmt.Errorf("schema migration %d failed: %w", m.version, err)
+			}
+		}
+		err := s.conn.Exec(ctx, fmt.Sprintf(
+			"INSERT INTO %s (version, description, host, instance) VALUES (?, ?, ?, ?)", s.table("schema_migrations")),
+			m.version, m.description, s.labels.Host, s.labels.Instance)
+		if err != nil {
+			return fmt.Errorf("failed to record schema migration %d: %w", m.version, err)
+		}
+	}
+	log.Printf("Schema migrated from version %d to %d", current, pending[len(pending)-1].version)
+	return nil
+}
+
+// migrateTable 对启动之后按数据表 table 的建表语句创建的表（staged_types、自定义日志类型、租户的表）执行所有迁移，
+// 这些表可能由旧版本创建，不在启动时的迁移范围内
+func (s *ClickHouseStorage) migrateTable(ctx context.Context, table string, t *tableSchema) error {
+	for _, m := range migrations {
+		if m.table == nil {
+			continue
+		}
+		for _, stmt := range m.table(table, t) {
+			if err := s.execDDL(ctx, stmt); err != nil {
+				return fmt.Errorf("schema migration %d failed on %s: %w", m.version, t.fullName(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// mirrorStorage 写入主存储后将同样的日志写入副本（如 Parquet 归档）；
+// 已处理文件、关联、重放队列等只使用主存储
+type mirrorStorage struct {
+	Storage
+	mirror Storage
+}
+
+// Mirror 返回同时写入 primary 和 mirror 的存储，任一写入失败时返回错误（批次重试时主存储按去重令牌去重）
+func Mirror(primary, mirror Storage) Storage {
+	return &mirrorStorage{Storage: primary, mirror: mirror}
+}
+
+func (m *mirrorStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return WithInsertToken(WithInsertToken(ctx, m.Storage, token), m.mirror, token)
+}
+
+// Unwrap 可选能力使用主存储的实现
+func (m *mirrorStorage) Unwrap() Storage {
+	return m.Storage
+}
+
+func (m *mirrorStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	if err := m.Storage.InsertMainLogs(ctx, entries, logFile); err != nil {
+		return err
+	}
+	return m.mirror.InsertMainLogs(ctx, entries, logFile)
+}
+
+func (m *mirrorStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if err := m.Storage.InsertAPILog(ctx, entry, logFile); err != nil {
+		return err
+	}
+	return m.mirror.InsertAPILog(ctx, entry, logFile)
+}
+
+func (m *mirrorStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if err := m.Storage.InsertEmbeddingLog(ctx, entry, logFile); err != nil {
+		return err
+	}
+	return m.mirror.InsertEmbeddingLog(ctx, entry, logFile)
+}
+
+func (m *mirrorStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if err := m.Storage.InsertEventBatch(ctx, entry, logFile); err != nil {
+		return err
+	}
+	return m.mirror.InsertEventBatch(ctx, entry, logFile)
+}
+
+func (m *mirrorStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if err := m.Storage.InsertJSONLines(ctx, logType, records, logFile); err != nil {
+		return err
+	}
+	return m.mirror.InsertJSONLines(ctx, logType, records, logFile)
+}
+
+// Close 先关闭副本（上传剩余的归档），再关闭主存储
+func (m *mirrorStorage) Close() error {
+	err := m.mirror.Close()
+	if perr := m.Storage.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
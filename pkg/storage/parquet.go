@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+func init() {
+	Register("parquet", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewParquetStorage(ctx, cfg, labels)
+	})
+}
+
+// ParquetStorage 在内存中按表和日期缓冲各表的行，定时或缓冲满时写为 Parquet 文件上传到 S3
+// 或写入本地目录，列与 ClickHouse 表相同。单独使用时已处理文件在其数据上传后才记录到 state_file，
+// 进程异常退出时未上传的文件重启后重新处理
+type ParquetStorage struct {
+	// 写入本地目录时为 nil
+	client *minio.Client
+	cfg    *config.ParquetConfig
+	labels Labels
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 本机已处理的文件，作为 ClickHouse 的归档时为 nil
+	processed *processedLog
+	// 配置了独立表的自定义日志类型 -> 表名
+	custom map[parser.LogType]string
+
+	mu sync.Mutex
+	// 表 + 日期 -> 缓冲的行
+	buffers map[parquetKey][]*row
+	rows    int
+	// 数据尚未上传的已处理文件
+	pending []processedRecord
+	seq     int
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// parquetKey 一个 Parquet 文件对应的表和日期
+type parquetKey struct {
+	table string
+	date  string
+}
+
+// NewParquetStorage 创建 S3 客户端并检查存储桶（配置了 dir 时创建本地目录），并启动定时上传
+func NewParquetStorage(ctx context.Context, cfg *config.Config, labels Labels) (*ParquetStorage, error) {
+	pc := &cfg.Storage.Parquet
+	var client *minio.Client
+	var err error
+	if pc.Dir != "" {
+		if err := os.MkdirAll(filepath.Join(pc.Dir, pc.Prefix), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create storage.parquet.dir: %w", err)
+		}
+	} else if client, err = newParquetClient(ctx, pc); err != nil {
+		return nil, err
+	}
+
+	s := &ParquetStorage{
+		client:  client,
+		cfg:     pc,
+		labels:  labels,
+		buffers: make(map[parquetKey][]*row),
+		custom:  make(map[parser.LogType]string),
+		full:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range cfg.CustomLogTypes {
+		if t.Table != "" {
+			s.custom[parser.LogType(t.Name)] = t.Table
+		}
+	}
+	if !pc.Archive {
+		if s.processed, err = openProcessedLog(pc.StateFile); err != nil {
+			return nil, fmt.Errorf("failed to open storage.parquet.state_file: %w", err)
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+func newParquetClient(ctx context.Context, pc *config.ParquetConfig) (*minio.Client, error) {
+	var creds *credentials.Credentials
+	if pc.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(pc.AccessKeyID, pc.SecretAccessKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{},
+		})
+	}
+	client, err := minio.New(pc.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: pc.UseSSL,
+		Region: pc.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	exists, err := client.BucketExists(ctx, pc.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", pc.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", pc.Bucket)
+	}
+	return client, nil
+}
+
+// run 每隔 flush_interval_seconds 或缓冲满时上传
+func (s *ParquetStorage) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Duration(s.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.full:
+		}
+		if err := s.Flush(context.Background()); err != nil {
+			log.Printf("Parquet: %v", err)
+		}
+	}
+}
+
+// add 将数据表 table 的行加入缓冲，按各行 timestamp 的 UTC 日期分组；
+// 日志类型配置了独立的表时文件以该表命名
+func (s *ParquetStorage) add(table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	target := table
+	if t, ok := s.custom[logType]; ok {
+		target = t
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rows {
+		r.set("host", s.labels.Host)
+		r.set("instance", s.labels.Instance)
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+		v, _ := r.get("timestamp")
+		ts, _ := v.(time.Time)
+		key := parquetKey{table: target, date: ts.UTC().Format("2006-01-02")}
+		s.buffers[key] = append(s.buffers[key], r)
+	}
+	s.rows += len(rows)
+	if s.rows >= s.cfg.MaxRows {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush 将缓冲的行写为 Parquet 文件上传，全部成功后记录等待中的已处理文件；
+// 上传失败的缓冲保留到下次上传
+func (s *ParquetStorage) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	buffers, pending := s.buffers, s.pending
+	s.buffers, s.pending, s.rows = make(map[parquetKey][]*row), nil, 0
+	s.mu.Unlock()
+
+	keys := make([]parquetKey, 0, len(buffers))
+	for key := range buffers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].date != keys[j].date {
+			return keys[i].date < keys[j].date
+		}
+		return keys[i].table < keys[j].table
+	})
+
+	var firstErr error
+	failed := make(map[parquetKey][]*row)
+	for _, key := range keys {
+		if err := s.upload(ctx, key, buffers[key]); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to upload %s rows of %s: %w", key.table, key.date, err)
+			}
+			failed[key] = buffers[key]
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if firstErr != nil {
+		// 失败的行放回缓冲之前，已处理文件继续等待
+		for key, rows := range failed {
+			s.buffers[key] = append(rows, s.buffers[key]...)
+			s.rows += len(rows)
+		}
+		s.pending = append(pending, s.pending...)
+		return firstErr
+	}
+	for _, r := range pending {
+		if err := s.processed.add(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upload 写出一个 Parquet 文件
+func (s *ParquetStorage) upload(ctx context.Context, key parquetKey, rows []*row) error {
+	data, err := encodeParquet(rows)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.seq++
+	name := fmt.Sprintf("%s_%s_%s_%d.parquet", key.table, s.labels.Host, time.Now().UTC().Format("20060102T150405"), s.seq)
+	s.mu.Unlock()
+	object := path.Join(s.cfg.Prefix, "date="+key.date, name)
+	if s.client == nil {
+		return s.writeLocal(object, data, len(rows))
+	}
+
+	_, err = s.client.PutObject(ctx, s.cfg.Bucket, object, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/vnd.apache.parquet",
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Parquet: uploaded %d rows to s3://%s/%s", len(rows), s.cfg.Bucket, object)
+	return nil
+}
+
+// writeLocal 写入临时文件后重命名，查询方不会读到写了一半的文件
+func (s *ParquetStorage) writeLocal(object string, data []byte, rows int) error {
+	file := filepath.Join(s.cfg.Dir, filepath.FromSlash(object))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+".tmp", data, 0644); err != nil {
+		os.Remove(file + ".tmp")
+		return err
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		os.Remove(file + ".tmp")
+		return err
+	}
+	log.Printf("Parquet: wrote %d rows to %s", rows, file)
+	return nil
+}
+
+func (s *ParquetStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.add("main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+func (s *ParquetStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.add("api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+func (s *ParquetStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.add("embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+func (s *ParquetStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.add("event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+// InsertJSONLines 写入 json_lines 类型的日志，日志类型须配置了 table
+func (s *ParquetStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if _, ok := s.custom[logType]; !ok {
+		return fmt.Errorf("no table for log type %s", logType)
+	}
+	return s.add("json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+// MarkFileProcessed 文件的数据上传后记录到 state_file
+func (s *ParquetStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	if s.processed == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, processedRecord{Path: filePath, Size: fileSize, MTime: mtime, Inode: inode, RecordCount: recordCount})
+	return nil
+}
+
+// IsFileProcessed 已记录或等待上传的文件都视为已处理
+func (s *ParquetStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	if s.processed == nil {
+		return false, nil
+	}
+	s.mu.Lock()
+	for _, r := range s.pending {
+		if r.Path == filePath && r.Size == fileSize && r.MTime.Equal(mtime) && (r.Inode == inode || r.Inode == 0 || inode == 0) {
+			s.mu.Unlock()
+			return true, nil
+		}
+	}
+	s.mu.Unlock()
+	return s.processed.contains(filePath, fileSize, mtime, inode), nil
+}
+
+func (s *ParquetStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	if s.processed == nil {
+		return ProcessedFile{}, false, nil
+	}
+	s.mu.Lock()
+	for i := len(s.pending) - 1; i >= 0; i-- {
+		if r := s.pending[i]; r.Path == filePath {
+			s.mu.Unlock()
+			return ProcessedFile{Size: r.Size, Inode: r.Inode, RecordCount: r.RecordCount}, true, nil
+		}
+	}
+	s.mu.Unlock()
+	last, found := s.processed.last(filePath)
+	return last, found, nil
+}
+
+// Close 停止定时上传并上传剩余的缓冲
+func (s *ParquetStorage) Close() error {
+	close(s.stop)
+	<-s.done
+	err := s.Flush(context.Background())
+	if s.processed != nil {
+		if cerr := s.processed.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
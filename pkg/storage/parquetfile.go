@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Parquet 文件格式中用到的常量（parquet.thrift）
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetUInt8           = 11
+	parquetUInt16          = 12
+
+	parquetPlain   = 0
+	parquetRLE     = 3
+	parquetGzip    = 2
+	parquetMagic   = "PAR1"
+	parquetVersion = 1
+)
+
+// parquetColumn 一列的类型及 PLAIN 编码的非空值
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32 // -1 表示无
+	optional  bool
+	values    bytes.Buffer
+	// optional 列各行是否非空
+	defined []bool
+}
+
+// newParquetColumn 按 Go 值的类型确定列类型：时间为毫秒时间戳，整数为 INT32/INT64，
+// 指针为可空列，数组等其他类型编码为 JSON 字符串
+func newParquetColumn(name string, v interface{}) *parquetColumn {
+	c := &parquetColumn{name: name, physical: parquetByteArray, converted: parquetUTF8}
+	t := reflect.TypeOf(v)
+	if t == nil {
+		c.optional = true
+		return c
+	}
+	if t.Kind() == reflect.Ptr {
+		c.optional = true
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		c.physical, c.converted = parquetInt64, parquetTimestampMillis
+		return c
+	}
+	switch t.Kind() {
+	case reflect.Uint8:
+		c.physical, c.converted = parquetInt32, parquetUInt8
+	case reflect.Uint16:
+		c.physical, c.converted = parquetInt32, parquetUInt16
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Bool:
+		c.physical, c.converted = parquetInt32, -1
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		c.physical, c.converted = parquetInt64, -1
+	case reflect.Float32, reflect.Float64:
+		c.physical, c.converted = parquetDouble, -1
+	}
+	return c
+}
+
+// add 追加一行的值，类型与列不符时按列类型转换，必填列的空值写入零值
+func (c *parquetColumn) add(v interface{}) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			rv = reflect.Value{}
+			break
+		}
+		rv = rv.Elem()
+	}
+	if c.optional {
+		c.defined = append(c.defined, rv.IsValid())
+		if !rv.IsValid() {
+			return
+		}
+	}
+
+	var buf [8]byte
+	switch c.physical {
+	case parquetInt32:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(parquetInt(rv)))
+		c.values.Write(buf[:4])
+	case parquetInt64:
+		n := parquetInt(rv)
+		if rv.IsValid() && rv.Type() == reflect.TypeOf(time.Time{}) {
+			n = rv.Interface().(time.Time).UnixMilli()
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	case parquetDouble:
+		var f float64
+		if rv.IsValid() {
+			switch rv.Kind() {
+			case reflect.Float32, reflect.Float64:
+				f = rv.Float()
+			default:
+				f = float64(parquetInt(rv))
+			}
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:])
+	default:
+		var s string
+		if rv.IsValid() {
+			if rv.Kind() == reflect.String {
+				s = rv.String()
+			} else {
+				data, _ := json.Marshal(rv.Interface())
+				s = string(data)
+			}
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+		c.values.Write(buf[:4])
+		c.values.WriteString(s)
+	}
+}
+
+// parquetInt 整数和布尔值转为 int64，其他类型为 0
+func parquetInt(rv reflect.Value) int64 {
+	if !rv.IsValid() {
+		return 0
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float())
+	}
+	return 0
+}
+
+// page 返回列的数据页内容：optional 列为 RLE 编码的定义级别（4 字节长度前缀）加非空值
+func (c *parquetColumn) page() []byte {
+	if !c.optional {
+		return c.values.Bytes()
+	}
+	var levels []byte
+	for i := 0; i < len(c.defined); {
+		j := i
+		for j < len(c.defined) && c.defined[j] == c.defined[i] {
+			j++
+		}
+		// RLE run：长度 << 1，之后为 1 字节的级别值
+		levels = binary.AppendUvarint(levels, uint64(j-i)<<1)
+		if c.defined[i] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		i = j
+	}
+	page := make([]byte, 4, 4+len(levels)+c.values.Len())
+	binary.LittleEndian.PutUint32(page, uint32(len(levels)))
+	page = append(page, levels...)
+	return append(page, c.values.Bytes()...)
+}
+
+// encodeParquet 将行编码为只有一个行组的 Parquet 文件（每列一个 gzip 压缩的 PLAIN 数据页），
+// 列和类型取自第一行
+func encodeParquet(rows []*row) ([]byte, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows")
+	}
+	first := rows[0]
+	columns := make([]*parquetColumn, len(first.fields))
+	for i, f := range first.fields {
+		columns[i] = newParquetColumn(f, first.values[i])
+	}
+	for _, r := range rows {
+		for i, c := range columns {
+			// 各行字段顺序相同，不同时按名称查找
+			if i < len(r.fields) && r.fields[i] == c.name {
+				c.add(r.values[i])
+				continue
+			}
+			v, _ := r.get(c.name)
+			c.add(v)
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(parquetMagic)
+	type chunk struct {
+		offset, compressed, uncompressed int64
+	}
+	chunks := make([]chunk, len(columns))
+	var total int64
+	for i, c := range columns {
+		data := c.page()
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+
+		h := newThriftWriter()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(compressed.Len()))
+		h.beginStruct(5)
+		h.i32(1, int32(len(rows)))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		header := h.finish()
+
+		chunks[i] = chunk{
+			offset:       int64(out.Len()),
+			compressed:   int64(len(header) + compressed.Len()),
+			uncompressed: int64(len(header) + len(data)),
+		}
+		total += chunks[i].uncompressed
+		out.Write(header)
+		out.Write(compressed.Bytes())
+	}
+
+	m := newThriftWriter()
+	m.i32(1, parquetVersion)
+	m.listBegin(2, thriftStruct, len(columns)+1)
+	m.elemBegin()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.endStruct()
+	for _, c := range columns {
+		m.elemBegin()
+		m.i32(1, c.physical)
+		if c.optional {
+			m.i32(3, parquetOptional)
+		} else {
+			m.i32(3, parquetRequired)
+		}
+		m.binary(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, c.converted)
+		}
+		m.endStruct()
+	}
+	m.i64(3, int64(len(rows)))
+	m.listBegin(4, thriftStruct, 1)
+	m.elemBegin()
+	m.listBegin(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		m.elemBegin()
+		m.i64(2, chunks[i].offset)
+		m.beginStruct(3)
+		m.i32(1, c.physical)
+		m.listBegin(2, thriftI32, 2)
+		m.varint(uint64(zigzag(parquetPlain)))
+		m.varint(uint64(zigzag(parquetRLE)))
+		m.listBegin(3, thriftBinary, 1)
+		m.rawBinary(c.name)
+		m.i32(4, parquetGzip)
+		m.i64(5, int64(len(rows)))
+		m.i64(6, chunks[i].uncompressed)
+		m.i64(7, chunks[i].compressed)
+		m.i64(9, chunks[i].offset)
+		m.endStruct()
+		m.endStruct()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(len(rows)))
+	m.endStruct()
+	m.binary(6, "cpa-logger")
+	footer := m.finish()
+
+	out.Write(footer)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	out.Write(size[:])
+	out.WriteString(parquetMagic)
+	return out.Bytes(), nil
+}
+
+// Thrift compact 协议的类型
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter 以 Thrift compact 协议编码结构体（Parquet 的页头和文件元数据），字段须按 id 递增写入
+type thriftWriter struct {
+	buf bytes.Buffer
+	// 各层结构体上一个字段的 id
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func zigzag(v int64) int64 {
+	return (v << 1) ^ (v >> 63)
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	top := len(w.last) - 1
+	if delta := id - w.last[top]; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(uint64(zigzag(int64(id))))
+	}
+	w.last[top] = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(uint64(zigzag(int64(v))))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(uint64(zigzag(v)))
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.rawBinary(s)
+}
+
+// rawBinary 写入不带字段头的字符串（列表元素）
+func (w *thriftWriter) rawBinary(s string) {
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.last = append(w.last, 0)
+}
+
+// elemBegin 开始列表中的一个结构体元素
+func (w *thriftWriter) elemBegin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) listBegin(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elemType)
+	w.varint(uint64(n))
+}
+
+// finish 结束最外层结构体并返回编码结果
+func (w *thriftWriter) finish() []byte {
+	w.buf.WriteByte(0)
+	return w.buf.Bytes()
+}
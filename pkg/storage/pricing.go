@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// CreateModelPricingTable 创建模型价格表，同一模型可按生效日期保存多条价格，不设 TTL
+func (s *ClickHouseStorage) CreateModelPricingTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			model String,
+			input_price Float64,
+			output_price Float64,
+			cache_read_price Float64,
+			cache_write_price Float64,
+			effective_date Date,
+			updated_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		ORDER BY (model, effective_date)
+	`, s.table("model_pricing"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create model_pricing table: %w", err)
+	}
+	return nil
+}
+
+// RefreshModelPricing 用 prices 替换 model_pricing 表的内容：写入全部价格后删除此前写入的行
+// （包括价格文件中已删除的模型），删除完成前查询 FINAL 时同一模型和日期读取新写入的价格
+func (s *ClickHouseStorage) RefreshModelPricing(ctx context.Context, prices []config.ModelPricing) error {
+	table := s.table("model_pricing")
+	refreshed := time.Now()
+	values := make([][]interface{}, 0, len(prices))
+	for _, p := range prices {
+		var date time.Time
+		if p.EffectiveDate != "" {
+			date, _ = time.Parse("2006-01-02", p.EffectiveDate)
+		}
+		values = append(values, []interface{}{p.Model, p.Input, p.Output, p.CacheRead, p.CacheWrite, date, refreshed})
+	}
+	if len(values) > 0 {
+		query := fmt.Sprintf("INSERT INTO %s (model, input_price, output_price, cache_read_price, cache_write_price, effective_date, updated_at) VALUES", table)
+		if err := s.sendBatch(ctx, query, values); err != nil {
+			return fmt.Errorf("failed to write model pricing: %w", err)
+		}
+	}
+
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 1,
+	}))
+	if err := s.conn.Exec(ctx, s.clusterDDL(fmt.Sprintf("ALTER TABLE %s DELETE WHERE updated_at < ?", table)), refreshed); err != nil {
+		return fmt.Errorf("failed to delete old model pricing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// processedRecord 已处理文件的记录
+type processedRecord struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	MTime       time.Time `json:"mtime"`
+	Inode       uint64    `json:"inode"`
+	RecordCount uint32    `json:"record_count"`
+	// 尚未写入 processed_files（state_store 使用），重启后继续重试
+	Pending bool `json:"pending,omitempty"`
+	// 非空时该行不是记录而是对记录的操作：synced（已写入 processed_files）、forget（删除该路径的记录）
+	Op string `json:"op,omitempty"`
+}
+
+// 记录操作
+const (
+	processedOpSynced = "synced"
+	processedOpForget = "forget"
+)
+
+// same 是否为同一次处理的记录
+func (r processedRecord) same(o processedRecord) bool {
+	return r.Size == o.Size && r.MTime.Equal(o.MTime) && r.Inode == o.Inode && r.RecordCount == o.RecordCount
+}
+
+// 文件行数超过 compactMinLines 且超过有效记录数的 compactRatio 倍时需要压缩
+const (
+	compactMinLines = 10000
+	compactRatio    = 2
+)
+
+// processedLog 记录在本地 JSON lines 文件中的已处理文件列表，供不能查询已写入数据的后端使用。
+// 内存中每个路径只保留最近一次处理的记录和尚未写入 processed_files 的记录；文件只追加，由 compact 重写
+type processedLog struct {
+	mu      sync.Mutex
+	records map[string][]processedRecord
+	file    *os.File
+	// 文件中的行数（包括被取代的记录和操作行）
+	lines int
+}
+
+// openProcessedLog 加载已有记录并以追加方式打开文件，目录不存在时创建；path 为空时只记录在内存中
+func openProcessedLog(path string) (*processedLog, error) {
+	l := &processedLog{records: make(map[string][]processedRecord)}
+	if path == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r processedRecord
+			l.lines++
+			// 写入中断时最后一行可能不完整，跳过
+			if json.Unmarshal(scanner.Bytes(), &r) == nil {
+				l.apply(r)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// apply 将一行记录或操作应用到内存中的记录，只保留该路径最近一次处理的记录和尚未写入的记录
+func (l *processedLog) apply(r processedRecord) {
+	switch r.Op {
+	case processedOpSynced:
+		for i, old := range l.records[r.Path] {
+			if old.same(r) {
+				l.records[r.Path][i].Pending = false
+			}
+		}
+	case processedOpForget:
+		delete(l.records, r.Path)
+		return
+	default:
+		l.records[r.Path] = append(l.records[r.Path], r)
+	}
+	records := l.records[r.Path]
+	kept := records[:0]
+	for i, old := range records {
+		if old.Pending || i == len(records)-1 {
+			kept = append(kept, old)
+		}
+	}
+	if len(kept) == 0 {
+		delete(l.records, r.Path)
+	} else {
+		l.records[r.Path] = kept
+	}
+}
+
+// add 追加一条记录
+func (l *processedLog) add(r processedRecord) error {
+	return l.write(r)
+}
+
+// markSynced 记录 r 已写入 processed_files
+func (l *processedLog) markSynced(r processedRecord) error {
+	r.Op, r.Pending = processedOpSynced, false
+	return l.write(r)
+}
+
+// forget 删除该路径的全部记录，之后该文件按未处理的文件处理
+func (l *processedLog) forget(filePath string) error {
+	return l.write(processedRecord{Path: filePath, Op: processedOpForget})
+}
+
+// write 追加一行并应用到内存中的记录
+func (l *processedLog) write(r processedRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to record processed file: %w", err)
+		}
+		l.lines++
+	}
+	l.apply(r)
+	return nil
+}
+
+// needsCompact 文件中被取代的记录和操作行是否已远多于有效记录
+func (l *processedLog) needsCompact() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil || l.lines < compactMinLines {
+		return false
+	}
+	live := 0
+	for _, rs := range l.records {
+		live += len(rs)
+	}
+	return l.lines > live*compactRatio
+}
+
+// pending 返回尚未写入 processed_files 的记录，按文件修改时间排列
+func (l *processedLog) pending() []processedRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var pending []processedRecord
+	for _, records := range l.records {
+		for _, r := range records {
+			if r.Pending {
+				pending = append(pending, r)
+			}
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].MTime.Before(pending[j].MTime) })
+	return pending
+}
+
+// compact 按内存中的记录重写文件（每个路径只有最近一次处理的记录和尚未写入 processed_files 的记录），
+// 去掉被取代的记录和操作行，返回去掉的行数；keep 不为 nil 时只保留 keep 返回 true 的记录。
+// 先写入临时文件再替换，中途失败时原文件不变
+func (l *processedLog) compact(keep func(processedRecord) bool) (removed int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return 0, nil
+	}
+	path := l.file.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	records := make(map[string][]processedRecord, len(l.records))
+	lines := 0
+	w := bufio.NewWriter(tmp)
+	for p, rs := range l.records {
+		for _, r := range rs {
+			if keep != nil && !keep(r) {
+				continue
+			}
+			lines++
+			line, err := json.Marshal(r)
+			if err != nil {
+				tmp.Close()
+				return 0, err
+			}
+			w.Write(append(line, '\n'))
+			records[p] = append(records[p], r)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	l.file.Close()
+	l.file = file
+	// 被取代的记录已在 apply 时从内存中去掉，按文件中减少的行数计算
+	removed = l.lines - lines
+	l.records = records
+	l.lines = lines
+	return removed, nil
+}
+
+// contains 与 ClickHouseStorage.IsFileProcessed 相同：路径、大小和修改时间一致，inode 一致或未知
+func (l *processedLog) contains(filePath string, fileSize int64, mtime time.Time, inode uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records[filePath] {
+		if r.Size == fileSize && r.MTime.Equal(mtime) && (r.Inode == inode || r.Inode == 0 || inode == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// last 返回该路径最近一次处理的记录
+func (l *processedLog) last(filePath string) (ProcessedFile, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := l.records[filePath]
+	if len(records) == 0 {
+		return ProcessedFile{}, false
+	}
+	r := records[len(records)-1]
+	return ProcessedFile{Size: r.Size, Inode: r.Inode, RecordCount: r.RecordCount}, true
+}
+
+func (l *processedLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
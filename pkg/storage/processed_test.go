@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testRecord(path string, size int64, pending bool) processedRecord {
+	return processedRecord{
+		Path:        path,
+		Size:        size,
+		MTime:       time.Date(2026, 1, 8, 0, 0, int(size), 0, time.UTC),
+		Inode:       1,
+		RecordCount: uint32(size),
+		Pending:     pending,
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestProcessedLogReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(l *processedLog) error
+		// 重新打开后每个路径在内存中的记录大小
+		want map[string][]int64
+		// 重新打开后的未同步记录数
+		pending int
+	}{
+		{
+			name: "latest record only",
+			ops: func(l *processedLog) error {
+				for size := int64(1); size <= 3; size++ {
+					if err := l.add(testRecord("a.log", size, false)); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			want: map[string][]int64{"a.log": {3}},
+		},
+		{
+			name: "pending records kept until synced",
+			ops: func(l *processedLog) error {
+				if err := l.add(testRecord("a.log", 1, true)); err != nil {
+					return err
+				}
+				return l.add(testRecord("a.log", 2, true))
+			},
+			want:    map[string][]int64{"a.log": {1, 2}},
+			pending: 2,
+		},
+		{
+			name: "synced record superseded",
+			ops: func(l *processedLog) error {
+				if err := l.add(testRecord("a.log", 1, true)); err != nil {
+					return err
+				}
+				if err := l.add(testRecord("a.log", 2, true)); err != nil {
+					return err
+				}
+				return l.markSynced(testRecord("a.log", 1, true))
+			},
+			want:    map[string][]int64{"a.log": {2}},
+			pending: 1,
+		},
+		{
+			name: "forget",
+			ops: func(l *processedLog) error {
+				if err := l.add(testRecord("a.log", 1, false)); err != nil {
+					return err
+				}
+				if err := l.add(testRecord("b.log", 1, true)); err != nil {
+					return err
+				}
+				return l.forget("b.log")
+			},
+			want: map[string][]int64{"a.log": {1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.jsonl")
+			l, err := openProcessedLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.ops(l); err != nil {
+				t.Fatal(err)
+			}
+			l.Close()
+
+			l, err = openProcessedLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if len(l.records) != len(tt.want) {
+				t.Fatalf("records = %v, want %v", l.records, tt.want)
+			}
+			for p, sizes := range tt.want {
+				rs := l.records[p]
+				if len(rs) != len(sizes) {
+					t.Fatalf("records[%s] = %v, want sizes %v", p, rs, sizes)
+				}
+				for i, size := range sizes {
+					if rs[i].Size != size {
+						t.Errorf("records[%s][%d].Size = %d, want %d", p, i, rs[i].Size, size)
+					}
+				}
+			}
+			if got := len(l.pending()); got != tt.pending {
+				t.Errorf("pending = %d, want %d", got, tt.pending)
+			}
+		})
+	}
+}
+
+func TestProcessedLogCompact(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []processedRecord
+		keep    func(processedRecord) bool
+		removed int
+		lines   int
+	}{
+		{
+			name: "superseded and op lines",
+			records: []processedRecord{
+				testRecord("a.log", 1, false),
+				testRecord("a.log", 2, false),
+				testRecord("b.log", 1, true),
+				{Path: "b.log", Size: 1, MTime: testRecord("", 1, false).MTime, Inode: 1, RecordCount: 1, Op: processedOpSynced},
+			},
+			removed: 2,
+			lines:   2,
+		},
+		{
+			name: "pending kept",
+			records: []processedRecord{
+				testRecord("a.log", 1, true),
+				testRecord("a.log", 2, true),
+			},
+			lines: 2,
+		},
+		{
+			name: "keep filter",
+			records: []processedRecord{
+				testRecord("a.log", 1, false),
+				testRecord("b.log", 5, false),
+			},
+			keep:    func(r processedRecord) bool { return r.Size > 1 },
+			removed: 1,
+			lines:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.jsonl")
+			l, err := openProcessedLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			for _, r := range tt.records {
+				if err := l.write(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			removed, err := l.compact(tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed = %d, want %d", removed, tt.removed)
+			}
+			if got := countLines(t, path); got != tt.lines {
+				t.Errorf("file lines = %d, want %d", got, tt.lines)
+			}
+			// 压缩后继续追加
+			if err := l.add(testRecord("c.log", 1, false)); err != nil {
+				t.Fatal(err)
+			}
+			if got := countLines(t, path); got != tt.lines+1 {
+				t.Errorf("file lines after append = %d, want %d", got, tt.lines+1)
+			}
+		})
+	}
+}
+
+func TestProcessedLogNeedsCompact(t *testing.T) {
+	tests := []struct {
+		name string
+		// 同一路径重复写入的次数
+		writes int
+		want   bool
+	}{
+		{name: "below min lines", writes: compactMinLines - 1, want: false},
+		{name: "superseded records", writes: compactMinLines, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := openProcessedLog(filepath.Join(t.TempDir(), "state.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			for i := 0; i < tt.writes; i++ {
+				if err := l.add(testRecord("a.log", int64(i), false)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := l.needsCompact(); got != tt.want {
+				t.Fatalf("needsCompact = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if _, err := l.compact(nil); err != nil {
+				t.Fatal(err)
+			}
+			if l.needsCompact() {
+				t.Error("needsCompact after compact = true")
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestFilter API 请求日志的查询条件，零值表示不过滤
+type RequestFilter struct {
+	Model  string
+	Status int
+	// 状态码类别，如 5 表示 5xx
+	StatusClass int
+	LogType     string
+	// 客户端 IP，通过 main_logs 中同一 request_id 的记录匹配
+	ClientIP string
+	Host     string
+	// 为 nil 时不过滤
+	Streamed *bool
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// ParseStatus 解析状态码条件：具体状态码（如 529）或类别（如 5xx）
+func ParseStatus(v string) (status, class int, err error) {
+	if len(v) == 3 && strings.HasSuffix(strings.ToLower(v), "xx") && v[0] >= '1' && v[0] <= '5' {
+		return 0, int(v[0] - '0'), nil
+	}
+	status, err = strconv.Atoi(v)
+	if err != nil || status < 100 || status > 599 {
+		return 0, 0, fmt.Errorf("invalid status: %s (use a code like 529 or a class like 5xx)", v)
+	}
+	return status, 0, nil
+}
+
+// RequestSummary API 请求日志的摘要
+type RequestSummary struct {
+	LogType        string    `json:"log_type"`
+	RequestID      string    `json:"request_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	Model          string    `json:"model"`
+	URL            string    `json:"url"`
+	Method         string    `json:"method"`
+	ResponseStatus uint16    `json:"response_status"`
+	Streamed       bool      `json:"streamed"`
+	Host           string    `json:"host"`
+	LogFile        string    `json:"log_file"`
+}
+
+// APILogRecord 完整的 API 请求日志
+type APILogRecord struct {
+	RequestSummary
+	Headers          json.RawMessage `json:"headers"`
+	RequestBody      string          `json:"request_body"`
+	ResponseHeaders  json.RawMessage `json:"response_headers"`
+	ResponseBody     string          `json:"response_body"`
+	FullResponse     string          `json:"full_response,omitempty"`
+	UpstreamRequests json.RawMessage `json:"upstream_requests,omitempty"`
+}
+
+// MainLogRecord main 日志中的一行
+type MainLogRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Level      string    `json:"level"`
+	Source     string    `json:"source"`
+	Message    string    `json:"message"`
+	StatusCode uint16    `json:"status_code,omitempty"`
+	Latency    string    `json:"latency,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Host       string    `json:"host"`
+}
+
+// EventRecord 事件批量日志中的一个事件
+type EventRecord struct {
+	RequestID string          `json:"request_id"`
+	Timestamp time.Time       `json:"timestamp"`
+	EventType string          `json:"event_type"`
+	EventName string          `json:"event_name"`
+	SessionID string          `json:"session_id"`
+	Model     string          `json:"model"`
+	Platform  string          `json:"platform"`
+	DeviceID  string          `json:"device_id"`
+	EventData json.RawMessage `json:"event_data"`
+	Host      string          `json:"host"`
+	LogFile   string          `json:"log_file"`
+}
+
+// RequestDetail 同一 request_id 的全部日志
+type RequestDetail struct {
+	RequestID string          `json:"request_id"`
+	APILogs   []APILogRecord  `json:"api_logs"`
+	MainLogs  []MainLogRecord `json:"main_logs"`
+}
+
+// readTable 返回查询使用的表：启用 body 去重时 api_logs 读取还原 body 的视图，
+// 启用 Distributed 表时读取 <表>_all（汇总各分片），启用 Buffer 时读取 Buffer 表（同时包含未落盘的数据）
+func (s *ClickHouseStorage) readTable(table string) string {
+	t := s.tables[table]
+	if table == "api_logs" && s.bodies != nil && !t.mapped {
+		return s.table("api_logs_resolved")
+	}
+	if s.cluster.Distributed && !t.mapped {
+		return t.fullName() + "_all"
+	}
+	if s.staged {
+		return t.fullName()
+	}
+	return s.insertTable(table)
+}
+
+// selectColumn 返回读取字段的 SELECT 表达式，mapped 表中未映射的字段读取为 fallback
+func (t *tableSchema) selectColumn(field, fallback string) string {
+	if col := t.column(field); col != "" && t.mapColumns[col] {
+		return fmt.Sprintf("toJSONString(`%s`) AS `%s`", col, field)
+	} else if col != "" {
+		return fmt.Sprintf("`%s` AS `%s`", col, field)
+	}
+	return fmt.Sprintf("%s AS `%s`", fallback, field)
+}
+
+// modelColumn 读取模型名的 SELECT 表达式：优先读取 model 列（升级前写入的行由列默认值从请求体提取），
+// 未映射 model 列的 mapped 表从请求体中提取
+func (t *tableSchema) modelColumn() string {
+	if col := t.column("model"); col != "" {
+		return fmt.Sprintf("`%s` AS `model`", col)
+	}
+	if col := t.column("request_body"); col != "" {
+		return fmt.Sprintf("JSONExtractString(`%s`, 'model') AS `model`", col)
+	}
+	return "'' AS `model`"
+}
+
+func (t *tableSchema) summaryColumns() []string {
+	return []string{
+		t.selectColumn("log_type", "''"),
+		t.selectColumn("request_id", "''"),
+		t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		t.modelColumn(),
+		t.selectColumn("url", "''"),
+		t.selectColumn("method", "''"),
+		t.selectColumn("response_status", "toUInt16(0)"),
+		t.selectColumn("streamed", "toUInt8(0)"),
+		t.selectColumn("host", "''"),
+		t.selectColumn("log_file", "''"),
+	}
+}
+
+// requestWhere 构造 RequestFilter 对应的 WHERE 子句
+func (s *ClickHouseStorage) requestWhere(f RequestFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.Model != "" {
+		where = append(where, "`model` = ?")
+		args = append(args, f.Model)
+	}
+	if f.Status != 0 {
+		where = append(where, "`response_status` = ?")
+		args = append(args, f.Status)
+	}
+	if f.StatusClass != 0 {
+		where = append(where, "intDiv(`response_status`, 100) = ?")
+		args = append(args, f.StatusClass)
+	}
+	if f.LogType != "" {
+		where = append(where, "`log_type` = ?")
+		args = append(args, f.LogType)
+	}
+	if f.Host != "" {
+		where = append(where, "`host` = ?")
+		args = append(args, f.Host)
+	}
+	if f.Streamed != nil {
+		where = append(where, "`streamed` = ?")
+		args = append(args, boolToUInt8(*f.Streamed))
+	}
+	if f.ClientIP != "" {
+		m := s.tables["main_logs"]
+		if idCol, ipCol := m.column("request_id"), m.column("client_ip"); idCol != "" && ipCol != "" {
+			where = append(where, fmt.Sprintf("`request_id` IN (SELECT `%s` FROM %s WHERE `%s` = ?)",
+				idCol, s.readTable("main_logs"), ipCol))
+			args = append(args, f.ClientIP)
+		} else {
+			// main_logs 中没有客户端 IP，无法匹配
+			where = append(where, "0")
+		}
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "`timestamp` >= ?")
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "`timestamp` < ?")
+		args = append(args, f.Until)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// latestAPILogs 读取 api_logs 的子查询：合并前同一请求（排序键 timestamp、request_id、log_type 相同）的多行
+// 只保留最后写入的一行，结果与 FINAL 相同，也适用于视图、Buffer 和 Distributed 表。
+// where 在去重前过滤，只能使用排序键中的列，其他条件在子查询外过滤
+func (s *ClickHouseStorage) latestAPILogs(cols []string, where string) string {
+	order := ""
+	if col := s.tables["api_logs"].column("inserted_at"); col != "" {
+		order = fmt.Sprintf(" ORDER BY `%s` DESC", col)
+	}
+	return fmt.Sprintf("(SELECT %s FROM %s%s%s LIMIT 1 BY `timestamp`, `request_id`, `log_type`)",
+		strings.Join(cols, ", "), s.readTable("api_logs"), where, order)
+}
+
+// timeWhere RequestFilter 的时间范围条件，同一请求的各行时间戳相同，可在去重前过滤
+func (s *ClickHouseStorage) timeWhere(f RequestFilter) (string, []interface{}) {
+	return s.requestWhere(RequestFilter{Since: f.Since, Until: f.Until})
+}
+
+// SearchRequests 按条件查询 API 请求日志摘要，按时间倒序
+func (s *ClickHouseStorage) SearchRequests(ctx context.Context, f RequestFilter) ([]RequestSummary, error) {
+	t := s.tables["api_logs"]
+	inner, args := s.timeWhere(f)
+	where, whereArgs := s.requestWhere(f)
+	query := fmt.Sprintf("SELECT * FROM %s%s ORDER BY `timestamp` DESC LIMIT %d",
+		s.latestAPILogs(t.summaryColumns(), inner), where, f.Limit)
+	args = append(args, whereArgs...)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []RequestSummary{}
+	for rows.Next() {
+		var r RequestSummary
+		var streamed uint8
+		if err := rows.Scan(&r.LogType, &r.RequestID, &r.Timestamp, &r.Model,
+			&r.URL, &r.Method, &r.ResponseStatus, &streamed, &r.Host, &r.LogFile); err != nil {
+			return nil, err
+		}
+		r.Streamed = streamed == 1
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// CountRequests 统计满足条件的 API 请求数
+func (s *ClickHouseStorage) CountRequests(ctx context.Context, f RequestFilter) (uint64, error) {
+	t := s.tables["api_logs"]
+	inner, args := s.timeWhere(f)
+	where, whereArgs := s.requestWhere(f)
+	query := fmt.Sprintf("SELECT count() FROM %s%s", s.latestAPILogs(t.summaryColumns(), inner), where)
+	args = append(args, whereArgs...)
+
+	var count uint64
+	if err := s.conn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetRequest 查询 request_id 对应的 API 日志和 main 日志
+// includeMain 为 false 时不查询 main 日志（如 main 日志写入了 VictoriaLogs）
+func (s *ClickHouseStorage) GetRequest(ctx context.Context, requestID string, includeMain bool) (*RequestDetail, error) {
+	detail := &RequestDetail{
+		RequestID: requestID,
+		MainLogs:  []MainLogRecord{},
+	}
+	var err error
+	if detail.APILogs, err = s.GetAPILogByRequestID(ctx, requestID); err != nil {
+		return nil, err
+	}
+	if !includeMain {
+		return detail, nil
+	}
+	if detail.MainLogs, err = s.GetMainLogsByRequestID(ctx, requestID); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// GetAPILogByRequestID 查询 request_id 对应的 API 日志（重试时可能有多条），按时间排序，body 已解密
+func (s *ClickHouseStorage) GetAPILogByRequestID(ctx context.Context, requestID string) ([]APILogRecord, error) {
+	t := s.tables["api_logs"]
+	cols := append(t.summaryColumns(),
+		t.selectColumn("headers", "''"),
+		t.selectColumn("request_body", "''"),
+		t.selectColumn("response_headers", "''"),
+		t.selectColumn("response_body", "''"),
+		t.selectColumn("full_response", "''"),
+		t.selectColumn("upstream_requests", "''"),
+	)
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(
+		"SELECT * FROM %s ORDER BY `timestamp`",
+		s.latestAPILogs(cols, " WHERE `request_id` = ?")), requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []APILogRecord{}
+	for rows.Next() {
+		var r APILogRecord
+		var headers, respHeaders, upstream string
+		var streamed uint8
+		if err := rows.Scan(&r.LogType, &r.RequestID, &r.Timestamp, &r.Model,
+			&r.URL, &r.Method, &r.ResponseStatus, &streamed, &r.Host, &r.LogFile,
+			&headers, &r.RequestBody, &respHeaders, &r.ResponseBody, &r.FullResponse, &upstream); err != nil {
+			return nil, err
+		}
+		if err := s.decryptBodies(&r.RequestBody, &r.ResponseBody, &r.FullResponse); err != nil {
+			return nil, err
+		}
+		if r.Model == "" && s.cipher != nil {
+			// 加密的请求体无法在 SQL 中提取模型名，解密后再提取
+			r.Model = bodyModel(r.RequestBody)
+		}
+		r.Streamed = streamed == 1
+		r.Headers = rawJSON(headers)
+		r.ResponseHeaders = rawJSON(respHeaders)
+		r.UpstreamRequests = rawJSON(upstream)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetMainLogsByRequestID 查询 request_id 对应的 main 日志行，按时间排序
+func (s *ClickHouseStorage) GetMainLogsByRequestID(ctx context.Context, requestID string) ([]MainLogRecord, error) {
+	m := s.tables["main_logs"]
+	cols := []string{
+		m.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		m.selectColumn("level", "''"),
+		m.selectColumn("source", "''"),
+		m.selectColumn("message", "''"),
+		m.selectColumn("status_code", "toUInt16(0)"),
+		m.selectColumn("latency", "''"),
+		m.selectColumn("client_ip", "''"),
+		m.selectColumn("method", "''"),
+		m.selectColumn("path", "''"),
+		m.selectColumn("host", "''"),
+	}
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(
+		"SELECT %s FROM %s WHERE `request_id` = ? ORDER BY `timestamp`",
+		strings.Join(cols, ", "), s.readTable("main_logs")), requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []MainLogRecord{}
+	for rows.Next() {
+		var r MainLogRecord
+		if err := rows.Scan(&r.Timestamp, &r.Level, &r.Source, &r.Message, &r.StatusCode,
+			&r.Latency, &r.ClientIP, &r.Method, &r.Path, &r.Host); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetEventsBySession 查询 session_id 对应的事件，按时间排序
+func (s *ClickHouseStorage) GetEventsBySession(ctx context.Context, sessionID string) ([]EventRecord, error) {
+	t := s.tables["event_logs"]
+	if t.column("session_id") == "" {
+		return nil, fmt.Errorf("session_id is not mapped in %s", t.fullName())
+	}
+	cols := []string{
+		t.selectColumn("request_id", "''"),
+		t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		t.selectColumn("event_type", "''"),
+		t.selectColumn("event_name", "''"),
+		t.selectColumn("session_id", "''"),
+		t.selectColumn("model", "''"),
+		t.selectColumn("platform", "''"),
+		t.selectColumn("device_id", "''"),
+		t.selectColumn("event_data", "''"),
+		t.selectColumn("host", "''"),
+		t.selectColumn("log_file", "''"),
+	}
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(
+		"SELECT %s FROM %s WHERE `%s` = ? ORDER BY `timestamp`",
+		strings.Join(cols, ", "), s.readTable("event_logs"), t.column("session_id")), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []EventRecord{}
+	for rows.Next() {
+		var r EventRecord
+		var data string
+		if err := rows.Scan(&r.RequestID, &r.Timestamp, &r.EventType, &r.EventName, &r.SessionID,
+			&r.Model, &r.Platform, &r.DeviceID, &data, &r.Host, &r.LogFile); err != nil {
+			return nil, err
+		}
+		r.EventData = rawJSON(data)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// rawJSON 将存储的 JSON 字符串原样输出，空值或非法 JSON 输出为 null
+func rawJSON(s string) json.RawMessage {
+	if s == "" || !json.Valid([]byte(s)) {
+		return nil
+	}
+	return json.RawMessage(s)
+}
+
+// RequestLogFiles 返回 request_id 对应的 API、embeddings 和事件日志文件
+// （main 日志包含多个请求，不在其中）
+func (s *ClickHouseStorage) RequestLogFiles(ctx context.Context, requestID string) ([]string, error) {
+	var parts []string
+	var args []interface{}
+	for _, name := range []string{"api_logs", "embedding_logs", "event_logs"} {
+		t := s.tables[name]
+		fileCol, idCol := t.column("log_file"), t.column("request_id")
+		if fileCol == "" || idCol == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("SELECT `%s` AS f FROM %s WHERE `%s` = ?",
+			fileCol, s.readTable(name), idCol))
+		args = append(args, requestID)
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.conn.Query(ctx, fmt.Sprintf("SELECT DISTINCT f FROM (%s) ORDER BY f",
+		strings.Join(parts, " UNION ALL ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 重放队列中请求的状态
+const (
+	ReplayPending   = "pending"
+	ReplaySucceeded = "succeeded"
+	ReplayFailed    = "failed"
+)
+
+// ReplayItem 重放队列中的一个失败请求
+type ReplayItem struct {
+	RequestID    string            `json:"request_id"`
+	LogType      string            `json:"log_type"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Method       string            `json:"method"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`
+	RequestBody  string            `json:"request_body,omitempty"`
+	FailedStatus uint16            `json:"failed_status"`
+	State        string            `json:"state"`
+	Attempts     uint16            `json:"attempts"`
+	LastStatus   uint16            `json:"last_status,omitempty"`
+	LastError    string            `json:"last_error,omitempty"`
+	ReplayedAt   *time.Time        `json:"replayed_at,omitempty"`
+}
+
+// ReplayFilter 重放队列查询条件
+type ReplayFilter struct {
+	// 为空时不限状态
+	State      string
+	RequestIDs []string
+	Limit      int
+}
+
+// CreateReplayQueueTable 创建失败请求重放队列表，每次重放以新版本行记录结果
+func (s *ClickHouseStorage) CreateReplayQueueTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			request_id String,
+			log_type LowCardinality(String),
+			timestamp DateTime64(3),
+			method LowCardinality(String),
+			url String,
+			headers String,
+			request_body String,
+			failed_status UInt16,
+			state LowCardinality(String),
+			attempts UInt16,
+			last_status UInt16,
+			last_error String,
+			replayed_at Nullable(DateTime64(3)),
+			host LowCardinality(String),
+			instance LowCardinality(String),
+			updated_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		ORDER BY (request_id, log_type)
+		TTL toDateTime(timestamp) + INTERVAL 30 DAY
+	`, s.table("replay_queue"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create replay_queue table: %w", err)
+	}
+	return nil
+}
+
+// EnqueueReplay 将失败的请求加入重放队列，已在队列中的请求（如重新处理日志文件时）不重复加入
+func (s *ClickHouseStorage) EnqueueReplay(ctx context.Context, entry *parser.APILogEntry) error {
+	var exists uint64
+	if err := s.conn.QueryRow(ctx, fmt.Sprintf(
+		"SELECT count() FROM %s WHERE request_id = ? AND log_type = ?", s.table("replay_queue")),
+		entry.RequestID, string(entry.LogType)).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	headers, err := json.Marshal(entry.Headers)
+	if err != nil {
+		return err
+	}
+	return s.insertReplay(ctx, ReplayItem{
+		RequestID:    entry.RequestID,
+		LogType:      string(entry.LogType),
+		Timestamp:    entry.Timestamp,
+		Method:       entry.Method,
+		URL:          entry.URL,
+		RequestBody:  entry.RequestBody,
+		FailedStatus: uint16(entry.ResponseStatus),
+		State:        ReplayPending,
+	}, string(headers))
+}
+
+// RecordReplay 记录一次重放的结果
+func (s *ClickHouseStorage) RecordReplay(ctx context.Context, item ReplayItem) error {
+	headers, err := json.Marshal(item.Headers)
+	if err != nil {
+		return err
+	}
+	return s.insertReplay(ctx, item, string(headers))
+}
+
+func (s *ClickHouseStorage) insertReplay(ctx context.Context, item ReplayItem, headers string) error {
+	if err := s.encryptBodies(&item.RequestBody); err != nil {
+		return err
+	}
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+		(request_id, log_type, timestamp, method, url, headers, request_body, failed_status,
+		 state, attempts, last_status, last_error, replayed_at, host, instance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.table("replay_queue")),
+		item.RequestID, item.LogType, item.Timestamp, item.Method, item.URL, headers, item.RequestBody,
+		item.FailedStatus, item.State, item.Attempts, item.LastStatus, item.LastError, item.ReplayedAt,
+		s.labels.Host, s.labels.Instance)
+}
+
+// ReplayQueue 按条件查询重放队列中各请求的最新状态，按请求时间排序
+func (s *ClickHouseStorage) ReplayQueue(ctx context.Context, f ReplayFilter) ([]ReplayItem, error) {
+	var conds []string
+	var args []interface{}
+	if f.State != "" {
+		conds = append(conds, "state = ?")
+		args = append(args, f.State)
+	}
+	if len(f.RequestIDs) > 0 {
+		conds = append(conds, "has(?, request_id)")
+		args = append(args, f.RequestIDs)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	limit := ""
+	if f.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(
+		"SELECT request_id, log_type, timestamp, method, url, headers, request_body, failed_status, "+
+			"state, attempts, last_status, last_error, replayed_at FROM %s FINAL%s ORDER BY timestamp%s",
+		s.table("replay_queue"), where, limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []ReplayItem
+	for rows.Next() {
+		var item ReplayItem
+		var headers string
+		if err := rows.Scan(&item.RequestID, &item.LogType, &item.Timestamp, &item.Method, &item.URL, &headers,
+			&item.RequestBody, &item.FailedStatus, &item.State, &item.Attempts, &item.LastStatus,
+			&item.LastError, &item.ReplayedAt); err != nil {
+			return nil, err
+		}
+		if err := s.decryptBodies(&item.RequestBody); err != nil {
+			return nil, fmt.Errorf("replay %s: %w", item.RequestID, err)
+		}
+		if err := json.Unmarshal([]byte(headers), &item.Headers); err != nil {
+			item.Headers = map[string]string{}
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
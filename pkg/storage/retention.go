@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// bodyColumns 各表的 body 列（upstream_requests 中包含上游请求和响应的 body），
+// 使用 bodyCodec 压缩，body_days 为这些列设置列 TTL
+var bodyColumns = map[string][]string{
+	"api_logs":       {"request_body", "response_body", "full_response", "upstream_requests"},
+	"embedding_logs": {"request_body", "error_body"},
+}
+
+// bodyCodec body 列的压缩方式，与建表语句一致
+const bodyCodec = "CODEC(ZSTD(3))"
+
+// ttlDays 返回建表语句中 TTL toDateTime(timestamp) + INTERVAL n DAY 的天数（ClickHouse 改写为 toIntervalDay(n)），
+// column 为空时为表 TTL，否则为该列的列 TTL；未设置时返回 0
+func ttlDays(createQuery, column string) int {
+	pattern := `ENGINE = .*? TTL toDateTime\(timestamp\) \+ toIntervalDay\((\d+)\)`
+	if column != "" {
+		pattern = "`?" + regexp.QuoteMeta(column) + "`? String (?:CODEC\\((?:[^()]|\\([^()]*\\))*\\) )?TTL toDateTime\\(timestamp\\) \\+ toIntervalDay\\((\\d+)\\)"
+	}
+	m := regexp.MustCompile(pattern).FindStringSubmatch(createQuery)
+	if m == nil {
+		return 0
+	}
+	var days int
+	fmt.Sscan(m[1], &days)
+	return days
+}
+
+// retentionTable 返回保留策略作用的表，用户维护的表（mapped 模式）返回 false
+func (s *ClickHouseStorage) retentionTable(name string) (string, bool) {
+	if t, ok := s.tables[name]; ok {
+		return t.fullName(), !t.mapped
+	}
+	return s.table(name), true
+}
+
+// physicalName 返回表在数据库中的表名（table_names 指定的表名或带 table_prefix）
+func (s *ClickHouseStorage) physicalName(name string) string {
+	if t, ok := s.tables[name]; ok {
+		return t.table
+	}
+	return s.tableName(name)
+}
+
+// ApplyRetention 将各表的 TTL 改为保留天数，body_days 大于 0 时为 body 列设置列 TTL；
+// 只修改元数据，不重写已有的 part（过期数据由 DropExpiredPartitions 和后台合并清理）。
+// 不存在的表和 TTL 已是保留天数的表、列跳过
+func (s *ClickHouseStorage) ApplyRetention(ctx context.Context, r *config.RetentionConfig) error {
+	existing, err := s.existingTables(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"materialize_ttl_after_modify": 0,
+	}))
+
+	names := make([]string, 0, len(r.Tables))
+	for name := range r.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		table, ok := s.retentionTable(name)
+		createQuery, exists := existing[s.physicalName(name)]
+		if !ok || !exists {
+			log.Printf("Retention: skipping table %s", name)
+			continue
+		}
+		current := ttlDays(createQuery, "")
+		if current == r.Tables[name] {
+			continue
+		}
+		log.Printf("Retention: changing TTL of %s from %d to %d days", name, current, r.Tables[name])
+		query := fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDateTime(timestamp) + INTERVAL %d DAY", table, r.Tables[name])
+		if err := s.execDDL(ctx, query); err != nil {
+			return fmt.Errorf("failed to set TTL on %s: %w", table, err)
+		}
+	}
+
+	if r.BodyDays == 0 {
+		return nil
+	}
+	for _, name := range dataTables {
+		table, ok := s.retentionTable(name)
+		createQuery, exists := existing[s.physicalName(name)]
+		if !ok || !exists {
+			continue
+		}
+		for _, col := range bodyColumns[name] {
+			if ttlDays(createQuery, col) == r.BodyDays {
+				continue
+			}
+			query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN `%s` String %s TTL toDateTime(timestamp) + INTERVAL %d DAY",
+				table, col, bodyCodec, r.BodyDays)
+			if err := s.execDDL(ctx, query); err != nil {
+				return fmt.Errorf("failed to set TTL on %s.%s: %w", table, col, err)
+			}
+		}
+	}
+	return nil
+}
+
+// partition 表的一个分区，table 为数据库中的表名
+type partition struct {
+	table, id   string
+	bytes, rows uint64
+}
+
+// listPartitions 返回数据库中 tables 各表的分区，tables 为 nil 时返回所有表的分区
+func (s *ClickHouseStorage) listPartitions(ctx context.Context, tables []string) ([]partition, error) {
+	query := `
+		SELECT table, partition_id, sum(bytes_on_disk) AS bytes, sum(rows) AS rows
+		FROM system.parts
+		WHERE active AND database = ?`
+	args := []interface{}{s.database}
+	if tables != nil {
+		query += " AND has(?, table)"
+		args = append(args, tables)
+	}
+	query += " GROUP BY table, partition_id ORDER BY table, partition_id"
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+	var parts []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.table, &p.id, &p.bytes, &p.rows); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	return parts, rows.Err()
+}
+
+// partitionEnd 返回按天（toYYYYMMDD）或按月（toYYYYMM）分区的结束时间，其他分区方式返回 false
+func partitionEnd(id string, loc *time.Location) (time.Time, bool) {
+	if day, err := time.ParseInLocation("20060102", id, loc); err == nil {
+		return day.AddDate(0, 0, 1), true
+	}
+	if month, err := time.ParseInLocation("200601", id, loc); err == nil {
+		return month.AddDate(0, 1, 0), true
+	}
+	return time.Time{}, false
+}
+
+// dropPartitions 依次删除分区，返回释放的磁盘空间（字节）；一个分区失败时继续处理其余分区，返回第一个错误
+func (s *ClickHouseStorage) dropPartitions(ctx context.Context, parts []partition, prefix string) (uint64, error) {
+	var freed uint64
+	var firstErr error
+	for _, p := range parts {
+		if ctx.Err() != nil {
+			return freed, ctx.Err()
+		}
+		if err := s.execDDL(ctx, fmt.Sprintf("ALTER TABLE %s.%s DROP PARTITION ID '%s'", s.database, p.table, p.id)); err != nil {
+			log.Printf("Failed to drop %s partition %s: %v", p.table, p.id, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to drop partition of %s: %w", p.table, err)
+			}
+			continue
+		}
+		log.Printf("%s: dropped %s partition %s (%d rows, %d bytes)", prefix, p.table, p.id, p.rows, p.bytes)
+		freed += p.bytes
+	}
+	return freed, firstErr
+}
+
+// DropExpiredPartitions 删除各表中整个分区都早于保留天数的按天（toYYYYMMDD）或按月（toYYYYMM）分区，
+// 其他分区方式的表由 TTL 清理；返回释放的磁盘空间（字节）。一个分区失败时继续处理其余分区，返回第一个错误
+func (s *ClickHouseStorage) DropExpiredPartitions(ctx context.Context, tables map[string]int, now time.Time) (uint64, error) {
+	// system.parts 中为实际表名，查询结果映射回配置中的表名
+	names := make([]string, 0, len(tables))
+	logical := make(map[string]string, len(tables))
+	for name := range tables {
+		if _, ok := s.retentionTable(name); ok {
+			names = append(names, s.physicalName(name))
+			logical[s.physicalName(name)] = name
+		}
+	}
+	parts, err := s.listPartitions(ctx, names)
+	if err != nil {
+		return 0, err
+	}
+	var expired []partition
+	for _, p := range parts {
+		// 分区结束时间也早于保留期限时才删除
+		end, ok := partitionEnd(p.id, now.Location())
+		if ok && !end.After(now.AddDate(0, 0, -tables[logical[p.table]])) {
+			expired = append(expired, p)
+		}
+	}
+	return s.dropPartitions(ctx, expired, "Retention")
+}
+
+// purgeTables 本实例创建的按时间分区的辅助表
+var purgeTables = []string{
+	"ingest_audit", "admin_audit", "client_ip_hourly", "abuse_candidates",
+	"capacity_forecast", "api_logs_hourly", "routing", "request_traces",
+}
+
+// PurgePartitions 删除本实例创建的各表（数据表、自定义日志类型、租户和 staged_types 的表、辅助表，用户维护的表除外）
+// 结束时间不晚于 before 的按天或按月分区，不受保留天数限制，用于紧急释放磁盘空间；
+// 同一数据库中的其他表不处理。dryRun 为 true 时只返回将删除的分区。
+// 返回删除（或将删除）的分区和释放的磁盘空间（字节）
+func (s *ClickHouseStorage) PurgePartitions(ctx context.Context, before time.Time, dryRun bool) ([]string, uint64, error) {
+	schemas := make([]*tableSchema, 0, len(dataTables)+len(s.custom))
+	for _, name := range dataTables {
+		schemas = append(schemas, s.tables[name])
+	}
+	for _, t := range s.custom {
+		schemas = append(schemas, t)
+	}
+	schemas = append(schemas, s.stagedTypeTables()...)
+	// 按数据库区分的租户表不在本数据库中，下面按数据库过滤
+	if s.tenants != nil {
+		tables, err := s.tenantTables(ctx, "api_logs", "embedding_logs")
+		if err != nil {
+			return nil, 0, err
+		}
+		schemas = append(schemas, tables...)
+	}
+	var names []string
+	for _, t := range schemas {
+		if !t.mapped && t.database == s.database {
+			names = append(names, t.table)
+		}
+	}
+	for _, name := range purgeTables {
+		names = append(names, s.physicalName(name))
+	}
+
+	parts, err := s.listPartitions(ctx, names)
+	if err != nil {
+		return nil, 0, err
+	}
+	var purge []partition
+	var purged []string
+	var bytes uint64
+	for _, p := range parts {
+		if end, ok := partitionEnd(p.id, before.Location()); ok && !end.After(before) {
+			purge = append(purge, p)
+			purged = append(purged, p.table+"/"+p.id)
+			bytes += p.bytes
+		}
+	}
+	if dryRun {
+		return purged, bytes, nil
+	}
+	freed, err := s.dropPartitions(ctx, purge, "Purge")
+	return purged, freed, err
+}
+
+// PurgeProcessedFiles 删除文件修改时间早于 before 的已处理记录，等待删除完成后合并 processed_files；
+// 这些文件若仍在日志目录中会被重新处理
+func (s *ClickHouseStorage) PurgeProcessedFiles(ctx context.Context, before time.Time) error {
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 1,
+	}))
+	table := s.table("processed_files")
+	if err := s.conn.Exec(ctx, s.clusterDDL(fmt.Sprintf("ALTER TABLE %s DELETE WHERE file_mtime < ?", table)), before); err != nil {
+		return fmt.Errorf("failed to delete rows from %s: %w", table, err)
+	}
+	if err := s.conn.Exec(ctx, fmt.Sprintf("OPTIMIZE TABLE %s FINAL", table)); err != nil {
+		return fmt.Errorf("failed to optimize %s: %w", table, err)
+	}
+	return nil
+}
+
+// existingTables 返回数据库中已存在的表及其建表语句
+func (s *ClickHouseStorage) existingTables(ctx context.Context) (map[string]string, error) {
+	rows, err := s.conn.Query(ctx, "SELECT name, create_table_query FROM system.tables WHERE database = ?", s.database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+	tables := make(map[string]string)
+	for rows.Next() {
+		var name, createQuery string
+		if err := rows.Scan(&name, &createQuery); err != nil {
+			return nil, err
+		}
+		tables[name] = createQuery
+	}
+	return tables, rows.Err()
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// CreateDailyUsageTable 创建每日用量汇总表，不设 TTL，原始数据过期后仍可查询长期趋势
+func (s *ClickHouseStorage) CreateDailyUsageTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			day Date,
+			log_type LowCardinality(String),
+			model LowCardinality(String),
+			api_key String,
+			requests UInt64,
+			errors UInt64,
+			streamed UInt64,
+			input_tokens UInt64,
+			output_tokens UInt64,
+			cache_read_input_tokens UInt64,
+			cache_creation_input_tokens UInt64,
+			cost Float64,
+			updated_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(updated_at)
+		PARTITION BY toYear(day)
+		ORDER BY (day, log_type, model, api_key)
+	`, s.table("daily_usage"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create daily_usage table: %w", err)
+	}
+	return nil
+}
+
+// RollupDailyUsage 将 API 日志按 (日期, 日志类型, 模型, API key) 汇总到 daily_usage
+// 重新计算最近 lookbackDays 天；汇总表落后时（首次运行或停机）从最后汇总的日期补齐，
+// 保证原始数据 TTL 过期前每一天都已汇总
+func (s *ClickHouseStorage) RollupDailyUsage(ctx context.Context, lookbackDays int, pricing map[string]config.ModelPrice, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, -(lookbackDays - 1))
+
+	t := s.tables["api_logs"]
+	var last time.Time
+	if err := s.conn.QueryRow(ctx, fmt.Sprintf("SELECT max(day) FROM %s", s.table("daily_usage"))).Scan(&last); err != nil {
+		return fmt.Errorf("failed to read last rollup day: %w", err)
+	}
+	if last.Year() <= 1970 {
+		if err := s.conn.QueryRow(ctx, fmt.Sprintf("SELECT toDate(min(`timestamp`)) FROM (SELECT %s FROM %s)",
+			t.selectColumn("timestamp", "toDateTime64(0, 3)"), s.readTable("api_logs"))).Scan(&last); err != nil {
+			return fmt.Errorf("failed to read earliest api log: %w", err)
+		}
+	}
+	if last.Year() > 1970 {
+		last = time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, now.Location())
+		if last.Before(from) {
+			from = last
+		}
+	}
+
+	cols := []string{
+		t.selectColumn("log_type", "''"),
+		t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		t.modelColumn(),
+		t.selectColumn("api_key", "''"),
+		t.selectColumn("response_status", "toUInt16(0)"),
+		t.selectColumn("streamed", "toUInt8(0)"),
+		t.selectColumn("incomplete", "toUInt8(0)"),
+		t.selectColumn("input_tokens", "toUInt64(0)"),
+		t.selectColumn("output_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_read_input_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_creation_input_tokens", "toUInt64(0)"),
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+		(day, log_type, model, api_key, requests, errors, streamed,
+		 input_tokens, output_tokens, cache_read_input_tokens, cache_creation_input_tokens, cost)
+		SELECT toDate(timestamp) AS day, log_type, model, api_key, count(), countIf(response_status >= 400), countIf(streamed = 1),
+			sum(input_tokens), sum(output_tokens), sum(cache_read_input_tokens), sum(cache_creation_input_tokens), sum(%s)
+		FROM (SELECT %s FROM %s)
+		WHERE incomplete = 0 AND timestamp >= ?
+		GROUP BY day, log_type, model, api_key
+	`, s.table("daily_usage"), costExpr(pricing), strings.Join(cols, ", "), s.readTable("api_logs"))
+	if err := s.conn.Exec(ctx, query, from); err != nil {
+		return fmt.Errorf("failed to roll up daily usage: %w", err)
+	}
+	return nil
+}
+
+// costExpr 按 pricing（美元 / 百万 token）计算单个请求费用的表达式，模型名按最长前缀匹配价格
+func costExpr(pricing map[string]config.ModelPrice) string {
+	if len(pricing) == 0 {
+		return "toFloat64(0)"
+	}
+	prefixes := make([]string, 0, len(pricing))
+	for prefix := range pricing {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	var b strings.Builder
+	b.WriteString("multiIf(")
+	for _, prefix := range prefixes {
+		p := pricing[prefix]
+		fmt.Fprintf(&b, "startsWith(model, %s), (input_tokens * %g + output_tokens * %g + "+
+			"cache_read_input_tokens * %g + cache_creation_input_tokens * %g) / 1000000, ",
+			quoteString(prefix), p.Input, p.Output, p.CacheRead, p.CacheWrite)
+	}
+	b.WriteString("toFloat64(0))")
+	return b.String()
+}
+
+// quoteString 转义为 ClickHouse 字符串字面量
+func quoteString(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// createRoutingTable 创建请求路由表
+func (s *ClickHouseStorage) createRoutingTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			request_id String,
+			timestamp DateTime64(3),
+			client_log_type LowCardinality(String),
+			provider_log_type LowCardinality(String),
+			requested_model String,
+			upstream_model String,
+			provider LowCardinality(String),
+			upstream_base_url LowCardinality(String),
+			upstream_url String,
+			upstream_attempts UInt16,
+			upstream_status UInt16,
+			response_status UInt16,
+			host LowCardinality(String),
+			instance LowCardinality(String),
+			inserted_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(inserted_at)
+		PARTITION BY toYYYYMM(timestamp)
+		ORDER BY (timestamp, request_id, client_log_type, provider_log_type)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`, s.table("routing"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create routing table: %w", err)
+	}
+	return nil
+}
+
+func (s *ClickHouseStorage) insertRouting(ctx context.Context, requestID string, client, provider requestSide) error {
+	last, attempts := lastUpstream(client, provider)
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+		(request_id, timestamp, client_log_type, provider_log_type, requested_model, upstream_model,
+		 provider, upstream_base_url, upstream_url, upstream_attempts, upstream_status, response_status, host, instance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.table("routing")),
+		requestID, client.timestamp, client.logType, provider.logType, client.model, upstreamModel(provider, last),
+		providerName(provider.url, last.URL), baseURL(last.URL), last.URL, uint16(attempts),
+		uint16(last.Status), client.status, s.labels.Host, s.labels.Instance)
+}
+
+// baseURL 上游地址的 scheme://host 部分
+func baseURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// 已知上游域名对应的提供方
+var providerHosts = []struct {
+	suffix   string
+	provider string
+}{
+	{"anthropic.com", "anthropic"},
+	{"openai.com", "openai"},
+	{"openai.azure.com", "azure"},
+	{"googleapis.com", "google"},
+	{"amazonaws.com", "aws"},
+	{"openrouter.ai", "openrouter"},
+	{"deepseek.com", "deepseek"},
+	{"x.ai", "xai"},
+}
+
+// providerName 提供方名称：provider 接口路径 /api/provider/<name>/... 中的名称，
+// 否则按上游域名判断，未知域名返回主机名
+func providerName(providerURL, upstreamURL string) string {
+	path := providerURL
+	if u, err := url.Parse(providerURL); err == nil {
+		path = u.Path
+	}
+	if rest, ok := strings.CutPrefix(path, "/api/provider/"); ok {
+		if name, _, _ := strings.Cut(rest, "/"); name != "" {
+			return name
+		}
+	}
+
+	u, err := url.Parse(upstreamURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := u.Hostname()
+	for _, p := range providerHosts {
+		if host == p.suffix || strings.HasSuffix(host, "."+p.suffix) {
+			return p.provider
+		}
+	}
+	return host
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// mainLogRows main_logs 表的行，各存储后端共用同一组字段
+func mainLogRows(entries []parser.MainLogEntry, logFile string) []*row {
+	rows := make([]*row, 0, len(entries))
+	for _, e := range entries {
+		r := &row{}
+		r.set("timestamp", e.Timestamp)
+		r.set("request_id", e.RequestID)
+		r.set("level", e.Level)
+		r.set("source", e.Source)
+		r.set("message", e.Message)
+		r.set("status_code", uint16(e.StatusCode))
+		r.set("latency", e.Latency)
+		r.set("client_ip", e.ClientIP)
+		r.set("method", e.Method)
+		r.set("path", e.Path)
+		r.set("timestamp_flag", e.Flag)
+		r.set("timestamp_skew_seconds", e.SkewSeconds)
+		r.set("log_file", logFile)
+		r.offset = e.Offset
+		rows = append(rows, r)
+	}
+
+	return rows
+}
+
+// apiLogRow api_logs 表的行，body 为明文，*_hash 为空（由 body 去重填充）
+func apiLogRow(entry *parser.APILogEntry, logFile string) *row {
+	headersJSON, _ := json.Marshal(entry.Headers)
+	respHeadersJSON, _ := json.Marshal(entry.ResponseHeaders)
+	upstreamJSON, _ := json.Marshal(entry.UpstreamRequests)
+
+	r := &row{}
+	r.set("log_type", string(entry.LogType))
+	r.set("request_id", entry.RequestID)
+	r.set("timestamp", entry.Timestamp)
+	r.set("version", entry.Version)
+	r.set("url", entry.URL)
+	r.set("method", entry.Method)
+	r.set("model", entry.Model)
+	r.set("response_model", entry.ResponseModel)
+	r.set("stop_reason", entry.StopReason)
+	r.set("headers", string(headersJSON))
+	r.set("request_body", entry.RequestBody)
+	r.set("response_status", uint16(entry.ResponseStatus))
+	r.set("error_type", entry.Error.Type)
+	r.set("error_message", entry.Error.Message)
+	r.set("error_code", entry.Error.Code)
+	r.set("error_source", entry.Error.Source)
+	r.set("response_headers", string(respHeadersJSON))
+	r.set("response_body", entry.ResponseBody)
+	r.set("full_response", entry.FullResponse)
+	r.set("upstream_requests", string(upstreamJSON))
+	// 启用 body 去重时由 insertRows 填充
+	r.set("request_body_hash", "")
+	r.set("response_body_hash", "")
+	r.set("full_response_hash", "")
+	rl := entry.RateLimit
+	r.set("ratelimit_requests_limit", rl.RequestsLimit)
+	r.set("ratelimit_requests_remaining", rl.RequestsRemaining)
+	r.set("ratelimit_requests_reset", rl.RequestsReset)
+	r.set("ratelimit_tokens_limit", rl.TokensLimit)
+	r.set("ratelimit_tokens_remaining", rl.TokensRemaining)
+	r.set("ratelimit_tokens_reset", rl.TokensReset)
+	r.set("ratelimit_input_tokens_remaining", rl.InputTokensRemaining)
+	r.set("ratelimit_output_tokens_remaining", rl.OutputTokensRemaining)
+	r.set("retry_after_seconds", rl.RetryAfterSeconds)
+	r.set("betas", stringArray(entry.Betas))
+	parts := multipartColumns(entry.MultipartParts)
+	r.set("multipart_parts.name", parts.names)
+	r.set("multipart_parts.filename", parts.filenames)
+	r.set("multipart_parts.content_type", parts.contentTypes)
+	r.set("multipart_parts.size", parts.sizes)
+	r.set("multipart_parts.value", parts.values)
+	r.set("tool_names", stringArray(entry.ToolNames))
+	r.set("tool_count", uint16(len(entry.ToolNames)))
+	r.set("mcp_servers", stringArray(entry.MCPServers))
+	r.set("mcp_tool_calls", stringArray(entry.MCPToolCalls))
+	calls := toolCallColumns(entry.ToolCalls)
+	r.set("tool_calls.id", calls.ids)
+	r.set("tool_calls.name", calls.names)
+	r.set("tool_calls.input", calls.inputs)
+	fingerprints := entry.PrefixFingerprints
+	if fingerprints == nil {
+		fingerprints = []uint64{}
+	}
+	r.set("prefix_fingerprints", fingerprints)
+	r.set("prefix_length", uint32(entry.PrefixLength))
+	r.set("incomplete", boolToUInt8(entry.Incomplete))
+	r.set("truncated", boolToUInt8(entry.Truncated))
+	r.set("timestamp_flag", entry.Flag)
+	r.set("timestamp_skew_seconds", entry.SkewSeconds)
+	r.set("input_tokens", entry.Usage.InputTokens)
+	r.set("output_tokens", entry.Usage.OutputTokens)
+	r.set("cache_read_input_tokens", entry.Usage.CacheReadInputTokens)
+	r.set("cache_creation_input_tokens", entry.Usage.CacheCreationInputTokens)
+	r.set("streamed", boolToUInt8(entry.Streamed))
+	r.set("api_key", entry.APIKey)
+	r.set("client_app", entry.ClientApp)
+	tp := entry.Throughput
+	r.set("response_ms", nullableUInt32(tp.ResponseMs))
+	r.set("duration_source", tp.Source)
+	r.set("output_tokens_per_second", nullableFloat64(tp.OutputTokensPerSecond))
+	r.set("log_file", logFile)
+
+	return r
+}
+
+// multipartArrays multipart_parts Nested 列的各子列
+type multipartArrays struct {
+	names        []string
+	filenames    []string
+	contentTypes []string
+	sizes        []uint64
+	values       []string
+}
+
+func multipartColumns(parts []parser.MultipartPart) multipartArrays {
+	a := multipartArrays{
+		names:        make([]string, 0, len(parts)),
+		filenames:    make([]string, 0, len(parts)),
+		contentTypes: make([]string, 0, len(parts)),
+		sizes:        make([]uint64, 0, len(parts)),
+		values:       make([]string, 0, len(parts)),
+	}
+	for _, p := range parts {
+		a.names = append(a.names, p.Name)
+		a.filenames = append(a.filenames, p.Filename)
+		a.contentTypes = append(a.contentTypes, p.ContentType)
+		a.sizes = append(a.sizes, uint64(p.Size))
+		a.values = append(a.values, p.Value)
+	}
+	return a
+}
+
+// toolCallArrays tool_calls Nested 列的各子列
+type toolCallArrays struct {
+	ids    []string
+	names  []string
+	inputs []string
+}
+
+func toolCallColumns(calls []parser.ToolCall) toolCallArrays {
+	a := toolCallArrays{
+		ids:    make([]string, 0, len(calls)),
+		names:  make([]string, 0, len(calls)),
+		inputs: make([]string, 0, len(calls)),
+	}
+	for _, c := range calls {
+		a.ids = append(a.ids, c.ID)
+		a.names = append(a.names, c.Name)
+		a.inputs = append(a.inputs, c.Input)
+	}
+	return a
+}
+
+// eventBatchRows event_logs 表的行，每个事件一行，缺少 event_data 的事件跳过
+func eventBatchRows(entry *parser.EventBatchEntry, logFile string) []*row {
+	rows := make([]*row, 0, len(entry.Events))
+	for i, evt := range entry.Events {
+		eventType, _ := evt["event_type"].(string)
+
+		eventData, ok := evt["event_data"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		eventName, _ := eventData["event_name"].(string)
+		sessionID, _ := eventData["session_id"].(string)
+		model, _ := eventData["model"].(string)
+		userType, _ := eventData["user_type"].(string)
+		deviceID, _ := eventData["device_id"].(string)
+
+		var platform string
+		if env, ok := eventData["env"].(map[string]interface{}); ok {
+			platform, _ = env["platform"].(string)
+		}
+
+		// 解析时间戳
+		var ts time.Time
+		if tsStr, ok := eventData["client_timestamp"].(string); ok {
+			ts, _ = time.Parse(time.RFC3339, tsStr)
+		}
+		if ts.IsZero() {
+			ts = entry.Timestamp
+		}
+		ts, tsCheck := parser.CheckTimestamp(ts, entry.ModTime)
+
+		mcpServer, mcpTool := parser.EventMCPInfo(eventData)
+
+		eventDataJSON, _ := json.Marshal(eventData)
+
+		r := &row{}
+		r.set("request_id", entry.RequestID)
+		r.set("timestamp", ts)
+		r.set("event_type", eventType)
+		r.set("event_name", eventName)
+		r.set("session_id", sessionID)
+		r.set("model", model)
+		r.set("user_type", userType)
+		r.set("platform", platform)
+		r.set("device_id", deviceID)
+		r.set("mcp_server", mcpServer)
+		r.set("mcp_tool", mcpTool)
+		r.set("event_data", string(eventDataJSON))
+		r.set("timestamp_flag", tsCheck.Flag)
+		r.set("timestamp_skew_seconds", tsCheck.SkewSeconds)
+		r.set("log_file", logFile)
+		r.offset = int64(entry.Offset + i)
+		rows = append(rows, r)
+	}
+
+	return rows
+}
+
+// embeddingLogRow embedding_logs 表的行，响应体仅在失败时保留
+func embeddingLogRow(entry *parser.EmbeddingLogEntry, logFile string) *row {
+	api := entry.API
+	headersJSON, _ := json.Marshal(api.Headers)
+	var errorBody string
+	if api.ResponseStatus >= 400 {
+		errorBody = api.ResponseBody
+	}
+
+	r := &row{}
+	r.set("request_id", api.RequestID)
+	r.set("timestamp", api.Timestamp)
+	r.set("url", api.URL)
+	r.set("method", api.Method)
+	r.set("model", entry.Model)
+	r.set("input_count", uint32(entry.InputCount))
+	r.set("prompt_tokens", uint32(entry.PromptTokens))
+	r.set("total_tokens", uint32(entry.TotalTokens))
+	r.set("embedding_count", uint32(entry.EmbeddingCount))
+	r.set("dimensions", uint32(entry.Dimensions))
+	r.set("response_status", uint16(api.ResponseStatus))
+	r.set("headers", string(headersJSON))
+	r.set("request_body", api.RequestBody)
+	r.set("error_body", errorBody)
+	r.set("client_app", api.ClientApp)
+	r.set("timestamp_flag", api.Flag)
+	r.set("timestamp_skew_seconds", api.SkewSeconds)
+	r.set("log_file", logFile)
+
+	return r
+}
+
+// jsonLineRows json_lines 类型日志表的行
+func jsonLineRows(logType parser.LogType, records []parser.JSONLineRecord, logFile string) []*row {
+	rows := make([]*row, 0, len(records))
+	for _, rec := range records {
+		r := &row{}
+		r.set("timestamp", rec.Timestamp)
+		r.set("request_id", rec.RequestID)
+		r.set("log_type", string(logType))
+		r.set("data", rec.Data)
+		r.set("timestamp_flag", rec.Flag)
+		r.set("timestamp_skew_seconds", rec.SkewSeconds)
+		r.set("log_file", logFile)
+		rows = append(rows, r)
+	}
+	return rows
+}
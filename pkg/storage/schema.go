@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// dataTables cpa-logger 写入的数据表（逻辑名）
+var dataTables = []string{"main_logs", "api_logs", "event_logs", "embedding_logs"}
+
+// tableSchema 数据表的写入目标及列映射
+type tableSchema struct {
+	database string
+	table    string
+	// mapped 为 true 表示表由用户维护，不执行建表和补列
+	mapped bool
+	// 字段名 -> 列名，列名为空表示不写入该字段
+	mapping map[string]string
+	// 目标表实际存在的列，仅 mapped 模式下加载
+	existing map[string]bool
+	// 请求头字段中实际为 Map 类型的列，其余（旧版本创建的 String 列）写入 JSON 字符串
+	mapColumns map[string]bool
+}
+
+// headerFields 请求头字段，写入 Map(String, String) 列或 JSON 字符串列
+var headerFields = []string{"headers", "response_headers"}
+
+// loadHeaderTypes 读取目标表请求头列的实际类型
+func (s *ClickHouseStorage) loadHeaderTypes(ctx context.Context, t *tableSchema) error {
+	types, err := s.tableColumns(ctx, t.fullName())
+	if err != nil {
+		return err
+	}
+	t.mapColumns = make(map[string]bool)
+	for _, field := range headerFields {
+		col := t.column(field)
+		if col == "" || types[col] == "" {
+			continue
+		}
+		if strings.HasPrefix(types[col], "Map(") {
+			t.mapColumns[col] = true
+		} else if !t.mapped {
+			log.Printf("%s.%s is a %s column, writing headers as JSON (new tables use Map(String, String))",
+				t.fullName(), col, types[col])
+		}
+	}
+	return nil
+}
+
+// headerMap 将请求头的 JSON 字符串转为 Map 列的值，无法解析（如已脱敏）时为空
+func headerMap(v interface{}) map[string]string {
+	m := map[string]string{}
+	if s, ok := v.(string); ok && s != "" {
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return map[string]string{}
+		}
+	}
+	return m
+}
+
+func (t *tableSchema) fullName() string {
+	return fmt.Sprintf("%s.%s", t.database, t.table)
+}
+
+// column 返回字段对应的列名，返回空字符串表示该字段不写入
+func (t *tableSchema) column(field string) string {
+	if !t.mapped {
+		return field
+	}
+	if col, ok := t.mapping[field]; ok {
+		return col
+	}
+	if t.existing[field] {
+		return field
+	}
+	return ""
+}
+
+// buildTableSchemas 根据配置生成各数据表的写入目标
+func buildTableSchemas(cfg *config.ClickHouseConfig) map[string]*tableSchema {
+	tables := make(map[string]*tableSchema, len(dataTables))
+	for _, name := range dataTables {
+		t := &tableSchema{database: cfg.Database, table: cfg.TableName(name)}
+		if m, ok := cfg.TableMappings[name]; ok && cfg.SchemaMode == "mapped" {
+			t.mapped = true
+			t.mapping = m.Columns
+			if m.Table != "" {
+				if db, table, ok := strings.Cut(m.Table, "."); ok {
+					t.database, t.table = db, table
+				} else {
+					t.table = m.Table
+				}
+			}
+		}
+		tables[name] = t
+	}
+	return tables
+}
+
+// loadMappedSchemas 读取用户维护表的实际列，并校验映射的列都存在
+func (s *ClickHouseStorage) loadMappedSchemas(ctx context.Context) error {
+	for _, name := range dataTables {
+		t := s.tables[name]
+		if !t.mapped {
+			continue
+		}
+
+		rows, err := s.conn.Query(ctx,
+			"SELECT name FROM system.columns WHERE database = ? AND table = ?",
+			t.database, t.table)
+		if err != nil {
+			return fmt.Errorf("failed to describe %s: %w", t.fullName(), err)
+		}
+		t.existing = make(map[string]bool)
+		for rows.Next() {
+			var col string
+			if err := rows.Scan(&col); err != nil {
+				rows.Close()
+				return err
+			}
+			t.existing[col] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(t.existing) == 0 {
+			return fmt.Errorf("mapped table %s for %s does not exist", t.fullName(), name)
+		}
+		var missing []string
+		for field, col := range t.mapping {
+			if col != "" && !t.existing[col] {
+				missing = append(missing, fmt.Sprintf("%s->%s", field, col))
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("mapped table %s is missing columns: %s", t.fullName(), strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+// row 待写入的一行数据，按字段名记录
+type row struct {
+	fields []string
+	values []interface{}
+	// 行在日志文件中的位置（main 日志为字节位置，事件为序号），用于生成去重键
+	offset int64
+}
+
+func (r *row) set(field string, value interface{}) {
+	r.fields = append(r.fields, field)
+	r.values = append(r.values, value)
+}
+
+// get 返回字段的值，字段不存在时 ok 为 false
+func (r *row) get(field string) (value interface{}, ok bool) {
+	for i, f := range r.fields {
+		if f == field {
+			return r.values[i], true
+		}
+	}
+	return nil, false
+}
+
+// replace 替换已有字段的值，字段不存在时忽略
+func (r *row) replace(field string, value interface{}) {
+	for i, f := range r.fields {
+		if f == field {
+			r.values[i] = value
+			return
+		}
+	}
+}
+
+// insertRows 按表的列映射批量写入，所有行的字段顺序必须一致
+func (s *ClickHouseStorage) insertRows(ctx context.Context, table string, rows []*row) error {
+	return s.insertRowsInto(ctx, table, s.tables[table], s.insertTable(table), rows)
+}
+
+// insertRowsInto 将数据表 table 的行写入 target（如租户的独立表），schema 为 target 的列映射
+func (s *ClickHouseStorage) insertRowsInto(ctx context.Context, table string, schema *tableSchema, target string, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// 每行附加主机和实例标识（数据表还附加去重键），再依次脱敏、body 去重和加密
+	_, dataTable := tableDDL[table]
+	for _, r := range rows {
+		r.set("host", s.labels.Host)
+		r.set("instance", s.labels.Instance)
+		if dataTable {
+			r.set("dedup_key", s.dedupKey(r))
+		}
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+		if table == "api_logs" && s.bodies != nil {
+			if err := s.dedupBodies(ctx, r); err != nil {
+				return fmt.Errorf("failed to store bodies: %w", err)
+			}
+		}
+		if err := s.encryptRow(table, r); err != nil {
+			return err
+		}
+	}
+
+	var cols []string
+	var idx []int
+	var maps []bool
+	for i, field := range rows[0].fields {
+		col := schema.column(field)
+		if col == "" {
+			continue
+		}
+		cols = append(cols, "`"+col+"`")
+		idx = append(idx, i)
+		maps = append(maps, schema.mapColumns[col])
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("no columns mapped for %s", schema.fullName())
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES", target, strings.Join(cols, ", "))
+	values := make([][]interface{}, len(rows))
+	for k, r := range rows {
+		values[k] = make([]interface{}, len(idx))
+		for j, i := range idx {
+			values[k][j] = r.values[i]
+			if maps[j] {
+				values[k][j] = headerMap(r.values[i])
+			}
+		}
+	}
+	if s.batcher != nil {
+		return s.batcher.add(ctx, table, query, values)
+	}
+	return s.sendBatch(ctx, query, values)
+}
+
+// sendBatch 执行 INSERT 语句写入各行
+func (s *ClickHouseStorage) sendBatch(ctx context.Context, query string, values [][]interface{}) error {
+	batch, err := s.conn.PrepareBatch(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := batch.Append(v...); err != nil {
+			batch.Abort()
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// stringArray Array(String) 列的值，nil 转为空数组
+func stringArray(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nullableUInt32 0 表示未知，写入 NULL
+func nullableUInt32(v uint32) *uint32 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+// nullableFloat64 0 表示未知，写入 NULL
+func nullableFloat64(v float64) *float64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SeriesMetrics 可按时间聚合的 API 请求指标
+var SeriesMetrics = []string{
+	"requests",
+	"errors",
+	"error_rate",
+	"input_tokens",
+	"output_tokens",
+	"cache_read_input_tokens",
+	"cache_creation_input_tokens",
+	"output_tokens_per_second",
+}
+
+// SeriesGroups 时间序列可按其拆分的字段
+var SeriesGroups = []string{"model", "status", "log_type", "host", "streamed", "client_app"}
+
+// Point 时间序列中的一个点
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series 一条时间序列，未分组时 Name 为空
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// seriesValue 指标对应的聚合表达式
+func seriesValue(metric string) (string, bool) {
+	switch metric {
+	case "requests":
+		return "toFloat64(count())", true
+	case "errors":
+		return "toFloat64(countIf(`response_status` >= 400))", true
+	case "error_rate":
+		return "countIf(`response_status` >= 400) / count()", true
+	case "input_tokens", "output_tokens", "cache_read_input_tokens", "cache_creation_input_tokens":
+		return fmt.Sprintf("toFloat64(sum(`%s`))", metric), true
+	case "output_tokens_per_second":
+		// 没有输出速度的请求（NULL）不计入平均值
+		return "ifNull(avg(`output_tokens_per_second`), 0)", true
+	}
+	return "", false
+}
+
+// seriesGroup 分组字段对应的表达式
+func seriesGroup(group string) (string, bool) {
+	switch group {
+	case "":
+		return "''", true
+	case "model", "log_type", "host", "client_app":
+		return fmt.Sprintf("toString(`%s`)", group), true
+	case "status":
+		return "toString(`response_status`)", true
+	case "streamed":
+		return "if(`streamed` = 1, 'streamed', 'non-streamed')", true
+	}
+	return "", false
+}
+
+// RequestSeries 按 step 时间间隔聚合 API 请求指标，groupBy 非空时按该字段拆分为多条序列
+func (s *ClickHouseStorage) RequestSeries(ctx context.Context, f RequestFilter, metric, groupBy string, step time.Duration) ([]Series, error) {
+	value, ok := seriesValue(metric)
+	if !ok {
+		return nil, fmt.Errorf("unknown metric: %s", metric)
+	}
+	group, ok := seriesGroup(groupBy)
+	if !ok {
+		return nil, fmt.Errorf("unknown group: %s", groupBy)
+	}
+	seconds := int64(step / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	t := s.tables["api_logs"]
+	cols := append(t.summaryColumns(),
+		t.selectColumn("input_tokens", "toUInt64(0)"),
+		t.selectColumn("output_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_read_input_tokens", "toUInt64(0)"),
+		t.selectColumn("cache_creation_input_tokens", "toUInt64(0)"),
+		t.selectColumn("output_tokens_per_second", "CAST(NULL, 'Nullable(Float64)')"),
+		t.selectColumn("client_app", "'unknown'"),
+	)
+	inner, args := s.timeWhere(f)
+	where, whereArgs := s.requestWhere(f)
+	args = append(args, whereArgs...)
+	// 同一请求在客户端日志（v1_*）和上游的各类日志中各有一行，未指定日志类型时只统计客户端日志，
+	// 避免重复计数；按 log_type 拆分时各类型分别统计
+	if f.LogType == "" && groupBy != "log_type" {
+		if where == "" {
+			where = " WHERE startsWith(`log_type`, 'v1_')"
+		} else {
+			where += " AND startsWith(`log_type`, 'v1_')"
+		}
+	}
+	query := fmt.Sprintf("SELECT toStartOfInterval(`timestamp`, INTERVAL %d SECOND) AS t, %s AS g, %s AS v "+
+		"FROM %s%s GROUP BY t, g ORDER BY t",
+		seconds, group, value, s.latestAPILogs(cols, inner), where)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*Series)
+	for rows.Next() {
+		var p Point
+		var name string
+		if err := rows.Scan(&p.Time, &name, &p.Value); err != nil {
+			return nil, err
+		}
+		series, ok := byName[name]
+		if !ok {
+			series = &Series{Name: name}
+			byName[name] = series
+		}
+		series.Points = append(series.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Series, 0, len(byName))
+	for _, series := range byName {
+		result = append(result, *series)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
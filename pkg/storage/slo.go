@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SLOQuery SLO 统计的请求范围和达标条件
+type SLOQuery struct {
+	// 为空时统计全部客户端请求（v1_*）
+	LogType string
+	Model   string
+	// 大于 0 时为延迟 SLO：延迟超过该值的请求不达标（延迟取自 main 日志）；
+	// 否则为可用性 SLO：状态码 >= 500 的请求不达标
+	LatencyMs int
+}
+
+// WindowCount 时间窗口内的请求总数和不达标数
+type WindowCount struct {
+	Window time.Duration
+	Total  uint64
+	Bad    uint64
+}
+
+// SLOCounts 统计截至 now 的各时间窗口内的请求总数和不达标数
+func (s *ClickHouseStorage) SLOCounts(ctx context.Context, q SLOQuery, windows []time.Duration, now time.Time) ([]WindowCount, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+	var longest time.Duration
+	for _, w := range windows {
+		longest = max(longest, w)
+	}
+	since := now.Add(-longest)
+
+	t := s.tables["api_logs"]
+	cols := []string{
+		t.selectColumn("log_type", "''"),
+		t.selectColumn("request_id", "''"),
+		t.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		t.modelColumn(),
+		t.selectColumn("url", "''"),
+		t.selectColumn("response_status", "toUInt16(0)"),
+		t.selectColumn("incomplete", "toUInt8(0)"),
+	}
+	conds := []string{"a.incomplete = 0", "a.timestamp >= ?", "a.timestamp <= ?"}
+	var args []interface{}
+	if q.LogType != "" {
+		conds = append(conds, "a.log_type = ?")
+	} else {
+		conds = append(conds, "startsWith(a.log_type, 'v1_')")
+	}
+	if q.Model != "" {
+		conds = append(conds, "a.model = ?")
+	}
+
+	var from, bad string
+	if q.LatencyMs > 0 {
+		// 按 request_id 和请求路径关联 main 日志中的 HTTP 访问记录，没有访问记录的请求不计入
+		m := s.tables["main_logs"]
+		mainCols := []string{
+			m.selectColumn("request_id", "''"),
+			m.selectColumn("timestamp", "toDateTime64(0, 3)"),
+			m.selectColumn("status_code", "toUInt16(0)"),
+			m.selectColumn("path", "''"),
+			m.selectColumn("latency", "''"),
+		}
+		from = fmt.Sprintf("(SELECT %s FROM %s) AS a INNER JOIN ("+
+			"SELECT request_id, splitByChar('?', path)[1] AS request_path, max(%s) AS latency_ms FROM (SELECT %s FROM %s) "+
+			"WHERE status_code != 0 AND timestamp >= ? GROUP BY request_id, request_path"+
+			") AS m ON m.request_id = a.request_id AND m.request_path = splitByChar('?', a.url)[1]",
+			strings.Join(cols, ", "), s.readTable("api_logs"),
+			durationMsExpr("latency"), strings.Join(mainCols, ", "), s.readTable("main_logs"))
+		bad = fmt.Sprintf("m.latency_ms > %d", q.LatencyMs)
+		args = append(args, since.Add(-linkWindow))
+	} else {
+		from = fmt.Sprintf("(SELECT %s FROM %s) AS a", strings.Join(cols, ", "), s.readTable("api_logs"))
+		bad = "a.response_status >= 500"
+	}
+
+	var selects []string
+	var windowArgs []interface{}
+	for _, w := range windows {
+		selects = append(selects, "countIf(a.timestamp >= ?)", fmt.Sprintf("countIf(a.timestamp >= ? AND %s)", bad))
+		windowArgs = append(windowArgs, now.Add(-w), now.Add(-w))
+	}
+	args = append(windowArgs, args...)
+	args = append(args, since, now)
+	if q.LogType != "" {
+		args = append(args, q.LogType)
+	}
+	if q.Model != "" {
+		args = append(args, q.Model)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(selects, ", "), from, strings.Join(conds, " AND "))
+	values := make([]uint64, 2*len(windows))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := s.conn.QueryRow(ctx, query, args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	counts := make([]WindowCount, len(windows))
+	for i, w := range windows {
+		counts[i] = WindowCount{Window: w, Total: values[2*i], Bad: values[2*i+1]}
+	}
+	return counts, nil
+}
+
+// durationMsExpr 将 Go 时长格式（如 98.5ms、1.2s、1m3.5s、850µs）的列转换为毫秒
+func durationMsExpr(col string) string {
+	c := "`" + col + "`"
+	return fmt.Sprintf("multiIf("+
+		"endsWith(%[1]s, 'ns'), toFloat64OrZero(substring(%[1]s, 1, length(%[1]s) - 2)) / 1000000, "+
+		"endsWith(%[1]s, 'µs'), toFloat64OrZero(substring(%[1]s, 1, length(%[1]s) - 3)) / 1000, "+
+		"endsWith(%[1]s, 'ms'), toFloat64OrZero(substring(%[1]s, 1, length(%[1]s) - 2)), "+
+		"toFloat64OrZero(extract(%[1]s, '([0-9]+)h')) * 3600000 + toFloat64OrZero(extract(%[1]s, '([0-9]+)m')) * 60000 + "+
+		"toFloat64OrZero(extract(%[1]s, '([0-9.]+)s$')) * 1000)", c)
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+const (
+	// spool 目录中压缩包的扩展名，写入中的文件带 .tmp 后缀
+	bundleExt = ".json.gz"
+	// 本机已处理文件的记录
+	spoolIndexFile = "processed.jsonl"
+	// 上传后的压缩包移入的子目录
+	consumedDir = "consumed"
+)
+
+// spoolBundle spool 目录中的一个压缩包，对应一次写入调用
+type spoolBundle struct {
+	// main_logs、api_log、embedding_log、event_batch、json_lines 或 processed
+	Kind     string `json:"kind"`
+	LogFile  string `json:"log_file"`
+	Token    string `json:"token,omitempty"`
+	Host     string `json:"host"`
+	Instance string `json:"instance"`
+
+	MainLogs     []parser.MainLogEntry     `json:"main_logs,omitempty"`
+	APILog       *parser.APILogEntry       `json:"api_log,omitempty"`
+	EmbeddingLog *parser.EmbeddingLogEntry `json:"embedding_log,omitempty"`
+	EventBatch   *parser.EventBatchEntry   `json:"event_batch,omitempty"`
+	LogType      parser.LogType            `json:"log_type,omitempty"`
+	JSONLines    []parser.JSONLineRecord   `json:"json_lines,omitempty"`
+	// 事件批量日志的文件修改时间（EventBatchEntry.ModTime 不参与 JSON 编码）
+	ModTime   time.Time        `json:"mod_time,omitempty"`
+	Processed *processedRecord `json:"processed,omitempty"`
+}
+
+type spoolTokenKey struct{}
+
+func init() {
+	Register("spool", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewSpoolStorage(cfg.Spool.Dir, labels)
+	})
+}
+
+// SpoolStorage 离线模式的存储：采集主机无法连接 ClickHouse 时，每次写入保存为 spool 目录中的一个
+// gzip 压缩包，由联网主机通过 ShipSpool 上传；本机已处理的文件记录在 spool 目录的 processed.jsonl
+type SpoolStorage struct {
+	dir    string
+	labels Labels
+	seq    atomic.Uint64
+	// 本机已处理的文件
+	processed *processedLog
+}
+
+// NewSpoolStorage 创建 spool 目录并加载已处理文件的记录
+func NewSpoolStorage(dir string, labels Labels) (*SpoolStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	processed, err := openProcessedLog(filepath.Join(dir, spoolIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	return &SpoolStorage{dir: dir, labels: labels, processed: processed}, nil
+}
+
+// WithInsertToken 记录去重令牌，上传时用于 ClickHouse 的插入去重
+func (s *SpoolStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, spoolTokenKey{}, token)
+}
+
+func (s *SpoolStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.writeBundle(ctx, &spoolBundle{Kind: "main_logs", LogFile: logFile, MainLogs: entries})
+}
+
+func (s *SpoolStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	return s.writeBundle(ctx, &spoolBundle{Kind: "api_log", LogFile: logFile, APILog: entry})
+}
+
+func (s *SpoolStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	return s.writeBundle(ctx, &spoolBundle{Kind: "embedding_log", LogFile: logFile, EmbeddingLog: entry})
+}
+
+func (s *SpoolStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	return s.writeBundle(ctx, &spoolBundle{Kind: "event_batch", LogFile: logFile, EventBatch: entry, ModTime: entry.ModTime})
+}
+
+func (s *SpoolStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	return s.writeBundle(ctx, &spoolBundle{Kind: "json_lines", LogFile: logFile, LogType: logType, JSONLines: records})
+}
+
+// MarkFileProcessed 写入 processed 压缩包（上传后记录到 processed_files 表），并记录到本机的已处理列表
+func (s *SpoolStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	r := processedRecord{Path: filePath, Size: fileSize, MTime: mtime, Inode: inode, RecordCount: recordCount}
+	if err := s.writeBundle(ctx, &spoolBundle{Kind: "processed", LogFile: filePath, Processed: &r}); err != nil {
+		return err
+	}
+	return s.processed.add(r)
+}
+
+// IsFileProcessed 与 ClickHouseStorage 相同：路径、大小和修改时间一致，inode 一致或未知
+func (s *SpoolStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	return s.processed.contains(filePath, fileSize, mtime, inode), nil
+}
+
+func (s *SpoolStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	last, found := s.processed.last(filePath)
+	return last, found, nil
+}
+
+func (s *SpoolStorage) Close() error {
+	return s.processed.Close()
+}
+
+// writeBundle 写入临时文件后重命名，上传方不会读到写了一半的压缩包
+func (s *SpoolStorage) writeBundle(ctx context.Context, b *spoolBundle) error {
+	b.Host, b.Instance = s.labels.Host, s.labels.Instance
+	b.Token, _ = ctx.Value(spoolTokenKey{}).(string)
+
+	// 文件名按时间和序号排序，上传时按写入顺序处理
+	name := fmt.Sprintf("%s-%08d%s", time.Now().UTC().Format("20060102T150405.000000000"), s.seq.Add(1), bundleExt)
+	path := filepath.Join(s.dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create spool bundle: %w", err)
+	}
+	zw := gzip.NewWriter(f)
+	err = json.NewEncoder(zw).Encode(b)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write spool bundle: %w", err)
+	}
+	return nil
+}
+
+// ShipSpool 按写入顺序将 spool 目录中的压缩包写入 ClickHouse，成功的压缩包移入 consumed 子目录。
+// 各行保留采集主机的 host/instance 标识并使用采集时的去重令牌，中断后重新执行不会产生重复数据。
+// 遇到失败时停止，保证文件的已处理记录不会先于其数据写入
+func ShipSpool(ctx context.Context, dir string, store *ClickHouseStorage) (int, error) {
+	names, err := spoolBundles(dir)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, consumedDir), 0755); err != nil {
+		return 0, err
+	}
+
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		path := filepath.Join(dir, name)
+		if err := shipBundleFile(ctx, store, path); err != nil {
+			return i, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.Rename(path, filepath.Join(dir, consumedDir, name)); err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
+
+// spoolBundles 返回目录中按写入顺序排列的压缩包文件名，不含写入中的临时文件
+func spoolBundles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), bundleExt) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// shipBundleFile 读取一个压缩包并写入 ClickHouse
+func shipBundleFile(ctx context.Context, store *ClickHouseStorage, path string) error {
+	b, err := readBundle(path)
+	if err != nil {
+		return err
+	}
+	return shipBundle(ctx, store, b)
+}
+
+func readBundle(path string) (*spoolBundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var b spoolBundle
+	if err := json.NewDecoder(zr).Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func shipBundle(ctx context.Context, store *ClickHouseStorage, b *spoolBundle) error {
+	target := store.WithLabels(Labels{Host: b.Host, Instance: b.Instance})
+	if b.Kind == "processed" {
+		p := b.Processed
+		return target.MarkFileProcessed(ctx, p.Path, p.Size, p.MTime, p.Inode, p.RecordCount)
+	}
+	if err := insertBundle(ctx, target, b); err != nil {
+		return err
+	}
+	// 关联数据为派生数据，失败只记录日志
+	if b.Kind == "api_log" {
+		if err := target.LinkAPILog(ctx, b.APILog); err != nil {
+			log.Printf("Error linking API log %s: %v", filepath.Base(b.LogFile), err)
+		}
+	}
+	return nil
+}
+
+// insertBundle 使用压缩包的去重令牌将其中的日志写入 target
+func insertBundle(ctx context.Context, target Storage, b *spoolBundle) error {
+	if b.Token != "" {
+		ctx = WithInsertToken(ctx, target, b.Token)
+	}
+	switch b.Kind {
+	case "main_logs":
+		return target.InsertMainLogs(ctx, b.MainLogs, b.LogFile)
+	case "api_log":
+		return target.InsertAPILog(ctx, b.APILog, b.LogFile)
+	case "embedding_log":
+		return target.InsertEmbeddingLog(ctx, b.EmbeddingLog, b.LogFile)
+	case "event_batch":
+		b.EventBatch.ModTime = b.ModTime
+		return target.InsertEventBatch(ctx, b.EventBatch, b.LogFile)
+	case "json_lines":
+		return target.InsertJSONLines(ctx, b.LogType, b.JSONLines, b.LogFile)
+	}
+	return fmt.Errorf("unknown bundle kind: %s", b.Kind)
+}
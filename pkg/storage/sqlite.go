@@ -0,0 +1,422 @@
+//go:build cgo
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// sqliteTimeFormat SQLite 中时间列的格式（UTC），可按字符串排序，ClickHouse 可直接解析
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+func init() {
+	Register("sqlite", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewSQLiteStorage(ctx, cfg, labels)
+	})
+}
+
+// SQLiteStorage 写入本地 SQLite 数据库，表名和列与 ClickHouse 相同（数组列为 JSON 字符串），
+// 用于不部署 ClickHouse 的单机环境；写入串行执行
+type SQLiteStorage struct {
+	db     *sql.DB
+	labels Labels
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 配置了独立表的自定义日志类型 -> 表名
+	custom map[parser.LogType]string
+}
+
+type sqliteTokenKey struct{}
+
+// sampleRows 各数据表的示例行，SQLite、DuckDB 建表时按字段的类型确定列类型
+func sampleRows() map[string]*row {
+	return map[string]*row{
+		"main_logs":      mainLogRows([]parser.MainLogEntry{{}}, "")[0],
+		"api_logs":       apiLogRow(&parser.APILogEntry{}, ""),
+		"embedding_logs": embeddingLogRow(&parser.EmbeddingLogEntry{API: &parser.APILogEntry{}}, ""),
+		"event_logs":     eventBatchRows(&parser.EventBatchEntry{Events: []map[string]interface{}{{"event_data": map[string]interface{}{}}}}, "")[0],
+		"json_lines":     jsonLineRows("", []parser.JSONLineRecord{{}}, "")[0],
+	}
+}
+
+// NewSQLiteStorage 打开数据库（WAL 模式）并建表
+func NewSQLiteStorage(ctx context.Context, cfg *config.Config, labels Labels) (*SQLiteStorage, error) {
+	path := cfg.Storage.SQLite.Path
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同时只允许一个写入
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStorage{db: db, labels: labels, custom: make(map[parser.LogType]string)}
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	schemas := sampleRows()
+	tables := make(map[string]*row)
+	for _, name := range dataTables {
+		tables[name] = schemas[name]
+	}
+	for _, t := range cfg.CustomLogTypes {
+		if t.Table == "" {
+			continue
+		}
+		s.custom[parser.LogType(t.Name)] = t.Table
+		if table, ok := kindTables[t.Parser]; ok {
+			tables[t.Table] = schemas[table]
+		} else {
+			tables[t.Table] = schemas["json_lines"]
+		}
+	}
+	if err := s.createTables(ctx, tables); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// createTables 按示例行建表，附加 host、instance、inserted_at 列及 timestamp、request_id、log_file 索引
+func (s *SQLiteStorage) createTables(ctx context.Context, tables map[string]*row) error {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS processed_files (
+			file_path TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			file_mtime TEXT NOT NULL,
+			file_inode INTEGER NOT NULL,
+			record_count INTEGER NOT NULL,
+			host TEXT NOT NULL,
+			instance TEXT NOT NULL,
+			processed_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS processed_files_path ON processed_files (file_path)`,
+		// 写入去重令牌，只保留一天
+		`CREATE TABLE IF NOT EXISTS insert_tokens (
+			table_name TEXT NOT NULL,
+			token TEXT NOT NULL,
+			inserted_at TEXT NOT NULL,
+			PRIMARY KEY (table_name, token)
+		)`,
+		fmt.Sprintf(`DELETE FROM insert_tokens WHERE inserted_at < '%s'`,
+			time.Now().UTC().AddDate(0, 0, -1).Format(sqliteTimeFormat)),
+	}
+	for _, name := range names {
+		var cols []string
+		for i, f := range tables[name].fields {
+			cols = append(cols, fmt.Sprintf("%q %s", f, sqliteType(tables[name].values[i])))
+		}
+		cols = append(cols, `"host" TEXT`, `"instance" TEXT`, `"inserted_at" TEXT DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))`)
+		stmts = append(stmts, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %q (\n\t%s\n)", name, strings.Join(cols, ",\n\t")))
+		for _, col := range []string{"timestamp", "request_id", "log_file"} {
+			if _, ok := tables[name].get(col); ok {
+				stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %q ON %q (%q)", name+"_"+col, name, col))
+			}
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create SQLite tables: %w", err)
+		}
+	}
+	for _, name := range names {
+		if err := s.addMissingColumns(ctx, name, tables[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns 为旧版本创建的表补齐新增的字段（CREATE TABLE IF NOT EXISTS 不修改已有的表）
+func (s *SQLiteStorage) addMissingColumns(ctx context.Context, name string, sample *row) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", name)
+	if err != nil {
+		return fmt.Errorf("failed to describe %s: %w", name, err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[col] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, f := range sample.fields {
+		if existing[f] {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", name, f, sqliteType(sample.values[i]))); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", f, name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteType 按字段值的 Go 类型返回 SQLite 列类型，数组等为 JSON 字符串
+func sqliteType(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "TEXT"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+// sqliteValue 转换为 SQLite 驱动支持的值：时间为 UTC 字符串，空指针为 NULL，数组等为 JSON
+func sqliteValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	switch x := rv.Interface().(type) {
+	case time.Time:
+		return x.UTC().Format(sqliteTimeFormat)
+	case string:
+		return x
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// 超过 int64 范围的值按位保存
+		return int64(rv.Uint())
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	data, _ := json.Marshal(rv.Interface())
+	return string(data)
+}
+
+// WithInsertToken 记录去重令牌：同一表中已写入过的令牌再次写入时跳过
+func (s *SQLiteStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, sqliteTokenKey{}, s.labels.Host+":"+token)
+}
+
+// insert 在一个事务中写入数据表 table 的行，日志类型配置了独立的表时写入该表
+func (s *SQLiteStorage) insert(ctx context.Context, table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	target := table
+	if t, ok := s.custom[logType]; ok {
+		target = t
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if token, ok := ctx.Value(sqliteTokenKey{}).(string); ok {
+		res, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO insert_tokens (table_name, token, inserted_at) VALUES (?, ?, ?)",
+			target, token, time.Now().UTC().Format(sqliteTimeFormat))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+	}
+
+	for _, r := range rows {
+		r.set("host", s.labels.Host)
+		r.set("instance", s.labels.Instance)
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+	}
+	cols := make([]string, len(rows[0].fields))
+	for i, f := range rows[0].fields {
+		cols[i] = fmt.Sprintf("%q", f)
+	}
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)",
+		target, strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into %s: %w", target, err)
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		args := make([]interface{}, len(r.values))
+		for i, v := range r.values {
+			args[i] = sqliteValue(v)
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", target, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.insert(ctx, "main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+func (s *SQLiteStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insert(ctx, "api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+func (s *SQLiteStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.insert(ctx, "embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+func (s *SQLiteStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.insert(ctx, "event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+// InsertJSONLines 写入 json_lines 类型的日志，日志类型须配置了 table
+func (s *SQLiteStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	if _, ok := s.custom[logType]; !ok {
+		return fmt.Errorf("no table for log type %s", logType)
+	}
+	return s.insert(ctx, "json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+// MarkFileProcessed 标记文件已处理，inode 为 0 表示未知
+func (s *SQLiteStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO processed_files (file_path, file_size, file_mtime, file_inode, record_count, host, instance, processed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, filePath, fileSize, mtime.UTC().Format(time.RFC3339Nano), int64(inode), recordCount,
+		s.labels.Host, s.labels.Instance, time.Now().UTC().Format(sqliteTimeFormat))
+	return err
+}
+
+// IsFileProcessed 与 ClickHouseStorage 相同：路径、大小和修改时间一致，inode 一致或未知
+func (s *SQLiteStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM processed_files
+		WHERE file_path = ? AND file_size = ? AND file_mtime = ?
+			AND (file_inode = ? OR file_inode = 0 OR ? = 0)
+			AND (host = ? OR host = '')
+	`, filePath, fileSize, mtime.UTC().Format(time.RFC3339Nano), int64(inode), int64(inode), s.labels.Host).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLiteStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	var last ProcessedFile
+	var inode int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT file_size, file_inode, record_count FROM processed_files
+		WHERE file_path = ? AND (host = ? OR host = '')
+		ORDER BY processed_at DESC, rowid DESC
+		LIMIT 1
+	`, filePath, s.labels.Host).Scan(&last.Size, &inode, &last.RecordCount)
+	if err == sql.ErrNoRows {
+		return ProcessedFile{}, false, nil
+	}
+	if err != nil {
+		return ProcessedFile{}, false, err
+	}
+	last.Inode = uint64(inode)
+	return last, true, nil
+}
+
+// tables 所有数据表，包括自定义日志类型的表
+func (s *SQLiteStorage) tables() []string {
+	tables := append([]string{}, dataTables...)
+	for _, t := range s.custom {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// DeleteFileRows 删除某个日志文件已写入各数据表的行
+func (s *SQLiteStorage) DeleteFileRows(ctx context.Context, logFile string) error {
+	for _, t := range s.tables() {
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %q WHERE log_file = ?", t), logFile); err != nil {
+			return fmt.Errorf("failed to delete rows from %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// RequestLogFiles 返回包含该请求的 API、embeddings 和事件日志文件
+func (s *SQLiteStorage) RequestLogFiles(ctx context.Context, requestID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT log_file FROM api_logs WHERE request_id = ?
+		UNION SELECT log_file FROM embedding_logs WHERE request_id = ?
+		UNION SELECT log_file FROM event_logs WHERE request_id = ?
+		ORDER BY 1
+	`, requestID, requestID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
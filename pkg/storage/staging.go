@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// stagingDDL 数据表 name 的 Null 引擎暂存表建表语句，列与数据表相同，%s 为表全名
+func stagingDDL(name string) string {
+	ddl := tableDDL[name]
+	return ddl[:strings.LastIndex(ddl, ") ENGINE =")] + ") ENGINE = Null\n"
+}
+
+// createStagingTables schema_mode 为 staged 时为各数据表创建 Null 引擎的暂存表 <表>_ingest，
+// 采集器只写入暂存表，由物化视图 <表>_ingest_mv 写入数据表；staged_types 中的日志类型另由
+// 物化视图 api_logs_<类型>_mv 写入与 api_logs 结构相同的 api_logs_<类型> 表
+func (s *ClickHouseStorage) createStagingTables(ctx context.Context) error {
+	for _, name := range dataTables {
+		t := s.tables[name]
+		if err := s.createTable(ctx, fmt.Sprintf(stagingDDL(name), t.fullName()+"_ingest")); err != nil {
+			return fmt.Errorf("failed to create %s_ingest table: %w", t.table, err)
+		}
+		if err := s.syncStagingView(ctx, t.fullName()+"_ingest_mv", t.fullName(), t.fullName()+"_ingest", ""); err != nil {
+			return fmt.Errorf("failed to create %s_ingest_mv view: %w", t.table, err)
+		}
+	}
+
+	source := s.tables["api_logs"].fullName() + "_ingest"
+	for i, t := range s.stagedTypeTables() {
+		if err := s.createTable(ctx, s.renamedDDL("api_logs", t.database, t.table)); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", t.table, err)
+		}
+		if err := s.migrateTable(ctx, "api_logs", t); err != nil {
+			return err
+		}
+		filter := "log_type = " + quoteString(s.stagedTypes[i])
+		if err := s.syncStagingView(ctx, t.fullName()+"_mv", t.fullName(), source, filter); err != nil {
+			return fmt.Errorf("failed to create %s_mv view: %w", t.table, err)
+		}
+	}
+	return nil
+}
+
+// stagedTypeTables staged_types 中各日志类型的 api_logs_<类型> 表
+func (s *ClickHouseStorage) stagedTypeTables() []*tableSchema {
+	api := s.tables["api_logs"]
+	tables := make([]*tableSchema, 0, len(s.stagedTypes))
+	for _, logType := range s.stagedTypes {
+		tables = append(tables, &tableSchema{database: api.database, table: api.table + "_" + logType})
+	}
+	return tables
+}
+
+// syncStagingView 创建从 source 写入 target 的物化视图，SELECT 显式列出两表共有的列。
+// 物化视图的列在创建时确定，之后补齐的列不会写入，因此列不一致时以新列重建视图：
+// 先创建新视图再与旧视图交换后删除，交换前后短暂重复写入的行在数据表合并时去重
+func (s *ClickHouseStorage) syncStagingView(ctx context.Context, view, target, source, filter string) error {
+	sourceCols, err := s.insertableColumns(ctx, source)
+	if err != nil {
+		return err
+	}
+	targetCols, err := s.insertableColumns(ctx, target)
+	if err != nil {
+		return err
+	}
+	inTarget := make(map[string]bool, len(targetCols))
+	for _, c := range targetCols {
+		inTarget[c] = true
+	}
+	var cols []string
+	for _, c := range sourceCols {
+		if inTarget[c] {
+			cols = append(cols, "`"+c+"`")
+		}
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), source)
+	if filter != "" {
+		query += " WHERE " + filter
+	}
+
+	current, err := s.tableColumns(ctx, view)
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return s.execDDL(ctx, fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s TO %s AS %s", view, target, query))
+	}
+	if len(current) == len(cols) {
+		same := true
+		for _, c := range cols {
+			if _, ok := current[strings.Trim(c, "`")]; !ok {
+				same = false
+				break
+			}
+		}
+		if same {
+			return nil
+		}
+	}
+
+	next := view + "_next"
+	exchange := fmt.Sprintf("EXCHANGE TABLES %s AND %s", view, next)
+	if s.cluster.Name != "" {
+		exchange += " ON CLUSTER " + quoteString(s.cluster.Name)
+	}
+	for _, stmt := range []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", next),
+		fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s TO %s AS %s", next, target, query),
+		exchange,
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", next),
+	} {
+		if err := s.execDDL(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	log.Printf("Recreated %s with %d columns (was %d)", view, len(cols), len(current))
+	return nil
+}
+
+// insertableColumns 按位置返回表中可写入的列（不含 MATERIALIZED、ALIAS 列）
+func (s *ClickHouseStorage) insertableColumns(ctx context.Context, table string) ([]string, error) {
+	database, name, _ := strings.Cut(table, ".")
+	rows, err := s.conn.Query(ctx, `
+		SELECT name FROM system.columns
+		WHERE database = ? AND table = ? AND default_kind NOT IN ('MATERIALIZED', 'ALIAS')
+		ORDER BY position`, database, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", table, err)
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+)
+
+// stateStorage 已处理文件的记录保存在本地 state_file 中：IsFileProcessed、LastProcessedFile 只读取本地记录，
+// 不再为每个文件查询 ClickHouse，ClickHouse 短暂不可用时也不影响检查。
+// 标记已处理时先写入本地，再经下层存储写入 processed_files 表（供其他主机、审计和查询使用），
+// 写入失败的记录由后台协程定时重试
+type stateStorage struct {
+	Storage
+	processed *processedLog
+	interval  time.Duration
+
+	mu      sync.Mutex
+	pending []processedRecord
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// LocalState 返回在本地记录已处理文件的存储，写入经 sink 执行。state_file 中没有记录时（首次启用）
+// 从 store 的 processed_files 表导入本主机的记录
+func LocalState(ctx context.Context, cfg *config.StateStoreConfig, store *ClickHouseStorage, sink Storage) (Storage, error) {
+	processed, err := openProcessedLog(cfg.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state_store.state_file: %w", err)
+	}
+	if len(processed.records) == 0 {
+		records, err := store.processedRecords(ctx)
+		if err != nil {
+			processed.Close()
+			return nil, err
+		}
+		for _, r := range records {
+			if err := processed.add(r); err != nil {
+				processed.Close()
+				return nil, err
+			}
+		}
+		log.Printf("State store: imported %d records from processed_files", len(records))
+	}
+	// 每个文件只保留最近一次处理的记录，避免状态文件无限增长
+	removed, err := processed.compact(nil)
+	if err != nil {
+		processed.Close()
+		return nil, fmt.Errorf("failed to compact state_store.state_file: %w", err)
+	}
+	if removed > 0 {
+		log.Printf("State store: compacted %d superseded lines", removed)
+	}
+	s := &stateStorage{
+		Storage:   sink,
+		processed: processed,
+		interval:  time.Duration(cfg.SyncIntervalSeconds) * time.Second,
+		// 上次退出时尚未写入 processed_files 的记录
+		pending: processed.pending(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if n := len(s.pending); n > 0 {
+		log.Printf("State store: %d processed records pending sync to processed_files", n)
+	}
+	go s.run()
+	return s, nil
+}
+
+// run 定时重试写入失败的记录，关闭时再尝试一次
+func (s *stateStorage) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sync()
+			s.compact()
+		case <-s.stop:
+			s.sync()
+			return
+		}
+	}
+}
+
+// compact 在状态文件中被取代的记录和操作行远多于有效记录时重写文件，避免长时间运行时文件无限增长
+func (s *stateStorage) compact() {
+	if !s.processed.needsCompact() {
+		return
+	}
+	removed, err := s.processed.compact(nil)
+	if err != nil {
+		log.Printf("State store: failed to compact state file: %v", err)
+		return
+	}
+	log.Printf("State store: compacted %d superseded lines", removed)
+}
+
+// sync 按标记顺序将尚未写入 processed_files 的记录写入，遇到错误时停止，下次从该记录继续
+func (s *stateStorage) sync() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for i, r := range pending {
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		err := s.Storage.MarkFileProcessed(ctx, r.Path, r.Size, r.MTime, r.Inode, r.RecordCount)
+		cancel()
+		if err != nil {
+			log.Printf("State store: failed to sync %d processed records: %v", len(pending)-i, err)
+			s.mu.Lock()
+			s.pending = append(pending[i:], s.pending...)
+			s.mu.Unlock()
+			return
+		}
+		s.synced(r)
+	}
+}
+
+// synced 在本地记录 r 已写入 processed_files，失败时下次启动会再写入一次（重复的记录在合并时去重）
+func (s *stateStorage) synced(r processedRecord) {
+	if err := s.processed.markSynced(r); err != nil {
+		log.Printf("State store: %v", err)
+	}
+}
+
+// MarkFileProcessed 写入本地记录后写入下层存储；下层写入失败时只记录日志，由后台协程重试，
+// 文件的数据已写入，不需要重新处理
+func (s *stateStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	r := processedRecord{Path: filePath, Size: fileSize, MTime: mtime, Inode: inode, RecordCount: recordCount, Pending: true}
+	if err := s.processed.add(r); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	queued := len(s.pending) > 0
+	if queued {
+		// 保持写入顺序，排在之前失败的记录之后
+		s.pending = append(s.pending, r)
+	}
+	s.mu.Unlock()
+	if queued {
+		return nil
+	}
+	if err := s.Storage.MarkFileProcessed(ctx, filePath, fileSize, mtime, inode, recordCount); err != nil {
+		log.Printf("State store: failed to record %s in processed_files (will retry): %v", filePath, err)
+		s.mu.Lock()
+		s.pending = append(s.pending, r)
+		s.mu.Unlock()
+		return nil
+	}
+	s.synced(r)
+	return nil
+}
+
+// ForgetProcessedFile 删除文件的本地记录和尚未写入 processed_files 的记录
+func (s *stateStorage) ForgetProcessedFile(ctx context.Context, filePath string) error {
+	s.mu.Lock()
+	pending := s.pending[:0]
+	for _, r := range s.pending {
+		if r.Path != filePath {
+			pending = append(pending, r)
+		}
+	}
+	s.pending = pending
+	s.mu.Unlock()
+	return s.processed.forget(filePath)
+}
+
+func (s *stateStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	return s.processed.contains(filePath, fileSize, mtime, inode), nil
+}
+
+func (s *stateStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	last, found := s.processed.last(filePath)
+	return last, found, nil
+}
+
+// Unwrap 可选能力使用下层存储的实现
+func (s *stateStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// Close 重试写入剩余的记录后关闭下层存储，仍未写入的记录在本地标记为待写入，下次启动时继续重试
+func (s *stateStorage) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	if n := len(s.pending); n > 0 {
+		log.Printf("State store: %d processed records not synced to processed_files, will retry on next start", n)
+	}
+	s.mu.Unlock()
+	s.processed.Close()
+	return s.Storage.Close()
+}
+
+// PurgeLocalState 删除 state_file 中文件修改时间早于 before 的记录并压缩文件，返回从文件中删除的行数；
+// 与 -purge-processed 删除 processed_files 中的记录对应
+func PurgeLocalState(cfg *config.StateStoreConfig, before time.Time) (int, error) {
+	processed, err := openProcessedLog(cfg.StateFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open state_store.state_file: %w", err)
+	}
+	defer processed.Close()
+	return processed.compact(func(r processedRecord) bool {
+		return !r.MTime.Before(before)
+	})
+}
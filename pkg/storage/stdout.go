@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+func init() {
+	Register("stdout", func(ctx context.Context, cfg *config.Config, labels Labels) (Storage, error) {
+		return NewStdoutStorage(cfg, labels)
+	})
+}
+
+// StdoutStorage 将各表的行以 NDJSON 写到标准输出或文件，用于检查解析结果或通过管道交给其他工具：
+// 每行一个 JSON 对象，table 为写入 ClickHouse 时的表名，其后为该表各列（与 ClickHouse 相同）
+type StdoutStorage struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+	// 写入文件时非 nil
+	file   *os.File
+	labels Labels
+	custom map[parser.LogType]string
+	// 配置了脱敏规则时非 nil
+	masker *masker
+	// 已处理的文件，未配置 state_file 时只记录在内存中，重启后重新输出所有文件
+	processed *processedLog
+}
+
+// NewStdoutStorage path 为空时写到标准输出（采集日志写到标准错误，不会混入输出），否则追加到文件
+func NewStdoutStorage(cfg *config.Config, labels Labels) (*StdoutStorage, error) {
+	sc := &cfg.Storage.Stdout
+	s := &StdoutStorage{labels: labels, custom: make(map[parser.LogType]string)}
+	var w io.Writer = os.Stdout
+	if sc.Path != "" {
+		if err := os.MkdirAll(filepath.Dir(sc.Path), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(sc.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage.stdout.path: %w", err)
+		}
+		s.file, w = f, f
+	}
+	s.out = bufio.NewWriter(w)
+
+	var err error
+	if len(cfg.ClickHouse.Masking.Rules) > 0 {
+		if s.masker, err = newMasker(&cfg.ClickHouse.Masking); err != nil {
+			s.closeFile()
+			return nil, err
+		}
+	}
+	for _, t := range cfg.CustomLogTypes {
+		if t.Table != "" {
+			s.custom[parser.LogType(t.Name)] = t.Table
+		}
+	}
+	if s.processed, err = openProcessedLog(sc.StateFile); err != nil {
+		s.closeFile()
+		return nil, fmt.Errorf("failed to open storage.stdout.state_file: %w", err)
+	}
+	return s, nil
+}
+
+func (s *StdoutStorage) closeFile() {
+	if s.file != nil {
+		s.file.Close()
+	}
+}
+
+// write 将数据表 table 的行编码为 JSON 行后一次写出，日志类型配置了独立的表时 table 为该表
+func (s *StdoutStorage) write(table string, logType parser.LogType, rows []*row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	target := table
+	if t, ok := s.custom[logType]; ok {
+		target = t
+	}
+
+	var buf bytes.Buffer
+	for _, r := range rows {
+		if s.masker != nil {
+			s.masker.apply(table, r)
+		}
+		// 按列的顺序逐个编码，输出的字段顺序与表结构一致
+		buf.WriteString(`{"table":`)
+		writeJSONValue(&buf, target)
+		if s.labels.Host != "" {
+			buf.WriteString(`,"host":`)
+			writeJSONValue(&buf, s.labels.Host)
+		}
+		if s.labels.Instance != "" {
+			buf.WriteString(`,"instance":`)
+			writeJSONValue(&buf, s.labels.Instance)
+		}
+		for i, f := range r.fields {
+			buf.WriteByte(',')
+			writeJSONValue(&buf, f)
+			buf.WriteByte(':')
+			if err := writeJSONValue(&buf, r.values[i]); err != nil {
+				return fmt.Errorf("%s.%s: %w", target, f, err)
+			}
+		}
+		buf.WriteString("}\n")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.out.Flush()
+}
+
+// writeJSONValue 写入值的 JSON 编码（不转义 HTML 字符，不带换行）
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func (s *StdoutStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return s.write("main_logs", parser.DetermineLogType(logFile), mainLogRows(entries, logFile))
+}
+
+func (s *StdoutStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.write("api_logs", entry.LogType, []*row{apiLogRow(entry, logFile)})
+}
+
+func (s *StdoutStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	if entry == nil {
+		return nil
+	}
+	return s.write("embedding_logs", entry.API.LogType, []*row{embeddingLogRow(entry, logFile)})
+}
+
+func (s *StdoutStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	if entry == nil || len(entry.Events) == 0 {
+		return nil
+	}
+	return s.write("event_logs", parser.DetermineLogType(logFile), eventBatchRows(entry, logFile))
+}
+
+func (s *StdoutStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	return s.write("json_lines", logType, jsonLineRows(logType, records, logFile))
+}
+
+func (s *StdoutStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	return s.processed.add(processedRecord{Path: filePath, Size: fileSize, MTime: mtime, Inode: inode, RecordCount: recordCount})
+}
+
+func (s *StdoutStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	return s.processed.contains(filePath, fileSize, mtime, inode), nil
+}
+
+func (s *StdoutStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	last, found := s.processed.last(filePath)
+	return last, found, nil
+}
+
+func (s *StdoutStorage) Close() error {
+	s.mu.Lock()
+	err := s.out.Flush()
+	s.mu.Unlock()
+	if s.file != nil {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if perr := s.processed.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
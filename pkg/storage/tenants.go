@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// tenantRouter 将请求日志（api_logs、embedding_logs）写入各租户独立的数据库或表，首次写入时建表
+type tenantRouter struct {
+	cfg *config.TenantRoutingConfig
+
+	mu sync.Mutex
+	// 租户 -> 数据表 -> 已创建的表
+	tables map[string]map[string]*tableSchema
+}
+
+func newTenantRouter(cfg *config.TenantRoutingConfig) *tenantRouter {
+	return &tenantRouter{cfg: cfg, tables: make(map[string]map[string]*tableSchema)}
+}
+
+// tenant 返回请求所属的租户，无法确定或未配置时为空（写入默认表）
+func (t *tenantRouter) tenant(entry *parser.APILogEntry) string {
+	var id string
+	switch t.cfg.Source {
+	case "api_key":
+		id = entry.APIKey
+	case "header":
+		for k, v := range entry.Headers {
+			if strings.EqualFold(k, t.cfg.Header) {
+				id = strings.TrimSpace(v)
+				break
+			}
+		}
+	}
+	if id == "" {
+		return ""
+	}
+	if len(t.cfg.Tenants) > 0 {
+		return t.cfg.Tenants[id]
+	}
+	// 未配置映射时直接使用请求头的值作为租户名，不是合法标识符的值写入默认表
+	id = strings.ToLower(id)
+	if !config.IsIdentifier(id) {
+		return ""
+	}
+	return id
+}
+
+// tenantTable 返回租户的数据表，不存在时创建
+func (s *ClickHouseStorage) tenantTable(ctx context.Context, tenant, table string) (*tableSchema, error) {
+	t := s.tenants
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if schema, ok := t.tables[tenant][table]; ok {
+		return schema, nil
+	}
+
+	schema := &tableSchema{database: s.database, table: s.tableName(table)}
+	if t.cfg.Mode == "database" {
+		schema.database = s.database + "_" + tenant
+		if err := s.execDDL(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", schema.database)); err != nil {
+			return nil, fmt.Errorf("failed to create database for tenant %s: %w", tenant, err)
+		}
+	} else {
+		schema.table += "_" + tenant
+	}
+	if err := s.createTable(ctx, s.renamedDDL(table, schema.database, schema.table)); err != nil {
+		return nil, fmt.Errorf("failed to create %s for tenant %s: %w", table, tenant, err)
+	}
+	if err := s.migrateTable(ctx, table, schema); err != nil {
+		return nil, err
+	}
+	if err := s.loadHeaderTypes(ctx, schema); err != nil {
+		return nil, err
+	}
+	log.Printf("Created %s for tenant %s", schema.fullName(), tenant)
+
+	if t.tables[tenant] == nil {
+		t.tables[tenant] = make(map[string]*tableSchema)
+	}
+	t.tables[tenant][table] = schema
+	return schema, nil
+}
+
+// insertRequestRows 写入请求日志，启用租户路由时写入请求所属租户的表；
+// 配置了独立表的自定义日志类型写入该表，不按租户路由
+func (s *ClickHouseStorage) insertRequestRows(ctx context.Context, table string, entry *parser.APILogEntry, rows []*row) error {
+	if _, ok := s.custom[entry.LogType]; ok || s.tenants == nil {
+		return s.insertTypedRows(ctx, table, entry.LogType, rows)
+	}
+	tenant := s.tenants.tenant(entry)
+	if tenant == "" {
+		return s.insertRows(ctx, table, rows)
+	}
+	schema, err := s.tenantTable(ctx, tenant, table)
+	if err != nil {
+		return err
+	}
+	return s.insertRowsInto(ctx, table, schema, schema.fullName(), rows)
+}
+
+// tenantTables 返回各租户的数据表 names：本次运行已创建的表，以及以前创建、按命名规则从 system.columns
+// 找到的带 log_file 列的 MergeTree 表（租户在本次运行中可能还没有写入）
+func (s *ClickHouseStorage) tenantTables(ctx context.Context, names ...string) ([]*tableSchema, error) {
+	t := s.tenants
+	seen := make(map[string]bool)
+	var tables []*tableSchema
+	t.mu.Lock()
+	for _, byTable := range t.tables {
+		for _, table := range names {
+			if schema, ok := byTable[table]; ok {
+				seen[schema.fullName()] = true
+				tables = append(tables, schema)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	for _, table := range names {
+		name := s.tableName(table)
+		query := `
+			SELECT c.database, c.table FROM system.columns AS c
+			INNER JOIN system.tables AS t ON t.database = c.database AND t.name = c.table
+			WHERE c.name = 'log_file' AND t.engine LIKE '%MergeTree'`
+		var args []interface{}
+		if t.cfg.Mode == "database" {
+			query += " AND c.database LIKE ? AND c.table = ?"
+			args = append(args, likePrefix(s.database+"_"), name)
+		} else {
+			query += " AND c.database = ? AND c.table LIKE ?"
+			args = append(args, s.database, likePrefix(name+"_"))
+		}
+		rows, err := s.conn.Query(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tenant tables: %w", err)
+		}
+		for rows.Next() {
+			schema := &tableSchema{}
+			if err := rows.Scan(&schema.database, &schema.table); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[schema.fullName()] {
+				seen[schema.fullName()] = true
+				tables = append(tables, schema)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// likePrefix 匹配以 prefix 开头、后面至少有一个字符的名称，转义 LIKE 的通配符
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "_%"
+}
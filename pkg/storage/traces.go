@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// createTracesTable 创建端到端请求追踪表，每个客户端请求一行
+func (s *ClickHouseStorage) createTracesTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			request_id String,
+			timestamp DateTime64(3),
+			client_log_type LowCardinality(String),
+			provider_log_type LowCardinality(String),
+			client_ip String,
+			user_agent String,
+			requested_model String,
+			upstream_model String,
+			provider LowCardinality(String),
+			upstream_url String,
+			upstream_attempts UInt16,
+			upstream_status UInt16,
+			response_status UInt16,
+			streamed UInt8,
+			client_latency_ms Nullable(UInt32),
+			provider_latency_ms Nullable(UInt32),
+			output_tokens_per_second Nullable(Float64),
+			input_tokens UInt64,
+			output_tokens UInt64,
+			cache_read_input_tokens UInt64,
+			cache_creation_input_tokens UInt64,
+			host LowCardinality(String),
+			instance LowCardinality(String),
+			inserted_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = ReplacingMergeTree(inserted_at)
+		PARTITION BY toYYYYMM(timestamp)
+		ORDER BY (timestamp, request_id, client_log_type)
+		TTL toDateTime(timestamp) + INTERVAL 90 DAY
+	`, s.table("request_traces"))
+	if err := s.createTable(ctx, query); err != nil {
+		return fmt.Errorf("failed to create request_traces table: %w", err)
+	}
+	return nil
+}
+
+// requestAccess request_id 在 main 日志中的 HTTP 访问记录：请求路径 -> 延迟，以及客户端 IP
+// main 日志尚未写入（或写入 VictoriaLogs）时为空
+func (s *ClickHouseStorage) requestAccess(ctx context.Context, requestID string, ts time.Time) (map[string]time.Duration, string, error) {
+	m := s.tables["main_logs"]
+	cols := []string{
+		m.selectColumn("request_id", "''"),
+		m.selectColumn("timestamp", "toDateTime64(0, 3)"),
+		m.selectColumn("status_code", "toUInt16(0)"),
+		m.selectColumn("path", "''"),
+		m.selectColumn("latency", "''"),
+		m.selectColumn("client_ip", "''"),
+	}
+	rows, err := s.conn.Query(ctx, fmt.Sprintf("SELECT `path`, `latency`, `client_ip` FROM (SELECT %s FROM %s) "+
+		"WHERE `request_id` = ? AND `status_code` != 0 AND `timestamp` BETWEEN ? AND ?",
+		strings.Join(cols, ", "), s.readTable("main_logs")),
+		requestID, ts.Add(-linkWindow), ts.Add(linkWindow))
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	latencies := make(map[string]time.Duration)
+	var clientIP string
+	for rows.Next() {
+		var path, latency, ip string
+		if err := rows.Scan(&path, &latency, &ip); err != nil {
+			return nil, "", err
+		}
+		if d, err := time.ParseDuration(strings.TrimSpace(latency)); err == nil {
+			latencies[urlPath(path)] = d
+		}
+		if clientIP == "" {
+			clientIP = strings.TrimSpace(ip)
+		}
+	}
+	return latencies, clientIP, rows.Err()
+}
+
+// urlPath 去掉查询参数的路径
+func urlPath(raw string) string {
+	path, _, _ := strings.Cut(raw, "?")
+	return path
+}
+
+func (s *ClickHouseStorage) insertTrace(ctx context.Context, requestID string, client, provider requestSide) error {
+	latencies, clientIP, err := s.requestAccess(ctx, requestID, client.timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to look up main logs: %w", err)
+	}
+	if clientIP == "" {
+		clientIP = forwardedFor(client.headers)
+	}
+	latencyMs := func(side requestSide) *uint32 {
+		d, ok := latencies[urlPath(side.url)]
+		if !ok {
+			return nil
+		}
+		ms := uint32(d.Milliseconds())
+		return &ms
+	}
+
+	last, attempts := lastUpstream(client, provider)
+	usage := provider.usage
+	if usage == (parser.Usage{}) {
+		usage = client.usage
+	}
+	// 输出速度按客户端看到的总延迟计算
+	var tokensPerSecond *float64
+	if ms := latencyMs(client); ms != nil && *ms > 0 && usage.OutputTokens > 0 {
+		tokensPerSecond = nullableFloat64(float64(usage.OutputTokens) / (float64(*ms) / 1000))
+	}
+
+	return s.conn.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+		(request_id, timestamp, client_log_type, provider_log_type, client_ip, user_agent,
+		 requested_model, upstream_model, provider, upstream_url, upstream_attempts, upstream_status, response_status,
+		 streamed, client_latency_ms, provider_latency_ms, output_tokens_per_second,
+		 input_tokens, output_tokens, cache_read_input_tokens, cache_creation_input_tokens, host, instance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.table("request_traces")),
+		requestID, client.timestamp, client.logType, provider.logType, clientIP, headerValue(client.headers, "User-Agent"),
+		client.model, upstreamModel(provider, last), providerName(provider.url, last.URL), last.URL,
+		uint16(attempts), uint16(last.Status), client.status,
+		boolToUInt8(client.streamed), latencyMs(client), latencyMs(provider), tokensPerSecond,
+		usage.InputTokens, usage.OutputTokens, usage.CacheReadInputTokens, usage.CacheCreationInputTokens,
+		s.labels.Host, s.labels.Instance)
+}
+
+// forwardedFor 从 X-Forwarded-For / X-Real-IP 请求头读取客户端 IP
+func forwardedFor(headers map[string]string) string {
+	if v := headerValue(headers, "X-Forwarded-For"); v != "" {
+		first, _, _ := strings.Cut(v, ",")
+		return strings.TrimSpace(first)
+	}
+	return headerValue(headers, "X-Real-IP")
+}
+
+// headerValue 不区分大小写读取请求头
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// VictoriaLogsStorage 通过 JSON line 接口将 main 日志写入 VictoriaLogs
+type VictoriaLogsStorage struct {
+	client    *http.Client
+	endpoint  string
+	accountID string
+	projectID string
+	labels    Labels
+}
+
+func NewVictoriaLogsStorage(cfg *config.VictoriaLogsConfig, labels Labels) (*VictoriaLogsStorage, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid victoria_logs url: %w", err)
+	}
+	base.Path += "/insert/jsonline"
+
+	q := base.Query()
+	q.Set("_stream_fields", strings.Join(cfg.StreamFields, ","))
+	q.Set("_time_field", "_time")
+	q.Set("_msg_field", "_msg")
+	base.RawQuery = q.Encode()
+
+	return &VictoriaLogsStorage{
+		client:    &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		endpoint:  base.String(),
+		accountID: cfg.AccountID,
+		projectID: cfg.ProjectID,
+		labels:    labels,
+	}, nil
+}
+
+// InsertMainLogs 批量写入主日志，每条日志一行 JSON
+func (s *VictoriaLogsStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range entries {
+		line := map[string]interface{}{
+			"_time":      e.Timestamp.Format(time.RFC3339Nano),
+			"_msg":       e.Message,
+			"request_id": e.RequestID,
+			"level":      e.Level,
+			"source":     e.Source,
+			"log_file":   logFile,
+			"host":       s.labels.Host,
+			"instance":   s.labels.Instance,
+		}
+		if e.Flag != "" {
+			line["timestamp_flag"] = e.Flag
+			line["timestamp_skew_seconds"] = e.SkewSeconds
+		}
+		if e.StatusCode != 0 {
+			line["status_code"] = e.StatusCode
+			line["latency"] = e.Latency
+			line["client_ip"] = e.ClientIP
+			line["method"] = e.Method
+			line["path"] = e.Path
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/stream+json")
+	if s.accountID != "" {
+		req.Header.Set("AccountID", s.accountID)
+	}
+	if s.projectID != "" {
+		req.Header.Set("ProjectID", s.projectID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push to VictoriaLogs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("VictoriaLogs returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
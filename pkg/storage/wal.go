@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ngk0ng/cpa-logger/pkg/config"
+	"github.com/k0ngk0ng/cpa-logger/pkg/parser"
+)
+
+// 预写队列的指标，通过 /debug/vars 暴露
+var (
+	walStats          = expvar.NewMap("wal")
+	walPendingBundles = new(expvar.Int)
+	walPendingBytes   = new(expvar.Int)
+	walShipped        = new(expvar.Int)
+	walFailed         = new(expvar.Int)
+)
+
+func init() {
+	walStats.Set("pending_bundles", walPendingBundles)
+	walStats.Set("pending_bytes", walPendingBytes)
+	walStats.Set("shipped", walShipped)
+	walStats.Set("failed", walFailed)
+}
+
+// 无法写入的压缩包（如表结构不兼容）移入的子目录，不阻塞之后的压缩包
+const walFailedDir = "failed"
+
+// errWALFull 积压达到 wal.max_size_mb
+var errWALFull = errors.New("wal is full")
+
+// walStorage 预写队列：写入和已处理记录先保存为 wal 目录中的 spool 压缩包，由后台协程按写入顺序
+// 写入 ClickHouse（ShipSpool 的方式：保留去重令牌，已处理记录在其数据之后写入）。
+// 已处理记录同时保存在 wal 目录的 processed.jsonl 中，IsFileProcessed、LastProcessedFile 先查本地记录，
+// ClickHouse 不可用时只按本地记录判断；
+// 查询、重放队列、审计等直接使用 ClickHouse
+type walStorage struct {
+	Storage
+	store *ClickHouseStorage
+	spool *SpoolStorage
+	cfg   config.WALConfig
+
+	pendingBytes atomic.Int64
+	// 有新的压缩包时通知后台协程
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// WAL 返回先写入 wal 目录再异步写入 store 的存储，启动时继续写入上次退出时积压的压缩包
+func WAL(cfg *config.WALConfig, store *ClickHouseStorage, labels Labels) (Storage, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+	processed, err := openWALProcessed(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	w := &walStorage{
+		Storage: store,
+		store:   store,
+		spool:   &SpoolStorage{dir: cfg.Dir, labels: labels, processed: processed},
+		cfg:     *cfg,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if _, err := w.pending(); err != nil {
+		processed.Close()
+		return nil, err
+	}
+	go w.run()
+	w.signal()
+	return w, nil
+}
+
+// openWALProcessed 打开 wal 目录中的已处理记录；旧版本只在内存中保存尚未写入的已处理记录，从积压的压缩包补齐
+func openWALProcessed(dir string) (*processedLog, error) {
+	processed, err := openProcessedLog(filepath.Join(dir, spoolIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal processed records: %w", err)
+	}
+	names, err := spoolBundles(dir)
+	if err != nil {
+		processed.Close()
+		return nil, err
+	}
+	for _, name := range names {
+		if b, err := readBundle(filepath.Join(dir, name)); err == nil && b.Kind == "processed" {
+			r := b.Processed
+			if !processed.contains(r.Path, r.Size, r.MTime, r.Inode) {
+				processed.add(*r)
+			}
+		}
+	}
+	if _, err := processed.compact(nil); err != nil {
+		processed.Close()
+		return nil, fmt.Errorf("failed to compact wal processed records: %w", err)
+	}
+	return processed, nil
+}
+
+// pending 返回积压的压缩包并更新积压大小
+func (w *walStorage) pending() ([]string, error) {
+	names, err := spoolBundles(w.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(w.cfg.Dir, name)); err == nil {
+			size += info.Size()
+		}
+	}
+	w.pendingBytes.Store(size)
+	walPendingBundles.Set(int64(len(names)))
+	walPendingBytes.Set(size)
+	return names, nil
+}
+
+func (w *walStorage) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run 有新的压缩包时写入 ClickHouse，失败时按 1s、2s、4s... 退避（最长 max_backoff_seconds）后重试；
+// 关闭时再尝试写入一次剩余的压缩包，失败的留在目录中下次启动时写入
+func (w *walStorage) run() {
+	defer close(w.done)
+	backoff := time.Second
+	maxBackoff := time.Duration(w.cfg.MaxBackoffSeconds) * time.Second
+	for {
+		var retry <-chan time.Time
+		if err := w.drain(); err != nil {
+			log.Printf("WAL: failed to write to ClickHouse (retrying in %s): %v", backoff, err)
+			retry = time.After(backoff)
+			backoff = min(backoff*2, maxBackoff)
+		} else {
+			backoff = time.Second
+		}
+		if w.spool.processed.needsCompact() {
+			if _, err := w.spool.processed.compact(nil); err != nil {
+				log.Printf("WAL: failed to compact processed records: %v", err)
+			}
+		}
+		select {
+		case <-w.notify:
+			if retry != nil {
+				// 写入失败后新的压缩包不提前重试
+				select {
+				case <-retry:
+				case <-w.stop:
+					w.drain()
+					return
+				}
+			}
+		case <-retry:
+		case <-w.stop:
+			w.drain()
+			return
+		}
+	}
+}
+
+// drain 按写入顺序写入积压的压缩包，写入成功后删除；临时错误时停止并返回错误，
+// 其他错误（压缩包损坏、表结构不兼容等）将压缩包移入 failed 子目录后继续
+func (w *walStorage) drain() error {
+	names, err := w.pending()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(w.cfg.Dir, name)
+		ctx, cancel := withTimeout(context.Background(), w.store.timeouts.Insert)
+		err := shipBundleFile(ctx, w.store, path)
+		cancel()
+		if err != nil {
+			if IsTransientError(err) || IsResourceError(err) {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			walFailed.Add(1)
+			log.Printf("WAL: moving %s to %s: %v", name, walFailedDir, err)
+			if err := os.MkdirAll(filepath.Join(w.cfg.Dir, walFailedDir), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(path, filepath.Join(w.cfg.Dir, walFailedDir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		walShipped.Add(1)
+	}
+	_, err = w.pending()
+	return err
+}
+
+// write 积压未超过上限时写入压缩包并通知后台协程
+func (w *walStorage) write(write func() error) error {
+	if limit := int64(w.cfg.MaxSizeMB) << 20; limit > 0 && w.pendingBytes.Load() >= limit {
+		return errWALFull
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	w.signal()
+	return nil
+}
+
+func (w *walStorage) WithInsertToken(ctx context.Context, token string) context.Context {
+	return w.spool.WithInsertToken(ctx, token)
+}
+
+func (w *walStorage) InsertMainLogs(ctx context.Context, entries []parser.MainLogEntry, logFile string) error {
+	return w.write(func() error { return w.spool.InsertMainLogs(ctx, entries, logFile) })
+}
+
+func (w *walStorage) InsertAPILog(ctx context.Context, entry *parser.APILogEntry, logFile string) error {
+	return w.write(func() error { return w.spool.InsertAPILog(ctx, entry, logFile) })
+}
+
+func (w *walStorage) InsertEmbeddingLog(ctx context.Context, entry *parser.EmbeddingLogEntry, logFile string) error {
+	return w.write(func() error { return w.spool.InsertEmbeddingLog(ctx, entry, logFile) })
+}
+
+func (w *walStorage) InsertEventBatch(ctx context.Context, entry *parser.EventBatchEntry, logFile string) error {
+	return w.write(func() error { return w.spool.InsertEventBatch(ctx, entry, logFile) })
+}
+
+func (w *walStorage) InsertJSONLines(ctx context.Context, logType parser.LogType, records []parser.JSONLineRecord, logFile string) error {
+	return w.write(func() error { return w.spool.InsertJSONLines(ctx, logType, records, logFile) })
+}
+
+// 请求关联在压缩包写入 ClickHouse 时执行（shipBundle），重放队列、审计和重新处理直接使用 ClickHouse
+
+func (w *walStorage) EnqueueReplay(ctx context.Context, entry *parser.APILogEntry) error {
+	return w.store.EnqueueReplay(ctx, entry)
+}
+
+func (w *walStorage) InsertIngestAudit(ctx context.Context, a *IngestAudit) error {
+	return w.store.InsertIngestAudit(ctx, a)
+}
+
+func (w *walStorage) DeleteFileRows(ctx context.Context, logFile string) error {
+	return w.store.DeleteFileRows(ctx, logFile)
+}
+
+func (w *walStorage) RequestLogFiles(ctx context.Context, requestID string) ([]string, error) {
+	return w.store.RequestLogFiles(ctx, requestID)
+}
+
+// MarkFileProcessed 已处理记录同样经预写队列写入，保证在文件的数据之后写入 ClickHouse
+func (w *walStorage) MarkFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64, recordCount uint32) error {
+	return w.write(func() error {
+		return w.spool.MarkFileProcessed(ctx, filePath, fileSize, mtime, inode, recordCount)
+	})
+}
+
+// IsFileProcessed 先查本地记录；本地没有记录且 ClickHouse 不可用（查询出错或健康检查标记为不可用）时
+// 按未处理返回，文件照常处理，已写入过的文件重新写入时带同一去重令牌，ClickHouse 恢复后丢弃重复的批次
+func (w *walStorage) IsFileProcessed(ctx context.Context, filePath string, fileSize int64, mtime time.Time, inode uint64) (bool, error) {
+	if w.spool.processed.contains(filePath, fileSize, mtime, inode) {
+		return true, nil
+	}
+	if !w.store.Healthy() {
+		return false, nil
+	}
+	processed, err := w.store.IsFileProcessed(ctx, filePath, fileSize, mtime, inode)
+	if err != nil && IsTransientError(err) {
+		return false, nil
+	}
+	return processed, err
+}
+
+// LastProcessedFile 先查本地记录，追加内容的 main 日志在 ClickHouse 不可用时也从上次的位置继续
+func (w *walStorage) LastProcessedFile(ctx context.Context, filePath string) (ProcessedFile, bool, error) {
+	if last, found := w.spool.processed.last(filePath); found {
+		return last, true, nil
+	}
+	if !w.store.Healthy() {
+		return ProcessedFile{}, false, nil
+	}
+	last, found, err := w.store.LastProcessedFile(ctx, filePath)
+	if err != nil && IsTransientError(err) {
+		return ProcessedFile{}, false, nil
+	}
+	return last, found, err
+}
+
+// Close 写入剩余的压缩包后关闭本地记录和 ClickHouse 连接
+func (w *walStorage) Close() error {
+	close(w.stop)
+	<-w.done
+	w.spool.Close()
+	return w.store.Close()
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenWALProcessed(t *testing.T) {
+	mtime := time.Date(2026, 1, 8, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// 重启前对 wal 目录的处理：模拟压缩包已写入 ClickHouse、旧版本没有 processed.jsonl 等
+		prepare func(t *testing.T, dir string)
+		want    bool
+	}{
+		{
+			name:    "pending bundle",
+			prepare: func(t *testing.T, dir string) {},
+			want:    true,
+		},
+		{
+			name: "bundle shipped before restart",
+			prepare: func(t *testing.T, dir string) {
+				removeBundles(t, dir)
+			},
+			want: true,
+		},
+		{
+			name: "bundle written by old version",
+			prepare: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, spoolIndexFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "nothing left",
+			prepare: func(t *testing.T, dir string) {
+				removeBundles(t, dir)
+				if err := os.Remove(filepath.Join(dir, spoolIndexFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool, err := NewSpoolStorage(dir, Labels{Host: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := spool.MarkFileProcessed(context.Background(), "/logs/main.log", 100, mtime, 7, 3); err != nil {
+				t.Fatal(err)
+			}
+			spool.Close()
+			tt.prepare(t, dir)
+
+			processed, err := openWALProcessed(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer processed.Close()
+			if got := processed.contains("/logs/main.log", 100, mtime, 7); got != tt.want {
+				t.Errorf("contains = %v, want %v", got, tt.want)
+			}
+			last, found := processed.last("/logs/main.log")
+			if found != tt.want {
+				t.Fatalf("last found = %v, want %v", found, tt.want)
+			}
+			if found && (last.Size != 100 || last.Inode != 7 || last.RecordCount != 3) {
+				t.Errorf("last = %+v", last)
+			}
+		})
+	}
+}
+
+func removeBundles(t *testing.T, dir string) {
+	t.Helper()
+	names, err := spoolBundles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}